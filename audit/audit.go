@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Outcomes of the actions events record.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Types of the targets of actions.
+const (
+	TargetUser      = "user"
+	TargetSession   = "session"
+	TargetClient    = "client"
+	TargetRole      = "role"
+	TargetGroup     = "group"
+	TargetTenant    = "tenant"
+	TargetConnector = "connector"
+	TargetSCIMToken = "scim_token"
+	TargetToken     = "personal_token"
+)
+
+// Sizes of the pages events are listed in.
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// Event records who did what to what, from where, and how it went. Events
+// are only ever appended.
+type Event struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"time" gorm:"index"`
+	// TenantID is the tenant the actor belongs to, 0 for the default one
+	TenantID uint `json:"tenant_id" gorm:"index"`
+	// ActorID is the user that acted, 0 when nobody was signed in
+	ActorID uint `json:"actor_id" gorm:"index"`
+	// Actor names whoever acted: the email of a user, even one that failed
+	// to sign in, or the operator of a CLI command
+	Actor      string                 `json:"actor"`
+	Action     string                 `json:"action" gorm:"index"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `json:"target_id" gorm:"index"`
+	IP         string                 `json:"ip"`
+	UserAgent  string                 `json:"user_agent"`
+	RequestID  string                 `json:"request_id"`
+	Outcome    string                 `json:"outcome"`
+	Metadata   map[string]interface{} `json:"metadata" gorm:"serializer:json"`
+}
+
+func (Event) TableName() string {
+	return "audit_events"
+}
+
+// Query filters the events Events returns, newest first. Zero fields
+// don't filter.
+type Query struct {
+	// TenantID narrows the events down to the tenant when it isn't nil
+	TenantID   *uint
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   string
+	// Outcome is OutcomeSuccess or OutcomeFailure
+	Outcome string
+	Since   time.Time
+	Until   time.Time
+
+	// Cursor is where the previous page ended, empty for the first page
+	Cursor string
+	// Limit is the size of a page, DefaultListLimit when 0 and at most
+	// MaxListLimit
+	Limit int
+}
+
+var csvHeader = []string{"id", "time", "tenant_id", "actor_id", "actor", "action", "target_type", "target_id", "ip", "user_agent", "request_id", "outcome", "metadata"}
+
+// WriteCSV writes events to w as CSV with a header row, metadata as JSON.
+func WriteCSV(w io.Writer, events []*Event) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		metadata, err := json.Marshal(e.Metadata)
+		if err != nil {
+			return err
+		}
+
+		err = cw.Write([]string{
+			strconv.FormatUint(uint64(e.ID), 10),
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(e.TenantID), 10),
+			strconv.FormatUint(uint64(e.ActorID), 10),
+			e.Actor,
+			e.Action,
+			e.TargetType,
+			e.TargetID,
+			e.IP,
+			e.UserAgent,
+			e.RequestID,
+			e.Outcome,
+			string(metadata),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,8 @@
+package audit
+
+import "errors"
+
+var (
+	ErrCursorInvalid  = errors.New("invalid audit cursor")
+	ErrOutcomeInvalid = errors.New("invalid audit outcome")
+)
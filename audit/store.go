@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+type Store interface {
+	// Record appends e to the audit log, setting its ID and time.
+	Record(e *Event) error
+
+	// Events gets a page of the events matching q, newest first, and the
+	// cursor of the next page, empty when it is the last one.
+	// Returns ErrOutcomeInvalid or ErrCursorInvalid for a query it can't
+	// run.
+	Events(q Query) ([]*Event, string, error)
+
+	// Migrate auto-migrates the Event model to database. On Postgres the
+	// events are also protected from being updated or deleted.
+	Migrate() error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+func (s *store) Record(e *Event) error {
+	e.ID = 0
+	return s.db.Create(e).Error
+}
+
+func (s *store) Events(q Query) ([]*Event, string, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultListLimit
+	}
+	if q.Limit > MaxListLimit {
+		q.Limit = MaxListLimit
+	}
+
+	tx := s.db.Model(&Event{})
+	if q.TenantID != nil {
+		tx = tx.Where("tenant_id = ?", *q.TenantID)
+	}
+	if q.ActorID != 0 {
+		tx = tx.Where("actor_id = ?", q.ActorID)
+	}
+	if q.Action != "" {
+		tx = tx.Where("action = ?", q.Action)
+	}
+	if q.TargetType != "" {
+		tx = tx.Where("target_type = ?", q.TargetType)
+	}
+	if q.TargetID != "" {
+		tx = tx.Where("target_id = ?", q.TargetID)
+	}
+	switch q.Outcome {
+	case "":
+	case OutcomeSuccess, OutcomeFailure:
+		tx = tx.Where("outcome = ?", q.Outcome)
+	default:
+		return nil, "", ErrOutcomeInvalid
+	}
+	if !q.Since.IsZero() {
+		tx = tx.Where("created_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		tx = tx.Where("created_at < ?", q.Until)
+	}
+
+	// events are appended in order, so the ID alone pages through them
+	if q.Cursor != "" {
+		id, err := strconv.ParseUint(q.Cursor, 10, 64)
+		if err != nil {
+			return nil, "", ErrCursorInvalid
+		}
+		tx = tx.Where("id < ?", id)
+	}
+
+	var events []*Event
+	if err := tx.Order("id DESC").Limit(q.Limit + 1).Find(&events).Error; err != nil {
+		return nil, "", err
+	}
+
+	if len(events) <= q.Limit {
+		return events, "", nil
+	}
+
+	events = events[:q.Limit]
+	return events, strconv.FormatUint(uint64(events[len(events)-1].ID), 10), nil
+}
+
+func (s *store) Migrate() error {
+	if err := s.db.AutoMigrate(&Event{}); err != nil {
+		return err
+	}
+
+	if s.db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	// even the application can't rewrite what it has recorded
+	return s.db.Exec(`
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit events are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+	BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+`).Error
+}
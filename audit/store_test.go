@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store_Events(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	acme := uint(1)
+	events := []*Event{
+		{ActorID: 1, Actor: "jane@example.com", Action: "login", Outcome: OutcomeSuccess},
+		{Actor: "jane@example.com", Action: "login", Outcome: OutcomeFailure, Metadata: map[string]interface{}{"reason": "password"}},
+		{ActorID: 1, Action: "user_disabled", TargetType: TargetUser, TargetID: "2", Outcome: OutcomeSuccess},
+		{TenantID: acme, ActorID: 3, Action: "login", Outcome: OutcomeSuccess},
+	}
+	for _, e := range events {
+		if err := s.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		q       Query
+		wantIDs []uint
+		wantErr error
+	}{
+		{name: "all", q: Query{}, wantIDs: []uint{4, 3, 2, 1}},
+		{name: "actor", q: Query{ActorID: 1}, wantIDs: []uint{3, 1}},
+		{name: "action", q: Query{Action: "login"}, wantIDs: []uint{4, 2, 1}},
+		{name: "failures", q: Query{Outcome: OutcomeFailure}, wantIDs: []uint{2}},
+		{name: "target", q: Query{TargetType: TargetUser, TargetID: "2"}, wantIDs: []uint{3}},
+		{name: "tenant", q: Query{TenantID: &acme}, wantIDs: []uint{4}},
+		{name: "until", q: Query{Until: time.Now().Add(-time.Hour)}, wantIDs: nil},
+		{name: "since", q: Query{Since: time.Now().Add(-time.Hour)}, wantIDs: []uint{4, 3, 2, 1}},
+		{name: "page", q: Query{Limit: 2}, wantIDs: []uint{4, 3}},
+		{name: "next page", q: Query{Limit: 2, Cursor: "3"}, wantIDs: []uint{2, 1}},
+		{name: "invalid outcome", q: Query{Outcome: "maybe"}, wantErr: ErrOutcomeInvalid},
+		{name: "invalid cursor", q: Query{Cursor: "x"}, wantErr: ErrCursorInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := s.Events(tt.q)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Events() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var ids []uint
+			for _, e := range got {
+				ids = append(ids, e.ID)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("Events() = %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Fatalf("Events() = %v, want %v", ids, tt.wantIDs)
+				}
+			}
+		})
+	}
+
+	_, next, err := s.Events(Query{Limit: 2})
+	if err != nil || next != "3" {
+		t.Errorf("Events() next = %q, %v, want 3", next, err)
+	}
+	_, next, err = s.Events(Query{Limit: 2, Cursor: next})
+	if err != nil || next != "" {
+		t.Errorf("Events() last next = %q, %v, want none", next, err)
+	}
+
+	got, _, _ := s.Events(Query{Outcome: OutcomeFailure})
+	if got[0].Metadata["reason"] != "password" {
+		t.Errorf("Events() metadata = %v, want the reason kept", got[0].Metadata)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteCSV(buf, []*Event{{ID: 1, Actor: "jane@example.com", Action: "login", Outcome: OutcomeSuccess, Metadata: map[string]interface{}{"acr": "1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[1]) != len(csvHeader) {
+		t.Fatalf("WriteCSV() = %v, want a header and an event", records)
+	}
+	if records[1][4] != "jane@example.com" || records[1][12] != `{"acr":"1"}` {
+		t.Errorf("WriteCSV() event = %v", records[1])
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
@@ -0,0 +1,43 @@
+package auth
+
+// Authentication Context Class References semaphore can satisfy, from the
+// weakest to the strongest.
+const (
+	ACRPassword    = "urn:semaphore:acr:password"
+	ACRMultiFactor = "urn:semaphore:acr:mfa"
+)
+
+// Authentication Method References as registered in RFC 8176.
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+	AMRHWK      = "hwk"
+)
+
+// AMRFederated is the method of a sign in through an upstream identity
+// provider. It isn't registered in RFC 8176, which has no such method.
+const AMRFederated = "fed"
+
+var acrLevels = map[string]int{
+	ACRPassword:    1,
+	ACRMultiFactor: 2,
+}
+
+// ACRSatisfies reports whether an authentication performed at acr is at
+// least as strong as one of wanted. Unknown values in wanted are ignored,
+// if none of them is known any authentication satisfies.
+func ACRSatisfies(acr string, wanted []string) bool {
+	min := 0
+	for _, w := range wanted {
+		level, ok := acrLevels[w]
+		if !ok {
+			continue
+		}
+		if min == 0 || level < min {
+			min = level
+		}
+	}
+
+	return acrLevels[acr] >= min
+}
@@ -0,0 +1,27 @@
+package auth
+
+import "testing"
+
+func TestACRSatisfies(t *testing.T) {
+	tests := []struct {
+		name   string
+		acr    string
+		wanted []string
+		want   bool
+	}{
+		{name: "nothing wanted", acr: ACRPassword, want: true},
+		{name: "unknown wanted", acr: ACRPassword, wanted: []string{"urn:other:loa:4"}, want: true},
+		{name: "password for password", acr: ACRPassword, wanted: []string{ACRPassword}, want: true},
+		{name: "password for mfa", acr: ACRPassword, wanted: []string{ACRMultiFactor}, want: false},
+		{name: "mfa for password", acr: ACRMultiFactor, wanted: []string{ACRPassword}, want: true},
+		{name: "password for mfa or password", acr: ACRPassword, wanted: []string{ACRMultiFactor, ACRPassword}, want: true},
+		{name: "unauthenticated for password", acr: "", wanted: []string{ACRPassword}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ACRSatisfies(tt.acr, tt.wanted); got != tt.want {
+				t.Errorf("ACRSatisfies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
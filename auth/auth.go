@@ -1,12 +1,15 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
+	"github.com/9d4/semaphore/rbac"
 	"github.com/9d4/semaphore/user"
 	"github.com/9d4/semaphore/util"
 	"github.com/golang-jwt/jwt/v4"
 	jww "github.com/spf13/jwalterweatherman"
 	v "github.com/spf13/viper"
+	"strconv"
 	"time"
 )
 
@@ -14,11 +17,54 @@ import (
 type AccessToken struct {
 	jwt.RegisteredClaims
 	User UserInfo `json:"user"`
+	// SessionID is the session the token was minted for, empty for tokens
+	// that don't belong to one
+	SessionID string `json:"sid,omitempty"`
+
+	// Roles, Groups and Permissions are the grants of the user when the
+	// token was minted
+	Roles       []string `json:"roles,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+
+	// Actor is the admin impersonating the user, nil unless the token was
+	// minted for an impersonation
+	Actor *Actor `json:"act,omitempty"`
+
+	// TokenID is the personal access token the claims were read from, 0
+	// for JWTs. It is never part of a JWT.
+	TokenID uint `json:"-"`
 }
 
-// RefreshToken represents jwt claims for user refresh token.
+// Can reports whether the token grants permission.
+func (at *AccessToken) Can(permission string) bool {
+	return rbac.Allows(at.Permissions, permission)
+}
+
+// RefreshToken represents jwt claims for user refresh token. Its ID
+// ("jti") is accepted by the session only once.
 type RefreshToken struct {
 	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+	Actor     *Actor `json:"act,omitempty"`
+}
+
+// Actor is the "act" claim (RFC 8693) of a token minted for an admin
+// acting as another user.
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// NewActor returns the actor claim of usr.
+func NewActor(usr user.User) *Actor {
+	return &Actor{Subject: fmt.Sprint(usr.ID), Email: usr.Email}
+}
+
+// ID returns the user ID of the actor, 0 when the subject isn't one.
+func (a *Actor) ID() uint {
+	id, _ := strconv.ParseUint(a.Subject, 10, 64)
+	return uint(id)
 }
 
 // UserInfo represents user info that will be part of AccessToken.
@@ -27,6 +73,8 @@ type UserInfo struct {
 	Email     string `json:"email"`
 	FirstName string `json:"firstname"`
 	LastName  string `json:"lastname"`
+	// TenantID is the tenant of the user, 0 for the default one
+	TenantID uint `json:"tenant_id,omitempty"`
 }
 
 const (
@@ -36,6 +84,10 @@ const (
 
 const AccessTokenIssuer = "semaphore"
 
+// RefreshTokenIssuer is distinct from AccessTokenIssuer so a refresh token
+// can't be used as a bearer token.
+const RefreshTokenIssuer = "semaphore-refresh"
+
 var DefaultJwtKeyFunc = func(key []byte) jwt.Keyfunc {
 	return func(token *jwt.Token) (interface{}, error) {
 		return key, nil
@@ -44,7 +96,11 @@ var DefaultJwtKeyFunc = func(key []byte) jwt.Keyfunc {
 
 var key []byte
 
-func GenerateAccessToken(usr user.User, key []byte, expiresIn time.Duration) (string, error) {
+func GenerateAccessToken(usr user.User, grants rbac.Grants, key []byte, expiresIn time.Duration) (string, error) {
+	return generateAccessToken(usr, grants, "", nil, key, expiresIn)
+}
+
+func generateAccessToken(usr user.User, grants rbac.Grants, sessionID string, actor *Actor, key []byte, expiresIn time.Duration) (string, error) {
 	at := jwt.NewWithClaims(jwt.SigningMethodHS256, AccessToken{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "semaphore",
@@ -56,14 +112,26 @@ func GenerateAccessToken(usr user.User, key []byte, expiresIn time.Duration) (st
 			Email:     usr.Email,
 			FirstName: usr.FirstName,
 			LastName:  usr.LastName,
+			TenantID:  usr.TenantID,
 		},
+		SessionID:   sessionID,
+		Roles:       grants.Roles,
+		Groups:      grants.Groups,
+		Permissions: grants.Permissions,
+		Actor:       actor,
 	})
 
 	return at.SignedString(key)
 }
 
-func GenerateRefreshToken(usr user.User, key []byte, expiresIn time.Duration) (string, error) {
-	claims := jwt.RegisteredClaims{}
+func GenerateRefreshToken(usr user.User, sessionID string, tokenID string, key []byte, expiresIn time.Duration) (string, error) {
+	return generateRefreshToken(usr, sessionID, tokenID, nil, key, expiresIn)
+}
+
+func generateRefreshToken(usr user.User, sessionID string, tokenID string, actor *Actor, key []byte, expiresIn time.Duration) (string, error) {
+	claims := RefreshToken{SessionID: sessionID, Actor: actor}
+	claims.ID = tokenID
+	claims.Issuer = RefreshTokenIssuer
 	claims.Subject = fmt.Sprint(usr.ID)
 	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(expiresIn))
 
@@ -72,15 +140,15 @@ func GenerateRefreshToken(usr user.User, key []byte, expiresIn time.Duration) (s
 	return rt.SignedString(key)
 }
 
-func GenerateTokenPair(usr user.User, key []byte) (accessToken string, refreshToken string, err error) {
-	at, genErr := GenerateAccessToken(usr, key, AccessTokenExpiration)
+func GenerateTokenPair(usr user.User, grants rbac.Grants, sessionID string, refreshTokenID string, key []byte) (accessToken string, refreshToken string, err error) {
+	at, genErr := generateAccessToken(usr, grants, sessionID, nil, key, AccessTokenExpiration)
 	if genErr != nil {
 		jww.TRACE.Println("apiServer:error:generateAccessToken", genErr)
 		err = genErr
 		return
 	}
 
-	rt, genErr := GenerateRefreshToken(usr, key, RefreshTokenExpiration)
+	rt, genErr := GenerateRefreshToken(usr, sessionID, refreshTokenID, key, RefreshTokenExpiration)
 	if genErr != nil {
 		jww.TRACE.Println("apiServer:error:generateAccessToken", genErr)
 		err = genErr
@@ -91,6 +159,27 @@ func GenerateTokenPair(usr user.User, key []byte) (accessToken string, refreshTo
 	return
 }
 
+// GenerateImpersonationTokenPair generates the tokens of actor acting as
+// usr. Neither of them outlives endsAt, when the impersonation ends.
+func GenerateImpersonationTokenPair(usr user.User, grants rbac.Grants, actor *Actor, sessionID string, refreshTokenID string, key []byte, endsAt time.Time) (accessToken string, refreshToken string, err error) {
+	remaining := time.Until(endsAt)
+	if remaining <= 0 {
+		return "", "", jwt.ErrTokenExpired
+	}
+
+	expiresIn := AccessTokenExpiration
+	if remaining < expiresIn {
+		expiresIn = remaining
+	}
+	if accessToken, err = generateAccessToken(usr, grants, sessionID, actor, key, expiresIn); err != nil {
+		return "", "", err
+	}
+	if refreshToken, err = generateRefreshToken(usr, sessionID, refreshTokenID, actor, key, remaining); err != nil {
+		return "", "", err
+	}
+	return
+}
+
 func ValidateAccessToken(token string, keyFunc jwt.Keyfunc) (*AccessToken, error) {
 	claims := AccessToken{}
 
@@ -116,13 +205,28 @@ func ValidateRefreshToken(token string, keyFunc jwt.Keyfunc) (*RefreshToken, err
 	}
 
 	// check if claims is created from normal auth, not from oauth
-	if claims.Issuer != AccessTokenIssuer {
+	if claims.Issuer != RefreshTokenIssuer {
 		return nil, jwt.ErrTokenInvalidClaims
 	}
 
 	return &claims, nil
 }
 
+// ExpiredRefreshToken returns the claims of token when it is valid except
+// for having expired.
+func ExpiredRefreshToken(token string, keyFunc jwt.Keyfunc) (*RefreshToken, bool) {
+	claims := RefreshToken{}
+
+	// the signature is checked even once the claims fail validation
+	_, err := jwt.ParseWithClaims(token, &claims, keyFunc)
+	var vErr *jwt.ValidationError
+	if !errors.As(err, &vErr) || vErr.Errors != jwt.ValidationErrorExpired || claims.Issuer != RefreshTokenIssuer {
+		return nil, false
+	}
+
+	return &claims, true
+}
+
 func getKey() []byte {
 	k := ""
 	if key == nil {
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/user"
+)
+
+func TestTokenPair_notInterchangeable(t *testing.T) {
+	key := []byte("key")
+	usr := user.User{ID: 1, Email: "alice@example.com"}
+
+	at, rt, err := GenerateTokenPair(usr, rbac.Grants{}, "sid", "jti", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateAccessToken(at, DefaultJwtKeyFunc(key)); err != nil {
+		t.Errorf("ValidateAccessToken(access token) error = %v", err)
+	}
+	if _, err := ValidateRefreshToken(rt, DefaultJwtKeyFunc(key)); err != nil {
+		t.Errorf("ValidateRefreshToken(refresh token) error = %v", err)
+	}
+	if claims, err := ValidateAccessToken(rt, DefaultJwtKeyFunc(key)); err == nil {
+		t.Errorf("ValidateAccessToken(refresh token) = %+v, want an error", claims)
+	}
+	if claims, err := ValidateRefreshToken(at, DefaultJwtKeyFunc(key)); err == nil {
+		t.Errorf("ValidateRefreshToken(access token) = %+v, want an error", claims)
+	}
+}
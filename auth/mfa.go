@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/9d4/semaphore/user"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// MFATokenIssuer is distinct from AccessTokenIssuer so an MFA token can't
+// be used as an access or refresh token.
+const MFATokenIssuer = "semaphore-mfa"
+
+// MFATokenExpiration is how long the user has to complete the second factor.
+const MFATokenExpiration = time.Minute * 5
+
+// MFAToken represents jwt claims for a login that passed the password check
+// and is waiting for the second factor.
+type MFAToken struct {
+	jwt.RegisteredClaims
+	// Enroll is set when the user has to enroll a second factor first.
+	Enroll bool `json:"enroll,omitempty"`
+}
+
+func GenerateMFAToken(usr user.User, enroll bool, key []byte) (string, error) {
+	claims := MFAToken{Enroll: enroll}
+	claims.Issuer = MFATokenIssuer
+	// the codes answered to a token are counted by its id
+	claims.ID = uuid.New().String()
+	claims.Subject = fmt.Sprint(usr.ID)
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(MFATokenExpiration))
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+func ValidateMFAToken(token string, keyFunc jwt.Keyfunc) (*MFAToken, error) {
+	claims := MFAToken{}
+
+	tk, err := jwt.ParseWithClaims(token, &claims, keyFunc)
+	if err != nil || !tk.Valid {
+		return nil, err
+	}
+
+	if claims.Issuer != MFATokenIssuer || claims.ID == "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return &claims, nil
+}
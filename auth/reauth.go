@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ReauthTokenIssuer is distinct from the other issuers so a reauth token
+// can't be used as any other token.
+const ReauthTokenIssuer = "semaphore-reauth"
+
+// ReauthTokenExpiration is how long the user has to sign in again before
+// coming back to the authorization request.
+const ReauthTokenExpiration = time.Minute * 10
+
+// ReauthToken represents jwt claims for an authorization request the user
+// was sent to sign in again for, at IssuedAt.
+type ReauthToken struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+	ClientID  string `json:"client_id"`
+}
+
+func GenerateReauthToken(sessionID string, clientID string, key []byte) (string, error) {
+	claims := ReauthToken{SessionID: sessionID, ClientID: clientID}
+	claims.Issuer = ReauthTokenIssuer
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(ReauthTokenExpiration))
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+func ValidateReauthToken(token string, keyFunc jwt.Keyfunc) (*ReauthToken, error) {
+	claims := ReauthToken{}
+
+	tk, err := jwt.ParseWithClaims(token, &claims, keyFunc)
+	if err != nil || !tk.Valid {
+		return nil, err
+	}
+
+	if claims.Issuer != ReauthTokenIssuer || claims.IssuedAt == nil {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/9d4/semaphore/user"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// EmailVerificationTokenIssuer is distinct from the other issuers so a
+// verification link can't be used as any other token.
+const EmailVerificationTokenIssuer = "semaphore-email-verification"
+
+// EmailVerificationTokenExpiration is how long a verification link works.
+const EmailVerificationTokenExpiration = time.Hour * 24
+
+// EmailVerificationToken represents jwt claims of an email verification
+// link. The address is part of it so the link stops working once the
+// user changes it.
+type EmailVerificationToken struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+func GenerateEmailVerificationToken(usr user.User, key []byte) (string, error) {
+	claims := EmailVerificationToken{Email: usr.Email}
+	claims.Issuer = EmailVerificationTokenIssuer
+	claims.Subject = fmt.Sprint(usr.ID)
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(EmailVerificationTokenExpiration))
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+func ValidateEmailVerificationToken(token string, keyFunc jwt.Keyfunc) (*EmailVerificationToken, error) {
+	claims := EmailVerificationToken{}
+
+	tk, err := jwt.ParseWithClaims(token, &claims, keyFunc)
+	if err != nil || !tk.Valid {
+		return nil, err
+	}
+
+	if claims.Issuer != EmailVerificationTokenIssuer {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return &claims, nil
+}
+
+// EmailChangeTokenIssuer is distinct from EmailVerificationTokenIssuer so
+// verifying an address can't switch to it.
+const EmailChangeTokenIssuer = "semaphore-email-change"
+
+// EmailChangeToken represents jwt claims of the link confirming a new
+// email address. It only switches from Email, the link stops working once
+// the address has changed otherwise.
+type EmailChangeToken struct {
+	jwt.RegisteredClaims
+	Email    string `json:"email"`
+	NewEmail string `json:"new_email"`
+}
+
+func GenerateEmailChangeToken(usr user.User, newEmail string, key []byte) (string, error) {
+	claims := EmailChangeToken{Email: usr.Email, NewEmail: newEmail}
+	claims.Issuer = EmailChangeTokenIssuer
+	claims.Subject = fmt.Sprint(usr.ID)
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(EmailVerificationTokenExpiration))
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+func ValidateEmailChangeToken(token string, keyFunc jwt.Keyfunc) (*EmailChangeToken, error) {
+	claims := EmailChangeToken{}
+
+	tk, err := jwt.ParseWithClaims(token, &claims, keyFunc)
+	if err != nil || !tk.Valid {
+		return nil, err
+	}
+
+	if claims.Issuer != EmailChangeTokenIssuer {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	osuser "os/user"
+	"strconv"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().Uint("actor", 0, "ID of the user who acted")
+	auditCmd.Flags().String("action", "", "What was done, e.g. login or user_created")
+	auditCmd.Flags().String("target-type", "", "Type of what it was done to: user, session, client, role, group or tenant")
+	auditCmd.Flags().String("target", "", "ID of what it was done to")
+	auditCmd.Flags().String("outcome", "", "success or failure")
+	auditCmd.Flags().String("since", "", "RFC 3339 time of the oldest event")
+	auditCmd.Flags().String("until", "", "RFC 3339 time the events happened before")
+	auditCmd.Flags().String("tenant", "", "Slug of the tenant, every tenant when left out")
+	auditCmd.Flags().Int("limit", 0, "Number of events at most, newest first, every event when 0")
+	auditCmd.Flags().String("format", "json", "json or csv")
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Export the audit log, newest events first",
+	Args:  cobra.NoArgs,
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		flags := cmd.Flags()
+		actor, _ := flags.GetUint("actor")
+		limit, _ := flags.GetInt("limit")
+		format, _ := flags.GetString("format")
+		if format != "json" && format != "csv" {
+			jww.FATAL.Fatal("format is json or csv")
+			return
+		}
+
+		q := audit.Query{ActorID: actor}
+		q.Action, _ = flags.GetString("action")
+		q.TargetType, _ = flags.GetString("target-type")
+		q.TargetID, _ = flags.GetString("target")
+		q.Outcome, _ = flags.GetString("outcome")
+
+		var err error
+		if q.Since, err = flagTime(cmd, "since"); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		if q.Until, err = flagTime(cmd, "until"); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		if slug, _ := flags.GetString("tenant"); slug != "" {
+			t, err := tenant.NewStore(passData.db).Tenant(slug)
+			if err != nil {
+				jww.FATAL.Fatal(err)
+				return
+			}
+			q.TenantID = &t.ID
+		}
+
+		store := audit.NewStore(passData.db)
+		events := []*audit.Event{}
+		for {
+			q.Limit = audit.MaxListLimit
+			if limit > 0 && limit-len(events) < q.Limit {
+				q.Limit = limit - len(events)
+			}
+
+			page, next, err := store.Events(q)
+			if err != nil {
+				jww.FATAL.Fatal(err)
+				return
+			}
+			events = append(events, page...)
+
+			if next == "" || (limit > 0 && len(events) >= limit) {
+				break
+			}
+			q.Cursor = next
+		}
+
+		if format == "csv" {
+			err = audit.WriteCSV(os.Stdout, events)
+		} else {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(events)
+		}
+		if err != nil {
+			jww.FATAL.Fatal(err)
+		}
+	}),
+}
+
+// flagTime parses the RFC 3339 time in the name flag, zero when it is
+// left out.
+func flagTime(cmd *cobra.Command, name string) (time.Time, error) {
+	value, _ := cmd.Flags().GetString(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// auditCommand records what cmd has done in the audit log. The actor is
+// the operating system user running it.
+func auditCommand(cmd *cobra.Command, passData *bootData, e audit.Event) {
+	e.Actor = "cli"
+	if u, err := osuser.Current(); err == nil {
+		e.Actor = "cli:" + u.Username
+	}
+	e.Outcome = audit.OutcomeSuccess
+	if e.Metadata == nil {
+		e.Metadata = map[string]interface{}{}
+	}
+	e.Metadata["command"] = cmd.CommandPath()
+
+	if err := audit.NewStore(passData.db).Record(&e); err != nil {
+		jww.ERROR.Println("unable to record audit event:", err)
+	}
+}
+
+// userEvent is an event of action done to usr.
+func userEvent(action string, usr *user.User) audit.Event {
+	return audit.Event{
+		TenantID:   usr.TenantID,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   strconv.FormatUint(uint64(usr.ID), 10),
+	}
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/federation"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+func init() {
+	rootCmd.AddCommand(connectorCmd)
+	connectorCmd.AddCommand(connectorListCmd)
+	connectorCmd.AddCommand(connectorAddCmd)
+	connectorCmd.AddCommand(connectorDeleteCmd)
+
+	connectorCmd.PersistentFlags().String("tenant", "", "Slug of the tenant the connector belongs to, the default one when left out")
+
+	flags := connectorAddCmd.Flags()
+	flags.String("kind", federation.KindOIDC, "Kind of the provider, oidc or oauth2")
+	flags.String("issuer", "", "Issuer of an OpenID Connect provider, its endpoints are discovered from it")
+	flags.String("auth-url", "", "Authorization endpoint, required for oauth2")
+	flags.String("token-url", "", "Token endpoint, required for oauth2")
+	flags.String("userinfo-url", "", "Userinfo endpoint, required for oauth2")
+	flags.String("client-id", "", "Client ID semaphore is registered with at the provider")
+	flags.String("client-secret", "", "Client secret semaphore is registered with at the provider")
+	flags.StringSlice("scope", nil, "Scope to request, can be repeated, email and profile when left out for oidc")
+	flags.String("claim-subject", "", "Claim identifying the user, sub when left out")
+	flags.String("claim-email", "", "Claim holding the email address, email when left out")
+	flags.String("claim-email-verified", "", "Claim telling whether the address is verified, email_verified when left out")
+	flags.String("claim-firstname", "", "Claim holding the first name, given_name when left out")
+	flags.String("claim-lastname", "", "Claim holding the last name, family_name when left out")
+	flags.StringSlice("domain", nil, "Email domain allowed to sign in, can be repeated, any when left out")
+	flags.Bool("trust-email", false, "Consider addresses verified when the provider doesn't say")
+	flags.Bool("provision", false, "Create the users signing in for the first time")
+}
+
+var connectorCmd = &cobra.Command{
+	Use:   "connector",
+	Short: "Upstream identity provider utilities",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var connectorListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the identity providers users can sign in with",
+	Args:  cobra.NoArgs,
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		connectors, err := federation.NewStore(passData.db).Connectors(connectorTenant(cmd, passData))
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		for _, c := range connectors {
+			endpoint := c.Issuer
+			if c.Kind == federation.KindOAuth2 {
+				endpoint = c.AuthURL
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Slug, c.Name, c.Kind, endpoint)
+		}
+		tw.Flush()
+	}),
+}
+
+var connectorAddCmd = &cobra.Command{
+	Use:   "add [slug] [name]",
+	Short: "Add an identity provider users can sign in with",
+	Long: `Add an identity provider users can sign in with.
+
+The provider has to send users back to [issuer]/auth/federation/[slug]/callback,
+or [issuer]/t/[tenant]/auth/federation/[slug]/callback for other tenants.`,
+	Args: cobra.ExactArgs(2),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		flags := cmd.Flags()
+		c := &federation.Connector{
+			TenantID: connectorTenant(cmd, passData),
+			Slug:     args[0],
+			Name:     args[1],
+		}
+		c.Kind, _ = flags.GetString("kind")
+		c.Issuer, _ = flags.GetString("issuer")
+		c.AuthURL, _ = flags.GetString("auth-url")
+		c.TokenURL, _ = flags.GetString("token-url")
+		c.UserinfoURL, _ = flags.GetString("userinfo-url")
+		c.ClientID, _ = flags.GetString("client-id")
+		c.ClientSecret, _ = flags.GetString("client-secret")
+		c.Scopes, _ = flags.GetStringSlice("scope")
+		c.Claims.Subject, _ = flags.GetString("claim-subject")
+		c.Claims.Email, _ = flags.GetString("claim-email")
+		c.Claims.EmailVerified, _ = flags.GetString("claim-email-verified")
+		c.Claims.FirstName, _ = flags.GetString("claim-firstname")
+		c.Claims.LastName, _ = flags.GetString("claim-lastname")
+		c.Domains, _ = flags.GetStringSlice("domain")
+		c.TrustEmail, _ = flags.GetBool("trust-email")
+		c.Provision, _ = flags.GetBool("provision")
+
+		if err := federation.NewStore(passData.db).Create(c); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{
+			TenantID:   c.TenantID,
+			Action:     "connector_created",
+			TargetType: audit.TargetConnector,
+			TargetID:   c.Slug,
+			Metadata: map[string]interface{}{
+				"kind":      c.Kind,
+				"client_id": c.ClientID,
+				"provision": c.Provision,
+			},
+		})
+
+		fmt.Println(c.Slug, "is added")
+	}),
+}
+
+var connectorDeleteCmd = &cobra.Command{
+	Use:   "delete [slug]",
+	Short: "Delete an identity provider and unlink the identities signed in with it",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		tenantID := connectorTenant(cmd, passData)
+		if err := federation.NewStore(passData.db).Delete(tenantID, args[0]); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{
+			TenantID:   tenantID,
+			Action:     "connector_deleted",
+			TargetType: audit.TargetConnector,
+			TargetID:   args[0],
+		})
+
+		fmt.Println(args[0], "is deleted")
+	}),
+}
+
+// connectorTenant gets the ID of the tenant in the tenant flag, commands
+// that manage what belongs to a tenant share it.
+func connectorTenant(cmd *cobra.Command, passData *bootData) uint {
+	slug, _ := cmd.Flags().GetString("tenant")
+	if slug == "" || slug == tenant.DefaultSlug {
+		return 0
+	}
+
+	t, err := tenant.NewStore(passData.db).Tenant(slug)
+	if err != nil {
+		jww.FATAL.Fatal(err)
+	}
+	return t.ID
+}
@@ -3,17 +3,28 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/oauth2"
 	"github.com/9d4/semaphore/oauth2/models"
 	"github.com/9d4/semaphore/oauth2/store"
+	"github.com/9d4/semaphore/tenant"
 	"github.com/spf13/cobra"
 	jww "github.com/spf13/jwalterweatherman"
 	"os"
+	"strings"
 	"text/tabwriter"
 )
 
 func init() {
 	rootCmd.AddCommand(oAuthCmd)
 	oAuthCmd.AddCommand(oAuthAddCmd)
+
+	oAuthAddCmd.Flags().StringSlice("post-logout-redirect-uri", nil, "URI the client may be sent to after logout, can be repeated")
+	oAuthAddCmd.Flags().String("frontchannel-logout-uri", "", "URI loaded in an iframe on logout")
+	oAuthAddCmd.Flags().String("backchannel-logout-uri", "", "URI the logout token is posted to on logout")
+	oAuthAddCmd.Flags().Bool("roles-claim", false, "Include the roles of the user in its access and ID tokens")
+	oAuthAddCmd.Flags().Bool("groups-claim", false, "Include the groups of the user in its access and ID tokens")
+	oAuthAddCmd.Flags().String("tenant", "", "Slug of the tenant the client belongs to, the default one when left out")
 }
 
 var oAuthCmd = &cobra.Command{
@@ -29,11 +40,33 @@ var oAuthAddCmd = &cobra.Command{
 	Short: "Add new client app",
 	Args:  cobra.ExactArgs(3),
 	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		postLogoutRedirectURIs, _ := cmd.Flags().GetStringSlice("post-logout-redirect-uri")
+		frontchannelLogoutURI, _ := cmd.Flags().GetString("frontchannel-logout-uri")
+		backchannelLogoutURI, _ := cmd.Flags().GetString("backchannel-logout-uri")
+		rolesClaim, _ := cmd.Flags().GetBool("roles-claim")
+		groupsClaim, _ := cmd.Flags().GetBool("groups-claim")
+		tenantSlug, _ := cmd.Flags().GetString("tenant")
+
 		clientStore := store.NewClientStoreRedis(passData.rdb)
+		var tenantID uint
+		if tenantSlug != "" && tenantSlug != tenant.DefaultSlug {
+			t, err := tenant.NewStore(passData.db).Tenant(tenantSlug)
+			if err != nil {
+				jww.FATAL.Fatal(err)
+				return
+			}
+			clientStore = store.NewTenantClientStoreRedis(passData.rdb, t.Slug)
+			tenantID = t.ID
+		}
 		err := clientStore.Set(args[0], &models.Client{
-			ID:     args[0],
-			Secret: args[2],
-			Domain: args[1],
+			ID:                     args[0],
+			Secret:                 args[2],
+			Domain:                 args[1],
+			PostLogoutRedirectURIs: postLogoutRedirectURIs,
+			FrontchannelLogoutURI:  frontchannelLogoutURI,
+			BackchannelLogoutURI:   backchannelLogoutURI,
+			RolesClaim:             rolesClaim,
+			GroupsClaim:            groupsClaim,
 		})
 		if err != nil {
 			jww.FATAL.Fatal(err)
@@ -45,12 +78,28 @@ var oAuthAddCmd = &cobra.Command{
 			jww.FATAL.Fatal(err)
 			return
 		}
+		auditCommand(cmd, passData, audit.Event{
+			TenantID:   tenantID,
+			Action:     "client_created",
+			TargetType: audit.TargetClient,
+			TargetID:   cli.GetID(),
+			Metadata:   map[string]interface{}{"domain": cli.GetDomain()},
+		})
 
 		tw := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
 		fmt.Println("Created!")
 		fmt.Fprintf(tw, "ClientID\t :%s\n", cli.GetID())
 		fmt.Fprintf(tw, "Secret\t :%s\n", cli.GetSecret())
 		fmt.Fprintf(tw, "Domain\t :%s\n", cli.GetDomain())
+		if logoutInfo, ok := cli.(oauth2.ClientLogoutInfo); ok {
+			fmt.Fprintf(tw, "PostLogoutRedirectURIs\t :%s\n", strings.Join(logoutInfo.GetPostLogoutRedirectURIs(), " "))
+			fmt.Fprintf(tw, "FrontchannelLogoutURI\t :%s\n", logoutInfo.GetFrontchannelLogoutURI())
+			fmt.Fprintf(tw, "BackchannelLogoutURI\t :%s\n", logoutInfo.GetBackchannelLogoutURI())
+		}
+		if claimsInfo, ok := cli.(oauth2.ClientClaimsInfo); ok {
+			fmt.Fprintf(tw, "RolesClaim\t :%t\n", claimsInfo.GetRolesClaim())
+			fmt.Fprintf(tw, "GroupsClaim\t :%t\n", claimsInfo.GetGroupsClaim())
+		}
 		tw.Flush()
 	}),
 }
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/user"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+func init() {
+	rootCmd.AddCommand(roleCmd)
+	roleCmd.AddCommand(roleListCmd)
+	roleCmd.AddCommand(roleSetCmd)
+	roleCmd.AddCommand(roleDeleteCmd)
+	roleCmd.AddCommand(roleAssignCmd)
+	roleCmd.AddCommand(roleUnassignCmd)
+
+	rootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(groupListCmd)
+	groupCmd.AddCommand(groupSetCmd)
+	groupCmd.AddCommand(groupDeleteCmd)
+	groupCmd.AddCommand(groupAddCmd)
+	groupCmd.AddCommand(groupRemoveCmd)
+
+	roleSetCmd.Flags().String("description", "", "What the role is for")
+	groupSetCmd.Flags().String("description", "", "What the group is for")
+}
+
+var roleCmd = &cobra.Command{
+	Use:   "role",
+	Short: "Role utilities",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var roleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the roles and their permissions",
+	Args:  cobra.NoArgs,
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		roles, err := rbac.NewStore(passData.db).Roles()
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		for _, r := range roles {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, strings.Join(r.Permissions, " "), r.Description)
+		}
+		tw.Flush()
+	}),
+}
+
+var roleSetCmd = &cobra.Command{
+	Use:   "set [name] [permission...]",
+	Short: "Create a role, or replace the permissions of an existing one",
+	Args:  cobra.MinimumNArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		description, _ := cmd.Flags().GetString("description")
+
+		r := &rbac.Role{Name: args[0], Description: description, Permissions: args[1:]}
+		if err := rbac.NewStore(passData.db).SaveRole(r); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{
+			Action:     "role_saved",
+			TargetType: audit.TargetRole,
+			TargetID:   r.Name,
+			Metadata:   map[string]interface{}{"permissions": r.Permissions},
+		})
+
+		fmt.Printf("%s grants %s\n", r.Name, strings.Join(r.Permissions, " "))
+	}),
+}
+
+var roleDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a role, taking it away from its users and groups",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		if err := rbac.NewStore(passData.db).DeleteRole(args[0]); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{Action: "role_deleted", TargetType: audit.TargetRole, TargetID: args[0]})
+
+		fmt.Println(args[0], "is deleted")
+	}),
+}
+
+var roleAssignCmd = &cobra.Command{
+	Use:   "assign [email] [role]",
+	Short: "Assign a role to a user",
+	Args:  cobra.ExactArgs(2),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		usr, err := user.NewStore(passData.db).UserByEmail(args[0])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		if err := rbac.NewStore(passData.db).AssignRole(usr.ID, args[1]); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		e := userEvent("role_assigned", usr)
+		e.Metadata = map[string]interface{}{"role": args[1]}
+		auditCommand(cmd, passData, e)
+
+		fmt.Println(usr.Email, "now has the role", args[1])
+	}),
+}
+
+var roleUnassignCmd = &cobra.Command{
+	Use:   "unassign [email] [role]",
+	Short: "Take a role assigned directly to a user away",
+	Args:  cobra.ExactArgs(2),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		usr, err := user.NewStore(passData.db).UserByEmail(args[0])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		if err := rbac.NewStore(passData.db).UnassignRole(usr.ID, args[1]); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		e := userEvent("role_unassigned", usr)
+		e.Metadata = map[string]interface{}{"role": args[1]}
+		auditCommand(cmd, passData, e)
+
+		fmt.Println(usr.Email, "no longer has the role", args[1], "directly")
+	}),
+}
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Group utilities",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var groupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the groups and their roles",
+	Args:  cobra.NoArgs,
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		groups, err := rbac.NewStore(passData.db).Groups()
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		for _, g := range groups {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", g.Name, strings.Join(g.Roles, " "), g.Description)
+		}
+		tw.Flush()
+	}),
+}
+
+var groupSetCmd = &cobra.Command{
+	Use:   "set [name] [role...]",
+	Short: "Create a group, or replace the roles of an existing one",
+	Args:  cobra.MinimumNArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		description, _ := cmd.Flags().GetString("description")
+
+		g := &rbac.Group{Name: args[0], Description: description, Roles: args[1:]}
+		if err := rbac.NewStore(passData.db).SaveGroup(g); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{
+			Action:     "group_saved",
+			TargetType: audit.TargetGroup,
+			TargetID:   g.Name,
+			Metadata:   map[string]interface{}{"roles": g.Roles},
+		})
+
+		fmt.Printf("members of %s have %s\n", g.Name, strings.Join(g.Roles, " "))
+	}),
+}
+
+var groupDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a group and its memberships",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		if err := rbac.NewStore(passData.db).DeleteGroup(args[0]); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{Action: "group_deleted", TargetType: audit.TargetGroup, TargetID: args[0]})
+
+		fmt.Println(args[0], "is deleted")
+	}),
+}
+
+var groupAddCmd = &cobra.Command{
+	Use:   "add [name] [email]",
+	Short: "Add a user to a group",
+	Args:  cobra.ExactArgs(2),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		usr, err := user.NewStore(passData.db).UserByEmail(args[1])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		if err := rbac.NewStore(passData.db).AddMember(args[0], usr.ID); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		e := userEvent("group_member_added", usr)
+		e.Metadata = map[string]interface{}{"group": args[0]}
+		auditCommand(cmd, passData, e)
+
+		fmt.Println(usr.Email, "is now a member of", args[0])
+	}),
+}
+
+var groupRemoveCmd = &cobra.Command{
+	Use:   "remove [name] [email]",
+	Short: "Remove a user from a group",
+	Args:  cobra.ExactArgs(2),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		usr, err := user.NewStore(passData.db).UserByEmail(args[1])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		if err := rbac.NewStore(passData.db).RemoveMember(args[0], usr.ID); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		e := userEvent("group_member_removed", usr)
+		e.Metadata = map[string]interface{}{"group": args[0]}
+		auditCommand(cmd, passData, e)
+
+		fmt.Println(usr.Email, "is no longer a member of", args[0])
+	}),
+}
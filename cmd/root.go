@@ -1,9 +1,14 @@
 package cmd
 
 import (
+	"context"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/9d4/semaphore/server"
 	"github.com/joho/godotenv"
@@ -18,22 +23,24 @@ var rootCmd = cobra.Command{
 	Short: "Start semaphore server.",
 	Long:  "Semaphore is blablabla..........",
 	Run: func(cmd *cobra.Command, args []string) {
-		srvErr, oauthSrvErr := server.Start(server.ParseViper(v))
-		log.Fatal(<-oauthSrvErr)
-		for {
-			select {
-			case err := <-oauthSrvErr:
-				jww.FATAL.Fatal(err)
-			case err := <-srvErr:
-				jww.FATAL.Fatal(err)
-			default:
-
-			}
+		srv, err := server.New(server.ParseViper(v))
+		if err != nil {
+			jww.FATAL.Fatal(err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err = srv.Run(ctx)
+		closeLogger()
+		if err != nil {
+			jww.FATAL.Fatal(err)
 		}
 	},
 }
 
 var (
+	logFile     *os.File
 	v           = viper.NewWithOptions(viper.EnvKeyReplacer(strings.NewReplacer("-", "_")))
 	globalFlags = flag.NewFlagSet(rootCmd.Name(), flag.ContinueOnError)
 	serverFlags = flag.NewFlagSet(rootCmd.Name(), flag.ContinueOnError)
@@ -74,6 +81,47 @@ func loadConfig() {
 
 func loadFlags() {
 	serverFlags.StringP("address", "a", "0.0.0.0:3500", "Address to listen on")
+	serverFlags.String("oauth-address", "", "Address to serve OAuth2 endpoints on, defaults to the main address")
+	serverFlags.String("cors-origins", "*", "Comma separated origins allowed to make cross-origin requests")
+	serverFlags.Int("rate-limit", 600, "Requests a client can make per minute")
+	serverFlags.Duration("shutdown-timeout", 15*time.Second, "How long to wait for in-flight requests on shutdown")
+	serverFlags.String("issuer", "http://semaphore.test", "Issuer URL used in OAuth2 and OpenID Connect tokens")
+	serverFlags.String("webauthn-origin", "", "Origin passkeys are registered on, defaults to the issuer")
+	serverFlags.String("mailer", "dir", "How emails are sent: smtp, or dir to write them to mail-dir")
+	serverFlags.String("mail-dir", "mail", "Directory emails are written to by the dir mailer")
+	serverFlags.String("mail-from", "Semaphore <noreply@semaphore.test>", "Sender address of emails")
+	serverFlags.String("smtp-host", "", "SMTP server host")
+	serverFlags.Int("smtp-port", 587, "SMTP server port")
+	serverFlags.String("smtp-username", "", "SMTP username")
+	serverFlags.String("smtp-password", "", "SMTP password")
+	serverFlags.String("verify-email", "off", "Require a verified email address: off, login to block login, oauth to block OAuth authorization")
+	serverFlags.Int("lockout-threshold", 10, "Failed logins in a row that lock an account, 0 never locks")
+	serverFlags.Duration("lockout-duration", 15*time.Minute, "How long a locked account or ip stays locked")
+	serverFlags.Int("lockout-ip-threshold", 100, "Failed logins from one ip that lock it out, 0 never locks")
+	serverFlags.Duration("impersonation-duration", 30*time.Minute, "How long an admin can act as another user")
+	serverFlags.Int("password-min-length", 8, "Characters a password has at least")
+	serverFlags.Int("password-max-length", 128, "Characters a password has at most")
+	serverFlags.Int("password-classes", 0, "How many of lowercase, uppercase, digits and symbols a password mixes")
+	serverFlags.Int("password-history", 0, "Previous passwords, the current one included, a new password can't repeat")
+	serverFlags.String("password-breached-file", "", "Sorted SHA-1 Pwned Passwords file of breached passwords to refuse")
+	serverFlags.Uint32("password-hash-time", 3, "Argon2id passes over memory when hashing passwords")
+	serverFlags.Uint32("password-hash-memory", 64*1024, "Argon2id memory in KiB when hashing passwords")
+	serverFlags.Uint8("password-hash-threads", 4, "Argon2id threads when hashing passwords")
+	serverFlags.String("ldap-url", "", "LDAP directory users of the default tenant sign in with, ldap:// or ldaps://")
+	serverFlags.Bool("ldap-start-tls", false, "Upgrade ldap:// connections with StartTLS")
+	serverFlags.String("ldap-ca-file", "", "PEM certificates the directory is verified with, the system roots when empty")
+	serverFlags.String("ldap-bind-dn", "", "DN users are searched with, their own credentials when empty")
+	serverFlags.String("ldap-bind-password", "", "Password of ldap-bind-dn")
+	serverFlags.String("ldap-user-dn", "", "DN users bind as, %s being their login, instead of searching them")
+	serverFlags.String("ldap-base-dn", "", "DN users are searched in")
+	serverFlags.String("ldap-user-filter", "(mail=%s)", "Filter finding a user by its login, %s being the login")
+	serverFlags.String("ldap-sync-filter", "(objectClass=person)", "Filter finding the users to sync")
+	serverFlags.String("ldap-email-attr", "mail", "Attribute holding the email address of a user")
+	serverFlags.String("ldap-firstname-attr", "givenName", "Attribute holding the first name of a user")
+	serverFlags.String("ldap-lastname-attr", "sn", "Attribute holding the last name of a user")
+	serverFlags.String("ldap-group-attr", "memberOf", "Attribute holding the group DNs of a user")
+	serverFlags.StringArray("ldap-group-role", nil, "Role the members of a group have, as role=group dn, can be repeated")
+	serverFlags.Duration("ldap-sync-interval", 0, "How often directory users are synced into semaphore, never when zero")
 
 	globalFlags.String("db-host", "127.0.0.1", "Database host")
 	globalFlags.String("db-port", "5432", "Database port")
@@ -90,6 +138,7 @@ func loadLogger() {
 	}
 
 	if err == nil {
+		logFile = logWriter
 		jww.SetLogOutput(logWriter)
 	}
 
@@ -97,3 +146,15 @@ func loadLogger() {
 	jww.SetLogThreshold(jww.LevelTrace)
 	jww.SetStdoutThreshold(jww.LevelInfo)
 }
+
+// closeLogger flushes the log file so nothing logged while shutting down is
+// lost.
+func closeLogger() {
+	if logFile == nil {
+		return
+	}
+
+	jww.SetLogOutput(io.Discard)
+	_ = logFile.Sync()
+	_ = logFile.Close()
+}
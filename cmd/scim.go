@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/scim"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+func init() {
+	rootCmd.AddCommand(scimCmd)
+	scimCmd.AddCommand(scimListCmd)
+	scimCmd.AddCommand(scimAddCmd)
+	scimCmd.AddCommand(scimDeleteCmd)
+
+	scimCmd.PersistentFlags().String("tenant", "", "Slug of the tenant the client provisions users of, the default one when left out")
+}
+
+var scimCmd = &cobra.Command{
+	Use:   "scim",
+	Short: "SCIM provisioning client utilities",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var scimListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the tokens of the provisioning clients",
+	Args:  cobra.NoArgs,
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		tokens, err := scim.NewStore(passData.db).Tokens(connectorTenant(cmd, passData))
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		for _, t := range tokens {
+			lastUsed := "never"
+			if t.LastUsedAt != nil {
+				lastUsed = t.LastUsedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, t.CreatedAt.Format(time.RFC3339), lastUsed)
+		}
+		tw.Flush()
+	}),
+}
+
+var scimAddCmd = &cobra.Command{
+	Use:   "add [name]",
+	Short: "Add a token a provisioning client manages users and groups with",
+	Long: `Add a token a provisioning client manages users and groups with.
+
+The client sends it as a bearer token to [issuer]/scim/v2. The token is
+printed once, only its hash is kept. Only the clients of the default tenant
+manage groups.`,
+	Args: cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		tenantID := connectorTenant(cmd, passData)
+		token, t, err := scim.NewStore(passData.db).Create(tenantID, args[0])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{
+			TenantID:   tenantID,
+			Action:     "scim_token_created",
+			TargetType: audit.TargetSCIMToken,
+			TargetID:   t.Name,
+		})
+
+		fmt.Println(token)
+	}),
+}
+
+var scimDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete the token of a provisioning client",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		tenantID := connectorTenant(cmd, passData)
+		if err := scim.NewStore(passData.db).Delete(tenantID, args[0]); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{
+			TenantID:   tenantID,
+			Action:     "scim_token_deleted",
+			TargetType: audit.TargetSCIMToken,
+			TargetID:   args[0],
+		})
+
+		fmt.Println(args[0], "is deleted")
+	}),
+}
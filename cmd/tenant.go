@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+func init() {
+	rootCmd.AddCommand(tenantCmd)
+	tenantCmd.AddCommand(tenantListCmd)
+	tenantCmd.AddCommand(tenantCreateCmd)
+	tenantCmd.AddCommand(tenantRotateKeyCmd)
+	tenantCmd.AddCommand(tenantDeleteCmd)
+
+	tenantCreateCmd.Flags().StringSlice("scope", nil, "OAuth2 scope the clients of the tenant can be granted, can be repeated, any when left out")
+}
+
+var tenantCmd = &cobra.Command{
+	Use:   "tenant",
+	Short: "Tenant utilities",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var tenantListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the tenants",
+	Args:  cobra.NoArgs,
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		tenants, err := tenant.NewStore(passData.db).Tenants()
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		for _, t := range tenants {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Slug, t.Name, strings.Join(t.Scopes, " "))
+		}
+		tw.Flush()
+	}),
+}
+
+var tenantCreateCmd = &cobra.Command{
+	Use:   "create [slug] [name]",
+	Short: "Create a tenant, its OAuth2 endpoints are served under /t/[slug]/oauth2/",
+	Args:  cobra.ExactArgs(2),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		scopes, _ := cmd.Flags().GetStringSlice("scope")
+
+		t := &tenant.Tenant{Slug: args[0], Name: args[1], Scopes: scopes}
+		if err := tenant.NewStore(passData.db).Create(t); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{Action: "tenant_created", TargetType: audit.TargetTenant, TargetID: t.Slug})
+
+		fmt.Println(t.Slug, "is created")
+	}),
+}
+
+var tenantRotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key [slug]",
+	Short: "Replace the signing key of a tenant, the tokens it has issued stop working",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		if err := tenant.NewStore(passData.db).RotateKey(args[0]); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{Action: "tenant_key_rotated", TargetType: audit.TargetTenant, TargetID: args[0]})
+
+		fmt.Println(args[0], "has a new signing key")
+	}),
+}
+
+var tenantDeleteCmd = &cobra.Command{
+	Use:   "delete [slug]",
+	Short: "Delete a tenant that has no users left",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		store := tenant.NewStore(passData.db)
+		t, err := store.Tenant(args[0])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		var users int64
+		err = passData.db.Unscoped().Model(&user.User{}).Where("tenant_id = ?", t.ID).Count(&users).Error
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		if users > 0 {
+			jww.FATAL.Fatalf("%s still has %d users", t.Slug, users)
+			return
+		}
+
+		if err := store.Delete(t.Slug); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{Action: "tenant_deleted", TargetType: audit.TargetTenant, TargetID: t.Slug})
+
+		fmt.Println(t.Slug, "is deleted")
+	}),
+}
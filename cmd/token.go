@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/user"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenAddCmd)
+	tokenCmd.AddCommand(tokenDeleteCmd)
+
+	tokenCmd.PersistentFlags().String("tenant", "", "Slug of the tenant of the user, the default one when left out")
+
+	flags := tokenAddCmd.Flags()
+	flags.StringSlice("scope", nil, "Permission of the user the token grants, can be repeated, none when left out")
+	flags.Int("expires-in", 0, "Number of days the token lasts, it doesn't expire when left out")
+}
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Personal access token utilities",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list [email]",
+	Short: "List the personal access tokens of a user",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		usr := tokenUser(cmd, passData, args[0])
+		tokens, err := pat.NewStore(passData.db).Tokens(usr.ID)
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		for _, t := range tokens {
+			expires, lastUsed := "never", "never"
+			if t.ExpiresAt != nil {
+				expires = t.ExpiresAt.Format(time.RFC3339)
+			}
+			if t.LastUsedAt != nil {
+				lastUsed = t.LastUsedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s...\t%s\t%s\t%s\n", t.ID, t.Name, t.Hint, strings.Join(t.Scopes, ","), expires, lastUsed)
+		}
+		tw.Flush()
+	}),
+}
+
+var tokenAddCmd = &cobra.Command{
+	Use:   "add [email] [name]",
+	Short: "Issue a personal access token to a user",
+	Long: `Issue a personal access token to a user.
+
+The token is sent as a bearer token in place of an access token. It is
+printed once, only its hash is kept.`,
+	Args: cobra.ExactArgs(2),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		usr := tokenUser(cmd, passData, args[0])
+
+		t := &pat.Token{UserID: usr.ID, TenantID: usr.TenantID, Name: args[1]}
+		t.Scopes, _ = cmd.Flags().GetStringSlice("scope")
+		if days, _ := cmd.Flags().GetInt("expires-in"); days > 0 {
+			expiresAt := time.Now().AddDate(0, 0, days)
+			t.ExpiresAt = &expiresAt
+		}
+
+		token, err := pat.NewStore(passData.db).Create(t)
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, tokenEvent("token_created", usr, t))
+
+		fmt.Println(token)
+	}),
+}
+
+var tokenDeleteCmd = &cobra.Command{
+	Use:   "delete [email] [id]",
+	Short: "Revoke a personal access token of a user",
+	Args:  cobra.ExactArgs(2),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		usr := tokenUser(cmd, passData, args[0])
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			jww.FATAL.Fatal(pat.ErrTokenNotFound)
+			return
+		}
+
+		if err := pat.NewStore(passData.db).Delete(usr.ID, uint(id)); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, tokenEvent("token_deleted", usr, &pat.Token{ID: uint(id)}))
+
+		fmt.Println("token", id, "is deleted")
+	}),
+}
+
+// tokenUser gets the user with the specified email in the tenant in the
+// tenant flag.
+func tokenUser(cmd *cobra.Command, passData *bootData, email string) *user.User {
+	usr, err := user.NewStore(passData.db).ForTenant(connectorTenant(cmd, passData)).UserByEmail(email)
+	if err != nil {
+		jww.FATAL.Fatal(err)
+	}
+	return usr
+}
+
+// tokenEvent is an event of action done to the personal access token t
+// of usr.
+func tokenEvent(action string, usr *user.User, t *pat.Token) audit.Event {
+	e := userEvent(action, usr)
+	e.TargetType = audit.TargetToken
+	e.TargetID = strconv.FormatUint(uint64(t.ID), 10)
+	e.Metadata = map[string]interface{}{"user": usr.ID}
+	if t.Name != "" {
+		e.Metadata["name"] = t.Name
+	}
+	return e
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/lockout"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/user"
+	"github.com/spf13/cobra"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userMFACmd)
+	userCmd.AddCommand(userVerifyCmd)
+	userCmd.AddCommand(userUnlockCmd)
+	userCmd.AddCommand(userAdminCmd)
+
+	userMFACmd.Flags().Bool("enforce", true, "Require the user to sign in with a second factor, --enforce=false lifts it")
+	userUnlockCmd.Flags().String("tenant", "", "Slug of the tenant of the user, the default one when left out")
+	userAdminCmd.Flags().Bool("grant", true, "Assign the admin role, --grant=false takes it away")
+}
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "User utilities",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var userMFACmd = &cobra.Command{
+	Use:   "mfa [email]",
+	Short: "Enforce two-factor authentication for a user",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		enforce, _ := cmd.Flags().GetBool("enforce")
+
+		usr, err := user.NewStore(passData.db).UserByEmail(args[0])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		if err := passData.db.Model(usr).Update("mfa_enforced", enforce).Error; err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		e := userEvent("mfa_enforced", usr)
+		e.Metadata = map[string]interface{}{"enforce": enforce}
+		auditCommand(cmd, passData, e)
+
+		if enforce {
+			fmt.Println("Two-factor authentication is now required for", usr.Email)
+		} else {
+			fmt.Println("Two-factor authentication is now optional for", usr.Email)
+		}
+	}),
+}
+
+var userVerifyCmd = &cobra.Command{
+	Use:   "verify [email]",
+	Short: "Mark the email address of a user as verified",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		usr, err := user.NewStore(passData.db).UserByEmail(args[0])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		if usr.EmailVerified() {
+			fmt.Println(usr.Email, "is already verified")
+			return
+		}
+
+		if err := passData.db.Model(usr).Update("email_verified_at", time.Now()).Error; err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, userEvent("email_verified", usr))
+
+		fmt.Println(usr.Email, "is now verified")
+	}),
+}
+
+var userUnlockCmd = &cobra.Command{
+	Use:   "unlock [email]",
+	Short: "Forget the failed logins of a user, lifting its lockout",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		ctx := context.Background()
+
+		var slug string
+		tenantID := connectorTenant(cmd, passData)
+		if tenantID != 0 {
+			slug, _ = cmd.Flags().GetString("tenant")
+		}
+		account := lockout.TenantAccount(slug, args[0])
+
+		// unlocking doesn't depend on the policies
+		attempts := lockout.NewStore(passData.rdb, lockout.Policy{}, lockout.Policy{})
+		failures, wait, err := attempts.Failures(ctx, account)
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		if err := attempts.Unlock(ctx, account); err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		auditCommand(cmd, passData, audit.Event{
+			TenantID:   tenantID,
+			Action:     "user_unlocked",
+			TargetType: audit.TargetUser,
+			Metadata:   map[string]interface{}{"email": args[0], "failures": failures},
+		})
+
+		if wait > 0 {
+			fmt.Printf("%s is unlocked, it was locked for %s after %d failed logins\n", args[0], wait.Round(time.Second), failures)
+		} else {
+			fmt.Printf("%s wasn't locked, %d failed logins forgotten\n", args[0], failures)
+		}
+	}),
+}
+
+var userAdminCmd = &cobra.Command{
+	Use:   "admin [email]",
+	Short: "Assign the admin role to a user",
+	Args:  cobra.ExactArgs(1),
+	Run: boot(func(cmd *cobra.Command, args []string, passData *bootData) {
+		grant, _ := cmd.Flags().GetBool("grant")
+
+		usr, err := user.NewStore(passData.db).UserByEmail(args[0])
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+
+		roles := rbac.NewStore(passData.db)
+		e := userEvent("role_assigned", usr)
+		if grant {
+			err = roles.AssignRole(usr.ID, rbac.AdminRole)
+		} else {
+			e.Action = "role_unassigned"
+			err = roles.UnassignRole(usr.ID, rbac.AdminRole)
+		}
+		if err != nil {
+			jww.FATAL.Fatal(err)
+			return
+		}
+		e.Metadata = map[string]interface{}{"role": rbac.AdminRole}
+		auditCommand(cmd, passData, e)
+
+		if grant {
+			fmt.Println(usr.Email, "is now an admin")
+		} else {
+			fmt.Println(usr.Email, "is no longer an admin")
+		}
+	}),
+}
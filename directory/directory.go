@@ -0,0 +1,342 @@
+// Package directory authenticates users against an LDAP directory, Active
+// Directory included, and lists the users it has.
+package directory
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Timeout bounds dialing the directory and each request made to it.
+const Timeout = 10 * time.Second
+
+// pageSize is how many users are asked for at once when listing them.
+const pageSize = 500
+
+// Attributes names the attributes users are mapped from.
+type Attributes struct {
+	Email     string
+	FirstName string
+	LastName  string
+	// Groups holds the DNs of the groups of the user, memberOf in Active
+	// Directory and OpenLDAP with the memberof overlay
+	Groups string
+}
+
+// DefaultAttributes are the attributes of inetOrgPerson, which Active
+// Directory has too.
+var DefaultAttributes = Attributes{
+	Email:     "mail",
+	FirstName: "givenName",
+	LastName:  "sn",
+	Groups:    "memberOf",
+}
+
+// Config is how to reach the directory and find users in it.
+type Config struct {
+	// URL of the directory, ldap:// or ldaps://
+	URL string
+	// StartTLS upgrades ldap:// connections before binding
+	StartTLS bool
+	// TLS is used by ldaps:// and StartTLS, the system roots when nil
+	TLS *tls.Config
+
+	// BindDN and BindPassword are the account users are searched with.
+	// When empty users are searched with their own credentials.
+	BindDN       string
+	BindPassword string
+
+	// UserDN is what users bind as, %s being their login. When empty users
+	// are searched in BaseDN with UserFilter and bind as the DN found.
+	UserDN string
+	// BaseDN is where users are searched
+	BaseDN string
+	// UserFilter finds a user by its login, %s being the login
+	UserFilter string
+	// SyncFilter finds every user to sync
+	SyncFilter string
+
+	// Attributes users are mapped from, DefaultAttributes for those left
+	// empty
+	Attributes Attributes
+	// GroupRoles maps the DNs of groups to the roles their members have
+	GroupRoles map[string]string
+}
+
+// Entry is a user of the directory.
+type Entry struct {
+	DN        string
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    []string
+}
+
+// Directory authenticates users against an LDAP directory.
+type Directory struct {
+	config Config
+}
+
+// New returns the directory c describes.
+// Returns ErrURLMissing or ErrUserDNMissing when it can't find users.
+func New(c Config) (*Directory, error) {
+	if c.URL == "" {
+		return nil, ErrURLMissing
+	}
+	if c.UserDN == "" && (c.BaseDN == "" || c.UserFilter == "") {
+		return nil, ErrUserDNMissing
+	}
+
+	a := &c.Attributes
+	a.Email = orDefault(a.Email, DefaultAttributes.Email)
+	a.FirstName = orDefault(a.FirstName, DefaultAttributes.FirstName)
+	a.LastName = orDefault(a.LastName, DefaultAttributes.LastName)
+	a.Groups = orDefault(a.Groups, DefaultAttributes.Groups)
+	c.SyncFilter = orDefault(c.SyncFilter, "(objectClass=person)")
+
+	// DNs are case insensitive
+	groupRoles := make(map[string]string, len(c.GroupRoles))
+	for group, role := range c.GroupRoles {
+		groupRoles[strings.ToLower(group)] = role
+	}
+	c.GroupRoles = groupRoles
+
+	return &Directory{config: c}, nil
+}
+
+// ParseGroupRoles parses mappings of the form role=group dn.
+// Returns ErrGroupRoleInvalid for a mapping of another form.
+func ParseGroupRoles(mappings []string) (map[string]string, error) {
+	groupRoles := make(map[string]string, len(mappings))
+	for _, m := range mappings {
+		role, group, ok := strings.Cut(m, "=")
+		if !ok || role == "" || group == "" {
+			return nil, fmt.Errorf("%w: %q", ErrGroupRoleInvalid, m)
+		}
+		groupRoles[group] = role
+	}
+	return groupRoles, nil
+}
+
+// Authenticate binds as the user with login and password and returns its
+// entry.
+// Returns ErrInvalidCredentials if the directory refuses them,
+// ErrUserNotFound or ErrUserAmbiguous when login doesn't match one user.
+func (d *Directory) Authenticate(login, password string) (*Entry, error) {
+	// an empty password would be an unauthenticated bind, which succeeds
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.config.UserDN != "" {
+		dn := fmt.Sprintf(d.config.UserDN, escapeDN(login))
+		if err := bind(conn, dn, password, ErrInvalidCredentials); err != nil {
+			return nil, err
+		}
+		if d.config.BaseDN == "" || d.config.UserFilter == "" {
+			return d.find(conn, dn, ldap.ScopeBaseObject, "(objectClass=*)")
+		}
+		return d.find(conn, d.config.BaseDN, ldap.ScopeWholeSubtree, d.userFilter(login))
+	}
+
+	if d.config.BindDN != "" {
+		if err := bind(conn, d.config.BindDN, d.config.BindPassword, ErrServiceBindRejected); err != nil {
+			return nil, err
+		}
+	}
+	e, err := d.find(conn, d.config.BaseDN, ldap.ScopeWholeSubtree, d.userFilter(login))
+	if err != nil {
+		return nil, err
+	}
+	if err := bind(conn, e.DN, password, ErrInvalidCredentials); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Users lists the users SyncFilter finds in BaseDN. Users without an email
+// address are left out.
+// Returns ErrBindDNMissing when there is no account to list them with.
+func (d *Directory) Users() ([]*Entry, error) {
+	if d.config.BindDN == "" || d.config.BaseDN == "" {
+		return nil, ErrBindDNMissing
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := bind(conn, d.config.BindDN, d.config.BindPassword, ErrServiceBindRejected); err != nil {
+		return nil, err
+	}
+
+	res, err := conn.SearchWithPaging(d.searchRequest(d.config.BaseDN, ldap.ScopeWholeSubtree, d.config.SyncFilter), pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		if entry := d.entry(e); entry.Email != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Roles gets the roles e has through its groups, by the group role
+// mapping.
+func (d *Directory) Roles(e *Entry) []string {
+	var roles []string
+	for _, group := range e.Groups {
+		if role, ok := d.config.GroupRoles[strings.ToLower(group)]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// MappedRoles gets every role the group role mapping gives.
+func (d *Directory) MappedRoles() []string {
+	roles := make([]string, 0, len(d.config.GroupRoles))
+	for _, role := range d.config.GroupRoles {
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+func (d *Directory) dial() (*ldap.Conn, error) {
+	opts := []ldap.DialOpt{ldap.DialWithDialer(&net.Dialer{Timeout: Timeout})}
+	if d.config.TLS != nil {
+		opts = append(opts, ldap.DialWithTLSConfig(d.config.TLS))
+	}
+
+	conn, err := ldap.DialURL(d.config.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(Timeout)
+
+	if d.config.StartTLS {
+		tlsConfig := d.config.TLS
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = hostname(d.config.URL)
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// find gets the one entry filter matches in base.
+func (d *Directory) find(conn *ldap.Conn, base string, scope int, filter string) (*Entry, error) {
+	req := d.searchRequest(base, scope, filter)
+	req.SizeLimit = 2
+
+	res, err := conn.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrUserNotFound
+	}
+	// the limit is exceeded by two users matching
+	if err != nil && (res == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded)) {
+		return nil, err
+	}
+
+	switch len(res.Entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+		e := d.entry(res.Entries[0])
+		if e.Email == "" {
+			return nil, ErrEmailMissing
+		}
+		return e, nil
+	}
+	return nil, ErrUserAmbiguous
+}
+
+func (d *Directory) searchRequest(base string, scope int, filter string) *ldap.SearchRequest {
+	a := d.config.Attributes
+	return ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, 0, int(Timeout.Seconds()), false,
+		filter, []string{a.Email, a.FirstName, a.LastName, a.Groups}, nil)
+}
+
+func (d *Directory) entry(e *ldap.Entry) *Entry {
+	a := d.config.Attributes
+	return &Entry{
+		DN:        e.DN,
+		Email:     e.GetAttributeValue(a.Email),
+		FirstName: e.GetAttributeValue(a.FirstName),
+		LastName:  e.GetAttributeValue(a.LastName),
+		Groups:    e.GetAttributeValues(a.Groups),
+	}
+}
+
+func (d *Directory) userFilter(login string) string {
+	return fmt.Sprintf(d.config.UserFilter, ldap.EscapeFilter(login))
+}
+
+// bind binds conn as dn, returning refused when the directory refuses the
+// credentials.
+func bind(conn *ldap.Conn, dn, password string, refused error) error {
+	err := conn.Bind(dn, password)
+	if ldap.IsErrorAnyOf(err, ldap.LDAPResultInvalidCredentials, ldap.LDAPResultInvalidDNSyntax, ldap.ErrorEmptyPassword) {
+		return refused
+	}
+	return err
+}
+
+// escapeDN escapes the characters RFC 4514 gives a meaning to in the value
+// of a DN.
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			(r == ' ' || r == '#') && i == 0,
+			r == ' ' && i == len(value)-1:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func hostname(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,438 @@
+package directory
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// stubEntry is a user of stubDirectory, which binds with password.
+type stubEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// stubDirectory is an LDAP server answering binds, searches and StartTLS
+// from its entries. With tls set it refuses to bind before StartTLS.
+type stubDirectory struct {
+	net.Listener
+	entries []stubEntry
+	tls     *tls.Config
+}
+
+func newStubDirectory(t *testing.T, tlsConfig *tls.Config, entries ...stubEntry) *stubDirectory {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &stubDirectory{Listener: ln, entries: entries, tls: tlsConfig}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *stubDirectory) URL() string {
+	return "ldap://" + d.Addr().String()
+}
+
+func (d *stubDirectory) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	bound, encrypted := "", false
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id := p.Children[0].Value.(int64)
+		op := p.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, password := op.Children[1].Value.(string), op.Children[2].Data.String()
+			var code uint16 = ldap.LDAPResultInvalidCredentials
+			if d.tls != nil && !encrypted {
+				code = ldap.LDAPResultConfidentialityRequired
+			} else if e := d.entry(name); e != nil && e.password == password {
+				code, bound = ldap.LDAPResultSuccess, e.dn
+			}
+			writeResult(conn, id, ldap.ApplicationBindResponse, code)
+
+		case ldap.ApplicationSearchRequest:
+			if bound == "" {
+				writeResult(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights)
+				continue
+			}
+			base := strings.ToLower(op.Children[0].Value.(string))
+			scope, limit := op.Children[1].Value.(int64), op.Children[3].Value.(int64)
+
+			var code uint16 = ldap.LDAPResultSuccess
+			found := 0
+			for _, e := range d.entries {
+				dn := strings.ToLower(e.dn)
+				inScope := dn == base || scope != ldap.ScopeBaseObject && strings.HasSuffix(dn, ","+base)
+				if !inScope || !matches(op.Children[6], e) {
+					continue
+				}
+				if found++; limit > 0 && int64(found) > limit {
+					code = ldap.LDAPResultSizeLimitExceeded
+					break
+				}
+				writeEntry(conn, id, e)
+			}
+			if found == 0 && scope == ldap.ScopeBaseObject {
+				code = ldap.LDAPResultNoSuchObject
+			}
+			writeResult(conn, id, ldap.ApplicationSearchResultDone, code)
+
+		case ldap.ApplicationExtendedRequest:
+			writeResult(conn, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+			tlsConn := tls.Server(conn, d.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, encrypted = tlsConn, true
+
+		default:
+			return
+		}
+	}
+}
+
+func (d *stubDirectory) entry(dn string) *stubEntry {
+	for i, e := range d.entries {
+		if strings.EqualFold(e.dn, dn) {
+			return &d.entries[i]
+		}
+	}
+	return nil
+}
+
+// matches evaluates the and, equality and presence filters.
+func matches(filter *ber.Packet, e stubEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, f := range filter.Children {
+			if !matches(f, e) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterEqualityMatch:
+		attr, value := filter.Children[0].Data.String(), filter.Children[1].Data.String()
+		for _, v := range attrValues(e, attr) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	case ldap.FilterPresent:
+		return len(attrValues(e, filter.Data.String())) > 0
+	}
+	return false
+}
+
+func attrValues(e stubEntry, attr string) []string {
+	for name, values := range e.attrs {
+		if strings.EqualFold(name, attr) {
+			return values
+		}
+	}
+	return nil
+}
+
+func envelope(id int64) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	return p
+}
+
+func writeResult(conn net.Conn, id int64, tag ber.Tag, code uint16) {
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+
+	p := envelope(id)
+	p.AppendChild(r)
+	conn.Write(p.Bytes())
+}
+
+func writeEntry(conn net.Conn, id int64, e stubEntry) {
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range e.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	r.AppendChild(attrs)
+
+	p := envelope(id)
+	p.AppendChild(r)
+	conn.Write(p.Bytes())
+}
+
+var (
+	service = stubEntry{dn: "cn=semaphore,dc=acme,dc=com", password: "service"}
+	jane    = stubEntry{dn: "uid=jane,ou=people,dc=acme,dc=com", password: "secret", attrs: map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"jane"},
+		"mail":        {"jane@acme.com"},
+		"givenName":   {"Jane"},
+		"sn":          {"Doe"},
+		"memberOf":    {"cn=Admins,ou=groups,dc=acme,dc=com", "cn=staff,ou=groups,dc=acme,dc=com"},
+	}}
+	john = stubEntry{dn: "uid=john,ou=people,dc=acme,dc=com", password: "hunter2", attrs: map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"john"},
+		"mail":        {"john@acme.com"},
+		"givenName":   {"John"},
+		"sn":          {"Roe"},
+	}}
+	// twin has the address of john
+	twin = stubEntry{dn: "uid=twin,ou=people,dc=acme,dc=com", password: "twin", attrs: map[string][]string{
+		"objectClass": {"person"},
+		"mail":        {"john@acme.com"},
+	}}
+	printer = stubEntry{dn: "cn=printer,ou=people,dc=acme,dc=com", attrs: map[string][]string{
+		"objectClass": {"person"},
+	}}
+)
+
+func TestDirectory_Authenticate(t *testing.T) {
+	stub := newStubDirectory(t, nil, service, jane, john)
+
+	search, err := New(Config{
+		URL:          stub.URL(),
+		BindDN:       service.dn,
+		BindPassword: service.password,
+		BaseDN:       "dc=acme,dc=com",
+		UserFilter:   "(&(objectClass=person)(mail=%s))",
+		GroupRoles:   map[string]string{"cn=admins,ou=groups,dc=acme,dc=com": "admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindAsUser, err := New(Config{URL: stub.URL(), UserDN: "uid=%s,ou=people,dc=acme,dc=com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		directory *Directory
+		login     string
+		password  string
+		want      string
+		wantErr   error
+	}{
+		{name: "search then bind", directory: search, login: "jane@acme.com", password: "secret", want: jane.dn},
+		{name: "search then bind, wrong password", directory: search, login: "jane@acme.com", password: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "search then bind, unknown", directory: search, login: "nobody@acme.com", password: "secret", wantErr: ErrUserNotFound},
+		{name: "search then bind, filter injection", directory: search, login: "*", password: "secret", wantErr: ErrUserNotFound},
+		{name: "empty password", directory: search, login: "jane@acme.com", password: "", wantErr: ErrInvalidCredentials},
+		{name: "bind as user", directory: bindAsUser, login: "john", password: "hunter2", want: john.dn},
+		{name: "bind as user, wrong password", directory: bindAsUser, login: "john", password: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "bind as user, dn injection", directory: bindAsUser, login: "john,ou=people", password: "hunter2", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.directory.Authenticate(tt.login, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != "" && got.DN != tt.want {
+				t.Errorf("Authenticate() = %s, want %s", got.DN, tt.want)
+			}
+		})
+	}
+
+	e, err := search.Authenticate("jane@acme.com", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Email != "jane@acme.com" || e.FirstName != "Jane" || e.LastName != "Doe" {
+		t.Errorf("Authenticate() = %+v, want the attributes of jane", e)
+	}
+	if roles := search.Roles(e); !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Errorf("Roles() = %v, want [admin]", roles)
+	}
+}
+
+func TestDirectory_AuthenticateAmbiguous(t *testing.T) {
+	stub := newStubDirectory(t, nil, service, john, twin)
+
+	d, err := New(Config{
+		URL:          stub.URL(),
+		BindDN:       service.dn,
+		BindPassword: service.password,
+		BaseDN:       "dc=acme,dc=com",
+		UserFilter:   "(mail=%s)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Authenticate("john@acme.com", "hunter2"); !errors.Is(err, ErrUserAmbiguous) {
+		t.Errorf("Authenticate() error = %v, want ErrUserAmbiguous", err)
+	}
+}
+
+func TestDirectory_StartTLS(t *testing.T) {
+	serverTLS, clientTLS := newTLSConfigs(t)
+	stub := newStubDirectory(t, serverTLS, service, jane)
+
+	config := Config{URL: stub.URL(), UserDN: "uid=%s,ou=people,dc=acme,dc=com", TLS: clientTLS}
+	plain, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Authenticate("jane", "secret"); err == nil {
+		t.Error("Authenticate() without StartTLS error = nil, want the directory to refuse")
+	}
+
+	config.StartTLS = true
+	d, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Authenticate("jane", "secret"); err != nil {
+		t.Errorf("Authenticate() with StartTLS error = %v", err)
+	}
+}
+
+func TestDirectory_Users(t *testing.T) {
+	stub := newStubDirectory(t, nil, service, jane, john, printer)
+
+	d, err := New(Config{
+		URL:          stub.URL(),
+		BindDN:       service.dn,
+		BindPassword: service.password,
+		BaseDN:       "ou=people,dc=acme,dc=com",
+		UserFilter:   "(mail=%s)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := d.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emails []string
+	for _, u := range users {
+		emails = append(emails, u.Email)
+	}
+	if want := []string{"jane@acme.com", "john@acme.com"}; !reflect.DeepEqual(emails, want) {
+		t.Errorf("Users() = %v, want %v", emails, want)
+	}
+
+	withoutBind, _ := New(Config{URL: stub.URL(), UserDN: "uid=%s,dc=acme,dc=com"})
+	if _, err := withoutBind.Users(); !errors.Is(err, ErrBindDNMissing) {
+		t.Errorf("Users() without bind dn error = %v, want ErrBindDNMissing", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{}); !errors.Is(err, ErrURLMissing) {
+		t.Errorf("New() without url error = %v, want ErrURLMissing", err)
+	}
+	if _, err := New(Config{URL: "ldap://localhost", BaseDN: "dc=acme,dc=com"}); !errors.Is(err, ErrUserDNMissing) {
+		t.Errorf("New() without user filter error = %v, want ErrUserDNMissing", err)
+	}
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	got, err := ParseGroupRoles([]string{"admin=cn=admins,ou=groups,dc=acme,dc=com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"cn=admins,ou=groups,dc=acme,dc=com": "admin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseGroupRoles() = %v, want %v", got, want)
+	}
+
+	if _, err := ParseGroupRoles([]string{"admin"}); !errors.Is(err, ErrGroupRoleInvalid) {
+		t.Errorf("ParseGroupRoles() error = %v, want ErrGroupRoleInvalid", err)
+	}
+}
+
+func Test_escapeDN(t *testing.T) {
+	tests := map[string]string{
+		"jane":           "jane",
+		"doe, jane":      `doe\, jane`,
+		"a+b=c":          `a\+b\=c`,
+		" #lead":         `\ #lead`,
+		"#lead":          `\#lead`,
+		"trail ":         `trail\ `,
+		`quote"back\`:    `quote\"back\\`,
+		"<angle>;semi":   `\<angle\>\;semi`,
+		"nul\x00ch":      `nul\00ch`,
+		"jane@acme.com":  "jane@acme.com",
+		"ünïcode":        "ünïcode",
+		"cn=x,dc=evil":   `cn\=x\,dc\=evil`,
+		"middle # space": "middle # space",
+	}
+	for value, want := range tests {
+		if got := escapeDN(value); got != want {
+			t.Errorf("escapeDN(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+// newTLSConfigs returns the configs of a server with a self-signed
+// certificate for 127.0.0.1 and of a client trusting it.
+func newTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: roots}
+}
@@ -0,0 +1,15 @@
+package directory
+
+import "errors"
+
+var (
+	ErrURLMissing          = errors.New("directory url is missing")
+	ErrUserDNMissing       = errors.New("either a user dn template or a base dn and a user filter are needed")
+	ErrBindDNMissing       = errors.New("listing users needs a bind dn and a base dn")
+	ErrUserNotFound        = errors.New("user not found in the directory")
+	ErrUserAmbiguous       = errors.New("more than one user in the directory matches")
+	ErrInvalidCredentials  = errors.New("invalid directory credentials")
+	ErrEmailMissing        = errors.New("directory user has no email address")
+	ErrGroupRoleInvalid    = errors.New("group role mapping is not role=group dn")
+	ErrServiceBindRejected = errors.New("the directory rejected the bind dn")
+)
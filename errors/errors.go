@@ -38,6 +38,50 @@ func WriteErrorJSON(c *fiber.Ctx, err *Error) error {
 // Errors
 var (
 	ErrCredentialNotFound = NewError(fiber.StatusUnauthorized, "auth_failed", "Credential not found")
+	ErrPasswordIncorrect  = NewError(fiber.StatusForbidden, "password_incorrect", "Current password is incorrect")
+	ErrLoginLocked        = NewError(fiber.StatusTooManyRequests, "login_locked", "Too many failed logins, please try again later")
+	ErrUserDisabled       = NewError(fiber.StatusForbidden, "user_disabled", "This account has been disabled")
 
 	ErrOauthClientNotFound = NewError(fiber.StatusNotFound, "oauth_client_not_found", "Client not found")
+
+	ErrMFAChallengeInvalid = NewError(fiber.StatusUnauthorized, "mfa_challenge_invalid", "Login expired, please login again")
+	ErrMFAInvalidCode      = NewError(fiber.StatusUnauthorized, "mfa_invalid_code", "Invalid verification code")
+	ErrMFAAlreadyEnrolled  = NewError(fiber.StatusConflict, "mfa_already_enrolled", "Two-factor authentication is already enabled")
+	ErrMFANotEnrolled      = NewError(fiber.StatusNotFound, "mfa_not_enrolled", "Two-factor authentication is not enabled")
+	ErrMFAEnforced         = NewError(fiber.StatusForbidden, "mfa_enforced", "Two-factor authentication is required for this account")
+
+	ErrPasskeyInvalid  = NewError(fiber.StatusUnauthorized, "passkey_invalid", "Passkey verification failed, please try again")
+	ErrPasskeyNotFound = NewError(fiber.StatusNotFound, "passkey_not_found", "Passkey not found")
+
+	ErrEmailNotVerified         = NewError(fiber.StatusForbidden, "email_not_verified", "Please verify your email address first")
+	ErrEmailVerificationInvalid = NewError(fiber.StatusBadRequest, "email_verification_invalid", "The verification link is invalid or has expired")
+	ErrEmailTaken               = NewError(fiber.StatusConflict, "email_taken", "This email address is already in use")
+
+	ErrSessionNotFound = NewError(fiber.StatusNotFound, "session_not_found", "Session not found")
+
+	ErrTokenNotFound  = NewError(fiber.StatusNotFound, "token_not_found", "Access token not found")
+	ErrTokenNameTaken = NewError(fiber.StatusConflict, "token_name_taken", "You already have an access token with this name")
+	ErrSignInRequired = NewError(fiber.StatusForbidden, "sign_in_required", "Please sign in to do this, access tokens can't")
+
+	ErrPasswordResetInvalid = NewError(fiber.StatusBadRequest, "password_reset_invalid", "The password reset link is invalid or has expired")
+
+	ErrUserNotFound   = NewError(fiber.StatusNotFound, "user_not_found", "User not found")
+	ErrAdminSelf      = NewError(fiber.StatusBadRequest, "admin_self", "You can't do this to your own account")
+	ErrRoleNotFound   = NewError(fiber.StatusNotFound, "role_not_found", "Role not found")
+	ErrGroupNotFound  = NewError(fiber.StatusNotFound, "group_not_found", "Group not found")
+	ErrGrantForbidden = NewError(fiber.StatusForbidden, "grant_forbidden", "You can't grant permissions you don't have")
+
+	ErrImpersonationForbidden = NewError(fiber.StatusForbidden, "impersonation_forbidden", "You can't impersonate users with permissions you don't have")
+	ErrImpersonating          = NewError(fiber.StatusForbidden, "impersonating", "This can't be done while impersonating a user")
+	ErrNotImpersonating       = NewError(fiber.StatusBadRequest, "not_impersonating", "You aren't impersonating anyone")
+
+	ErrTenantNotFound  = NewError(fiber.StatusNotFound, "tenant_not_found", "Tenant not found")
+	ErrTenantSlugTaken = NewError(fiber.StatusConflict, "tenant_slug_taken", "This tenant slug is already in use")
+	ErrTenantNotEmpty  = NewError(fiber.StatusConflict, "tenant_not_empty", "The tenant still has users")
+
+	ErrConnectorNotFound          = NewError(fiber.StatusNotFound, "connector_not_found", "Identity provider not found")
+	ErrFederationFailed           = NewError(fiber.StatusBadGateway, "federation_failed", "Login with the identity provider failed, please try again")
+	ErrFederationDomainNotAllowed = NewError(fiber.StatusForbidden, "federation_domain_not_allowed", "This email domain can't login with the identity provider")
+	ErrFederationEmailUnverified  = NewError(fiber.StatusForbidden, "federation_email_unverified", "The identity provider hasn't verified your email address")
+	ErrFederationAccountNotFound  = NewError(fiber.StatusNotFound, "federation_account_not_found", "There is no account for this identity")
 )
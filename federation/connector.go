@@ -0,0 +1,119 @@
+package federation
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Kinds of upstream identity providers.
+const (
+	// KindOIDC is an OpenID Connect provider, its endpoints are discovered
+	// from its issuer and users are identified by its ID tokens.
+	KindOIDC = "oidc"
+	// KindOAuth2 is a plain OAuth2 provider, users are identified by what
+	// its userinfo endpoint replies.
+	KindOAuth2 = "oauth2"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+// Connector is an upstream identity provider the users of a tenant can
+// sign in with.
+type Connector struct {
+	ID       uint   `json:"id" gorm:"primarykey"`
+	TenantID uint   `json:"tenant_id" gorm:"index:tenant_connector_index,unique,priority:1"`
+	Slug     string `json:"slug" gorm:"index:tenant_connector_index,unique,priority:2"`
+	// Name is what the sign in page shows, e.g. Google
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+
+	// Issuer is where the endpoints of a KindOIDC provider are
+	// discovered, the endpoints below override them
+	Issuer      string `json:"issuer"`
+	AuthURL     string `json:"auth_url"`
+	TokenURL    string `json:"token_url"`
+	UserinfoURL string `json:"userinfo_url"`
+
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"-"`
+	// Scopes are requested on top of openid for KindOIDC providers
+	Scopes []string     `json:"scopes" gorm:"serializer:json"`
+	Claims ClaimMapping `json:"claims" gorm:"embedded;embeddedPrefix:claim_"`
+
+	// Domains are the domains of the email addresses allowed to sign in,
+	// any when empty
+	Domains []string `json:"domains" gorm:"serializer:json"`
+	// TrustEmail takes the email addresses of the provider as verified
+	// when it doesn't tell
+	TrustEmail bool `json:"trust_email"`
+	// Provision creates a user on first sign in when no user of the
+	// tenant has the email address
+	Provision bool `json:"provision"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ClaimMapping names the claims of the provider the user is made of, the
+// standard OpenID Connect ones when empty.
+type ClaimMapping struct {
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+}
+
+// AllowsEmail reports whether users with the email address can sign in
+// with c.
+func (c *Connector) AllowsEmail(email string) bool {
+	if len(c.Domains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range c.Domains {
+		if strings.ToLower(d) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidSlug reports whether slug can name a connector in URLs.
+func ValidSlug(slug string) bool {
+	return slugPattern.MatchString(slug)
+}
+
+// Identity links the user a provider knows as Subject to a user of
+// semaphore.
+type Identity struct {
+	ID          uint   `json:"id" gorm:"primarykey"`
+	ConnectorID uint   `json:"connector_id" gorm:"index:connector_subject_index,unique,priority:1"`
+	Subject     string `json:"subject" gorm:"index:connector_subject_index,unique,priority:2"`
+	UserID      uint   `json:"user_id" gorm:"index"`
+	// Email is the address the provider last told
+	Email string `json:"email"`
+
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// TableName overrides the default identities.
+func (Identity) TableName() string {
+	return "federated_identities"
+}
+
+// ExternalUser is a user as an upstream provider knows it.
+type ExternalUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
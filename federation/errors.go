@@ -0,0 +1,17 @@
+package federation
+
+import "errors"
+
+var (
+	ErrConnectorNotFound = errors.New("connector not found")
+	ErrSlugInvalid       = errors.New("invalid connector slug")
+	ErrSlugTaken         = errors.New("connector slug is already in use")
+	ErrKindInvalid       = errors.New("connector kind is oidc or oauth2")
+	ErrEndpointsMissing  = errors.New("connector has no issuer or endpoints")
+	ErrIdentityNotFound  = errors.New("identity not found")
+
+	ErrDiscoveryFailed = errors.New("unable to discover the provider")
+	ErrIDTokenInvalid  = errors.New("invalid id token")
+	ErrSubjectMissing  = errors.New("provider told no subject")
+	ErrStateInvalid    = errors.New("invalid federation state")
+)
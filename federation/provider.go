@@ -0,0 +1,311 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+)
+
+// DiscoveryTTL is how long the discovered endpoints of a provider are
+// used before being discovered again.
+const DiscoveryTTL = time.Hour
+
+// defaultScopes are requested from OpenID Connect providers along with
+// openid when the connector names none.
+var defaultScopes = []string{"email", "profile"}
+
+// discovery is the part of an OpenID Connect discovery document semaphore
+// uses.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+
+	discoveredAt time.Time
+}
+
+// Providers makes the Provider of connectors, keeping what is discovered
+// of OpenID Connect providers for DiscoveryTTL.
+type Providers struct {
+	client *http.Client
+
+	mu         sync.Mutex
+	discovered map[string]*discovery
+}
+
+// NewProviders returns Providers talking to providers with client, a
+// client with a 10 seconds timeout when nil.
+func NewProviders(client *http.Client) *Providers {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Providers{client: client, discovered: make(map[string]*discovery)}
+}
+
+// Provider gets the provider c connects to.
+// Returns ErrDiscoveryFailed when the endpoints of an OpenID Connect
+// provider can't be discovered.
+func (p *Providers) Provider(ctx context.Context, c *Connector) (*Provider, error) {
+	prov := &Provider{
+		connector:   c,
+		client:      p.client,
+		userinfoURL: c.UserinfoURL,
+		config: oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Endpoint:     oauth2.Endpoint{AuthURL: c.AuthURL, TokenURL: c.TokenURL},
+			Scopes:       c.Scopes,
+		},
+	}
+	if c.Kind != KindOIDC {
+		return prov, nil
+	}
+
+	d, err := p.discover(ctx, c.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	prov.issuer = d.Issuer
+	if prov.config.Endpoint.AuthURL == "" {
+		prov.config.Endpoint.AuthURL = d.AuthorizationEndpoint
+	}
+	if prov.config.Endpoint.TokenURL == "" {
+		prov.config.Endpoint.TokenURL = d.TokenEndpoint
+	}
+	if prov.userinfoURL == "" {
+		prov.userinfoURL = d.UserinfoEndpoint
+	}
+
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+	prov.config.Scopes = append([]string{"openid"}, scopes...)
+	return prov, nil
+}
+
+func (p *Providers) discover(ctx context.Context, issuer string) (*discovery, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	p.mu.Lock()
+	d, ok := p.discovered[issuer]
+	p.mu.Unlock()
+	if ok && time.Since(d.discoveredAt) < DiscoveryTTL {
+		return d, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscoveryFailed, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s replied %s", ErrDiscoveryFailed, issuer, res.Status)
+	}
+
+	d = &discovery{}
+	if err := json.NewDecoder(res.Body).Decode(d); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscoveryFailed, err)
+	}
+	// the document of one issuer can't speak for another
+	if strings.TrimSuffix(d.Issuer, "/") != issuer || d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" {
+		return nil, fmt.Errorf("%w: %s has an invalid discovery document", ErrDiscoveryFailed, issuer)
+	}
+	d.discoveredAt = time.Now()
+
+	p.mu.Lock()
+	p.discovered[issuer] = d
+	p.mu.Unlock()
+	return d, nil
+}
+
+// Provider is an upstream identity provider users are sent to sign in
+// with, using the authorization code flow with PKCE.
+type Provider struct {
+	connector   *Connector
+	issuer      string
+	config      oauth2.Config
+	userinfoURL string
+	client      *http.Client
+}
+
+// AuthCodeURL is where the browser is sent to sign in with the provider,
+// which sends it back to redirectURI along with state.
+func (p *Provider) AuthCodeURL(redirectURI string, s *State) string {
+	config := p.config
+	config.RedirectURL = redirectURI
+
+	challenge := sha256.Sum256([]byte(s.Verifier))
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+	if p.connector.Kind == KindOIDC {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", s.Nonce))
+	}
+	return config.AuthCodeURL(s.State, opts...)
+}
+
+// Exchange trades the code the provider sent the browser back with for
+// the user who signed in.
+// Returns ErrIDTokenInvalid when the ID token wasn't issued to semaphore
+// for this sign in, ErrSubjectMissing when the user can't be told apart.
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code string, s *State) (*ExternalUser, error) {
+	config := p.config
+	config.RedirectURL = redirectURI
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", s.Verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if p.connector.Kind == KindOIDC {
+		rawIDToken, _ := token.Extra("id_token").(string)
+		if claims, err = p.verifyIDToken(rawIDToken, s.Nonce); err != nil {
+			return nil, err
+		}
+
+		mapping := p.connector.Claims
+		if _, ok := claims[claimName(mapping.Email, "email")]; ok || p.userinfoURL == "" {
+			return p.externalUser(claims)
+		}
+	}
+
+	info, err := p.userinfo(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if claims == nil {
+		return p.externalUser(info)
+	}
+
+	// the userinfo of another user can't complete the ID token
+	if info["sub"] != claims["sub"] {
+		return nil, ErrIDTokenInvalid
+	}
+	for k, v := range info {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return p.externalUser(claims)
+}
+
+// verifyIDToken checks the ID token has been issued to semaphore by the
+// provider for the sign in with nonce. Its signature isn't checked: it
+// comes straight from the token endpoint over TLS, which OpenID Connect
+// Core 3.1.3.7 allows to trust instead.
+func (p *Provider) verifyIDToken(raw, nonce string) (map[string]interface{}, error) {
+	if raw == "" {
+		return nil, ErrIDTokenInvalid
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIDTokenInvalid, err)
+	}
+
+	iss, _ := claims["iss"].(string)
+	if strings.TrimSuffix(iss, "/") != strings.TrimSuffix(p.issuer, "/") {
+		return nil, fmt.Errorf("%w: issued by %s", ErrIDTokenInvalid, iss)
+	}
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, fmt.Errorf("%w: issued to another client", ErrIDTokenInvalid)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: expired", ErrIDTokenInvalid)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrIDTokenInvalid)
+	}
+	return claims, nil
+}
+
+func (p *Provider) userinfo(ctx context.Context, token *oauth2.Token) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userinfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	token.SetAuthHeader(req)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo replied %s", res.Status)
+	}
+
+	info := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// externalUser maps claims to a user through the claim mapping of the
+// connector.
+func (p *Provider) externalUser(claims map[string]interface{}) (*ExternalUser, error) {
+	mapping := p.connector.Claims
+
+	u := &ExternalUser{
+		Subject:   claimString(claims, claimName(mapping.Subject, "sub")),
+		Email:     claimString(claims, claimName(mapping.Email, "email")),
+		FirstName: claimString(claims, claimName(mapping.FirstName, "given_name")),
+		LastName:  claimString(claims, claimName(mapping.LastName, "family_name")),
+	}
+	if u.Subject == "" {
+		return nil, ErrSubjectMissing
+	}
+
+	switch verified := claims[claimName(mapping.EmailVerified, "email_verified")].(type) {
+	case bool:
+		u.EmailVerified = verified
+	case string:
+		u.EmailVerified = verified == "true"
+	case nil:
+		u.EmailVerified = p.connector.TrustEmail
+	}
+	return u, nil
+}
+
+func claimName(mapped, standard string) string {
+	if mapped != "" {
+		return mapped
+	}
+	return standard
+}
+
+// claimString gets a claim as a string, numeric IDs included.
+func claimString(claims map[string]interface{}, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
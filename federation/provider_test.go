@@ -0,0 +1,303 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// fakeIdP is an OpenID Connect provider issuing the claims of the next
+// sign in to whoever redeems its code with the right verifier.
+type fakeIdP struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	claims   jwt.MapClaims
+	userinfo map[string]interface{}
+	codes    map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	idp := &fakeIdP{codes: make(map[string]fakeGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"userinfo_endpoint":      idp.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		grant, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		claims := jwt.MapClaims{}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		idp.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		if _, ok := claims["nonce"]; !ok {
+			claims["nonce"] = grant.nonce
+		}
+		idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("idp"))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		json.NewEncoder(w).Encode(idp.userinfo)
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// authorize signs in at the provider following authURL and returns the
+// code it sends back.
+func (idp *fakeIdP) authorize(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	code := "code-" + q.Get("state")
+	idp.codes[code] = fakeGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	return code
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	idp := newFakeIdP(t)
+	redirectURI := "http://semaphore.test/auth/federation/corp/callback"
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            idp.URL,
+			"aud":            "semaphore",
+			"sub":            "42",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"email":          "jane@acme.com",
+			"email_verified": true,
+			"given_name":     "Jane",
+			"family_name":    "Doe",
+		}
+	}
+
+	tests := []struct {
+		name      string
+		connector Connector
+		claims    func(jwt.MapClaims)
+		userinfo  map[string]interface{}
+		want      *ExternalUser
+		wantErr   error
+	}{
+		{
+			name:      "id token",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore"},
+			want:      &ExternalUser{Subject: "42", Email: "jane@acme.com", EmailVerified: true, FirstName: "Jane", LastName: "Doe"},
+		},
+		{
+			name:      "email from userinfo",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore"},
+			claims:    func(c jwt.MapClaims) { delete(c, "email"); delete(c, "email_verified") },
+			userinfo:  map[string]interface{}{"sub": "42", "email": "jane@acme.com", "email_verified": "true"},
+			want:      &ExternalUser{Subject: "42", Email: "jane@acme.com", EmailVerified: true, FirstName: "Jane", LastName: "Doe"},
+		},
+		{
+			name:      "userinfo of someone else",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore"},
+			claims:    func(c jwt.MapClaims) { delete(c, "email") },
+			userinfo:  map[string]interface{}{"sub": "43", "email": "john@acme.com"},
+			wantErr:   ErrIDTokenInvalid,
+		},
+		{
+			name:      "mapped claims",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore", Claims: ClaimMapping{Email: "upn", FirstName: "name"}},
+			claims: func(c jwt.MapClaims) {
+				c["upn"] = "jane.doe@acme.com"
+				c["name"] = "Jane Doe"
+				delete(c, "email_verified")
+			},
+			want: &ExternalUser{Subject: "42", Email: "jane.doe@acme.com", FirstName: "Jane Doe", LastName: "Doe"},
+		},
+		{
+			name:      "trusted email",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore", TrustEmail: true},
+			claims:    func(c jwt.MapClaims) { delete(c, "email_verified") },
+			want:      &ExternalUser{Subject: "42", Email: "jane@acme.com", EmailVerified: true, FirstName: "Jane", LastName: "Doe"},
+		},
+		{
+			name:      "another issuer",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore"},
+			claims:    func(c jwt.MapClaims) { c["iss"] = "https://evil.test" },
+			wantErr:   ErrIDTokenInvalid,
+		},
+		{
+			name:      "another audience",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore"},
+			claims:    func(c jwt.MapClaims) { c["aud"] = "other" },
+			wantErr:   ErrIDTokenInvalid,
+		},
+		{
+			name:      "expired",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore"},
+			claims:    func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+			wantErr:   ErrIDTokenInvalid,
+		},
+		{
+			name:      "replayed nonce",
+			connector: Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore"},
+			claims:    func(c jwt.MapClaims) { c["nonce"] = "old" },
+			wantErr:   ErrIDTokenInvalid,
+		},
+		{
+			name: "oauth2 userinfo",
+			connector: Connector{Kind: KindOAuth2, ClientID: "semaphore", AuthURL: idp.URL + "/authorize", TokenURL: idp.URL + "/token",
+				UserinfoURL: idp.URL + "/userinfo", Claims: ClaimMapping{Subject: "id", FirstName: "login"}},
+			userinfo: map[string]interface{}{"id": 1234567, "login": "jane", "email": "jane@acme.com"},
+			want:     &ExternalUser{Subject: "1234567", Email: "jane@acme.com", FirstName: "jane"},
+		},
+		{
+			name:      "oauth2 without subject",
+			connector: Connector{Kind: KindOAuth2, ClientID: "semaphore", AuthURL: idp.URL + "/authorize", TokenURL: idp.URL + "/token", UserinfoURL: idp.URL + "/userinfo"},
+			userinfo:  map[string]interface{}{"email": "jane@acme.com"},
+			wantErr:   ErrSubjectMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			idp.mu.Lock()
+			idp.claims, idp.userinfo = claims, tt.userinfo
+			idp.mu.Unlock()
+
+			ctx := context.Background()
+			prov, err := NewProviders(nil).Provider(ctx, &tt.connector)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := NewState(0, "corp", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := idp.authorize(t, prov.AuthCodeURL(redirectURI, s))
+
+			got, err := prov.Exchange(ctx, redirectURI, code, s)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil && *got != *tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvider_ExchangeWrongVerifier(t *testing.T) {
+	idp := newFakeIdP(t)
+	redirectURI := "http://semaphore.test/auth/federation/corp/callback"
+
+	ctx := context.Background()
+	prov, err := NewProviders(nil).Provider(ctx, &Connector{Kind: KindOIDC, Issuer: idp.URL, ClientID: "semaphore"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := NewState(0, "corp", "")
+	code := idp.authorize(t, prov.AuthCodeURL(redirectURI, s))
+
+	// a stolen code is useless without the verifier of the browser
+	other, _ := NewState(0, "corp", "")
+	other.State, other.Nonce = s.State, s.Nonce
+	if _, err := prov.Exchange(ctx, redirectURI, code, other); err == nil {
+		t.Error("Exchange() with another verifier error = nil, want an error")
+	}
+}
+
+func TestProviders_discoverInvalid(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 "https://evil.test",
+			"authorization_endpoint": "https://evil.test/authorize",
+			"token_endpoint":         "https://evil.test/token",
+		})
+	}))
+	defer srv.Close()
+
+	_, err := NewProviders(nil).Provider(context.Background(), &Connector{Kind: KindOIDC, Issuer: srv.URL})
+	if !errors.Is(err, ErrDiscoveryFailed) {
+		t.Errorf("Provider() error = %v, want ErrDiscoveryFailed", err)
+	}
+}
+
+func TestParseState(t *testing.T) {
+	key := []byte("key")
+
+	s, err := NewState(1, "corp", "from=oauth_authorize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := s.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseState(raw, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != s.State || got.Verifier != s.Verifier || got.TenantID != 1 || got.Return != s.Return {
+		t.Errorf("ParseState() = %+v, want %+v", got, s)
+	}
+
+	if _, err := ParseState(raw, []byte("other")); !errors.Is(err, ErrStateInvalid) {
+		t.Errorf("ParseState() with another key error = %v, want ErrStateInvalid", err)
+	}
+}
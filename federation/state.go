@@ -0,0 +1,70 @@
+package federation
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	StateIssuer     = "semaphore:federation"
+	StateExpiration = 10 * time.Minute
+)
+
+// State is what a sign in started with a connector is completed with. It
+// is signed by the server and kept by the browser in a cookie, so only the
+// browser that started the sign in can complete it.
+type State struct {
+	jwt.RegisteredClaims
+	// State is sent to the provider and has to come back with the code
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+
+	TenantID  uint   `json:"tenant_id"`
+	Connector string `json:"connector"`
+	// Return is the query of the sign in page the user started from
+	Return string `json:"return,omitempty"`
+}
+
+// NewState starts a sign in to the tenant with the specified ID through
+// the connector with the specified slug.
+func NewState(tenantID uint, connector, ret string) (*State, error) {
+	s := &State{TenantID: tenantID, Connector: connector, Return: ret}
+	for _, v := range []*string{&s.State, &s.Nonce, &s.Verifier} {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		*v = base64.RawURLEncoding.EncodeToString(buf)
+	}
+	return s, nil
+}
+
+// Sign signs s with key for StateExpiration.
+func (s *State) Sign(key []byte) (string, error) {
+	now := time.Now()
+	s.Issuer = StateIssuer
+	s.IssuedAt = jwt.NewNumericDate(now)
+	s.ExpiresAt = jwt.NewNumericDate(now.Add(StateExpiration))
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, s).SignedString(key)
+}
+
+// ParseState parses a state signed with key.
+// Returns ErrStateInvalid if it wasn't or has expired.
+func ParseState(raw string, key []byte) (*State, error) {
+	s := &State{}
+	tk, err := jwt.ParseWithClaims(raw, s, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrStateInvalid
+		}
+		return key, nil
+	})
+	if err != nil || !tk.Valid || s.Issuer != StateIssuer {
+		return nil, ErrStateInvalid
+	}
+	return s, nil
+}
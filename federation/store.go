@@ -0,0 +1,140 @@
+package federation
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Store interface {
+	// Create inserts the connector.
+	// Returns ErrSlugInvalid or ErrSlugTaken for a slug it can't use,
+	// ErrKindInvalid or ErrEndpointsMissing when it can't be used to sign
+	// in.
+	Create(c *Connector) error
+
+	// Connector gets the connector of the tenant with the specified slug.
+	// Returns ErrConnectorNotFound if there is no such connector.
+	Connector(tenantID uint, slug string) (*Connector, error)
+
+	// Connectors gets the connectors of the tenant, by slug.
+	Connectors(tenantID uint) ([]*Connector, error)
+
+	// Delete deletes the connector of the tenant with the specified slug
+	// and the identities linked through it.
+	// Returns ErrConnectorNotFound if there is no such connector.
+	Delete(tenantID uint, slug string) error
+
+	// Identity gets the identity the connector with the specified ID knows
+	// as subject.
+	// Returns ErrIdentityNotFound if there is no such identity.
+	Identity(connectorID uint, subject string) (*Identity, error)
+
+	// Link saves i, linking its subject to its user, and records it has
+	// just been signed in with.
+	Link(i *Identity) error
+
+	// DeleteUser deletes the identities of the user with the specified ID.
+	DeleteUser(userID uint) error
+
+	// Migrate auto-migrates the Connector and Identity models to database.
+	Migrate() error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+func (s *store) Create(c *Connector) error {
+	if !ValidSlug(c.Slug) {
+		return ErrSlugInvalid
+	}
+	switch c.Kind {
+	case KindOIDC:
+		if c.Issuer == "" {
+			return ErrEndpointsMissing
+		}
+	case KindOAuth2:
+		if c.AuthURL == "" || c.TokenURL == "" || c.UserinfoURL == "" {
+			return ErrEndpointsMissing
+		}
+	default:
+		return ErrKindInvalid
+	}
+
+	if _, err := s.Connector(c.TenantID, c.Slug); !errors.Is(err, ErrConnectorNotFound) {
+		if err == nil {
+			return ErrSlugTaken
+		}
+		return err
+	}
+
+	c.ID = 0
+	return s.db.Create(c).Error
+}
+
+func (s *store) Connector(tenantID uint, slug string) (*Connector, error) {
+	var c Connector
+	err := s.db.Where("tenant_id = ? AND slug = ?", tenantID, slug).First(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrConnectorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *store) Connectors(tenantID uint) ([]*Connector, error) {
+	var connectors []*Connector
+	err := s.db.Where("tenant_id = ?", tenantID).Order("slug").Find(&connectors).Error
+	return connectors, err
+}
+
+func (s *store) Delete(tenantID uint, slug string) error {
+	c, err := s.Connector(tenantID, slug)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("connector_id = ?", c.ID).Delete(&Identity{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(c).Error
+	})
+}
+
+func (s *store) Identity(connectorID uint, subject string) (*Identity, error) {
+	var i Identity
+	err := s.db.Where("connector_id = ? AND subject = ?", connectorID, subject).First(&i).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (s *store) Link(i *Identity) error {
+	i.LastLoginAt = time.Now()
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "connector_id"}, {Name: "subject"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "email", "last_login_at"}),
+	}).Create(i).Error
+}
+
+func (s *store) DeleteUser(userID uint) error {
+	return s.db.Where("user_id = ?", userID).Delete(&Identity{}).Error
+}
+
+func (s *store) Migrate() error {
+	return s.db.AutoMigrate(&Connector{}, &Identity{})
+}
@@ -0,0 +1,144 @@
+package federation
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store_Create(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+
+	tests := []struct {
+		name      string
+		connector Connector
+		wantErr   error
+	}{
+		{name: "oidc", connector: Connector{Slug: "google", Kind: KindOIDC, Issuer: "https://accounts.google.com"}},
+		{name: "same slug in another tenant", connector: Connector{TenantID: 1, Slug: "google", Kind: KindOIDC, Issuer: "https://accounts.google.com"}},
+		{name: "oauth2", connector: Connector{Slug: "github", Kind: KindOAuth2, AuthURL: "https://github.com/login/oauth/authorize",
+			TokenURL: "https://github.com/login/oauth/access_token", UserinfoURL: "https://api.github.com/user"}},
+		{name: "taken", connector: Connector{Slug: "google", Kind: KindOIDC, Issuer: "https://accounts.google.com"}, wantErr: ErrSlugTaken},
+		{name: "invalid slug", connector: Connector{Slug: "Google Workspace", Kind: KindOIDC, Issuer: "https://accounts.google.com"}, wantErr: ErrSlugInvalid},
+		{name: "unknown kind", connector: Connector{Slug: "saml", Kind: "saml"}, wantErr: ErrKindInvalid},
+		{name: "oidc without issuer", connector: Connector{Slug: "okta", Kind: KindOIDC}, wantErr: ErrEndpointsMissing},
+		{name: "oauth2 without userinfo", connector: Connector{Slug: "gitlab", Kind: KindOAuth2, AuthURL: "https://gitlab.com/oauth/authorize",
+			TokenURL: "https://gitlab.com/oauth/token"}, wantErr: ErrEndpointsMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Create(&tt.connector); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	connectors, err := s.Connectors(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connectors) != 2 || connectors[0].Slug != "github" || connectors[1].Slug != "google" {
+		t.Errorf("Connectors() = %v, want github and google", connectors)
+	}
+}
+
+func Test_store_Link(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	google := &Connector{Slug: "google", Kind: KindOIDC, Issuer: "https://accounts.google.com"}
+	if err := s.Create(google); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Identity(google.ID, "123"); !errors.Is(err, ErrIdentityNotFound) {
+		t.Fatalf("Identity() error = %v, want ErrIdentityNotFound", err)
+	}
+
+	if err := s.Link(&Identity{ConnectorID: google.ID, Subject: "123", UserID: 1, Email: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	// signing in again updates the identity
+	if err := s.Link(&Identity{ConnectorID: google.ID, Subject: "123", UserID: 1, Email: "jane@acme.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Identity(google.ID, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != 1 || got.Email != "jane@acme.com" || got.LastLoginAt.IsZero() {
+		t.Errorf("Identity() = %+v, want user 1 with the new email", got)
+	}
+
+	if err := s.Delete(0, "google"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Identity(google.ID, "123"); !errors.Is(err, ErrIdentityNotFound) {
+		t.Errorf("Identity() after Delete() error = %v, want ErrIdentityNotFound", err)
+	}
+	if err := s.Delete(0, "google"); !errors.Is(err, ErrConnectorNotFound) {
+		t.Errorf("Delete() again error = %v, want ErrConnectorNotFound", err)
+	}
+}
+
+func TestConnector_AllowsEmail(t *testing.T) {
+	c := &Connector{Domains: []string{"acme.com"}}
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{email: "jane@acme.com", want: true},
+		{email: "jane@ACME.com", want: true},
+		{email: "jane@acme.com.evil.com", want: false},
+		{email: "jane@example.com", want: false},
+		{email: "jane", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			if got := c.AllowsEmail(tt.email); got != tt.want {
+				t.Errorf("AllowsEmail() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !(&Connector{}).AllowsEmail("jane@example.com") {
+		t.Error("AllowsEmail() without domains = false, want true")
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
@@ -0,0 +1,72 @@
+package lockout
+
+import "time"
+
+// Policy decides how long failed logins hold back the next attempt.
+type Policy struct {
+	// FreeAttempts is how many failures in a row go without delay.
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure past FreeAttempts,
+	// it doubles with every following failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Threshold is the number of failures that locks out for
+	// LockoutDuration, 0 never locks out.
+	Threshold       int
+	LockoutDuration time.Duration
+
+	// Window is how long a failure is remembered after the last one.
+	Window time.Duration
+}
+
+// Delay returns how long to wait after the given number of failures in a
+// row.
+func (p Policy) Delay(failures int) time.Duration {
+	if p.Threshold > 0 && failures >= p.Threshold {
+		return p.LockoutDuration
+	}
+
+	past := failures - p.FreeAttempts
+	if past <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < past; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// maxDelays bounds the delays of a policy that neither locks out nor caps
+// its delay.
+const maxDelays = 32
+
+// delays lists Delay for 1 failure in a row onwards, up to where it stops
+// changing. More failures wait as long as the last one.
+func (p Policy) delays() []time.Duration {
+	var delays []time.Duration
+	for failures := 1; failures <= maxDelays; failures++ {
+		delay := p.Delay(failures)
+		delays = append(delays, delay)
+
+		if p.Threshold > 0 {
+			if failures >= p.Threshold {
+				break
+			}
+			continue
+		}
+		if failures > p.FreeAttempts && (p.BaseDelay <= 0 || (p.MaxDelay > 0 && delay >= p.MaxDelay)) {
+			break
+		}
+	}
+	return delays
+}
@@ -0,0 +1,55 @@
+package lockout
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Delay(t *testing.T) {
+	p := Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		Threshold:       10,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures int
+		want     time.Duration
+	}{
+		{name: "no failures", policy: p, failures: 0, want: 0},
+		{name: "free attempts", policy: p, failures: 3, want: 0},
+		{name: "first delay", policy: p, failures: 4, want: time.Second},
+		{name: "doubled", policy: p, failures: 6, want: 4 * time.Second},
+		{name: "capped", policy: p, failures: 9, want: 10 * time.Second},
+		{name: "locked out", policy: p, failures: 10, want: 15 * time.Minute},
+		{name: "still locked out", policy: p, failures: 12, want: 15 * time.Minute},
+		{name: "never locks out", policy: Policy{FreeAttempts: 1, BaseDelay: time.Second, MaxDelay: time.Minute}, failures: 100, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Delay(tt.failures); got != tt.want {
+				t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_delays(t *testing.T) {
+	p := Policy{FreeAttempts: 1, BaseDelay: time.Second, MaxDelay: 4 * time.Second, Threshold: 4, LockoutDuration: time.Hour}
+	want := []time.Duration{0, time.Second, 2 * time.Second, time.Hour}
+	if got := p.delays(); !reflect.DeepEqual(got, want) {
+		t.Errorf("delays() = %v, want %v", got, want)
+	}
+
+	// without a threshold the delays stop once capped
+	p.Threshold = 0
+	want = []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second}
+	if got := p.delays(); !reflect.DeepEqual(got, want) {
+		t.Errorf("delays() without threshold = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,208 @@
+package lockout
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+const keyPrefix = "lockout:"
+
+type Store interface {
+	// Attempt reserves an attempt on the account from ip before it is
+	// verified, counting it as a failure until Release says otherwise.
+	// Returns how long to wait when the account or ip is held back, the
+	// attempt isn't reserved then. Attempts made in parallel are reserved
+	// one at a time, so they can't all get past a lockout.
+	Attempt(ctx context.Context, account string, ip string) (time.Duration, error)
+
+	// Fail records that the attempt reserved on the account from ip
+	// failed and returns how long to wait before the next one.
+	Fail(ctx context.Context, account string, ip string) (time.Duration, error)
+
+	// Release takes back the attempt reserved on the account from ip, it
+	// didn't fail. Earlier failures are kept.
+	Release(ctx context.Context, account string, ip string) error
+
+	// Failures gets the number of failures in a row of the account and how
+	// long it is held back for.
+	Failures(ctx context.Context, account string) (int, time.Duration, error)
+
+	// Unlock forgets the failures of the account, on a successful login or
+	// by an admin. Failures from an ip are only forgotten with time.
+	Unlock(ctx context.Context, account string) error
+
+	// Answer counts an answer to the challenge with id before it is
+	// verified and returns how many it has had. The count is forgotten
+	// after ttl, once the challenge has expired.
+	Answer(ctx context.Context, challenge string, ttl time.Duration) (int, error)
+}
+
+type store struct {
+	rdb     *redis.Client
+	account Policy
+	ip      Policy
+}
+
+// NewStore creates Store backed by redis, counting failures per account
+// with the account policy and per client ip with the ip policy.
+func NewStore(rdb *redis.Client, account Policy, ip Policy) Store {
+	return &store{rdb: rdb, account: account, ip: ip}
+}
+
+// attemptScript reserves an attempt on the account (KEYS[1], its block
+// KEYS[2]) and the ip (KEYS[3], KEYS[4]) unless either is blocked. Blocks
+// are set as if the attempt fails, ARGV holds the window and the delays
+// in milliseconds of each: window, number of delays, the delays.
+var attemptScript = redis.NewScript(`
+local wait = math.max(redis.call("PTTL", KEYS[2]), redis.call("PTTL", KEYS[4]))
+if wait > 0 then
+	return wait
+end
+
+local offset = 1
+for i = 1, 3, 2 do
+	local failures = redis.call("INCR", KEYS[i])
+	redis.call("PEXPIRE", KEYS[i], ARGV[offset])
+
+	local n = tonumber(ARGV[offset + 1])
+	if n > 0 then
+		local delay = tonumber(ARGV[offset + 1 + math.min(failures, n)])
+		if delay > 0 then
+			redis.call("SET", KEYS[i + 1], failures, "PX", delay)
+		end
+	end
+	offset = offset + 2 + n
+end
+return 0
+`)
+
+// releaseScript takes back a failure of KEYS[1], dropping its block
+// KEYS[2] when it was set by the attempt being taken back.
+var releaseScript = redis.NewScript(`
+local failures = redis.call("GET", KEYS[1])
+if not failures then
+	return 0
+end
+if redis.call("GET", KEYS[2]) == failures then
+	redis.call("DEL", KEYS[2])
+end
+
+if tonumber(failures) <= 1 then
+	redis.call("DEL", KEYS[1])
+	return 0
+end
+return redis.call("DECR", KEYS[1])
+`)
+
+func (s *store) Attempt(ctx context.Context, account string, ip string) (time.Duration, error) {
+	aKey, iKey := accountKey(account), ipKey(ip)
+
+	args := append(policyArgs(s.account), policyArgs(s.ip)...)
+	wait, err := attemptScript.Run(ctx, s.rdb, []string{aKey, blockKey(aKey), iKey, blockKey(iKey)}, args...).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+func (s *store) Fail(ctx context.Context, account string, ip string) (time.Duration, error) {
+	// the failure was counted when the attempt was reserved
+	pipe := s.rdb.Pipeline()
+	accountWait := pipe.PTTL(ctx, blockKey(accountKey(account)))
+	ipWait := pipe.PTTL(ctx, blockKey(ipKey(ip)))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	// PTTL is negative when there is no block
+	wait := accountWait.Val()
+	if ipWait.Val() > wait {
+		wait = ipWait.Val()
+	}
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
+
+func (s *store) Release(ctx context.Context, account string, ip string) error {
+	aKey, iKey := accountKey(account), ipKey(ip)
+	if err := releaseScript.Run(ctx, s.rdb, []string{aKey, blockKey(aKey)}).Err(); err != nil {
+		return err
+	}
+	return releaseScript.Run(ctx, s.rdb, []string{iKey, blockKey(iKey)}).Err()
+}
+
+// policyArgs are the arguments attemptScript takes for p.
+func policyArgs(p Policy) []interface{} {
+	delays := p.delays()
+	args := []interface{}{p.Window.Milliseconds(), len(delays)}
+	for _, d := range delays {
+		args = append(args, d.Milliseconds())
+	}
+	return args
+}
+
+func (s *store) Failures(ctx context.Context, account string) (int, time.Duration, error) {
+	key := accountKey(account)
+
+	pipe := s.rdb.Pipeline()
+	failures := pipe.Get(ctx, key)
+	wait := pipe.PTTL(ctx, blockKey(key))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+
+	count, _ := failures.Int()
+	if wait.Val() < 0 {
+		return count, 0, nil
+	}
+	return count, wait.Val(), nil
+}
+
+func (s *store) Unlock(ctx context.Context, account string) error {
+	key := accountKey(account)
+	return s.rdb.Del(ctx, key, blockKey(key)).Err()
+}
+
+func (s *store) Answer(ctx context.Context, challenge string, ttl time.Duration) (int, error) {
+	key := challengeKey(challenge)
+
+	pipe := s.rdb.TxPipeline()
+	answers := pipe.Incr(ctx, key)
+	pipe.PExpire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(answers.Val()), nil
+}
+
+// TenantAccount is the account failed logins with email to the tenant with
+// slug are counted by, the same address can belong to a user of every
+// tenant. slug is empty for the default tenant.
+func TenantAccount(slug string, email string) string {
+	if slug == "" {
+		return email
+	}
+	return slug + "/" + email
+}
+
+// accountKey is case insensitive like email addresses.
+func accountKey(account string) string {
+	return keyPrefix + "account:" + strings.ToLower(account)
+}
+
+func ipKey(ip string) string {
+	return keyPrefix + "ip:" + ip
+}
+
+func challengeKey(challenge string) string {
+	return keyPrefix + "challenge:" + challenge
+}
+
+func blockKey(key string) string {
+	return key + ":block"
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type dirMailer struct {
+	dir  string
+	from string
+}
+
+// NewDir creates Mailer that writes every message from from to an .eml
+// file in dir instead of sending it. It is meant for development.
+func NewDir(dir string, from string) Mailer {
+	return &dirMailer{dir: dir, from: from}
+}
+
+func (m *dirMailer) Send(_ context.Context, msg *Message) error {
+	buf, err := build(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(m.dir, name), buf, 0o600)
+}
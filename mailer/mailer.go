@@ -0,0 +1,128 @@
+// Package mailer sends the emails semaphore needs to reach its users, like
+// address verification and password reset links.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoRecipient  = errors.New("message has no recipient")
+	ErrInvalidEmail = errors.New("invalid email address")
+)
+
+// Message is an email to a single recipient. HTML is optional, Text is
+// always sent for clients that don't render it.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	// Send delivers msg or returns why it couldn't.
+	Send(ctx context.Context, msg *Message) error
+}
+
+// build renders msg as an RFC 5322 message from from.
+func build(from string, msg *Message) ([]byte, error) {
+	if msg.To == "" {
+		return nil, ErrNoRecipient
+	}
+
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, ErrInvalidEmail
+	}
+
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, ErrInvalidEmail
+	}
+
+	id, err := messageID(from)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	header := func(key, value string) {
+		fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+	}
+
+	header("From", from)
+	header("To", msg.To)
+	// a line break would start a header of its own
+	subject := strings.NewReplacer("\r", "", "\n", " ").Replace(msg.Subject)
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", id)
+	header("MIME-Version", "1.0")
+
+	if msg.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "8bit")
+		buf.WriteString("\r\n")
+		buf.WriteString(crlf(msg.Text))
+		return buf.Bytes(), nil
+	}
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write([]byte(crlf(part.content))); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	header("Content-Type", "multipart/alternative; boundary="+w.Boundary())
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+func messageID(from string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	domain := "semaphore"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			domain = addr.Address[at+1:]
+		}
+	}
+
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">", nil
+}
+
+// crlf normalizes line endings, SMTP requires CRLF.
+func crlf(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
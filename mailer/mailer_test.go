@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"context"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_build(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      *Message
+		wantType string
+		wantErr  error
+	}{
+		{
+			name:     "text",
+			msg:      &Message{To: "user@example.com", Subject: "Verify your email", Text: "Hi\nthere"},
+			wantType: "text/plain; charset=utf-8",
+		},
+		{
+			name:     "text and html",
+			msg:      &Message{To: "User <user@example.com>", Subject: "Verify", Text: "Hi", HTML: "<p>Hi</p>"},
+			wantType: "multipart/alternative",
+		},
+		{name: "no recipient", msg: &Message{Subject: "Verify"}, wantErr: ErrNoRecipient},
+		{name: "invalid recipient", msg: &Message{To: "user@example.com\r\nBcc: x@evil.test"}, wantErr: ErrInvalidEmail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := build("Semaphore <noreply@semaphore.test>", tt.msg)
+			if err != tt.wantErr {
+				t.Fatalf("build() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			m, err := mail.ReadMessage(strings.NewReader(string(buf)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Header.Get("To"); got != tt.msg.To {
+				t.Errorf("To = %q, want %q", got, tt.msg.To)
+			}
+			if got := m.Header.Get("Content-Type"); !strings.HasPrefix(got, tt.wantType) {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if !strings.HasSuffix(m.Header.Get("Message-ID"), "@semaphore.test>") {
+				t.Errorf("Message-ID = %q, want it on the sender domain", m.Header.Get("Message-ID"))
+			}
+		})
+	}
+}
+
+func Test_dirMailer_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := NewDir(dir, "Semaphore <noreply@semaphore.test>")
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(context.Background(), &Message{To: "user@example.com", Subject: "Hello", Text: "link"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("wrote %d files, want 2", len(files))
+	}
+
+	buf, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), "Subject: Hello") {
+		t.Errorf("mail = %q, want the subject", buf)
+	}
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPConfig is where and as whom mails are sent.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender address, optionally with a display name.
+	From string
+}
+
+type smtpMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTP creates Mailer that delivers through an SMTP server. STARTTLS is
+// used when the server offers it, credentials are only sent over TLS.
+func NewSMTP(config SMTPConfig) Mailer {
+	return &smtpMailer{config: config}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	buf, err := build(m.config.From, msg)
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(m.config.From)
+	if err != nil {
+		return ErrInvalidEmail
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return ErrInvalidEmail
+	}
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+
+	// smtp.SendMail doesn't take a context, don't keep the caller waiting
+	// once it is done
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, from.Address, []string{to.Address}, buf)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,10 @@
+package mfa
+
+import "errors"
+
+var (
+	ErrTOTPNotFound = errors.New("totp not enrolled")
+	ErrInvalidCode  = errors.New("invalid verification code")
+	// ErrNoRecoveryCodes means the user has no unused recovery code left.
+	ErrNoRecoveryCodes = errors.New("no recovery codes left")
+)
@@ -0,0 +1,34 @@
+package mfa
+
+import "time"
+
+// TOTP is a user's enrollment of a time-based one-time password
+// authenticator. The enrollment only counts once the user has proven the
+// authenticator works by sending a code.
+type TOTP struct {
+	ID     uint `gorm:"primarykey"`
+	UserID uint `gorm:"uniqueIndex"`
+
+	// Secret is the base32 encoded shared secret. Only its encrypted form
+	// is stored.
+	Secret          string `gorm:"-"`
+	EncryptedSecret string
+
+	ConfirmedAt *time.Time
+	// LastStep is the time step of the last accepted code. Codes of that
+	// step or earlier are refused so a code can't be used twice.
+	LastStep int64
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName overrides the default totps.
+func (TOTP) TableName() string {
+	return "mfa_totp"
+}
+
+// Confirmed reports whether the user has completed the enrollment.
+func (t *TOTP) Confirmed() bool {
+	return t.ConfirmedAt != nil
+}
@@ -0,0 +1,70 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// RecoveryCodeCount is how many codes a batch is made of.
+	RecoveryCodeCount = 10
+	// RecoveryCodesLow is the number of unused codes from which the user is
+	// told to generate a new batch.
+	RecoveryCodesLow = 3
+)
+
+// recoveryCodeAlphabet leaves out characters that are easily mistaken for
+// one another when written down.
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// RecoveryCode is a single-use code that completes a login in place of the
+// second factor. Only its hash is stored.
+type RecoveryCode struct {
+	ID     uint `gorm:"primarykey"`
+	UserID uint `gorm:"index"`
+
+	CodeHash string
+	UsedAt   *time.Time
+
+	CreatedAt time.Time
+}
+
+// TableName overrides the default recovery_codes.
+func (RecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// GenerateRecoveryCodes returns a new batch of codes formatted like
+// xxxxx-xxxxx.
+func GenerateRecoveryCodes() ([]string, error) {
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 10)
+		for j := range buf {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			buf[j] = recoveryCodeAlphabet[n.Int64()]
+		}
+		codes[i] = string(buf[:5]) + "-" + string(buf[5:])
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed in any case, with or without
+// the separator.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
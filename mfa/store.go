@@ -0,0 +1,199 @@
+package mfa
+
+import (
+	"errors"
+	"time"
+
+	"github.com/9d4/semaphore/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Store interface {
+	// TOTP gets the TOTP enrollment of the user with its secret decrypted.
+	// Returns ErrTOTPNotFound if the user has none.
+	TOTP(userID uint) (*TOTP, error)
+
+	// SaveTOTP creates or replaces the TOTP enrollment of the user,
+	// encrypting its secret.
+	SaveTOTP(t *TOTP) error
+
+	// DeleteTOTP removes the TOTP enrollment of the user.
+	DeleteTOTP(userID uint) error
+
+	// VerifyTOTP checks code against the user's TOTP enrollment and marks
+	// its time step as used. Returns ErrInvalidCode if the code is wrong or
+	// has been used before.
+	VerifyTOTP(userID uint, code string) error
+
+	// ConfirmTOTP verifies code like VerifyTOTP and completes the
+	// enrollment.
+	ConfirmTOTP(userID uint, code string) error
+
+	// RegenerateRecoveryCodes replaces the recovery codes of the user with
+	// a new batch and returns it. The codes can't be read afterwards.
+	RegenerateRecoveryCodes(userID uint) ([]string, error)
+
+	// UseRecoveryCode checks code against the unused recovery codes of the
+	// user and marks it as used. Returns how many are left, or
+	// ErrInvalidCode if none matches.
+	UseRecoveryCode(userID uint, code string) (int, error)
+
+	// RecoveryCodesLeft counts the unused recovery codes of the user.
+	RecoveryCodesLeft(userID uint) (int, error)
+
+	// Migrate auto-migrates the mfa models to database.
+	Migrate() error
+}
+
+type store struct {
+	db  *gorm.DB
+	key []byte
+}
+
+// NewStore creates Store. key encrypts the secrets at rest.
+func NewStore(db *gorm.DB, key []byte) Store {
+	return &store{db: db, key: key}
+}
+
+func (s *store) TOTP(userID uint) (*TOTP, error) {
+	var t TOTP
+	tx := s.db.Where("user_id = ?", userID).First(&t)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrTOTPNotFound
+		}
+		return nil, tx.Error
+	}
+
+	secret, err := util.Decrypt(s.key, t.EncryptedSecret)
+	if err != nil {
+		return nil, err
+	}
+	t.Secret = string(secret)
+
+	return &t, nil
+}
+
+func (s *store) SaveTOTP(t *TOTP) error {
+	encrypted, err := util.Encrypt(s.key, []byte(t.Secret))
+	if err != nil {
+		return err
+	}
+	t.EncryptedSecret = encrypted
+
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"encrypted_secret", "confirmed_at", "last_step", "updated_at"}),
+	}).Create(t).Error
+}
+
+func (s *store) DeleteTOTP(userID uint) error {
+	return s.db.Where("user_id = ?", userID).Delete(&TOTP{}).Error
+}
+
+func (s *store) VerifyTOTP(userID uint, code string) error {
+	t, err := s.TOTP(userID)
+	if err != nil {
+		return err
+	}
+
+	step, ok := ValidateTOTP(t.Secret, code, time.Now())
+	if !ok || step <= t.LastStep {
+		return ErrInvalidCode
+	}
+
+	// only one of concurrent requests with the same code gets through
+	tx := s.db.Model(&TOTP{}).
+		Where("user_id = ? AND last_step < ?", userID, step).
+		Update("last_step", step)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (s *store) ConfirmTOTP(userID uint, code string) error {
+	if err := s.VerifyTOTP(userID, code); err != nil {
+		return err
+	}
+
+	return s.db.Model(&TOTP{}).
+		Where("user_id = ? AND confirmed_at IS NULL", userID).
+		Update("confirmed_at", time.Now()).Error
+}
+
+func (s *store) RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	batch := make([]RecoveryCode, len(codes))
+	for i, code := range codes {
+		hash, err := util.HashString([]byte(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		batch[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&batch).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *store) UseRecoveryCode(userID uint, code string) (int, error) {
+	var unused []RecoveryCode
+	if err := s.db.Where("user_id = ? AND used_at IS NULL", userID).Find(&unused).Error; err != nil {
+		return 0, err
+	}
+
+	if len(unused) == 0 {
+		return 0, ErrNoRecoveryCodes
+	}
+
+	code = normalizeRecoveryCode(code)
+	for _, rc := range unused {
+		if !util.VerifyEncoded([]byte(code), []byte(rc.CodeHash)) {
+			continue
+		}
+
+		// only one of concurrent requests with the same code gets through
+		tx := s.db.Model(&RecoveryCode{}).
+			Where("id = ? AND used_at IS NULL", rc.ID).
+			Update("used_at", time.Now())
+		if tx.Error != nil {
+			return 0, tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			return 0, ErrInvalidCode
+		}
+
+		return len(unused) - 1, nil
+	}
+
+	return 0, ErrInvalidCode
+}
+
+func (s *store) RecoveryCodesLeft(userID uint) (int, error) {
+	var left int64
+	tx := s.db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&left)
+	return int(left), tx.Error
+}
+
+func (s *store) Migrate() error {
+	return s.db.AutoMigrate(&TOTP{}, &RecoveryCode{})
+}
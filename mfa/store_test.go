@@ -0,0 +1,160 @@
+package mfa
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store_SaveTOTP(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db, []byte("key"))
+	if err := s.SaveTOTP(&TOTP{UserID: 1, Secret: "JBSWY3DPEHPK3PXP"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored TOTP
+	db.First(&stored)
+	if stored.EncryptedSecret == "" || stored.EncryptedSecret == "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("secret stored as %q, want it encrypted", stored.EncryptedSecret)
+	}
+
+	// enrolling again replaces the pending secret
+	if err := s.SaveTOTP(&TOTP{UserID: 1, Secret: "KRSXG5CTMVRXEZLU"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.TOTP(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Secret != "KRSXG5CTMVRXEZLU" {
+		t.Errorf("TOTP().Secret = %v, want %v", got.Secret, "KRSXG5CTMVRXEZLU")
+	}
+
+	if _, err := s.TOTP(2); err != ErrTOTPNotFound {
+		t.Errorf("TOTP() error = %v, want %v", err, ErrTOTPNotFound)
+	}
+}
+
+func Test_store_VerifyTOTP(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(db, []byte("key"))
+	if err := s.SaveTOTP(&TOTP{UserID: 1, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ConfirmTOTP(1, code); err != nil {
+		t.Fatalf("ConfirmTOTP() error = %v", err)
+	}
+
+	if err := s.VerifyTOTP(1, code); err != ErrInvalidCode {
+		t.Errorf("VerifyTOTP() with a used code error = %v, want %v", err, ErrInvalidCode)
+	}
+
+	got, err := s.TOTP(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Confirmed() {
+		t.Error("Confirmed() = false after ConfirmTOTP, want true")
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db, nil).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
+
+func Test_store_UseRecoveryCode(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db, []byte("key"))
+	if _, err := s.UseRecoveryCode(1, "abcde-fghjk"); err != ErrNoRecoveryCodes {
+		t.Errorf("UseRecoveryCode() without codes error = %v, want %v", err, ErrNoRecoveryCodes)
+	}
+
+	old, err := s.RegenerateRecoveryCodes(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := s.RegenerateRecoveryCodes(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("RegenerateRecoveryCodes() = %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	tests := []struct {
+		name     string
+		userID   uint
+		code     string
+		wantLeft int
+		wantErr  error
+	}{
+		{name: "old batch", userID: 1, code: old[0], wantErr: ErrInvalidCode},
+		{name: "valid", userID: 1, code: codes[0], wantLeft: RecoveryCodeCount - 1},
+		{name: "used", userID: 1, code: codes[0], wantErr: ErrInvalidCode},
+		{name: "typed loosely", userID: 1, code: " " + strings.ToUpper(strings.Replace(codes[1], "-", "", 1)), wantLeft: RecoveryCodeCount - 2},
+		{name: "other user", userID: 2, code: codes[2], wantErr: ErrNoRecoveryCodes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, err := s.UseRecoveryCode(tt.userID, tt.code)
+			if err != tt.wantErr {
+				t.Fatalf("UseRecoveryCode() error = %v, want %v", err, tt.wantErr)
+			}
+			if left != tt.wantLeft {
+				t.Errorf("UseRecoveryCode() = %d, want %d", left, tt.wantLeft)
+			}
+		})
+	}
+
+	if left, _ := s.RecoveryCodesLeft(1); left != RecoveryCodeCount-2 {
+		t.Errorf("RecoveryCodesLeft() = %d, want %d", left, RecoveryCodeCount-2)
+	}
+}
@@ -0,0 +1,103 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters as defined by RFC 6238. They are what authenticator apps
+// assume when the provisioning uri doesn't say otherwise.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+
+	// totpSkew is how many steps before and after the current one are
+	// accepted to make up for clock drift.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random base32 encoded secret.
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPURI returns the otpauth uri authenticator apps scan as a QR code.
+func TOTPURI(issuer string, account string, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TOTPDigits))
+	q.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// ValidateTOTP reports whether code is valid for secret at t. The time step
+// the code belongs to is returned so the caller can refuse it next time.
+func ValidateTOTP(secret string, code string, t time.Time) (step int64, ok bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := t.Unix() / int64(TOTPPeriod.Seconds())
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// TOTPCode returns the code an authenticator app shows for secret at t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+
+	return totpCode(key, t.Unix()/int64(TOTPPeriod.Seconds())), nil
+}
+
+// totpCode computes the HOTP value of RFC 4226 for counter.
+func totpCode(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
@@ -0,0 +1,68 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_totpCode(t *testing.T) {
+	// RFC 6238 Appendix B test vectors for SHA1, truncated to 6 digits
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := totpCode(key, tt.unix/30); got != tt.want {
+				t.Errorf("totpCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	at := time.Unix(1111111109, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		t      time.Time
+		want   bool
+	}{
+		{name: "current step", secret: secret, code: "081804", t: at, want: true},
+		{name: "previous step", secret: secret, code: "081804", t: at.Add(TOTPPeriod), want: true},
+		{name: "next step", secret: secret, code: "081804", t: at.Add(-TOTPPeriod), want: true},
+		{name: "too old", secret: secret, code: "081804", t: at.Add(2 * TOTPPeriod), want: false},
+		{name: "lowercase secret", secret: strings.ToLower(secret), code: "081804", t: at, want: true},
+		{name: "wrong code", secret: secret, code: "123456", t: at, want: false},
+		{name: "short code", secret: secret, code: "81804", t: at, want: false},
+		{name: "malformed secret", secret: "not base32!", code: "081804", t: at, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := ValidateTOTP(tt.secret, tt.code, tt.t); got != tt.want {
+				t.Errorf("ValidateTOTP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	got := TOTPURI("Semaphore", "user@example.com", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/Semaphore:user@example.com?algorithm=SHA1&digits=6&issuer=Semaphore&period=30&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Errorf("TOTPURI() = %v, want %v", got, want)
+	}
+}
@@ -49,6 +49,14 @@ var (
 	ErrInvalidCodeChallengeLen        = errors.New("invalid_request")
 )
 
+// https://openid.net/specs/openid-connect-core-1_0.html#AuthError
+var (
+	ErrInteractionRequired             = errors.New("interaction_required")
+	ErrLoginRequired                   = errors.New("login_required")
+	ErrConsentRequired                 = errors.New("consent_required")
+	ErrUnmetAuthenticationRequirements = errors.New("unmet_authentication_requirements")
+)
+
 // Descriptions error description
 var Descriptions = map[error]string{
 	ErrInvalidRequest:                 "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed",
@@ -64,6 +72,11 @@ var Descriptions = map[error]string{
 	ErrCodeChallengeRquired:           "PKCE is required. code_challenge is missing",
 	ErrUnsupportedCodeChallengeMethod: "Selected code_challenge_method not supported",
 	ErrInvalidCodeChallengeLen:        "Code challenge length must be between 43 and 128 charachters long",
+
+	ErrInteractionRequired:             "The authorization server requires end-user interaction of some form to proceed",
+	ErrLoginRequired:                   "The authorization server requires end-user authentication",
+	ErrConsentRequired:                 "The authorization server requires end-user consent",
+	ErrUnmetAuthenticationRequirements: "The authorization server is unable to meet the requested authentication requirements",
 }
 
 // StatusCodes response error HTTP status code
@@ -81,4 +94,9 @@ var StatusCodes = map[error]int{
 	ErrCodeChallengeRquired:           400,
 	ErrUnsupportedCodeChallengeMethod: 400,
 	ErrInvalidCodeChallengeLen:        400,
+
+	ErrInteractionRequired:             401,
+	ErrLoginRequired:                   401,
+	ErrConsentRequired:                 401,
+	ErrUnmetAuthenticationRequirements: 401,
 }
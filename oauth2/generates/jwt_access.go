@@ -15,6 +15,8 @@ import (
 // JWTAccessClaims jwt claims
 type JWTAccessClaims struct {
 	jwt.StandardClaims
+	Roles  []string `json:"roles,omitempty"`
+	Groups []string `json:"groups,omitempty"`
 }
 
 // Valid claims verification
@@ -41,6 +43,10 @@ type JWTAccessGenerate struct {
 	SignedKey    []byte
 	SignedMethod jwt.SigningMethod
 	Issuer       string
+
+	// UserClaims gets the roles and groups claims of the user the token
+	// is generated for, none are included when it is nil
+	UserClaims func(ctx context.Context, client oauth2.ClientInfo, userID string) (roles []string, groups []string, err error)
 }
 
 // Token based on the UUID generated token
@@ -54,6 +60,14 @@ func (a *JWTAccessGenerate) Token(ctx context.Context, data *oauth2.GenerateBasi
 		},
 	}
 
+	if a.UserClaims != nil && data.UserID != "" {
+		roles, groups, err := a.UserClaims(ctx, data.Client, data.UserID)
+		if err != nil {
+			return "", "", err
+		}
+		claims.Roles, claims.Groups = roles, groups
+	}
+
 	token := jwt.NewWithClaims(a.SignedMethod, claims)
 	if a.SignedKeyID != "" {
 		token.Header["kid"] = a.SignedKeyID
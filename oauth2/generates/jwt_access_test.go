@@ -28,7 +28,7 @@ func TestJWTAccess(t *testing.T) {
 			},
 		}
 
-		gen := generates.NewJWTAccessGenerate("", []byte("00000000"), jwt.SigningMethodHS512)
+		gen := generates.NewJWTAccessGenerate("", "", []byte("00000000"), jwt.SigningMethodHS512)
 		access, refresh, err := gen.Token(context.Background(), data, true)
 		So(err, ShouldBeNil)
 		So(access, ShouldNotBeEmpty)
@@ -49,3 +49,36 @@ func TestJWTAccess(t *testing.T) {
 		So(claims.Subject, ShouldEqual, "000000")
 	})
 }
+
+func TestJWTAccessUserClaims(t *testing.T) {
+	Convey("Test JWT Access Generate with user claims", t, func() {
+		data := &oauth2.GenerateBasic{
+			Client: &models.Client{
+				ID:     "123456",
+				Secret: "123456",
+			},
+			UserID: "000000",
+			TokenInfo: &models.Token{
+				AccessCreateAt:  time.Now(),
+				AccessExpiresIn: time.Second * 120,
+			},
+		}
+
+		gen := generates.NewJWTAccessGenerate("", "", []byte("00000000"), jwt.SigningMethodHS512)
+		gen.UserClaims = func(ctx context.Context, client oauth2.ClientInfo, userID string) ([]string, []string, error) {
+			So(client.GetID(), ShouldEqual, "123456")
+			So(userID, ShouldEqual, "000000")
+			return []string{"admin"}, []string{"staff"}, nil
+		}
+		access, _, err := gen.Token(context.Background(), data, false)
+		So(err, ShouldBeNil)
+
+		claims := &generates.JWTAccessClaims{}
+		_, err = jwt.ParseWithClaims(access, claims, func(t *jwt.Token) (interface{}, error) {
+			return []byte("00000000"), nil
+		})
+		So(err, ShouldBeNil)
+		So(claims.Roles, ShouldResemble, []string{"admin"})
+		So(claims.Groups, ShouldResemble, []string{"staff"})
+	})
+}
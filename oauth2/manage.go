@@ -13,6 +13,7 @@ type TokenGenerateRequest struct {
 	UserID              string
 	RedirectURI         string
 	Scope               string
+	SessionID           string
 	Code                string
 	CodeChallenge       string
 	CodeChallengeMethod CodeChallengeMethod
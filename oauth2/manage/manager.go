@@ -156,6 +156,7 @@ func (m *Manager) GenerateAuthToken(ctx context.Context, rt oauth2.ResponseType,
 	ti.SetUserID(tgr.UserID)
 	ti.SetRedirectURI(tgr.RedirectURI)
 	ti.SetScope(tgr.Scope)
+	ti.SetSessionID(tgr.SessionID)
 
 	createAt := time.Now()
 	td := &oauth2.GenerateBasic{
@@ -306,6 +307,7 @@ func (m *Manager) GenerateAccessToken(ctx context.Context, gt oauth2.GrantType,
 		}
 		tgr.UserID = ti.GetUserID()
 		tgr.Scope = ti.GetScope()
+		tgr.SessionID = ti.GetSessionID()
 		if exp := ti.GetAccessExpiresIn(); exp > 0 {
 			tgr.AccessTokenExp = exp
 		}
@@ -316,6 +318,7 @@ func (m *Manager) GenerateAccessToken(ctx context.Context, gt oauth2.GrantType,
 	ti.SetUserID(tgr.UserID)
 	ti.SetRedirectURI(tgr.RedirectURI)
 	ti.SetScope(tgr.Scope)
+	ti.SetSessionID(tgr.SessionID)
 
 	createAt := time.Now()
 	ti.SetAccessCreateAt(createAt)
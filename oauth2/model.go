@@ -13,6 +13,19 @@ type (
 		GetUserID() string
 	}
 
+	// ClientLogoutInfo the client logout registration interface
+	ClientLogoutInfo interface {
+		GetPostLogoutRedirectURIs() []string
+		GetFrontchannelLogoutURI() string
+		GetBackchannelLogoutURI() string
+	}
+
+	// ClientClaimsInfo the client registration of optional token claims
+	ClientClaimsInfo interface {
+		GetRolesClaim() bool
+		GetGroupsClaim() bool
+	}
+
 	// ClientPasswordVerifier the password handler interface
 	ClientPasswordVerifier interface {
 		VerifyPassword(string) bool
@@ -30,6 +43,8 @@ type (
 		SetRedirectURI(string)
 		GetScope() string
 		SetScope(string)
+		GetSessionID() string
+		SetSessionID(string)
 
 		GetCode() string
 		SetCode(string)
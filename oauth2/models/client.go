@@ -6,6 +6,13 @@ type Client struct {
 	Secret string
 	Domain string
 	UserID string
+
+	PostLogoutRedirectURIs []string
+	FrontchannelLogoutURI  string
+	BackchannelLogoutURI   string
+
+	RolesClaim  bool
+	GroupsClaim bool
 }
 
 // GetID client id
@@ -27,3 +34,28 @@ func (c *Client) GetDomain() string {
 func (c *Client) GetUserID() string {
 	return c.UserID
 }
+
+// GetPostLogoutRedirectURIs registered uris the client may be sent to after logout
+func (c *Client) GetPostLogoutRedirectURIs() []string {
+	return c.PostLogoutRedirectURIs
+}
+
+// GetFrontchannelLogoutURI uri rendered in an iframe on logout
+func (c *Client) GetFrontchannelLogoutURI() string {
+	return c.FrontchannelLogoutURI
+}
+
+// GetBackchannelLogoutURI uri the logout token is posted to on logout
+func (c *Client) GetBackchannelLogoutURI() string {
+	return c.BackchannelLogoutURI
+}
+
+// GetRolesClaim whether the roles of the user are included in its tokens
+func (c *Client) GetRolesClaim() bool {
+	return c.RolesClaim
+}
+
+// GetGroupsClaim whether the groups of the user are included in its tokens
+func (c *Client) GetGroupsClaim() bool {
+	return c.GroupsClaim
+}
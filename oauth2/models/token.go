@@ -17,6 +17,7 @@ type Token struct {
 	UserID              string        `bson:"UserID"`
 	RedirectURI         string        `bson:"RedirectURI"`
 	Scope               string        `bson:"Scope"`
+	SessionID           string        `bson:"SessionID"`
 	Code                string        `bson:"Code"`
 	CodeChallenge       string        `bson:"CodeChallenge"`
 	CodeChallengeMethod string        `bson:"CodeChallengeMethod"`
@@ -75,6 +76,16 @@ func (t *Token) SetScope(scope string) {
 	t.Scope = scope
 }
 
+// GetSessionID the session the authorization was granted in
+func (t *Token) GetSessionID() string {
+	return t.SessionID
+}
+
+// SetSessionID the session the authorization was granted in
+func (t *Token) SetSessionID(sessionID string) {
+	t.SessionID = sessionID
+}
+
 // GetCode authorization code
 func (t *Token) GetCode() string {
 	return t.Code
@@ -43,6 +43,7 @@ type AuthorizeRequest struct {
 	RedirectURI         string
 	State               string
 	UserID              string
+	SessionID           string
 	CodeChallenge       string
 	CodeChallengeMethod oauth2.CodeChallengeMethod
 	AccessTokenExp      time.Duration
@@ -43,6 +43,9 @@ type (
 	// AuthorizeScopeHandler set the authorized scope
 	AuthorizeScopeHandler func(w http.ResponseWriter, r *http.Request) (scope string, err error)
 
+	// AuthorizeSessionHandler get the session id the authorization is granted in
+	AuthorizeSessionHandler func(r *http.Request) (sessionID string, err error)
+
 	// AccessTokenExpHandler set expiration date for the access token
 	AccessTokenExpHandler func(w http.ResponseWriter, r *http.Request) (exp time.Duration, err error)
 
@@ -55,6 +55,7 @@ type Server struct {
 	ExtensionFieldsHandler       ExtensionFieldsHandler
 	AccessTokenExpHandler        AccessTokenExpHandler
 	AuthorizeScopeHandler        AuthorizeScopeHandler
+	AuthorizeSessionHandler      AuthorizeSessionHandler
 	ResponseTokenHandler         ResponseTokenHandler
 }
 
@@ -71,10 +72,29 @@ func (s *Server) redirectError(w http.ResponseWriter, req *AuthorizeRequest, err
 		return err
 	}
 
+	if rerr := s.resolveRedirectURI(req); rerr != nil {
+		return err
+	}
+
 	data, _, _ := s.GetErrorData(err)
 	return s.redirect(w, req, data)
 }
 
+// resolveRedirectURI falls back to the default domain provided by the client
+// when the request has no redirect uri.
+func (s *Server) resolveRedirectURI(req *AuthorizeRequest) error {
+	if req.RedirectURI != "" {
+		return nil
+	}
+
+	client, err := s.Manager.GetClient(req.Request.Context(), req.ClientID)
+	if err != nil {
+		return err
+	}
+	req.RedirectURI = client.GetDomain()
+	return nil
+}
+
 func (s *Server) redirect(w http.ResponseWriter, req *AuthorizeRequest, data map[string]interface{}) error {
 	uri, err := s.GetRedirectURI(req, data)
 	if err != nil {
@@ -129,8 +149,6 @@ func (s *Server) GetRedirectURI(req *AuthorizeRequest, data map[string]interface
 	}
 
 	switch req.ResponseType {
-	case oauth2.Code:
-		u.RawQuery = q.Encode()
 	case oauth2.Token:
 		u.RawQuery = ""
 		fragment, err := url.QueryUnescape(q.Encode())
@@ -138,6 +156,8 @@ func (s *Server) GetRedirectURI(req *AuthorizeRequest, data map[string]interface
 			return "", err
 		}
 		u.Fragment = fragment
+	default:
+		u.RawQuery = q.Encode()
 	}
 
 	return u.String(), nil
@@ -172,19 +192,29 @@ func (s *Server) ValidationAuthorizeRequest(r *http.Request) (*AuthorizeRequest,
 		return nil, errors.ErrInvalidRequest
 	}
 
-	resType := oauth2.ResponseType(r.FormValue("response_type"))
-	if resType.String() == "" {
-		return nil, errors.ErrUnsupportedResponseType
-	} else if allowed := s.CheckResponseType(resType); !allowed {
-		return nil, errors.ErrUnauthorizedClient
+	// from here on errors are returned along with the request so they can
+	// be redirected back to the client
+	req := &AuthorizeRequest{
+		RedirectURI:  redirectURI,
+		ResponseType: oauth2.ResponseType(r.FormValue("response_type")),
+		ClientID:     clientID,
+		State:        r.FormValue("state"),
+		Scope:        r.FormValue("scope"),
+		Request:      r,
+	}
+
+	if req.ResponseType.String() == "" {
+		return req, errors.ErrUnsupportedResponseType
+	} else if allowed := s.CheckResponseType(req.ResponseType); !allowed {
+		return req, errors.ErrUnauthorizedClient
 	}
 
 	cc := r.FormValue("code_challenge")
 	if cc == "" && s.Config.ForcePKCE {
-		return nil, errors.ErrCodeChallengeRquired
+		return req, errors.ErrCodeChallengeRquired
 	}
 	if cc != "" && (len(cc) < 43 || len(cc) > 128) {
-		return nil, errors.ErrInvalidCodeChallengeLen
+		return req, errors.ErrInvalidCodeChallengeLen
 	}
 
 	ccm := oauth2.CodeChallengeMethod(r.FormValue("code_challenge_method"))
@@ -193,19 +223,11 @@ func (s *Server) ValidationAuthorizeRequest(r *http.Request) (*AuthorizeRequest,
 	//	ccm = oauth2.CodeChallengePlain
 	//}
 	if ccm != "" && !s.CheckCodeChallengeMethod(ccm) {
-		return nil, errors.ErrUnsupportedCodeChallengeMethod
+		return req, errors.ErrUnsupportedCodeChallengeMethod
 	}
 
-	req := &AuthorizeRequest{
-		RedirectURI:         redirectURI,
-		ResponseType:        resType,
-		ClientID:            clientID,
-		State:               r.FormValue("state"),
-		Scope:               r.FormValue("scope"),
-		Request:             r,
-		CodeChallenge:       cc,
-		CodeChallengeMethod: ccm,
-	}
+	req.CodeChallenge = cc
+	req.CodeChallengeMethod = ccm
 	return req, nil
 }
 
@@ -231,6 +253,7 @@ func (s *Server) GetAuthorizeToken(ctx context.Context, req *AuthorizeRequest) (
 		UserID:         req.UserID,
 		RedirectURI:    req.RedirectURI,
 		Scope:          req.Scope,
+		SessionID:      req.SessionID,
 		AccessTokenExp: req.AccessTokenExp,
 		Request:        req.Request,
 	}
@@ -283,17 +306,26 @@ func (s *Server) HandleAuthorizeRequest(w http.ResponseWriter, r *http.Request)
 	if fn := s.AuthorizeScopeHandler; fn != nil {
 		scope, err := fn(w, r)
 		if err != nil {
-			return err
+			return s.handleError(w, req, err)
 		} else if scope != "" {
 			req.Scope = scope
 		}
 	}
 
+	// bind the authorization to the user's session
+	if fn := s.AuthorizeSessionHandler; fn != nil {
+		sid, err := fn(r)
+		if err != nil {
+			return s.handleError(w, req, err)
+		}
+		req.SessionID = sid
+	}
+
 	// specify the expiration time of access token
 	if fn := s.AccessTokenExpHandler; fn != nil {
 		exp, err := fn(w, r)
 		if err != nil {
-			return err
+			return s.handleError(w, req, err)
 		}
 		req.AccessTokenExp = exp
 	}
@@ -304,12 +336,8 @@ func (s *Server) HandleAuthorizeRequest(w http.ResponseWriter, r *http.Request)
 	}
 
 	// If the redirect URI is empty, the default domain provided by the client is used.
-	if req.RedirectURI == "" {
-		client, err := s.Manager.GetClient(ctx, req.ClientID)
-		if err != nil {
-			return err
-		}
-		req.RedirectURI = client.GetDomain()
+	if err := s.resolveRedirectURI(req); err != nil {
+		return err
 	}
 
 	return s.redirect(w, req, s.GetAuthorizeData(req.ResponseType, ti))
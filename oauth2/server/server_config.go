@@ -89,6 +89,11 @@ func (s *Server) SetAuthorizeScopeHandler(handler AuthorizeScopeHandler) {
 	s.AuthorizeScopeHandler = handler
 }
 
+// SetAuthorizeSessionHandler set the session the authorization is granted in
+func (s *Server) SetAuthorizeSessionHandler(handler AuthorizeSessionHandler) {
+	s.AuthorizeSessionHandler = handler
+}
+
 // SetResponseTokenHandler response token handing
 func (s *Server) SetResponseTokenHandler(handler ResponseTokenHandler) {
 	s.ResponseTokenHandler = handler
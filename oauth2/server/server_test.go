@@ -242,6 +242,41 @@ func TestImplicit(t *testing.T) {
 		Expect().Status(http.StatusOK)
 }
 
+func TestAuthorizeErrorRedirect(t *testing.T) {
+	tsrv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testServer(t, w, r)
+	}))
+	defer tsrv.Close()
+	e := httpexpect.New(t, tsrv.URL)
+
+	csrv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth2":
+			r.ParseForm()
+			if r.Form.Get("error") != errors.ErrUnsupportedResponseType.Error() {
+				t.Error("unexpected error:", r.Form.Get("error"))
+			}
+			if r.Form.Get("state") != "123" {
+				t.Error("unrecognized state:", r.Form.Get("state"))
+			}
+		}
+	}))
+	defer csrv.Close()
+
+	manager.MapClientStorage(clientStore(csrv.URL))
+	srv = server.NewDefaultServer(manager)
+	srv.SetUserAuthorizationHandler(func(w http.ResponseWriter, r *http.Request) (userID string, err error) {
+		t.Error("user authorization must not be reached")
+		return
+	})
+
+	e.GET("/authorize").
+		WithQuery("client_id", clientID).
+		WithQuery("state", "123").
+		WithQuery("redirect_uri", csrv.URL+"/oauth2").
+		Expect().Status(http.StatusOK)
+}
+
 func TestPasswordCredentials(t *testing.T) {
 	tsrv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		testServer(t, w, r)
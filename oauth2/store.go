@@ -32,4 +32,11 @@ type (
 		// use the refresh token for token information data
 		GetByRefresh(ctx context.Context, refresh string) (TokenInfo, error)
 	}
+
+	// UserTokenStore the token information storage interface that can
+	// delete everything issued to a user
+	UserTokenStore interface {
+		// delete the authorization codes and tokens of the user
+		RemoveByUserID(ctx context.Context, userID string) error
+	}
 )
@@ -13,17 +13,24 @@ const ClientStoreCachePrefix = "oauth:client:"
 
 // NewClientStoreRedis create client store
 func NewClientStoreRedis(rdb *redis.Client) *ClientStoreRedis {
-	return &ClientStoreRedis{rdb: rdb}
+	return &ClientStoreRedis{rdb: rdb, prefix: ClientStoreCachePrefix}
+}
+
+// NewTenantClientStoreRedis create client store of the clients of a tenant,
+// which can't be found by the stores of other tenants
+func NewTenantClientStoreRedis(rdb *redis.Client, tenant string) *ClientStoreRedis {
+	return &ClientStoreRedis{rdb: rdb, prefix: "oauth:tenant:" + tenant + ":client:"}
 }
 
 // ClientStoreRedis client information store
 type ClientStoreRedis struct {
-	rdb *redis.Client
+	rdb    *redis.Client
+	prefix string
 }
 
 // GetByID according to the ID for the client information
 func (cs *ClientStoreRedis) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
-	key := ClientStoreCachePrefix + id
+	key := cs.prefix + id
 	res := cs.rdb.Get(ctx, key)
 	if res.Err() != nil {
 		return nil, res.Err()
@@ -40,7 +47,7 @@ func (cs *ClientStoreRedis) GetByID(ctx context.Context, id string) (oauth2.Clie
 
 // Set set client information
 func (cs *ClientStoreRedis) Set(id string, cli oauth2.ClientInfo) (err error) {
-	key := ClientStoreCachePrefix + id
+	key := cs.prefix + id
 
 	jsonBuf := &bytes.Buffer{}
 	err = json.NewEncoder(jsonBuf).Encode(cli)
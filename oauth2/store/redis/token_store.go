@@ -13,9 +13,10 @@ import (
 )
 
 var (
-	_             oauth2.TokenStore = &TokenStore{}
-	jsonMarshal                     = jsoniter.Marshal
-	jsonUnmarshal                   = jsoniter.Unmarshal
+	_             oauth2.TokenStore     = &TokenStore{}
+	_             oauth2.UserTokenStore = &TokenStore{}
+	jsonMarshal                         = jsoniter.Marshal
+	jsonUnmarshal                       = jsoniter.Unmarshal
 )
 
 // NewRedisStore create an instance of a redis store
@@ -63,6 +64,7 @@ type clienter interface {
 	Exists(ctx context.Context, key ...string) *redis.IntCmd
 	TxPipeline() redis.Pipeliner
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
 	Close() error
 }
 
@@ -81,6 +83,28 @@ func (s *TokenStore) wrapperKey(key string) string {
 	return fmt.Sprintf("%s%s", s.ns, key)
 }
 
+// userKey is a sorted set of the keys holding what was issued to the user,
+// scored by when they expire.
+func (s *TokenStore) userKey(userID string) string {
+	return s.wrapperKey("user:" + userID)
+}
+
+// index records key as issued to the user of info and drops the entries
+// that have expired.
+func (s *TokenStore) index(ctx context.Context, pipe redis.Pipeliner, info oauth2.TokenInfo, key string, expiresIn time.Duration) {
+	userID := info.GetUserID()
+	if userID == "" {
+		return
+	}
+
+	now := time.Now()
+	pipe.ZAdd(ctx, s.userKey(userID), &redis.Z{
+		Score:  float64(now.Add(expiresIn).Unix()),
+		Member: key,
+	})
+	pipe.ZRemRangeByScore(ctx, s.userKey(userID), "-inf", fmt.Sprint(now.Unix()))
+}
+
 func (s *TokenStore) checkError(result redis.Cmder) (bool, error) {
 	if err := result.Err(); err != nil {
 		if err == redis.Nil {
@@ -184,6 +208,7 @@ func (s *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
 	pipe := s.cli.TxPipeline()
 	if code := info.GetCode(); code != "" {
 		pipe.Set(ctx, s.wrapperKey(code), jv, info.GetCodeExpiresIn())
+		s.index(ctx, pipe, info, code, info.GetCodeExpiresIn())
 	} else {
 		basicID := uuid.Must(uuid.NewRandom()).String()
 		aexp := info.GetAccessExpiresIn()
@@ -199,6 +224,7 @@ func (s *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
 
 		pipe.Set(ctx, s.wrapperKey(info.GetAccess()), basicID, aexp)
 		pipe.Set(ctx, s.wrapperKey(basicID), jv, rexp)
+		s.index(ctx, pipe, info, basicID, rexp)
 	}
 
 	if _, err := pipe.Exec(ctx); err != nil {
@@ -222,6 +248,43 @@ func (s *TokenStore) RemoveByRefresh(ctx context.Context, refresh string) error
 	return s.removeToken(ctx, refresh, true)
 }
 
+// RemoveByUserID Delete the authorization codes and tokens issued to the user
+func (s *TokenStore) RemoveByUserID(ctx context.Context, userID string) error {
+	keys, err := s.cli.ZRange(ctx, s.userKey(userID), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	remove := []string{s.userKey(userID)}
+	for _, key := range keys {
+		token, err := s.getToken(ctx, key)
+		if err != nil {
+			return err
+		} else if token == nil {
+			continue
+		}
+
+		remove = append(remove, s.wrapperKey(key))
+		if token.GetCode() != "" {
+			continue
+		}
+		if access := token.GetAccess(); access != "" {
+			remove = append(remove, s.wrapperKey(access))
+		}
+		if refresh := token.GetRefresh(); refresh != "" {
+			remove = append(remove, s.wrapperKey(refresh))
+		}
+	}
+
+	// one key at a time, a cluster can't delete keys of different slots at once
+	for _, key := range remove {
+		if _, err := s.checkError(s.cli.Del(ctx, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetByCode Use the authorization code for token information data
 func (s *TokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
 	return s.getToken(ctx, code)
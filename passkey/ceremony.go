@@ -0,0 +1,84 @@
+package passkey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const ceremonyKeyPrefix = "webauthn:ceremony:"
+
+// CeremonyTimeout is how long the browser has to answer a challenge.
+const CeremonyTimeout = 5 * time.Minute
+
+// Ceremony kinds, a challenge issued for one can't complete another.
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+	CeremonySecondFactor = "second_factor"
+)
+
+// Ceremony is the state kept between issuing a challenge and verifying
+// the authenticator response.
+type Ceremony struct {
+	ID     string
+	Kind   string
+	UserID uint
+
+	Session webauthn.SessionData
+}
+
+type CeremonyStore interface {
+	// Create stores a new ceremony and fills its ID.
+	Create(ctx context.Context, c *Ceremony) error
+
+	// Take gets and removes the ceremony, a challenge can be answered once.
+	// Returns ErrCeremonyNotFound if it has expired or has been taken.
+	Take(ctx context.Context, id string) (*Ceremony, error)
+}
+
+type ceremonyStore struct {
+	rdb *redis.Client
+}
+
+// NewCeremonyStore creates CeremonyStore backed by redis. Ceremonies
+// expire after CeremonyTimeout.
+func NewCeremonyStore(rdb *redis.Client) CeremonyStore {
+	return &ceremonyStore{rdb: rdb}
+}
+
+func (s *ceremonyStore) Create(ctx context.Context, c *Ceremony) error {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+	c.ID = id.String()
+
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return s.rdb.Set(ctx, ceremonyKeyPrefix+c.ID, buf, CeremonyTimeout).Err()
+}
+
+func (s *ceremonyStore) Take(ctx context.Context, id string) (*Ceremony, error) {
+	buf, err := s.rdb.GetDel(ctx, ceremonyKeyPrefix+id).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrCeremonyNotFound
+		}
+		return nil, err
+	}
+
+	c := &Ceremony{}
+	if err := json.Unmarshal(buf, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,11 @@
+package passkey
+
+import "errors"
+
+var (
+	ErrCredentialNotFound = errors.New("credential not found")
+	ErrCeremonyNotFound   = errors.New("ceremony not found")
+	// ErrSignCount means the authenticator reported a signature counter
+	// that didn't increase, the credential may have been cloned.
+	ErrSignCount = errors.New("signature counter did not increase")
+)
@@ -0,0 +1,73 @@
+package passkey
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Credential is a WebAuthn public key credential registered by a user.
+type Credential struct {
+	ID     uint `gorm:"primarykey" json:"id"`
+	UserID uint `gorm:"index" json:"-"`
+
+	// Name tells the user's credentials apart in the dashboard.
+	Name string `json:"name"`
+
+	CredentialID    []byte `gorm:"uniqueIndex" json:"-"`
+	PublicKey       []byte `json:"-"`
+	AttestationType string `json:"-"`
+	// Transports is a space separated list of protocol.AuthenticatorTransport.
+	Transports string `json:"-"`
+	AAGUID     []byte `json:"-"`
+	SignCount  uint32 `json:"-"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// TableName overrides the default credentials.
+func (Credential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// NewCredential converts a credential that has just been registered.
+func NewCredential(userID uint, name string, c *webauthn.Credential) *Credential {
+	transports := make([]string, len(c.Transport))
+	for i, t := range c.Transport {
+		transports[i] = string(t)
+	}
+
+	return &Credential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transports:      strings.Join(transports, " "),
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+	}
+}
+
+// WebAuthn converts c to what the webauthn library verifies against.
+func (c *Credential) WebAuthn() webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	for _, t := range strings.Fields(c.Transports) {
+		transports = append(transports, protocol.AuthenticatorTransport(t))
+	}
+
+	return webauthn.Credential{
+		ID:              c.CredentialID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.AAGUID,
+			SignCount: c.SignCount,
+		},
+	}
+}
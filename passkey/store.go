@@ -0,0 +1,108 @@
+package passkey
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Store interface {
+	// Create stores a newly registered credential.
+	Create(c *Credential) error
+
+	// Credentials gets every credential registered by the user.
+	Credentials(userID uint) ([]*Credential, error)
+
+	// Credential gets a credential of the user by its id.
+	// Returns ErrCredentialNotFound if the user has no such credential.
+	Credential(userID uint, id uint) (*Credential, error)
+
+	// Used records a successful assertion with signCount. Returns
+	// ErrSignCount if the counter didn't increase since the last one.
+	Used(c *Credential, signCount uint32) error
+
+	// Rename changes the name of a credential of the user.
+	Rename(userID uint, id uint, name string) error
+
+	// Delete removes a credential of the user.
+	Delete(userID uint, id uint) error
+
+	// Migrate auto-migrates the Credential model to database.
+	Migrate() error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+func (s *store) Create(c *Credential) error {
+	return s.db.Create(c).Error
+}
+
+func (s *store) Credentials(userID uint) ([]*Credential, error) {
+	var credentials []*Credential
+	tx := s.db.Where("user_id = ?", userID).Order("id").Find(&credentials)
+	return credentials, tx.Error
+}
+
+func (s *store) Credential(userID uint, id uint) (*Credential, error) {
+	var c Credential
+	tx := s.db.Where("user_id = ? AND id = ?", userID, id).Limit(1).Find(&c)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrCredentialNotFound
+	}
+	return &c, nil
+}
+
+func (s *store) Used(c *Credential, signCount uint32) error {
+	// authenticators without a counter always report 0
+	if signCount == 0 && c.SignCount == 0 {
+		return s.db.Model(c).Update("last_used_at", time.Now()).Error
+	}
+
+	tx := s.db.Model(&Credential{}).
+		Where("id = ? AND sign_count < ?", c.ID, signCount).
+		Updates(map[string]interface{}{"sign_count": signCount, "last_used_at": time.Now()})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrSignCount
+	}
+
+	c.SignCount = signCount
+	return nil
+}
+
+func (s *store) Rename(userID uint, id uint, name string) error {
+	tx := s.db.Model(&Credential{}).Where("user_id = ? AND id = ?", userID, id).Update("name", name)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
+
+func (s *store) Delete(userID uint, id uint) error {
+	tx := s.db.Where("user_id = ? AND id = ?", userID, id).Delete(&Credential{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
+
+func (s *store) Migrate() error {
+	return s.db.AutoMigrate(&Credential{})
+}
@@ -0,0 +1,131 @@
+package passkey
+
+import (
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store_Used(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	cred := NewCredential(1, "Laptop", &webauthn.Credential{
+		ID:        []byte("credential"),
+		PublicKey: []byte("key"),
+		Transport: []protocol.AuthenticatorTransport{protocol.USB, protocol.NFC},
+		Authenticator: webauthn.Authenticator{
+			SignCount: 5,
+		},
+	})
+	if err := s.Create(cred); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cred.WebAuthn().Transport; len(got) != 2 || got[1] != protocol.NFC {
+		t.Errorf("WebAuthn().Transport = %v, want [usb nfc]", got)
+	}
+
+	tests := []struct {
+		name      string
+		signCount uint32
+		wantErr   error
+	}{
+		{name: "increased", signCount: 6},
+		{name: "same", signCount: 6, wantErr: ErrSignCount},
+		{name: "decreased", signCount: 2, wantErr: ErrSignCount},
+		{name: "reset", signCount: 0, wantErr: ErrSignCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Used(cred, tt.signCount); err != tt.wantErr {
+				t.Errorf("Used() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := s.Credential(1, cred.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SignCount != 6 || got.LastUsedAt == nil {
+		t.Errorf("Credential() = sign count %d, last used %v, want 6 and set", got.SignCount, got.LastUsedAt)
+	}
+
+	// authenticators without a counter always report 0
+	counterless := NewCredential(1, "Phone", &webauthn.Credential{ID: []byte("counterless")})
+	if err := s.Create(counterless); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Used(counterless, 0); err != nil {
+			t.Errorf("Used() without counter error = %v", err)
+		}
+	}
+}
+
+func Test_store_Delete(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	cred := &Credential{UserID: 1, Name: "Key", CredentialID: []byte("credential")}
+	if err := s.Create(cred); err != nil {
+		t.Fatal(err)
+	}
+
+	// another user's credential can't be touched
+	if err := s.Rename(2, cred.ID, "Mine"); err != ErrCredentialNotFound {
+		t.Errorf("Rename() other user error = %v, want %v", err, ErrCredentialNotFound)
+	}
+	if err := s.Delete(2, cred.ID); err != ErrCredentialNotFound {
+		t.Errorf("Delete() other user error = %v, want %v", err, ErrCredentialNotFound)
+	}
+
+	if err := s.Rename(1, cred.ID, "Security key"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Credential(1, cred.ID); got == nil || got.Name != "Security key" {
+		t.Errorf("Credential() after Rename = %+v, want name Security key", got)
+	}
+
+	if err := s.Delete(1, cred.ID); err != nil {
+		t.Fatal(err)
+	}
+	if credentials, _ := s.Credentials(1); len(credentials) != 0 {
+		t.Errorf("Credentials() after Delete = %v, want none", credentials)
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
@@ -0,0 +1,50 @@
+package passkey
+
+import (
+	"github.com/9d4/semaphore/user"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// User adapts user.User to webauthn.User.
+type User struct {
+	*user.User
+	Credentials []*Credential
+}
+
+var _ webauthn.User = (*User)(nil)
+
+// WebAuthnID is the user handle stored on the authenticator. The uuid is
+// used since the handle must not contain personal information.
+func (u *User) WebAuthnID() []byte {
+	return []byte(u.UUID)
+}
+
+func (u *User) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *User) WebAuthnDisplayName() string {
+	return u.FirstName + " " + u.LastName
+}
+
+func (u *User) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *User) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.Credentials))
+	for i, c := range u.Credentials {
+		credentials[i] = c.WebAuthn()
+	}
+	return credentials
+}
+
+// Credential finds the credential of the user with the given credential id.
+func (u *User) Credential(credentialID []byte) *Credential {
+	for _, c := range u.Credentials {
+		if string(c.CredentialID) == string(credentialID) {
+			return c
+		}
+	}
+	return nil
+}
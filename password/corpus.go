@@ -0,0 +1,117 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// Corpus tells whether a password is known to have been breached.
+type Corpus interface {
+	// Breached reports whether password is in the corpus.
+	Breached(password string) (bool, error)
+}
+
+// FileCorpus is a Corpus read from a file of uppercase hex SHA-1 hashes,
+// one "HASH:COUNT" line per password sorted by hash. It is the format of
+// the Pwned Passwords download, which is the k-anonymity range files
+// joined with their prefixes, so the corpus is searched offline without
+// loading it in memory.
+type FileCorpus struct {
+	f    *os.File
+	size int64
+}
+
+// corpusLineMax is longer than any line of the corpus, hash and count
+// included.
+const corpusLineMax = 128
+
+// OpenCorpus opens the corpus file at path.
+func OpenCorpus(path string) (*FileCorpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &FileCorpus{f: f, size: info.Size()}, nil
+}
+
+// Breached binary searches the corpus for the hash of password.
+func (c *FileCorpus) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := []byte(strings.ToUpper(hex.EncodeToString(sum[:])))
+
+	// lo is always the start of a line, lines starting before it hash
+	// lower and lines starting at or after hi hash higher
+	lo, hi := int64(0), c.size
+	for lo < hi {
+		start, line, err := c.lineAfter(lo+(hi-lo)/2, lo)
+		if err == nil && start >= hi {
+			// no line starts in the upper half, go on from lo
+			start, line, err = c.lineAfter(lo, lo)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch cmp := bytes.Compare(lineHash(line), hash); {
+		case cmp == 0:
+			return true, nil
+		case cmp < 0:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = start
+		}
+	}
+
+	return false, nil
+}
+
+// lineAfter returns the first line starting at or after offset, offset
+// being lo means a line starts there.
+func (c *FileCorpus) lineAfter(offset int64, lo int64) (int64, []byte, error) {
+	buf := make([]byte, 2*corpusLineMax)
+	n, err := c.f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	buf = buf[:n]
+
+	start := offset
+	if offset != lo {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return c.size, nil, nil
+		}
+		start += int64(i) + 1
+		buf = buf[i+1:]
+	}
+
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	}
+	return start, buf, nil
+}
+
+// lineHash returns the hash a corpus line starts with.
+func lineHash(line []byte) []byte {
+	line = bytes.TrimRight(line, "\r")
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return bytes.ToUpper(line)
+}
+
+// Close closes the corpus file.
+func (c *FileCorpus) Close() error {
+	return c.f.Close()
+}
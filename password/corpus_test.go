@@ -0,0 +1,68 @@
+package password
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFileCorpus_Breached(t *testing.T) {
+	var breached []string
+	for i := 0; i < 500; i++ {
+		breached = append(breached, fmt.Sprintf("breached%d", i))
+	}
+
+	tests := []struct {
+		name      string
+		separator string
+		trailing  string
+	}{
+		{name: "lf", separator: "\n", trailing: "\n"},
+		{name: "crlf", separator: "\r\n", trailing: "\r\n"},
+		{name: "no trailing newline", separator: "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := writeCorpus(t, breached, tt.separator, tt.trailing)
+			defer c.Close()
+
+			for _, pwd := range breached {
+				if ok, err := c.Breached(pwd); err != nil || !ok {
+					t.Fatalf("Breached(%q) = %v, %v, want true", pwd, ok, err)
+				}
+			}
+			for _, pwd := range []string{"", "breached500", "correct horse battery staple"} {
+				if ok, err := c.Breached(pwd); err != nil || ok {
+					t.Fatalf("Breached(%q) = %v, %v, want false", pwd, ok, err)
+				}
+			}
+		})
+	}
+}
+
+// writeCorpus writes the hashes of passwords the way the Pwned Passwords
+// download lists them.
+func writeCorpus(t *testing.T, passwords []string, separator string, trailing string) *FileCorpus {
+	t.Helper()
+
+	lines := make([]string, len(passwords))
+	for i, pwd := range passwords {
+		lines[i] = fmt.Sprintf("%X:%d", sha1.Sum([]byte(pwd)), i+1)
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, separator)+trailing), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenCorpus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
@@ -0,0 +1,68 @@
+package password
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// History is a password hash a user has replaced.
+type History struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"index"`
+	Hash      string `json:"-"`
+	CreatedAt time.Time
+}
+
+// TableName overrides the default histories.
+func (History) TableName() string {
+	return "password_history"
+}
+
+type HistoryStore interface {
+	// Add records hash as replaced by the user, keeping only its keep most
+	// recent ones.
+	Add(userID uint, hash string, keep int) error
+
+	// Hashes gets the n hashes the user has replaced most recently.
+	Hashes(userID uint, n int) ([]string, error)
+
+	// Migrate auto-migrates the History model to database.
+	Migrate() error
+}
+
+type historyStore struct {
+	db *gorm.DB
+}
+
+func NewHistoryStore(db *gorm.DB) HistoryStore {
+	return &historyStore{db: db}
+}
+
+func (s *historyStore) Add(userID uint, hash string, keep int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&History{UserID: userID, Hash: hash}).Error; err != nil {
+			return err
+		}
+
+		kept := tx.Model(&History{}).Select("id").
+			Where("user_id = ?", userID).
+			Order("id DESC").
+			Limit(keep)
+		return tx.Where("user_id = ? AND id NOT IN (?)", userID, kept).Delete(&History{}).Error
+	})
+}
+
+func (s *historyStore) Hashes(userID uint, n int) ([]string, error) {
+	var hashes []string
+	tx := s.db.Model(&History{}).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(n).
+		Pluck("hash", &hashes)
+	return hashes, tx.Error
+}
+
+func (s *historyStore) Migrate() error {
+	return s.db.AutoMigrate(&History{})
+}
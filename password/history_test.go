@@ -0,0 +1,68 @@
+package password
+
+import (
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_historyStore(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewHistoryStore(db)
+	for i := 1; i <= 4; i++ {
+		if err := s.Add(1, fmt.Sprint("hash", i), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add(2, "other", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, err := s.Hashes(1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 || hashes[0] != "hash4" || hashes[1] != "hash3" {
+		t.Errorf("Hashes() = %v, want the 2 kept most recent first", hashes)
+	}
+
+	if hashes, _ := s.Hashes(1, 1); len(hashes) != 1 || hashes[0] != "hash4" {
+		t.Errorf("Hashes(1) = %v, want [hash4]", hashes)
+	}
+	if hashes, _ := s.Hashes(2, 5); len(hashes) != 1 {
+		t.Errorf("Hashes() other user = %v, want its own", hashes)
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewHistoryStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
@@ -0,0 +1,118 @@
+package password
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy decides which passwords are acceptable.
+type Policy struct {
+	// MinLength and MaxLength bound the number of characters, MaxLength
+	// keeps what is hashed from growing unbounded. 0 doesn't bound.
+	MinLength int
+	MaxLength int
+
+	// Classes is how many of lowercase letters, uppercase letters, digits
+	// and symbols a password mixes at least.
+	Classes int
+
+	// History is how many of the previous passwords of a user, the current
+	// one included, can't be chosen again.
+	History int
+}
+
+// Violation tells which rule a password breaks. Rule names match the
+// validation tags they replace, Param is the limit of the rule.
+type Violation struct {
+	Rule  string
+	Param string
+}
+
+func (v *Violation) Error() string {
+	if v.Param == "" {
+		return "password violates " + v.Rule
+	}
+	return "password violates " + v.Rule + "=" + v.Param
+}
+
+// Rules a password can break.
+const (
+	RuleMin      = "min"
+	RuleMax      = "max"
+	RuleClasses  = "classes"
+	RulePersonal = "personal"
+	RuleBreached = "breached"
+	RuleReused   = "reused"
+)
+
+// personalMinLength is how long a piece of personal data has to be to be
+// looked for, shorter ones are too common to tell anything.
+const personalMinLength = 3
+
+// Check returns a *Violation when password breaks the rules of p, or
+// contains any of personal, like the email address or the name of the
+// user, regardless of case.
+func (p Policy) Check(password string, personal ...string) error {
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		return &Violation{Rule: RuleMin, Param: strconv.Itoa(p.MinLength)}
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return &Violation{Rule: RuleMax, Param: strconv.Itoa(p.MaxLength)}
+	}
+
+	if p.Classes > 0 && classes(password) < p.Classes {
+		return &Violation{Rule: RuleClasses, Param: strconv.Itoa(p.Classes)}
+	}
+
+	lower := strings.ToLower(password)
+	for _, value := range personal {
+		for _, part := range personalParts(value) {
+			if strings.Contains(lower, part) {
+				return &Violation{Rule: RulePersonal}
+			}
+		}
+	}
+
+	return nil
+}
+
+// classes counts the character classes password mixes.
+func classes(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// personalParts splits a piece of personal data into the words worth
+// looking for, an email address is looked for by its local part.
+func personalParts(value string) []string {
+	value = strings.ToLower(value)
+	if at := strings.LastIndex(value, "@"); at >= 0 {
+		value = value[:at]
+	}
+
+	var parts []string
+	words := strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if utf8.RuneCountInString(word) >= personalMinLength {
+			parts = append(parts, word)
+		}
+	}
+	return parts
+}
@@ -0,0 +1,39 @@
+package password
+
+import "testing"
+
+func TestPolicy_Check(t *testing.T) {
+	p := Policy{MinLength: 8, MaxLength: 16, Classes: 2}
+	personal := []string{"jane.doe@example.com", "Jane", "Al"}
+
+	tests := []struct {
+		name     string
+		password string
+		wantRule string
+	}{
+		{name: "valid", password: "correct horse"},
+		{name: "short", password: "abc 1", wantRule: RuleMin},
+		{name: "long", password: "correct horse battery staple", wantRule: RuleMax},
+		{name: "multibyte counted as characters", password: "ĉĝĥĵŝŭ 12"},
+		{name: "one class", password: "correcthorse", wantRule: RuleClasses},
+		{name: "email", password: "doe is 1 cool", wantRule: RulePersonal},
+		{name: "name any case", password: "i am JANE 42", wantRule: RulePersonal},
+		{name: "short name ignored", password: "always 42!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.password, personal...)
+			if tt.wantRule == "" {
+				if err != nil {
+					t.Errorf("Check() error = %v, want none", err)
+				}
+				return
+			}
+
+			violation, ok := err.(*Violation)
+			if !ok || violation.Rule != tt.wantRule {
+				t.Errorf("Check() error = %v, want rule %s", err, tt.wantRule)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package pat
+
+import "errors"
+
+var (
+	ErrTokenInvalid  = errors.New("invalid personal access token")
+	ErrTokenNotFound = errors.New("personal access token not found")
+	ErrNameTaken     = errors.New("personal access token name is taken")
+	ErrNameInvalid   = errors.New("invalid personal access token name")
+	ErrScopeInvalid  = errors.New("invalid personal access token scope")
+	ErrExpiryInvalid = errors.New("personal access token expires in the past")
+)
@@ -0,0 +1,92 @@
+// Package pat keeps the personal access tokens scripts authenticate to
+// the API with in place of a user signing in.
+package pat
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/9d4/semaphore/rbac"
+)
+
+// TokenPrefix starts every token, telling them apart from JWTs and other
+// secrets.
+const TokenPrefix = "smp_"
+
+// hintLength is how many characters of a token are kept to recognize it.
+const hintLength = len(TokenPrefix) + 4
+
+// Token lets whoever has it act as its user, with the permissions of the
+// user its scopes allow. Only its hash is stored.
+type Token struct {
+	ID       uint `json:"id" gorm:"primarykey"`
+	UserID   uint `json:"-" gorm:"index:pat_name_index,unique,priority:1"`
+	TenantID uint `json:"-"`
+	// Name tells the tokens of the user apart
+	Name string `json:"name" gorm:"index:pat_name_index,unique,priority:2"`
+	// Scopes are the permissions of the user the token grants, it only
+	// identifies the user without any
+	Scopes []string `json:"scopes" gorm:"serializer:json"`
+	// Hint is the start of the token, for the user to recognize it
+	Hint string `json:"hint"`
+
+	TokenHash string `json:"-" gorm:"uniqueIndex"`
+	// ExpiresAt is nil for tokens that don't expire
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName overrides the default tokens.
+func (Token) TableName() string {
+	return "personal_access_tokens"
+}
+
+// Expired reports whether the token has expired at now.
+func (t *Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// Narrow gets the permissions of granted the scopes of the token allow.
+func (t *Token) Narrow(granted []string) []string {
+	var permissions []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			permissions = append(permissions, p)
+		}
+	}
+
+	for _, p := range granted {
+		if rbac.Allows(t.Scopes, p) {
+			add(p)
+		}
+	}
+	// a scope narrower than what the user has is granted as is
+	for _, scope := range t.Scopes {
+		if rbac.Allows(granted, scope) {
+			add(scope)
+		}
+	}
+	return permissions
+}
+
+// generateToken returns a random token to hand to the user.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a token for lookup. The token is random enough that a
+// fast hash doesn't make it guessable.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
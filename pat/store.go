@@ -0,0 +1,141 @@
+package pat
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/9d4/semaphore/rbac"
+	"gorm.io/gorm"
+)
+
+// lastUsedPrecision is how often using a token is recorded, sparing a
+// write per request.
+const lastUsedPrecision = time.Minute
+
+// nameMax is the longest name a token can be given.
+const nameMax = 64
+
+type Store interface {
+	// Create issues t to its user, filling in its hash and hint. Returns
+	// the token to hand to the user, it can't be read afterwards.
+	// Returns ErrNameInvalid, ErrNameTaken, ErrScopeInvalid or
+	// ErrExpiryInvalid for a token it can't issue.
+	Create(t *Token) (string, error)
+
+	// Authenticate gets the token and records it has just been used.
+	// Returns ErrTokenInvalid if there is no such token or it has expired.
+	Authenticate(token string) (*Token, error)
+
+	// Tokens gets the tokens of the user, newest first.
+	Tokens(userID uint) ([]*Token, error)
+
+	// Delete deletes the token of the user with the specified ID.
+	// Returns ErrTokenNotFound if there is no such token.
+	Delete(userID uint, id uint) error
+
+	// DeleteAll deletes every token of the user.
+	DeleteAll(userID uint) error
+
+	// Migrate auto-migrates the Token model to database.
+	Migrate() error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+func (s *store) Create(t *Token) (string, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" || len(t.Name) > nameMax {
+		return "", ErrNameInvalid
+	}
+	for _, scope := range t.Scopes {
+		if !rbac.ValidPermission(scope) {
+			return "", ErrScopeInvalid
+		}
+	}
+	sort.Strings(t.Scopes)
+	if t.ExpiresAt != nil && t.Expired(time.Now()) {
+		return "", ErrExpiryInvalid
+	}
+
+	err := s.db.Where("user_id = ? AND name = ?", t.UserID, t.Name).First(&Token{}).Error
+	if err == nil {
+		return "", ErrNameTaken
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	t.ID = 0
+	t.TokenHash = hashToken(token)
+	t.Hint = token[:hintLength]
+	t.LastUsedAt = nil
+	if err := s.db.Create(t).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *store) Authenticate(token string) (*Token, error) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return nil, ErrTokenInvalid
+	}
+
+	var t Token
+	err := s.db.Where("token_hash = ?", hashToken(token)).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if t.Expired(now) {
+		return nil, ErrTokenInvalid
+	}
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= lastUsedPrecision {
+		if err := s.db.Model(&t).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+		t.LastUsedAt = &now
+	}
+	return &t, nil
+}
+
+func (s *store) Tokens(userID uint) ([]*Token, error) {
+	var tokens []*Token
+	err := s.db.Where("user_id = ?", userID).Order("id DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (s *store) Delete(userID uint, id uint) error {
+	tx := s.db.Where("user_id = ? AND id = ?", userID, id).Delete(&Token{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+func (s *store) DeleteAll(userID uint) error {
+	return s.db.Where("user_id = ?", userID).Delete(&Token{}).Error
+}
+
+func (s *store) Migrate() error {
+	return s.db.AutoMigrate(&Token{})
+}
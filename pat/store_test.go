@@ -0,0 +1,149 @@
+package pat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/rbac"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+
+	created := &Token{UserID: 1, Name: " deploy ", Scopes: []string{rbac.PermUsersRead, rbac.PermAuditRead}}
+	token, err := s.Create(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, created.Hint) || created.TokenHash == token || created.Name != "deploy" {
+		t.Fatalf("Create() = %q, %+v, want a hinted token stored hashed", token, created)
+	}
+
+	past := time.Now().Add(-time.Second)
+	tests := []struct {
+		name    string
+		token   Token
+		wantErr error
+	}{
+		{name: "same name of another user", token: Token{UserID: 2, Name: "deploy"}},
+		{name: "taken", token: Token{UserID: 1, Name: "deploy"}, wantErr: ErrNameTaken},
+		{name: "empty", token: Token{UserID: 1, Name: " "}, wantErr: ErrNameInvalid},
+		{name: "too long", token: Token{UserID: 1, Name: strings.Repeat("a", 65)}, wantErr: ErrNameInvalid},
+		{name: "invalid scope", token: Token{UserID: 1, Name: "ci", Scopes: []string{"everything"}}, wantErr: ErrScopeInvalid},
+		{name: "expired", token: Token{UserID: 1, Name: "ci", ExpiresAt: &past}, wantErr: ErrExpiryInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Create(&tt.token); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := s.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != created.ID || got.LastUsedAt == nil || len(got.Scopes) != 2 {
+		t.Errorf("Authenticate() = %+v, want token %d with its scopes marked used", got, created.ID)
+	}
+	for _, invalid := range []string{"", "deploy", TokenPrefix + "guess", token + "x"} {
+		if _, err := s.Authenticate(invalid); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("Authenticate(%q) error = %v, want %v", invalid, err, ErrTokenInvalid)
+		}
+	}
+
+	soon := time.Now().Add(time.Hour)
+	expiring := &Token{UserID: 1, Name: "expiring", ExpiresAt: &soon}
+	expiringToken, err := s.Create(expiring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(expiring).Update("expires_at", past).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(expiringToken); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Authenticate() expired error = %v, want %v", err, ErrTokenInvalid)
+	}
+
+	if tokens, _ := s.Tokens(1); len(tokens) != 2 || tokens[0].Name != "expiring" {
+		t.Errorf("Tokens() = %v, want expiring and deploy", tokens)
+	}
+
+	if err := s.Delete(2, created.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Delete() of another user error = %v, want %v", err, ErrTokenNotFound)
+	}
+	if err := s.Delete(1, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(token); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Authenticate() after Delete() error = %v, want %v", err, ErrTokenInvalid)
+	}
+
+	if err := s.DeleteAll(1); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := s.Tokens(1); len(tokens) != 0 {
+		t.Errorf("Tokens() after DeleteAll() = %v, want none", tokens)
+	}
+}
+
+func TestToken_Narrow(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		granted []string
+		want    string
+	}{
+		{name: "no scopes", granted: []string{rbac.PermAll}, want: "[]"},
+		{name: "scope of admin", scopes: []string{rbac.PermUsersRead}, granted: []string{rbac.PermAll}, want: "[users:read]"},
+		{name: "scope not granted", scopes: []string{rbac.PermUsersWrite}, granted: []string{rbac.PermUsersRead}, want: "[]"},
+		{name: "wide scope", scopes: []string{"users:*"}, granted: []string{rbac.PermUsersRead, rbac.PermRolesRead}, want: "[users:read]"},
+		{name: "every scope", scopes: []string{rbac.PermAll}, granted: []string{"roles:*", rbac.PermAuditRead}, want: "[roles:* audit:read]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &Token{Scopes: tt.scopes}
+			got := token.Narrow(tt.granted)
+			if s := "[" + strings.Join(got, " ") + "]"; s != tt.want {
+				t.Errorf("Narrow() = %s, want %s", s, tt.want)
+			}
+		})
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
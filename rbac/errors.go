@@ -0,0 +1,12 @@
+package rbac
+
+import "errors"
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrGroupNotFound     = errors.New("group not found")
+	ErrNameInvalid       = errors.New("invalid name")
+	ErrNameTaken         = errors.New("name is taken")
+	ErrPermissionInvalid = errors.New("invalid permission")
+	ErrRoleBuiltIn       = errors.New("built-in role can't be changed")
+)
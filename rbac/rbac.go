@@ -0,0 +1,131 @@
+package rbac
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Permissions checked by the server.
+const (
+	PermUsersRead  = "users:read"
+	PermUsersWrite = "users:write"
+	// users can only be impersonated by someone granted everything they
+	// are granted
+	PermUsersImpersonate = "users:impersonate"
+	PermRolesRead        = "roles:read"
+	PermRolesWrite       = "roles:write"
+
+	// tenants are only managed by users of the default tenant, whatever
+	// the users of other tenants are granted
+	PermTenantsRead  = "tenants:read"
+	PermTenantsWrite = "tenants:write"
+
+	PermAuditRead = "audit:read"
+)
+
+// AdminRole is the role every permission is granted to. It is created on
+// migration and can't be deleted.
+const AdminRole = "admin"
+
+// PermAll grants every permission.
+const PermAll = "*"
+
+var (
+	namePattern       = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+	permissionPattern = regexp.MustCompile(`^[a-z0-9_-]+:([a-z0-9_-]+|\*)$`)
+)
+
+// Role is a named set of permissions, assigned to users directly or
+// through their groups.
+type Role struct {
+	ID          uint   `json:"-" gorm:"primarykey"`
+	Name        string `json:"name" gorm:"uniqueIndex"`
+	Description string `json:"description"`
+	// Permissions are kept in RolePermission
+	Permissions []string  `json:"permissions" gorm:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RolePermission is a permission granted to a role.
+type RolePermission struct {
+	RoleID     uint   `gorm:"primaryKey;autoIncrement:false"`
+	Permission string `gorm:"primaryKey"`
+}
+
+// Group is a named set of users, each member has the roles of the group.
+type Group struct {
+	ID          uint   `json:"-" gorm:"primarykey"`
+	Name        string `json:"name" gorm:"uniqueIndex"`
+	Description string `json:"description"`
+	// Roles are kept in GroupRole
+	Roles     []string  `json:"roles" gorm:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GroupRole is a role the members of a group have.
+type GroupRole struct {
+	GroupID uint `gorm:"primaryKey;autoIncrement:false"`
+	RoleID  uint `gorm:"primaryKey;autoIncrement:false"`
+}
+
+// GroupMember is a user belonging to a group.
+type GroupMember struct {
+	GroupID uint `gorm:"primaryKey;autoIncrement:false"`
+	UserID  uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// UserRole is a role assigned to a user directly.
+type UserRole struct {
+	UserID uint `gorm:"primaryKey;autoIncrement:false"`
+	RoleID uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// Grants are what a user may do: its roles, direct or through its groups,
+// its groups and the permissions of its roles.
+type Grants struct {
+	Roles       []string `json:"roles"`
+	Groups      []string `json:"groups"`
+	Permissions []string `json:"permissions"`
+}
+
+// Allows reports whether the granted permissions include want, either
+// as is, through the wildcard of its resource ("users:*") or through
+// PermAll.
+func Allows(granted []string, want string) bool {
+	resource, _, _ := strings.Cut(want, ":")
+	for _, p := range granted {
+		if p == want || p == PermAll || p == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidName reports whether name can name a role or a group.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// ValidPermission reports whether p is PermAll or a "resource:action"
+// permission, the action can be "*".
+func ValidPermission(p string) bool {
+	return p == PermAll || permissionPattern.MatchString(p)
+}
+
+// normalize sorts values and drops the duplicates.
+func normalize(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
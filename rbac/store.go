@@ -0,0 +1,501 @@
+package rbac
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Store interface {
+	// Roles gets every role with its permissions, by name.
+	Roles() ([]*Role, error)
+
+	// Role gets the role with the specified name.
+	// Returns ErrRoleNotFound if there is no such role.
+	Role(name string) (*Role, error)
+
+	// SaveRole creates the role, or replaces the description and the
+	// permissions of the one with the same name.
+	// Returns ErrNameInvalid, ErrPermissionInvalid or ErrRoleBuiltIn for a
+	// role it can't save.
+	SaveRole(r *Role) error
+
+	// DeleteRole deletes the role with the specified name, taking it away
+	// from its users and groups.
+	// Returns ErrRoleNotFound if there is no such role.
+	DeleteRole(name string) error
+
+	// Groups gets every group with its roles, by name.
+	Groups() ([]*Group, error)
+
+	// Group gets the group with the specified name.
+	// Returns ErrGroupNotFound if there is no such group.
+	Group(name string) (*Group, error)
+
+	// GroupByID gets the group with the specified ID.
+	// Returns ErrGroupNotFound if there is no such group.
+	GroupByID(id uint) (*Group, error)
+
+	// SaveGroup creates the group, or replaces the description and the
+	// roles of the one with the same name.
+	// Returns ErrNameInvalid or ErrRoleNotFound for a group it can't save.
+	SaveGroup(g *Group) error
+
+	// RenameGroup renames the group with the specified name, keeping its
+	// roles and members.
+	// Returns ErrGroupNotFound if there is no such group, ErrNameInvalid or
+	// ErrNameTaken for a name it can't use.
+	RenameGroup(name string, newName string) error
+
+	// DeleteGroup deletes the group with the specified name and its
+	// memberships.
+	// Returns ErrGroupNotFound if there is no such group.
+	DeleteGroup(name string) error
+
+	// Members gets the IDs of the users in the group.
+	// Returns ErrGroupNotFound if there is no such group.
+	Members(group string) ([]uint, error)
+
+	// SetMembers replaces the users in the group.
+	// Returns ErrGroupNotFound if there is no such group.
+	SetMembers(group string, userIDs []uint) error
+
+	// AddMember adds the user to the group, adding it again does nothing.
+	// Changing members marks the group updated.
+	// Returns ErrGroupNotFound if there is no such group.
+	AddMember(group string, userID uint) error
+
+	// RemoveMember removes the user from the group.
+	// Returns ErrGroupNotFound if there is no such group.
+	RemoveMember(group string, userID uint) error
+
+	// AssignRole assigns the role to the user, assigning it again does
+	// nothing.
+	// Returns ErrRoleNotFound if there is no such role.
+	AssignRole(userID uint, role string) error
+
+	// UnassignRole takes the role assigned directly to the user away.
+	// Returns ErrRoleNotFound if there is no such role.
+	UnassignRole(userID uint, role string) error
+
+	// Grants gets the roles, groups and permissions of the user.
+	Grants(userID uint) (*Grants, error)
+
+	// UsersWithRole gets the IDs of the users having the role, directly or
+	// through a group.
+	UsersWithRole(role string) ([]uint, error)
+
+	// DeleteUser forgets the roles and groups of the user.
+	DeleteUser(userID uint) error
+
+	// Migrate auto-migrates the models to database and creates AdminRole.
+	Migrate() error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+func (s *store) Roles() ([]*Role, error) {
+	var roles []*Role
+	if err := s.db.Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	for _, r := range roles {
+		if err := s.db.Model(&RolePermission{}).Where("role_id = ?", r.ID).
+			Order("permission").
+			Pluck("permission", &r.Permissions).Error; err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+func (s *store) Role(name string) (*Role, error) {
+	r, err := s.role(s.db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Model(&RolePermission{}).Where("role_id = ?", r.ID).
+		Order("permission").
+		Pluck("permission", &r.Permissions).Error
+	return r, err
+}
+
+func (s *store) role(tx *gorm.DB, name string) (*Role, error) {
+	r := &Role{}
+	err := tx.Where("name = ?", name).First(r).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	return r, err
+}
+
+func (s *store) SaveRole(r *Role) error {
+	if !ValidName(r.Name) {
+		return ErrNameInvalid
+	}
+	if r.Name == AdminRole {
+		return ErrRoleBuiltIn
+	}
+	for _, p := range r.Permissions {
+		if !ValidPermission(p) {
+			return ErrPermissionInvalid
+		}
+	}
+	r.Permissions = normalize(r.Permissions)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return saveRole(tx, r)
+	})
+}
+
+// saveRole upserts r by name and replaces its permissions.
+func saveRole(tx *gorm.DB, r *Role) error {
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"description", "updated_at"}),
+	}).Create(r).Error
+	if err != nil {
+		return err
+	}
+
+	// the ID isn't set back on every database when the role exists
+	if err := tx.Where("name = ?", r.Name).First(r).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("role_id = ?", r.ID).Delete(&RolePermission{}).Error; err != nil {
+		return err
+	}
+	for _, p := range r.Permissions {
+		if err := tx.Create(&RolePermission{RoleID: r.ID, Permission: p}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) DeleteRole(name string) error {
+	if name == AdminRole {
+		return ErrRoleBuiltIn
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		r, err := s.role(tx, name)
+		if err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{&RolePermission{}, &GroupRole{}, &UserRole{}} {
+			if err := tx.Where("role_id = ?", r.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(r).Error
+	})
+}
+
+func (s *store) Groups() ([]*Group, error) {
+	var groups []*Group
+	if err := s.db.Order("name").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		if err := s.groupRoles(g); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+func (s *store) Group(name string) (*Group, error) {
+	g, err := s.group(s.db, name)
+	if err != nil {
+		return nil, err
+	}
+	return g, s.groupRoles(g)
+}
+
+func (s *store) GroupByID(id uint) (*Group, error) {
+	g := &Group{}
+	err := s.db.Where("id = ?", id).First(g).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, s.groupRoles(g)
+}
+
+func (s *store) group(tx *gorm.DB, name string) (*Group, error) {
+	g := &Group{}
+	err := tx.Where("name = ?", name).First(g).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGroupNotFound
+	}
+	return g, err
+}
+
+func (s *store) groupRoles(g *Group) error {
+	return s.db.Model(&Role{}).
+		Joins("JOIN group_roles ON group_roles.role_id = roles.id").
+		Where("group_roles.group_id = ?", g.ID).
+		Order("roles.name").
+		Pluck("roles.name", &g.Roles).Error
+}
+
+func (s *store) SaveGroup(g *Group) error {
+	if !ValidName(g.Name) {
+		return ErrNameInvalid
+	}
+	g.Roles = normalize(g.Roles)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		roleIDs := make([]uint, len(g.Roles))
+		for i, name := range g.Roles {
+			r, err := s.role(tx, name)
+			if err != nil {
+				return err
+			}
+			roleIDs[i] = r.ID
+		}
+
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "updated_at"}),
+		}).Create(g).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("name = ?", g.Name).First(g).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("group_id = ?", g.ID).Delete(&GroupRole{}).Error; err != nil {
+			return err
+		}
+		for _, id := range roleIDs {
+			if err := tx.Create(&GroupRole{GroupID: g.ID, RoleID: id}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) RenameGroup(name string, newName string) error {
+	if !ValidName(newName) {
+		return ErrNameInvalid
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		g, err := s.group(tx, name)
+		if err != nil || name == newName {
+			return err
+		}
+
+		if _, err := s.group(tx, newName); !errors.Is(err, ErrGroupNotFound) {
+			if err == nil {
+				return ErrNameTaken
+			}
+			return err
+		}
+		return tx.Model(g).Update("name", newName).Error
+	})
+}
+
+func (s *store) DeleteGroup(name string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		g, err := s.group(tx, name)
+		if err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{&GroupRole{}, &GroupMember{}} {
+			if err := tx.Where("group_id = ?", g.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(g).Error
+	})
+}
+
+func (s *store) Members(group string) ([]uint, error) {
+	g, err := s.group(s.db, group)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	err = s.db.Model(&GroupMember{}).Where("group_id = ?", g.ID).Order("user_id").Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+func (s *store) SetMembers(group string, userIDs []uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		g, err := s.group(tx, group)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("group_id = ?", g.ID).Delete(&GroupMember{}).Error; err != nil {
+			return err
+		}
+		for _, id := range userIDs {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&GroupMember{GroupID: g.ID, UserID: id}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return touchGroup(tx, g)
+	})
+}
+
+func (s *store) AddMember(group string, userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		g, err := s.group(tx, group)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&GroupMember{GroupID: g.ID, UserID: userID}).Error
+		if err != nil {
+			return err
+		}
+		return touchGroup(tx, g)
+	})
+}
+
+func (s *store) RemoveMember(group string, userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		g, err := s.group(tx, group)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("group_id = ? AND user_id = ?", g.ID, userID).Delete(&GroupMember{}).Error; err != nil {
+			return err
+		}
+		return touchGroup(tx, g)
+	})
+}
+
+// touchGroup marks g updated, its members being part of it.
+func touchGroup(tx *gorm.DB, g *Group) error {
+	return tx.Model(g).Update("updated_at", time.Now()).Error
+}
+
+func (s *store) AssignRole(userID uint, role string) error {
+	r, err := s.role(s.db, role)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserRole{UserID: userID, RoleID: r.ID}).Error
+}
+
+func (s *store) UnassignRole(userID uint, role string) error {
+	r, err := s.role(s.db, role)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Where("user_id = ? AND role_id = ?", userID, r.ID).Delete(&UserRole{}).Error
+}
+
+func (s *store) Grants(userID uint) (*Grants, error) {
+	g := &Grants{}
+
+	err := s.db.Model(&Group{}).
+		Joins("JOIN group_members ON group_members.group_id = groups.id").
+		Where("group_members.user_id = ?", userID).
+		Pluck("groups.name", &g.Groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	roleIDs := s.roleIDsOf(userID)
+	if err := s.db.Model(&Role{}).Where("id IN (?)", roleIDs).Pluck("name", &g.Roles).Error; err != nil {
+		return nil, err
+	}
+	err = s.db.Model(&RolePermission{}).Where("role_id IN (?)", roleIDs).
+		Distinct("permission").
+		Pluck("permission", &g.Permissions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	g.Roles, g.Groups, g.Permissions = normalize(g.Roles), normalize(g.Groups), normalize(g.Permissions)
+	return g, nil
+}
+
+// roleIDsOf selects the IDs of the roles the user has, directly or
+// through a group.
+func (s *store) roleIDsOf(userID uint) *gorm.DB {
+	return s.db.Raw(`SELECT role_id FROM user_roles WHERE user_id = ?
+		UNION SELECT group_roles.role_id FROM group_roles
+		JOIN group_members ON group_members.group_id = group_roles.group_id
+		WHERE group_members.user_id = ?`, userID, userID)
+}
+
+func (s *store) UsersWithRole(role string) ([]uint, error) {
+	r, err := s.role(s.db, role)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	err = s.db.Raw(`SELECT user_id FROM user_roles WHERE role_id = ?
+		UNION SELECT group_members.user_id FROM group_members
+		JOIN group_roles ON group_roles.group_id = group_members.group_id
+		WHERE group_roles.role_id = ?`, r.ID, r.ID).
+		Scan(&ids).Error
+	return ids, err
+}
+
+func (s *store) DeleteUser(userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&UserRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&GroupMember{}).Error
+	})
+}
+
+func (s *store) Migrate() error {
+	err := s.db.AutoMigrate(&Role{}, &RolePermission{}, &Group{}, &GroupRole{}, &GroupMember{}, &UserRole{})
+	if err != nil {
+		return err
+	}
+	return SeedAdminRole(s.db)
+}
+
+// SeedAdminRole creates AdminRole with every permission unless it exists.
+func SeedAdminRole(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("name = ?", AdminRole).First(&Role{}).Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		return saveRole(tx, &Role{
+			Name:        AdminRole,
+			Description: "Manages the users, their roles and groups",
+			Permissions: []string{PermAll},
+		})
+	})
+}
@@ -0,0 +1,285 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store_roles(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+
+	admin, err := s.Role(AdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(admin.Permissions) != "[*]" {
+		t.Errorf("admin permissions = %v, want [*]", admin.Permissions)
+	}
+
+	tests := []struct {
+		name    string
+		role    Role
+		wantErr error
+	}{
+		{name: "create", role: Role{Name: "support", Permissions: []string{PermUsersRead, PermUsersRead}}},
+		{name: "replace", role: Role{Name: "support", Description: "Helps users", Permissions: []string{PermUsersWrite, PermUsersRead}}},
+		{name: "invalid name", role: Role{Name: "Support Team"}, wantErr: ErrNameInvalid},
+		{name: "invalid permission", role: Role{Name: "support", Permissions: []string{"users"}}, wantErr: ErrPermissionInvalid},
+		{name: "admin", role: Role{Name: AdminRole}, wantErr: ErrRoleBuiltIn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SaveRole(&tt.role); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SaveRole() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	roles, err := s.Roles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 || roles[1].Name != "support" || roles[1].Description != "Helps users" {
+		t.Fatalf("Roles() = %v, want admin and the replaced support", roles)
+	}
+	if fmt.Sprint(roles[1].Permissions) != "[users:read users:write]" {
+		t.Errorf("support permissions = %v, want [users:read users:write]", roles[1].Permissions)
+	}
+
+	if err := s.DeleteRole(AdminRole); !errors.Is(err, ErrRoleBuiltIn) {
+		t.Errorf("DeleteRole(admin) error = %v, want %v", err, ErrRoleBuiltIn)
+	}
+	if err := s.DeleteRole("support"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRole("support"); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("DeleteRole() again error = %v, want %v", err, ErrRoleNotFound)
+	}
+
+	var left int64
+	db.Model(&RolePermission{}).Count(&left)
+	if left != 1 {
+		t.Errorf("%d permissions left, want only the admin one", left)
+	}
+}
+
+func Test_store_Grants(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	for _, r := range []*Role{
+		{Name: "support", Permissions: []string{PermUsersRead}},
+		{Name: "auditor", Permissions: []string{PermUsersRead, PermRolesRead}},
+	} {
+		if err := s.SaveRole(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveGroup(&Group{Name: "staff", Roles: []string{"auditor"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveGroup(&Group{Name: "ghosts", Roles: []string{"missing"}}); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("SaveGroup() with a missing role error = %v, want %v", err, ErrRoleNotFound)
+	}
+
+	const alice, bob, carol = 1, 2, 3
+	steps := []func() error{
+		func() error { return s.AssignRole(alice, "support") },
+		func() error { return s.AssignRole(alice, "support") },
+		func() error { return s.AddMember("staff", alice) },
+		func() error { return s.AddMember("staff", bob) },
+		func() error { return s.AssignRole(carol, AdminRole) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		userID uint
+		want   Grants
+	}{
+		{name: "direct and group", userID: alice, want: Grants{
+			Roles:       []string{"auditor", "support"},
+			Groups:      []string{"staff"},
+			Permissions: []string{PermRolesRead, PermUsersRead},
+		}},
+		{name: "group", userID: bob, want: Grants{
+			Roles:       []string{"auditor"},
+			Groups:      []string{"staff"},
+			Permissions: []string{PermRolesRead, PermUsersRead},
+		}},
+		{name: "admin", userID: carol, want: Grants{
+			Roles:       []string{AdminRole},
+			Groups:      []string{},
+			Permissions: []string{PermAll},
+		}},
+		{name: "nothing", userID: 4, want: Grants{Roles: []string{}, Groups: []string{}, Permissions: []string{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Grants(tt.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(*got) != fmt.Sprint(tt.want) {
+				t.Errorf("Grants() = %v, want %v", *got, tt.want)
+			}
+		})
+	}
+
+	if ids, _ := s.UsersWithRole("auditor"); fmt.Sprint(ids) != "[1 2]" {
+		t.Errorf("UsersWithRole(auditor) = %v, want [1 2]", ids)
+	}
+
+	if err := s.RemoveMember("staff", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnassignRole(alice, "support"); err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := s.Grants(alice); len(g.Roles) != 0 || len(g.Groups) != 0 {
+		t.Errorf("Grants() after removal = %v, want none", *g)
+	}
+
+	if err := s.DeleteGroup("staff"); err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := s.Grants(bob); len(g.Roles) != 0 {
+		t.Errorf("Grants() after the group is deleted = %v, want none", *g)
+	}
+
+	if err := s.DeleteUser(carol); err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := s.Grants(carol); len(g.Roles) != 0 {
+		t.Errorf("Grants() of a deleted user = %v, want none", *g)
+	}
+}
+
+func Test_store_groupMembers(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	for _, name := range []string{"staff", "ops"} {
+		if err := s.SaveGroup(&Group{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	staff, err := s.Group("staff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetMembers("staff", []uint{3, 1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := s.Members("staff"); fmt.Sprint(ids) != "[1 3]" {
+		t.Errorf("Members() = %v, want [1 3]", ids)
+	}
+	if err := s.SetMembers("staff", []uint{2}); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := s.Members("staff"); fmt.Sprint(ids) != "[2]" {
+		t.Errorf("Members() after replacing = %v, want [2]", ids)
+	}
+	if _, err := s.Members("missing"); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("Members(missing) error = %v, want %v", err, ErrGroupNotFound)
+	}
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		wantErr error
+	}{
+		{name: "invalid", from: "staff", to: "Staff Team", wantErr: ErrNameInvalid},
+		{name: "taken", from: "staff", to: "ops", wantErr: ErrNameTaken},
+		{name: "missing", from: "missing", to: "found", wantErr: ErrGroupNotFound},
+		{name: "same", from: "staff", to: "staff"},
+		{name: "rename", from: "staff", to: "employees"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.RenameGroup(tt.from, tt.to); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RenameGroup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	renamed, err := s.GroupByID(staff.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "employees" || !renamed.UpdatedAt.After(staff.UpdatedAt) {
+		t.Errorf("GroupByID() = %+v, want employees updated after %v", renamed, staff.UpdatedAt)
+	}
+	if ids, _ := s.Members("employees"); fmt.Sprint(ids) != "[2]" {
+		t.Errorf("Members() after renaming = %v, want [2]", ids)
+	}
+	if _, err := s.GroupByID(staff.ID + 10); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("GroupByID(missing) error = %v, want %v", err, ErrGroupNotFound)
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		granted []string
+		want    string
+		allowed bool
+	}{
+		{granted: []string{PermUsersRead}, want: PermUsersRead, allowed: true},
+		{granted: []string{PermUsersRead}, want: PermUsersWrite, allowed: false},
+		{granted: []string{"users:*"}, want: PermUsersWrite, allowed: true},
+		{granted: []string{"users:*"}, want: PermRolesRead, allowed: false},
+		{granted: []string{PermAll}, want: PermRolesWrite, allowed: true},
+		{granted: nil, want: PermUsersRead, allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.granted, tt.want), func(t *testing.T) {
+			if got := Allows(tt.granted, tt.want); got != tt.allowed {
+				t.Errorf("Allows() = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
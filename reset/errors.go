@@ -0,0 +1,7 @@
+package reset
+
+import "errors"
+
+// ErrTokenInvalid means the token doesn't exist, has expired or has been
+// used already.
+var ErrTokenInvalid = errors.New("invalid password reset token")
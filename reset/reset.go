@@ -0,0 +1,46 @@
+package reset
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// TokenExpiration is how long a reset link can be used.
+const TokenExpiration = time.Hour
+
+// Token lets the owner of an email address set a new password once. Only
+// its hash is stored.
+type Token struct {
+	ID     uint `gorm:"primarykey"`
+	UserID uint `gorm:"index"`
+
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+
+	CreatedAt time.Time
+}
+
+// TableName overrides the default tokens.
+func (Token) TableName() string {
+	return "password_reset_tokens"
+}
+
+// generateToken returns a random token to mail to the user.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a token for lookup. The token is random enough that a
+// fast hash doesn't make it guessable.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,97 @@
+package reset
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Store interface {
+	// Create issues a reset token for the user, replacing the ones it
+	// hasn't used. Returns the token to mail, it can't be read afterwards.
+	Create(userID uint) (string, error)
+
+	// Token gets token without using it.
+	// Returns ErrTokenInvalid if it doesn't exist, has expired or has been
+	// used already.
+	Token(token string) (*Token, error)
+
+	// Take marks token as used and returns who it was issued to.
+	// Returns ErrTokenInvalid if it doesn't exist, has expired or has been
+	// used already.
+	Take(token string) (*Token, error)
+
+	// Migrate auto-migrates the Token model to database.
+	Migrate() error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+func (s *store) Create(userID uint) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", userID).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&Token{
+			UserID:    userID,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(TokenExpiration),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *store) Token(token string) (*Token, error) {
+	var t Token
+	tx := s.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).First(&t)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, tx.Error
+	}
+	return &t, nil
+}
+
+func (s *store) Take(token string) (*Token, error) {
+	hash := hashToken(token)
+	now := time.Now()
+
+	// a single update decides which of concurrent requests gets the token
+	tx := s.db.Model(&Token{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrTokenInvalid
+	}
+
+	var t Token
+	if err := s.db.Where("token_hash = ?", hash).First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *store) Migrate() error {
+	return s.db.AutoMigrate(&Token{})
+}
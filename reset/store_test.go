@@ -0,0 +1,98 @@
+package reset
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store_Take(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	replaced, err := s.Create(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.Create(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := s.Create(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&Token{}).Where("user_id = ?", 2).Update("expires_at", time.Now().Add(-time.Minute))
+
+	var stored Token
+	db.Where("user_id = ?", 1).First(&stored)
+	if stored.TokenHash == token {
+		t.Error("token stored in plain text")
+	}
+
+	// looking a token up doesn't use it
+	for i := 0; i < 2; i++ {
+		if got, err := s.Token(token); err != nil || got.UserID != 1 {
+			t.Fatalf("Token() = %v, %v, want the token of user 1", got, err)
+		}
+	}
+	if _, err := s.Token(expired); err != ErrTokenInvalid {
+		t.Errorf("Token() expired error = %v, want %v", err, ErrTokenInvalid)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantUserID uint
+		wantErr    error
+	}{
+		{name: "replaced", token: replaced, wantErr: ErrTokenInvalid},
+		{name: "valid", token: token, wantUserID: 1},
+		{name: "used", token: token, wantErr: ErrTokenInvalid},
+		{name: "expired", token: expired, wantErr: ErrTokenInvalid},
+		{name: "unknown", token: "unknown", wantErr: ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Take(tt.token)
+			if err != tt.wantErr {
+				t.Fatalf("Take() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.UserID != tt.wantUserID {
+				t.Errorf("Take() user = %d, want %d", got.UserID, tt.wantUserID)
+			}
+		})
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
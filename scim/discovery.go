@@ -0,0 +1,202 @@
+package scim
+
+// Discovery documents, RFC 7644 section 4, describing what is supported
+// of RFC 7643: the core User and Group schemas without extensions.
+
+// ServiceProviderConfig is what the service provider supports.
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	DocumentationURI      string                 `json:"documentationUri,omitempty"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  BulkSupport            `json:"bulk"`
+	Filter                FilterSupport          `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+	Meta                  *DocumentMeta          `json:"meta"`
+}
+
+// DocumentMeta is what a discovery document is.
+type DocumentMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+type BulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type FilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+// NewServiceProviderConfig returns the configuration served at base, the
+// URL of the SCIM endpoints.
+func NewServiceProviderConfig(base string) *ServiceProviderConfig {
+	return &ServiceProviderConfig{
+		Schemas:        []string{SchemaServiceProviderConfig},
+		Patch:          Supported{Supported: true},
+		Filter:         FilterSupport{Supported: true, MaxResults: MaxCount},
+		ChangePassword: Supported{Supported: true},
+		ETag:           Supported{Supported: true},
+		AuthenticationSchemes: []AuthenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer Token",
+			Description: "A token issued to the provisioning client, in the Authorization header",
+			Primary:     true,
+		}},
+		Meta: &DocumentMeta{ResourceType: "ServiceProviderConfig", Location: base + "/ServiceProviderConfig"},
+	}
+}
+
+// ResourceType describes the endpoint of a kind of resource.
+type ResourceType struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Endpoint    string        `json:"endpoint"`
+	Description string        `json:"description"`
+	Schema      string        `json:"schema"`
+	Meta        *DocumentMeta `json:"meta"`
+}
+
+// ResourceTypes returns the types of resources served at base.
+func ResourceTypes(base string) []*ResourceType {
+	return []*ResourceType{
+		{
+			Schemas:     []string{SchemaResourceType},
+			ID:          "User",
+			Name:        "User",
+			Endpoint:    "/Users",
+			Description: "User Account",
+			Schema:      SchemaUser,
+			Meta:        &DocumentMeta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/User"},
+		},
+		{
+			Schemas:     []string{SchemaResourceType},
+			ID:          "Group",
+			Name:        "Group",
+			Endpoint:    "/Groups",
+			Description: "Group",
+			Schema:      SchemaGroup,
+			Meta:        &DocumentMeta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/Group"},
+		},
+	}
+}
+
+// Schema describes the attributes of a resource.
+type Schema struct {
+	Schemas     []string           `json:"schemas"`
+	ID          string             `json:"id"`
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Attributes  []*SchemaAttribute `json:"attributes"`
+	Meta        *DocumentMeta      `json:"meta"`
+}
+
+type SchemaAttribute struct {
+	Name          string             `json:"name"`
+	Type          string             `json:"type"`
+	MultiValued   bool               `json:"multiValued"`
+	Description   string             `json:"description,omitempty"`
+	Required      bool               `json:"required"`
+	CaseExact     bool               `json:"caseExact"`
+	Mutability    string             `json:"mutability"`
+	Returned      string             `json:"returned"`
+	Uniqueness    string             `json:"uniqueness"`
+	SubAttributes []*SchemaAttribute `json:"subAttributes,omitempty"`
+}
+
+// attribute returns a single-valued, optional, read-write attribute
+// returned by default, the most common kind.
+func attribute(name, typ, description string) *SchemaAttribute {
+	return &SchemaAttribute{
+		Name:        name,
+		Type:        typ,
+		Description: description,
+		Mutability:  "readWrite",
+		Returned:    "default",
+		Uniqueness:  "none",
+	}
+}
+
+// Schemas returns the schemas of the resources served at base.
+func Schemas(base string) []*Schema {
+	userName := attribute("userName", "string", "The email address the user signs in with")
+	userName.Required, userName.Uniqueness = true, "server"
+
+	name := attribute("name", "complex", "The components of the name of the user")
+	name.SubAttributes = []*SchemaAttribute{
+		attribute("formatted", "string", "The full name"),
+		attribute("givenName", "string", "The first name"),
+		attribute("familyName", "string", "The last name"),
+	}
+
+	emails := attribute("emails", "complex", "The email address of the user, the same as userName")
+	emails.MultiValued = true
+	emails.SubAttributes = []*SchemaAttribute{
+		attribute("value", "string", "The email address"),
+		attribute("type", "string", "The kind of address, work"),
+		attribute("primary", "boolean", "Whether it is the primary address"),
+	}
+
+	password := attribute("password", "string", "The password of the user, checked against the password policy")
+	password.Mutability, password.Returned = "writeOnly", "never"
+
+	userGroups := attribute("groups", "complex", "The groups the user is a member of")
+	userGroups.MultiValued, userGroups.Mutability = true, "readOnly"
+	userGroups.SubAttributes = []*SchemaAttribute{
+		attribute("value", "string", "The id of the group"),
+		attribute("display", "string", "The name of the group"),
+		attribute("$ref", "reference", "The URI of the group"),
+	}
+
+	displayName := attribute("displayName", "string", "The name of the group: lowercase letters, digits, '.', '_' and '-'")
+	displayName.Required, displayName.CaseExact, displayName.Uniqueness = true, true, "server"
+
+	members := attribute("members", "complex", "The users in the group")
+	members.MultiValued = true
+	members.SubAttributes = []*SchemaAttribute{
+		attribute("value", "string", "The id of the user"),
+		attribute("display", "string", "The email address of the user"),
+		attribute("$ref", "reference", "The URI of the user"),
+	}
+
+	return []*Schema{
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          SchemaUser,
+			Name:        "User",
+			Description: "User Account",
+			Attributes: []*SchemaAttribute{
+				userName, name, emails,
+				attribute("active", "boolean", "Whether the user can sign in"),
+				password, userGroups,
+			},
+			Meta: &DocumentMeta{ResourceType: "Schema", Location: base + "/Schemas/" + SchemaUser},
+		},
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          SchemaGroup,
+			Name:        "Group",
+			Description: "Group",
+			Attributes:  []*SchemaAttribute{displayName, members},
+			Meta:        &DocumentMeta{ResourceType: "Schema", Location: base + "/Schemas/" + SchemaGroup},
+		},
+	}
+}
@@ -0,0 +1,34 @@
+package scim
+
+import "errors"
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrInvalidValue  = errors.New("invalid value")
+	ErrInvalidSyntax = errors.New("invalid syntax")
+	ErrNoTarget      = errors.New("path matches no attribute")
+
+	ErrTokenInvalid   = errors.New("invalid scim token")
+	ErrTokenNotFound  = errors.New("scim token not found")
+	ErrTokenNameTaken = errors.New("scim token name is taken")
+	ErrNameInvalid    = errors.New("invalid scim token name")
+)
+
+// ErrorType gets the scimType of the errors of filters and PATCH
+// operations, empty for other errors.
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidFilter):
+		return "invalidFilter"
+	case errors.Is(err, ErrInvalidPath):
+		return "invalidPath"
+	case errors.Is(err, ErrInvalidValue):
+		return "invalidValue"
+	case errors.Is(err, ErrInvalidSyntax):
+		return "invalidSyntax"
+	case errors.Is(err, ErrNoTarget):
+		return "noTarget"
+	}
+	return ""
+}
@@ -0,0 +1,573 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Filter is a parsed filter, RFC 7644 section 3.4.2.2. It matches
+// resources in their JSON form, or narrows down a query through Where.
+type Filter interface {
+	// Match reports whether the resource, decoded from JSON, matches.
+	Match(resource map[string]interface{}) bool
+
+	// sql is the condition matching the filter on the columns of attrs.
+	sql(attrs map[string]Attribute) (string, []interface{}, error)
+}
+
+// Attribute is the column an attribute is filtered on.
+type Attribute struct {
+	// Column is the column, or an SQL expression
+	Column string
+	// CaseExact compares strings as they are instead of regardless of case
+	CaseExact bool
+}
+
+// Where narrows tx down to the rows f matches, attrs mapping the lowercase
+// paths of the attributes, "name.givenname", to their columns.
+// Returns ErrInvalidFilter for attributes missing from attrs.
+func Where(tx *gorm.DB, f Filter, attrs map[string]Attribute) (*gorm.DB, error) {
+	if f == nil {
+		return tx, nil
+	}
+	cond, args, err := f.sql(attrs)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Where(cond, args...), nil
+}
+
+// Comparison operators.
+const (
+	opEq = "eq"
+	opNe = "ne"
+	opCo = "co"
+	opSw = "sw"
+	opEw = "ew"
+	opGt = "gt"
+	opGe = "ge"
+	opLt = "lt"
+	opLe = "le"
+	opPr = "pr"
+)
+
+var compareOps = map[string]bool{opEq: true, opNe: true, opCo: true, opSw: true, opEw: true, opGt: true, opGe: true, opLt: true, opLe: true}
+
+// ParseFilter parses a filter.
+// Returns ErrInvalidFilter if it isn't one.
+func ParseFilter(s string) (Filter, error) {
+	p := &parser{tokens: tokenize(s)}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	return f, nil
+}
+
+type compare struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+type present struct {
+	path []string
+}
+
+type logical struct {
+	and         bool
+	left, right Filter
+}
+
+type not struct {
+	f Filter
+}
+
+// valuePath matches the items of a multi-valued attribute, emails[type
+// eq "work"].
+type valuePath struct {
+	attr   string
+	filter Filter
+}
+
+func (c *compare) Match(r map[string]interface{}) bool {
+	for _, v := range values(r, c.path) {
+		if compareValue(v, c.op, c.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *present) Match(r map[string]interface{}) bool {
+	for _, v := range values(r, p.path) {
+		if s, ok := v.(string); !ok || s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *logical) Match(r map[string]interface{}) bool {
+	if l.and {
+		return l.left.Match(r) && l.right.Match(r)
+	}
+	return l.left.Match(r) || l.right.Match(r)
+}
+
+func (n *not) Match(r map[string]interface{}) bool {
+	return !n.f.Match(r)
+}
+
+func (v *valuePath) Match(r map[string]interface{}) bool {
+	for _, item := range items(r, v.attr) {
+		if m, ok := item.(map[string]interface{}); ok && v.filter.Match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compare) sql(attrs map[string]Attribute) (string, []interface{}, error) {
+	a, ok := attrs[strings.Join(c.path, ".")]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: can't filter by %s", ErrInvalidFilter, strings.Join(c.path, "."))
+	}
+
+	if c.value == nil {
+		switch c.op {
+		case opEq:
+			return a.Column + " IS NULL", nil, nil
+		case opNe:
+			return a.Column + " IS NOT NULL", nil, nil
+		}
+		return "", nil, fmt.Errorf("%w: only eq and ne compare to null", ErrInvalidFilter)
+	}
+
+	column, value := a.Column, c.value
+	if s, ok := value.(string); ok && !a.CaseExact {
+		column, value = "lower("+column+")", strings.ToLower(s)
+	}
+
+	switch c.op {
+	case opCo, opSw, opEw:
+		s, ok := value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s compares strings", ErrInvalidFilter, c.op)
+		}
+		s = escapeLike(s)
+		switch c.op {
+		case opCo:
+			s = "%" + s + "%"
+		case opSw:
+			s += "%"
+		case opEw:
+			s = "%" + s
+		}
+		return column + ` LIKE ? ESCAPE '\'`, []interface{}{s}, nil
+	}
+
+	sqlOps := map[string]string{opEq: "=", opNe: "<>", opGt: ">", opGe: ">=", opLt: "<", opLe: "<="}
+	return column + " " + sqlOps[c.op] + " ?", []interface{}{value}, nil
+}
+
+func (p *present) sql(attrs map[string]Attribute) (string, []interface{}, error) {
+	a, ok := attrs[strings.Join(p.path, ".")]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: can't filter by %s", ErrInvalidFilter, strings.Join(p.path, "."))
+	}
+	return a.Column + " IS NOT NULL", nil, nil
+}
+
+func (l *logical) sql(attrs map[string]Attribute) (string, []interface{}, error) {
+	left, leftArgs, err := l.left.sql(attrs)
+	if err != nil {
+		return "", nil, err
+	}
+	right, rightArgs, err := l.right.sql(attrs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	op := " OR "
+	if l.and {
+		op = " AND "
+	}
+	return "(" + left + op + right + ")", append(leftArgs, rightArgs...), nil
+}
+
+func (n *not) sql(attrs map[string]Attribute) (string, []interface{}, error) {
+	cond, args, err := n.f.sql(attrs)
+	if err != nil {
+		return "", nil, err
+	}
+	return "NOT (" + cond + ")", args, nil
+}
+
+// sql filters the items of the attribute as its sub-attributes,
+// emails[value eq "x"] as emails.value eq "x".
+func (v *valuePath) sql(attrs map[string]Attribute) (string, []interface{}, error) {
+	prefixed := make(map[string]Attribute)
+	for path, a := range attrs {
+		if strings.HasPrefix(path, v.attr+".") {
+			prefixed[strings.TrimPrefix(path, v.attr+".")] = a
+		}
+	}
+	return v.filter.sql(prefixed)
+}
+
+// values gets the values at path in r, the items of multi-valued
+// attributes being values each. A multi-valued attribute of complex
+// values compares by their value sub-attribute.
+func values(r map[string]interface{}, path []string) []interface{} {
+	current := []interface{}{r}
+	for _, name := range path {
+		var next []interface{}
+		for _, v := range current {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			next = append(next, items(m, name)...)
+		}
+		current = next
+	}
+
+	var out []interface{}
+	for _, v := range current {
+		if m, ok := v.(map[string]interface{}); ok {
+			if value, ok := lookup(m, "value"); ok {
+				v = value
+			}
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// items gets the value of the attribute of r, or its items when it is
+// multi-valued.
+func items(r map[string]interface{}, name string) []interface{} {
+	v, ok := lookup(r, name)
+	if !ok || v == nil {
+		return nil
+	}
+	if list, ok := v.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{v}
+}
+
+// lookup gets the attribute of r, attribute names being case insensitive.
+func lookup(r map[string]interface{}, name string) (interface{}, bool) {
+	key, ok := findKey(r, name)
+	if !ok {
+		return nil, false
+	}
+	return r[key], true
+}
+
+func findKey(r map[string]interface{}, name string) (string, bool) {
+	if _, ok := r[name]; ok {
+		return name, true
+	}
+	for k := range r {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// compareValue compares v to want with op, strings regardless of case.
+func compareValue(v interface{}, op string, want interface{}) bool {
+	if want == nil {
+		return (op == opEq) == (v == nil)
+	}
+
+	switch want := want.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		s, want = strings.ToLower(s), strings.ToLower(want)
+		switch op {
+		case opEq:
+			return s == want
+		case opNe:
+			return s != want
+		case opCo:
+			return strings.Contains(s, want)
+		case opSw:
+			return strings.HasPrefix(s, want)
+		case opEw:
+			return strings.HasSuffix(s, want)
+		case opGt:
+			return s > want
+		case opGe:
+			return s >= want
+		case opLt:
+			return s < want
+		case opLe:
+			return s <= want
+		}
+	case float64:
+		n, ok := v.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case opEq:
+			return n == want
+		case opNe:
+			return n != want
+		case opGt:
+			return n > want
+		case opGe:
+			return n >= want
+		case opLt:
+			return n < want
+		case opLe:
+			return n <= want
+		}
+	case bool:
+		b, ok := v.(bool)
+		if !ok {
+			return false
+		}
+		switch op {
+		case opEq:
+			return b == want
+		case opNe:
+			return b != want
+		}
+	}
+	return false
+}
+
+// escapeLike makes the wildcards of s match themselves.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// tokenize splits a filter into parentheses, brackets, quoted strings and
+// words.
+func tokenize(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte("()[]", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(s) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && strings.IndexByte(`()[]"`, s[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidFilter}, args...)...)
+}
+
+func (p *parser) expect(token string) error {
+	if got := p.next(); got != token {
+		return p.errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+// or parses filters joined by or, which binds the loosest.
+func (p *parser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Filter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (Filter, error) {
+	switch t := p.peek(); {
+	case strings.EqualFold(t, "not"):
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return &not{f: f}, p.expect(")")
+	case t == "(":
+		p.next()
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	}
+	return p.attrExp()
+}
+
+func (p *parser) attrExp() (Filter, error) {
+	path, err := parseAttrPath(p.next())
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek() == "[" {
+		if len(path) != 1 {
+			return nil, p.errorf("%s can't have a value filter", strings.Join(path, "."))
+		}
+		p.next()
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return &valuePath{attr: path[0], filter: f}, p.expect("]")
+	}
+
+	op := strings.ToLower(p.next())
+	if op == opPr {
+		return &present{path: path}, nil
+	}
+	if !compareOps[op] {
+		return nil, p.errorf("unknown operator %q", op)
+	}
+
+	value, err := parseCompValue(p.next())
+	if err != nil {
+		return nil, err
+	}
+	return &compare{path: path, op: op, value: value}, nil
+}
+
+// parseAttrPath splits an attribute path into its lowercase names,
+// dropping the URN of the schema it may start with.
+func parseAttrPath(s string) ([]string, error) {
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		s = s[i+1:]
+	}
+	if s == "" {
+		return nil, fmt.Errorf("%w: attribute missing", ErrInvalidFilter)
+	}
+
+	path := strings.Split(strings.ToLower(s), ".")
+	for _, name := range path {
+		if !validAttrName(name) {
+			return nil, fmt.Errorf("%w: invalid attribute %q", ErrInvalidFilter, s)
+		}
+	}
+	return path, nil
+}
+
+func validAttrName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r == '$' && i == 0:
+		case i > 0 && (r >= '0' && r <= '9' || r == '_' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func parseCompValue(s string) (interface{}, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, fmt.Errorf("%w: invalid value %s", ErrInvalidFilter, s)
+	}
+	switch v.(type) {
+	case string, float64:
+		return v, nil
+	}
+	return nil, fmt.Errorf("%w: invalid value %s", ErrInvalidFilter, s)
+}
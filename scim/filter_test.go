@@ -0,0 +1,166 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const jane = `{
+	"id": "2819c223",
+	"userName": "Jane@Example.com",
+	"name": {"givenName": "Jane", "familyName": "Doe"},
+	"emails": [{"value": "jane@example.com", "type": "work", "primary": true}, {"value": "jd@home.org", "type": "home"}],
+	"active": true,
+	"meta": {"lastModified": "2023-01-02T03:04:05Z"}
+}`
+
+func decode(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestFilter_Match(t *testing.T) {
+	r := decode(t, jane)
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "jane@example.com"`, true},
+		{`USERNAME Eq "JANE@EXAMPLE.COM"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "jane"`, true},
+		{`userName ne "jane@example.com"`, false},
+		{`name.familyName co "oe"`, true},
+		{`name.givenName ew "x"`, false},
+		{`emails co "home.org"`, true},
+		{`emails[type eq "work" and value ew "example.com"]`, true},
+		{`emails[type eq "work" and value ew "home.org"]`, false},
+		{`emails.type eq "home"`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`title pr`, false},
+		{`name pr and not (active eq false)`, true},
+		{`meta.lastModified gt "2023-01-01T00:00:00Z"`, true},
+		{`userName eq "john@example.com" or name.givenName eq "Jane"`, true},
+		{`(userName eq "john@example.com" or name.givenName eq "John") and active eq true`, false},
+		{`userName eq "a" and userName eq "b" or active eq true`, true},
+		{`title eq null`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(r); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilter_invalid(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName equals "x"`,
+		`userName eq "x" and`,
+		`(userName eq "x"`,
+		`userName eq "x")`,
+		`userName eq unquoted`,
+		`emails[type eq "work"`,
+		`not userName eq "x"`,
+		`user-name@ eq "x"`,
+	} {
+		if _, err := ParseFilter(filter); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("ParseFilter(%q) error = %v, want %v", filter, err, ErrInvalidFilter)
+		}
+	}
+}
+
+func TestWhere(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	type person struct {
+		ID         uint
+		Email      string
+		FirstName  string
+		DisabledAt *time.Time
+	}
+	if err := db.AutoMigrate(&person{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, p := range []*person{
+		{Email: "jane@example.com", FirstName: "Jane"},
+		{Email: "john@example.com", FirstName: "John", DisabledAt: &now},
+		{Email: "50%_off@example.org", FirstName: "Sale"},
+	} {
+		if err := db.Create(p).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attrs := map[string]Attribute{
+		"username":       {Column: "email"},
+		"emails.value":   {Column: "email"},
+		"name.givenname": {Column: "first_name"},
+		"active":         {Column: "(disabled_at IS NULL)"},
+		"id":             {Column: "id", CaseExact: true},
+	}
+
+	tests := []struct {
+		filter  string
+		want    []uint
+		wantErr error
+	}{
+		{filter: `userName eq "JANE@example.com"`, want: []uint{1}},
+		{filter: `emails[value sw "jo"]`, want: []uint{2}},
+		{filter: `userName co "%_"`, want: []uint{3}},
+		{filter: `userName ew ".com" and not (name.givenName eq "jane")`, want: []uint{2}},
+		{filter: `active eq true`, want: []uint{1, 3}},
+		{filter: `active eq false or id gt 2`, want: []uint{2, 3}},
+		{filter: `name.givenName pr`, want: []uint{1, 2, 3}},
+		{filter: `title eq "x"`, wantErr: ErrInvalidFilter},
+		{filter: `emails[type eq "work"]`, wantErr: ErrInvalidFilter},
+		{filter: `userName gt null`, wantErr: ErrInvalidFilter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx, err := Where(db.Model(&person{}), f, attrs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Where() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var ids []uint
+			if err := tx.Order("id").Pluck("id", &ids).Error; err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+				t.Errorf("Where() matches %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	if tx, err := Where(db, nil, attrs); err != nil || tx != db {
+		t.Errorf("Where() without a filter = %v, %v, want tx as is", tx, err)
+	}
+}
@@ -0,0 +1,325 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH, RFC 7644 section 3.5.2.
+type PatchRequest struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+// Operation is an operation of a PATCH: add, replace or remove the value
+// at path.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// path is where an operation applies: an attribute, optionally narrowed
+// down to the items of a multi-valued one matching a filter, optionally
+// a sub-attribute of it.
+type path struct {
+	attr   string
+	filter Filter
+	sub    string
+}
+
+// Apply applies the operations of r in order to the resource, decoded from
+// JSON. Attribute names are case insensitive. Items of multi-valued
+// attributes can also be removed by value, the way some clients remove
+// the members of groups.
+// Returns ErrInvalidSyntax, ErrInvalidPath, ErrInvalidValue or
+// ErrNoTarget for operations it can't apply.
+func (r *PatchRequest) Apply(resource map[string]interface{}) error {
+	if len(r.Schemas) > 0 && !contains(r.Schemas, MessagePatchOp) {
+		return fmt.Errorf("%w: schemas is missing %s", ErrInvalidSyntax, MessagePatchOp)
+	}
+	if len(r.Operations) == 0 {
+		return fmt.Errorf("%w: no operations", ErrInvalidSyntax)
+	}
+
+	for _, op := range r.Operations {
+		if err := op.apply(resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (op *Operation) apply(resource map[string]interface{}) error {
+	var value interface{}
+	if len(op.Value) > 0 {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+		}
+	}
+
+	kind := strings.ToLower(op.Op)
+	switch kind {
+	case "add", "replace":
+		if op.Path != "" {
+			p, err := parsePath(op.Path)
+			if err != nil {
+				return err
+			}
+			return set(resource, p, value, kind == "add")
+		}
+
+		// without a path the value holds the attributes, or paths, to set
+		attrs, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: %s without a path takes an object", ErrInvalidValue, kind)
+		}
+		for k, v := range attrs {
+			p, err := parsePath(k)
+			if err != nil {
+				return err
+			}
+			if err := set(resource, p, v, kind == "add"); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "remove":
+		if op.Path == "" {
+			return fmt.Errorf("%w: remove takes a path", ErrNoTarget)
+		}
+		p, err := parsePath(op.Path)
+		if err != nil {
+			return err
+		}
+		remove(resource, p, value)
+		return nil
+	}
+	return fmt.Errorf("%w: unknown op %q", ErrInvalidSyntax, op.Op)
+}
+
+// parsePath parses attr, attr.sub, attr[filter] or attr[filter].sub, attr
+// optionally starting with the URN of its schema.
+func parsePath(s string) (*path, error) {
+	head, rest := s, ""
+	var filter string
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		j := strings.LastIndexByte(s, ']')
+		if j < i {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, s)
+		}
+		head, filter, rest = s[:i], s[i+1:j], s[j+1:]
+	}
+	if i := strings.LastIndex(head, ":"); i >= 0 {
+		head = head[i+1:]
+	}
+
+	p := &path{}
+	names := strings.Split(head, ".")
+	switch {
+	case len(names) == 1:
+		p.attr = names[0]
+	case len(names) == 2 && filter == "":
+		p.attr, p.sub = names[0], names[1]
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, s)
+	}
+
+	if filter != "" {
+		f, err := ParseFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPath, err)
+		}
+		p.filter = f
+		if rest != "" {
+			if rest[0] != '.' || !validAttrName(strings.ToLower(rest[1:])) {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidPath, s)
+			}
+			p.sub = rest[1:]
+		}
+	}
+
+	if !validAttrName(strings.ToLower(p.attr)) || (p.sub != "" && !validAttrName(strings.ToLower(p.sub))) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, s)
+	}
+	return p, nil
+}
+
+// set sets the value at p. Complex values are merged into the ones they
+// replace, add appends to multi-valued attributes.
+func set(r map[string]interface{}, p *path, value interface{}, add bool) error {
+	key := keyOf(r, p.attr)
+
+	if p.filter != nil {
+		matched := false
+		for _, item := range items(r, key) {
+			m, ok := item.(map[string]interface{})
+			if !ok || !p.filter.Match(m) {
+				continue
+			}
+			matched = true
+			if p.sub != "" {
+				m[keyOf(m, p.sub)] = value
+				continue
+			}
+			sub, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: %s items take an object", ErrInvalidValue, p.attr)
+			}
+			merge(m, sub)
+		}
+		if !matched {
+			return fmt.Errorf("%w: no %s item matches", ErrNoTarget, p.attr)
+		}
+		return nil
+	}
+
+	if p.sub != "" {
+		switch parent := r[key].(type) {
+		case map[string]interface{}:
+			parent[keyOf(parent, p.sub)] = value
+		case []interface{}:
+			for _, item := range parent {
+				if m, ok := item.(map[string]interface{}); ok {
+					m[keyOf(m, p.sub)] = value
+				}
+			}
+		default:
+			r[key] = map[string]interface{}{p.sub: value}
+		}
+		return nil
+	}
+
+	switch existing := r[key].(type) {
+	case []interface{}:
+		if !add {
+			break
+		}
+		added, ok := value.([]interface{})
+		if !ok {
+			added = []interface{}{value}
+		}
+		for _, v := range added {
+			if indexOf(existing, v) < 0 {
+				existing = append(existing, v)
+			}
+		}
+		r[key] = existing
+		return nil
+	case map[string]interface{}:
+		if sub, ok := value.(map[string]interface{}); ok {
+			merge(existing, sub)
+			return nil
+		}
+	}
+	r[key] = value
+	return nil
+}
+
+// remove removes the value at p, or the items of a multi-valued attribute
+// equal to value. Removing what isn't there does nothing.
+func remove(r map[string]interface{}, p *path, value interface{}) {
+	key, ok := findKey(r, p.attr)
+	if !ok {
+		return
+	}
+
+	if p.filter != nil {
+		list, _ := r[key].([]interface{})
+		kept := list[:0]
+		for _, item := range list {
+			m, ok := item.(map[string]interface{})
+			switch {
+			case !ok || !p.filter.Match(m):
+				kept = append(kept, item)
+			case p.sub != "":
+				if k, ok := findKey(m, p.sub); ok {
+					delete(m, k)
+				}
+				kept = append(kept, item)
+			}
+		}
+		r[key] = kept
+		return
+	}
+
+	if p.sub != "" {
+		parents := items(r, key)
+		for _, parent := range parents {
+			if m, ok := parent.(map[string]interface{}); ok {
+				if k, ok := findKey(m, p.sub); ok {
+					delete(m, k)
+				}
+			}
+		}
+		return
+	}
+
+	list, isList := r[key].([]interface{})
+	if !isList || value == nil {
+		delete(r, key)
+		return
+	}
+
+	removed, ok := value.([]interface{})
+	if !ok {
+		removed = []interface{}{value}
+	}
+	kept := list[:0]
+	for _, item := range list {
+		if indexOf(removed, item) < 0 {
+			kept = append(kept, item)
+		}
+	}
+	r[key] = kept
+}
+
+// keyOf gets the key of the attribute in r, name when r doesn't have it.
+func keyOf(r map[string]interface{}, name string) string {
+	if key, ok := findKey(r, name); ok {
+		return key
+	}
+	return name
+}
+
+// merge sets the attributes of src in dst.
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		dst[keyOf(dst, k)] = v
+	}
+}
+
+// indexOf gets the index of v in list, -1 when it isn't there. Complex
+// values with a value sub-attribute are the same when their values are.
+func indexOf(list []interface{}, v interface{}) int {
+	for i, item := range list {
+		if sameItem(item, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameItem(a, b interface{}) bool {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		av, aHas := lookup(am, "value")
+		bv, bHas := lookup(bm, "value")
+		if aHas && bHas {
+			return reflect.DeepEqual(av, bv)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPatchRequest_Apply(t *testing.T) {
+	group := `{
+		"displayName": "staff",
+		"members": [{"value": "a", "display": "a@example.com"}, {"value": "b"}, {"value": "c"}]
+	}`
+
+	tests := []struct {
+		name       string
+		resource   string
+		operations string
+		want       string
+		wantErr    error
+	}{
+		{
+			name:       "replace attribute",
+			resource:   jane,
+			operations: `[{"op": "replace", "path": "userName", "value": "jane.doe@example.com"}]`,
+			want:       `{"userName": "jane.doe@example.com"}`,
+		},
+		{
+			name:       "replace sub-attribute, case insensitive",
+			resource:   jane,
+			operations: `[{"op": "Replace", "path": "NAME.familyName", "value": "Smith"}]`,
+			want:       `{"name": {"givenName": "Jane", "familyName": "Smith"}}`,
+		},
+		{
+			name:       "replace without path",
+			resource:   jane,
+			operations: `[{"op": "replace", "value": {"active": "False", "name.givenName": "Janet", "urn:ietf:params:scim:schemas:core:2.0:User:userName": "janet@example.com"}}]`,
+			want:       `{"active": "False", "name": {"givenName": "Janet", "familyName": "Doe"}, "userName": "janet@example.com"}`,
+		},
+		{
+			name:       "replace complex merges",
+			resource:   jane,
+			operations: `[{"op": "replace", "path": "name", "value": {"givenName": "Janet"}}]`,
+			want:       `{"name": {"givenName": "Janet", "familyName": "Doe"}}`,
+		},
+		{
+			name:       "replace filtered sub-attribute",
+			resource:   jane,
+			operations: `[{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "jane@corp.example"}]`,
+			want: `{"emails": [{"value": "jane@corp.example", "type": "work", "primary": true},
+				{"value": "jd@home.org", "type": "home"}]}`,
+		},
+		{
+			name:       "replace filtered without match",
+			resource:   jane,
+			operations: `[{"op": "replace", "path": "emails[type eq \"other\"].value", "value": "x"}]`,
+			wantErr:    ErrNoTarget,
+		},
+		{
+			name:       "add members",
+			resource:   group,
+			operations: `[{"op": "add", "path": "members", "value": [{"value": "b"}, {"value": "d"}]}]`,
+			want:       `{"members": [{"value": "a", "display": "a@example.com"}, {"value": "b"}, {"value": "c"}, {"value": "d"}]}`,
+		},
+		{
+			name:       "add to missing attribute",
+			resource:   `{"displayName": "staff"}`,
+			operations: `[{"op": "add", "path": "members", "value": [{"value": "a"}]}]`,
+			want:       `{"members": [{"value": "a"}]}`,
+		},
+		{
+			name:       "remove filtered member",
+			resource:   group,
+			operations: `[{"op": "remove", "path": "members[value eq \"b\"]"}]`,
+			want:       `{"members": [{"value": "a", "display": "a@example.com"}, {"value": "c"}]}`,
+		},
+		{
+			name:       "remove members by value",
+			resource:   group,
+			operations: `[{"op": "remove", "path": "members", "value": [{"value": "a"}, {"value": "c"}]}]`,
+			want:       `{"members": [{"value": "b"}]}`,
+		},
+		{
+			name:       "remove every member",
+			resource:   group,
+			operations: `[{"op": "remove", "path": "members"}]`,
+			want:       `{"members": null}`,
+		},
+		{
+			name:       "remove missing member",
+			resource:   group,
+			operations: `[{"op": "remove", "path": "members[value eq \"z\"]"}]`,
+			want:       `{}`,
+		},
+		{
+			name:       "operations in order",
+			resource:   group,
+			operations: `[{"op": "remove", "path": "members"}, {"op": "add", "path": "members", "value": [{"value": "z"}]}, {"op": "replace", "path": "displayName", "value": "ops"}]`,
+			want:       `{"displayName": "ops", "members": [{"value": "z"}]}`,
+		},
+		{name: "remove without path", resource: group, operations: `[{"op": "remove"}]`, wantErr: ErrNoTarget},
+		{name: "unknown op", resource: group, operations: `[{"op": "move", "path": "members"}]`, wantErr: ErrInvalidSyntax},
+		{name: "no operations", resource: group, operations: `[]`, wantErr: ErrInvalidSyntax},
+		{name: "invalid path", resource: group, operations: `[{"op": "replace", "path": "a.b.c", "value": 1}]`, wantErr: ErrInvalidPath},
+		{name: "invalid filter", resource: group, operations: `[{"op": "remove", "path": "members[value]"}]`, wantErr: ErrInvalidPath},
+		{name: "value without path", resource: group, operations: `[{"op": "add", "value": "x"}]`, wantErr: ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := decode(t, tt.resource)
+			req := &PatchRequest{Schemas: []string{MessagePatchOp}}
+			if err := json.Unmarshal([]byte(tt.operations), &req.Operations); err != nil {
+				t.Fatal(err)
+			}
+
+			err := req.Apply(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// the attributes want lists are compared, the others are left as is
+			want := decode(t, tt.resource)
+			for k, v := range decode(t, tt.want) {
+				if v == nil {
+					delete(want, k)
+					continue
+				}
+				want[k] = v
+			}
+			if !reflect.DeepEqual(normalize(t, r), want) {
+				got, _ := json.Marshal(r)
+				t.Errorf("Apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchRequest_Apply_schema(t *testing.T) {
+	req := &PatchRequest{
+		Schemas:    []string{SchemaUser},
+		Operations: []Operation{{Op: "remove", Path: "title"}},
+	}
+	if err := req.Apply(map[string]interface{}{}); !errors.Is(err, ErrInvalidSyntax) {
+		t.Errorf("Apply() error = %v, want %v", err, ErrInvalidSyntax)
+	}
+}
+
+// normalize round-trips r through JSON, making the slices it shares with
+// the original resource its own.
+func normalize(t *testing.T, r map[string]interface{}) map[string]interface{} {
+	t.Helper()
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decode(t, string(buf))
+}
@@ -0,0 +1,169 @@
+// Package scim implements the parts of SCIM 2.0 (RFC 7643, RFC 7644)
+// provisioning clients need: the resources and their schemas, filters,
+// PATCH operations and the tokens clients authenticate with.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// URNs of the schemas and messages.
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+
+	MessageListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	MessagePatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	MessageError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Sizes of the pages resources are listed in.
+const (
+	DefaultCount = 100
+	MaxCount     = 200
+)
+
+// Meta is what a resource is and when it changed.
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+	Version      string    `json:"version,omitempty"`
+}
+
+// Version is the weak entity tag of a resource last modified at t.
+func Version(t time.Time) string {
+	return `W/"` + strconv.FormatInt(t.UnixNano(), 36) + `"`
+}
+
+// Name is the name of a user.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValue is an item of a multi-valued attribute: emails, groups,
+// members.
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// User is a user resource.
+type User struct {
+	Schemas    []string     `json:"schemas"`
+	ID         string       `json:"id,omitempty"`
+	ExternalID string       `json:"externalId,omitempty"`
+	UserName   string       `json:"userName"`
+	Name       *Name        `json:"name,omitempty"`
+	Emails     []MultiValue `json:"emails,omitempty"`
+	Active     *Boolean     `json:"active,omitempty"`
+	Password   string       `json:"password,omitempty"`
+	Groups     []MultiValue `json:"groups,omitempty"`
+	Meta       *Meta        `json:"meta,omitempty"`
+}
+
+// PrimaryEmail gets the primary email address of u, the first one when
+// none is primary.
+func (u *User) PrimaryEmail() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// Group is a group resource.
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// Boolean is a boolean some clients send as a string, "True" or "False".
+type Boolean bool
+
+func (b *Boolean) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case bool:
+		*b = Boolean(v)
+		return nil
+	case string:
+		parsed, err := strconv.ParseBool(strings.ToLower(v))
+		if err == nil {
+			*b = Boolean(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not a boolean", ErrInvalidValue, data)
+}
+
+// ListResponse is a page of resources.
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// NewListResponse returns the page of resources starting at startIndex,
+// out of total.
+func NewListResponse(resources []interface{}, startIndex, total int) *ListResponse {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return &ListResponse{
+		Schemas:      []string{MessageListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// Error is an error response.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError returns the error response of status, scimType being empty for
+// the statuses that have none.
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{MessageError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
@@ -0,0 +1,113 @@
+package scim
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// lastUsedPrecision is how often using a token is recorded, sparing a
+// write per request.
+const lastUsedPrecision = time.Minute
+
+type Store interface {
+	// Create issues a token named name to a client of the tenant. Returns
+	// the token to hand to the client, it can't be read afterwards.
+	// Returns ErrNameInvalid or ErrTokenNameTaken for a name it can't use.
+	Create(tenantID uint, name string) (string, *Token, error)
+
+	// Authenticate gets the token and records it has just been used.
+	// Returns ErrTokenInvalid if there is no such token.
+	Authenticate(token string) (*Token, error)
+
+	// Tokens gets the tokens of the tenant, by name.
+	Tokens(tenantID uint) ([]*Token, error)
+
+	// Delete deletes the token of the tenant with the specified name.
+	// Returns ErrTokenNotFound if there is no such token.
+	Delete(tenantID uint, name string) error
+
+	// Migrate auto-migrates the Token model to database.
+	Migrate() error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+func (s *store) Create(tenantID uint, name string) (string, *Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return "", nil, ErrNameInvalid
+	}
+
+	err := s.db.Where("tenant_id = ? AND name = ?", tenantID, name).First(&Token{}).Error
+	if err == nil {
+		return "", nil, ErrTokenNameTaken
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil, err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	t := &Token{TenantID: tenantID, Name: name, TokenHash: hashToken(token)}
+	if err := s.db.Create(t).Error; err != nil {
+		return "", nil, err
+	}
+	return token, t, nil
+}
+
+func (s *store) Authenticate(token string) (*Token, error) {
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return nil, ErrTokenInvalid
+	}
+
+	var t Token
+	err := s.db.Where("token_hash = ?", hashToken(token)).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= lastUsedPrecision {
+		if err := s.db.Model(&t).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+		t.LastUsedAt = &now
+	}
+	return &t, nil
+}
+
+func (s *store) Tokens(tenantID uint) ([]*Token, error) {
+	var tokens []*Token
+	err := s.db.Where("tenant_id = ?", tenantID).Order("name").Find(&tokens).Error
+	return tokens, err
+}
+
+func (s *store) Delete(tenantID uint, name string) error {
+	tx := s.db.Where("tenant_id = ? AND name = ?", tenantID, name).Delete(&Token{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+func (s *store) Migrate() error {
+	return s.db.AutoMigrate(&Token{})
+}
@@ -0,0 +1,100 @@
+package scim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+
+	token, created, err := s.Create(0, "hr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, TokenPrefix) || created.TokenHash == token {
+		t.Fatalf("Create() = %q, want a prefixed token stored hashed", token)
+	}
+
+	tests := []struct {
+		name     string
+		tenantID uint
+		token    string
+		wantErr  error
+	}{
+		{name: "same name in another tenant", tenantID: 1, token: "hr"},
+		{name: "taken", token: "hr", wantErr: ErrTokenNameTaken},
+		{name: "empty", token: " ", wantErr: ErrNameInvalid},
+		{name: "too long", token: strings.Repeat("a", 65), wantErr: ErrNameInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.Create(tt.tenantID, tt.token); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := s.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != created.ID || got.LastUsedAt == nil {
+		t.Errorf("Authenticate() = %+v, want token %d marked used", got, created.ID)
+	}
+	for _, invalid := range []string{"", "hr", TokenPrefix + "guess", token + "x"} {
+		if _, err := s.Authenticate(invalid); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("Authenticate(%q) error = %v, want %v", invalid, err, ErrTokenInvalid)
+		}
+	}
+
+	if tokens, _ := s.Tokens(0); len(tokens) != 1 || tokens[0].Name != "hr" {
+		t.Errorf("Tokens() = %v, want hr", tokens)
+	}
+
+	if err := s.Delete(0, "hr"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(0, "hr"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Delete() again error = %v, want %v", err, ErrTokenNotFound)
+	}
+	if _, err := s.Authenticate(token); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Authenticate() after Delete() error = %v, want %v", err, ErrTokenInvalid)
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
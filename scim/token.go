@@ -0,0 +1,46 @@
+package scim
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// TokenPrefix starts every token, telling them apart from other secrets.
+const TokenPrefix = "scim_"
+
+// Token lets a provisioning client manage the users and groups of a
+// tenant. Only its hash is stored.
+type Token struct {
+	ID       uint `json:"id" gorm:"primarykey"`
+	TenantID uint `json:"tenant_id" gorm:"index:scim_token_name_index,unique,priority:1"`
+	// Name tells the clients of the tenant apart
+	Name string `json:"name" gorm:"index:scim_token_name_index,unique,priority:2"`
+
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName overrides the default tokens.
+func (Token) TableName() string {
+	return "scim_tokens"
+}
+
+// generateToken returns a random token to hand to the client.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a token for lookup. The token is random enough that a
+// fast hash doesn't make it guessable.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"github.com/9d4/semaphore/auth"
 	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/rbac"
 	"github.com/9d4/semaphore/server/middleware"
 	"github.com/9d4/semaphore/server/types"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/tenant"
 	"github.com/go-playground/validator/v10"
 	jww "github.com/spf13/jwalterweatherman"
-	"sort"
 	"strconv"
 	"time"
 
@@ -24,9 +26,18 @@ import (
 
 type apiServer struct {
 	*Config
-	app *fiber.App
-	db  *gorm.DB
-	v   *viper.Viper
+	app       *fiber.App
+	db        *gorm.DB
+	v         *viper.Viper
+	sessions  session.Store
+	mfa       *mfaChallenge
+	passkeys  *passkeyCeremony
+	verifier  *emailVerifier
+	passwords *passwordPolicy
+	logins    loginBackend
+	resets    *passwordReset
+	guard     *loginGuard
+	revoker   revoker
 }
 
 type userInfo struct {
@@ -41,48 +52,128 @@ type accessToken struct {
 	jwt.RegisteredClaims
 }
 
-type refreshToken struct {
-	jwt.RegisteredClaims
-}
-
-func newApiServer(db *gorm.DB, opts ...Option) *apiServer {
-	config := &Config{}
-
-	if len(opts) < 1 {
-		config = &(*defaultConfig)
+// newApiServer builds the API on the collaborators of srv, so it shares
+// the sessions, second factors and login guard of the login form. Signing
+// users out goes through its OAuth server.
+func newApiServer(srv *server) *apiServer {
+	s := &apiServer{
+		Config:    srv.Config,
+		app:       fiber.New(),
+		v:         srv.v,
+		db:        srv.db,
+		sessions:  srv.sessions,
+		mfa:       srv.mfa,
+		passkeys:  srv.passkeys,
+		verifier:  srv.verifier,
+		passwords: srv.passwords,
+		logins:    srv.logins,
+		resets:    srv.resets,
+		guard:     srv.guard,
+		revoker:   srv.oauth,
 	}
 
-	sort.Slice(opts, func(i, j int) bool {
-		_, isConfig := opts[i].(*Config)
-		_, isConfig2 := opts[j].(*Config)
-		return isConfig && !isConfig2
-	})
-
-	for _, opt := range opts {
-		if opt != nil {
-			opt.Apply(config)
-		}
-	}
-
-	srv := &apiServer{
-		Config: config,
-		app:    fiber.New(),
-		v:      viper.GetViper(),
-		db:     db,
-	}
-
-	srv.setupRoutes()
-	return srv
+	s.setupRoutes()
+	return s
 }
 
 func (s *apiServer) setupRoutes() {
-	bearerAuth := middleware.BearerAuth(s.KeyBytes)
+	bearerAuth := middleware.BearerAuth(s.KeyBytes, &personalTokens{db: s.db})
+
+	s.app.Use(s.auditImpersonation)
 
 	s.app.Post("/login", s.handleLogin)
+	s.app.Post("/login/mfa", s.handleLoginMFA)
+	s.app.Post("/login/mfa/enroll", s.mfa.handleEnroll)
+	s.app.Post("/login/mfa/recovery", s.handleLoginRecovery)
+	s.app.Post("/login/mfa/webauthn", s.passkeys.handleSecondFactorBegin)
+	s.app.Post("/login/mfa/webauthn/finish", s.handleLoginMFAPasskey)
+	s.app.Post("/login/passkey", s.passkeys.handleLoginBegin)
+	s.app.Post("/login/passkey/finish", s.handleLoginPasskey)
 	s.app.Post("/renew", s.handleRenew)
+	s.app.Post("/verify-email/resend", s.verifier.handleResend)
+	s.app.Post("/password/forgot", s.resets.handleRequest)
+	s.app.Post("/password/reset", s.resets.handleConfirm)
 	users := s.app.Group("users/")
 	users.Get(":userid/profile", bearerAuth, s.handleUsersProfile)
+	users.Patch(":userid/profile", bearerAuth, s.handleUsersProfileUpdate)
+	users.Post(":userid/password", bearerAuth, requireSignIn, s.handleUsersPasswordChange)
+	users.Post(":userid/email", bearerAuth, requireSignIn, s.handleUsersEmailChange)
 	users.Post("/", s.handleUsersStore)
+	s.app.Get("tenants/:slug", s.handleTenantBranding)
+
+	mfaRouter := s.app.Group("mfa/", bearerAuth, requireSignIn)
+	mfaRouter.Get("/", s.handleMFAStatus)
+	mfaRouter.Post("totp", s.handleTOTPEnroll)
+	mfaRouter.Post("totp/confirm", s.handleTOTPConfirm)
+	mfaRouter.Delete("totp", s.handleTOTPDelete)
+	mfaRouter.Post("recovery-codes", s.handleRecoveryCodes)
+
+	sessionRouter := s.app.Group("sessions/", bearerAuth, requireSignIn)
+	sessionRouter.Get("/", s.handleSessions)
+	sessionRouter.Delete("/", s.handleSessionsRevoke)
+	sessionRouter.Delete(":id", s.handleSessionRevoke)
+
+	passkeyRouter := s.app.Group("passkeys/", bearerAuth, requireSignIn)
+	passkeyRouter.Get("/", s.handlePasskeys)
+	passkeyRouter.Post("register", s.handlePasskeyRegister)
+	passkeyRouter.Post("register/finish", s.handlePasskeyRegisterFinish)
+	passkeyRouter.Patch(":id", s.handlePasskeyRename)
+	passkeyRouter.Delete(":id", s.handlePasskeyDelete)
+
+	s.app.Post("impersonation/end", bearerAuth, s.handleImpersonationEnd)
+
+	tokenRouter := s.app.Group("tokens/", bearerAuth)
+	tokenRouter.Get("/", s.handleTokens)
+	tokenRouter.Post("/", requireSignIn, s.handleTokenCreate)
+	tokenRouter.Delete(":token", requireSignIn, s.handleTokenDelete)
+
+	readUsers := middleware.RequirePermission(rbac.PermUsersRead)
+	writeUsers := middleware.RequirePermission(rbac.PermUsersWrite)
+	readRoles := middleware.RequirePermission(rbac.PermRolesRead)
+	writeRoles := middleware.RequirePermission(rbac.PermRolesWrite)
+	readTenants := middleware.RequirePermission(rbac.PermTenantsRead)
+	writeTenants := middleware.RequirePermission(rbac.PermTenantsWrite)
+
+	adminRouter := s.app.Group("admin/", bearerAuth)
+	adminUsers := adminRouter.Group("users/")
+	adminUsers.Get("/", readUsers, s.handleAdminUsers)
+	adminUsers.Post("/", writeUsers, s.handleAdminUserCreate)
+	adminUsers.Get(":id", readUsers, s.handleAdminUser)
+	adminUsers.Delete(":id", writeUsers, s.handleAdminUserDelete)
+	adminUsers.Post(":id/disable", writeUsers, s.handleAdminUserDisable)
+	adminUsers.Post(":id/enable", writeUsers, s.handleAdminUserEnable)
+	adminUsers.Post(":id/restore", writeUsers, s.handleAdminUserRestore)
+	adminUsers.Post(":id/logout", writeUsers, s.handleAdminUserLogout)
+	adminUsers.Delete(":id/purge", writeUsers, s.handleAdminUserPurge)
+	adminUsers.Get(":id/grants", readRoles, s.handleAdminUserGrants)
+	adminUsers.Put(":id/roles/:role", writeRoles, s.handleAdminUserRole(false))
+	adminUsers.Delete(":id/roles/:role", writeRoles, s.handleAdminUserRole(true))
+	adminUsers.Get(":id/tokens", readUsers, s.handleAdminUserTokens)
+	adminUsers.Delete(":id/tokens/:token", writeUsers, s.handleAdminUserTokenDelete)
+	adminUsers.Post(":id/impersonate", requireSignIn, middleware.RequirePermission(rbac.PermUsersImpersonate), s.handleImpersonate)
+
+	// roles and groups are shared by all tenants, only admins of the
+	// default tenant see and change them
+	adminRoles := adminRouter.Group("roles/", requireDefaultTenant)
+	adminRoles.Get("/", readRoles, s.handleRoles)
+	adminRoles.Put(":name", writeRoles, s.handleRoleSave)
+	adminRoles.Delete(":name", writeRoles, s.handleRoleDelete)
+
+	adminGroups := adminRouter.Group("groups/", requireDefaultTenant)
+	adminGroups.Get("/", readRoles, s.handleGroups)
+	adminGroups.Put(":name", writeRoles, s.handleGroupSave)
+	adminGroups.Delete(":name", writeRoles, s.handleGroupDelete)
+	adminGroups.Put(":name/members/:id", writeRoles, s.handleGroupMember(false))
+	adminGroups.Delete(":name/members/:id", writeRoles, s.handleGroupMember(true))
+
+	adminTenants := adminRouter.Group("tenants/", requireDefaultTenant)
+	adminTenants.Get("/", readTenants, s.handleTenants)
+	adminTenants.Get(":slug", readTenants, s.handleTenant)
+	adminTenants.Put(":slug", writeTenants, s.handleTenantSave)
+	adminTenants.Post(":slug/rotate-key", writeTenants, s.handleTenantRotateKey)
+	adminTenants.Delete(":slug", writeTenants, s.handleTenantDelete)
+
+	adminRouter.Get("audit/", middleware.RequirePermission(rbac.PermAuditRead), s.handleAuditEvents)
 }
 
 func (s *apiServer) handleLogin(c *fiber.Ctx) error {
@@ -96,42 +187,155 @@ func (s *apiServer) handleLogin(c *fiber.Ctx) error {
 		return err
 	}
 
-	var usr user.User
-	result := s.db.First(&usr, user.User{Email: cred.Email})
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return errs.WriteErrorJSON(c, errs.ErrCredentialNotFound)
+	key := guardKey(tenant.Default, cred.Email)
+	if err := s.guard.attempt(c, key); err != nil {
+		auditLoginFailure(c, s.db, 0, cred.Email, "locked_out")
+		return replyError(c, err)
 	}
 
-	match := util.VerifyEncoded([]byte(cred.Password), []byte(usr.Password))
-	if !match {
+	found, err := s.logins.authenticate(c.UserContext(), tenant.Default, cred.Email, cred.Password)
+	if reason := loginFailure(err); reason != "" {
+		s.guard.failed(c.UserContext(), key, c.IP())
+		auditLoginFailure(c, s.db, 0, cred.Email, reason)
 		return errs.WriteErrorJSON(c, errs.ErrCredentialNotFound)
 	}
+	s.guard.passed(c.UserContext(), key, c.IP())
+	if err != nil {
+		return replyError(c, err)
+	}
+	usr := *found
+
+	if err := allowLogin(s.verifier, usr); err != nil {
+		return replyError(c, err)
+	}
 
 	// if url contains query "check=1" then don't generate token
 	if c.Query("check") == "1" {
 		return c.SendStatus(200)
 	}
 
-	tokenPair, err := s.generateTokenPair(usr)
+	// the failures are only forgotten once the second factor is passed too
+	required, err := s.mfa.begin(c, usr)
+	if err != nil || required {
+		return err
+	}
+	s.guard.succeeded(c.UserContext(), key)
+
+	return s.issueTokenPair(c, usr, auth.ACRPassword, []string{auth.AMRPassword})
+}
+
+// handleLoginMFA completes a login that is waiting for the second factor.
+func (s *apiServer) handleLoginMFA(c *fiber.Ctx) error {
+	usr, err := s.mfa.complete(c)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	if err := allowLogin(s.verifier, *usr); err != nil {
+		return replyError(c, err)
+	}
+
+	return s.issueTokenPair(c, *usr, auth.ACRMultiFactor, mfaAMR)
+}
+
+// handleLoginRecovery completes a login waiting for the second factor
+// with a recovery code.
+func (s *apiServer) handleLoginRecovery(c *fiber.Ctx) error {
+	usr, err := s.mfa.completeRecovery(c)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	if err := allowLogin(s.verifier, *usr); err != nil {
+		return replyError(c, err)
+	}
+
+	return s.issueTokenPair(c, *usr, auth.ACRMultiFactor, mfaAMR)
+}
+
+// handleLoginMFAPasskey completes a login waiting for the second factor
+// with a passkey.
+func (s *apiServer) handleLoginMFAPasskey(c *fiber.Ctx) error {
+	usr, err := s.passkeys.completeSecondFactor(c)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	if err := allowLogin(s.verifier, *usr); err != nil {
+		return replyError(c, err)
+	}
+
+	return s.issueTokenPair(c, *usr, auth.ACRMultiFactor, passkeyMFAAMR)
+}
+
+// handleLoginPasskey signs in with a passkey alone.
+func (s *apiServer) handleLoginPasskey(c *fiber.Ctx) error {
+	usr, err := s.passkeys.completeLogin(c)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	if err := allowLogin(s.verifier, *usr); err != nil {
+		return replyError(c, err)
+	}
+
+	return s.issueTokenPair(c, *usr, auth.ACRMultiFactor, passkeyAMR)
+}
+
+// issueTokenPair starts a session for usr and replies its token pair.
+// The API signs in users of the default tenant only, like its login does.
+func (s *apiServer) issueTokenPair(c *fiber.Ctx, usr user.User, acr string, amr []string) error {
+	if usr.TenantID != tenant.Default.ID {
+		return errs.WriteErrorJSON(c, errs.ErrCredentialNotFound)
+	}
+
+	sess := &session.Session{
+		UserID:    usr.ID,
+		AuthTime:  time.Now(),
+		ACR:       acr,
+		AMR:       amr,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+	}
+	if err := s.sessions.Create(c.UserContext(), sess); err != nil {
+		jww.ERROR.Println("unable to create session on login:", err)
+		return fiber.ErrInternalServerError
+	}
+
+	tokenPair, err := s.generateTokenPair(usr, sess.ID, sess.RefreshTokenID)
 	if err != nil {
 		return fiber.ErrInternalServerError
 	}
 
+	auditLogin(c, s.db, &usr, sess)
+
 	return c.JSON(tokenPair)
 }
 
 // This endpoint is not truly rest. It takes cookie containing
 // refresh token set by server.
 func (s *apiServer) handleRenew(c *fiber.Ctx) error {
-	rtRaw := c.Cookies("rt")
+	// an admin impersonating a user gets the tokens of the impersonation
+	// until it ends, and its own again after
+	if irt := c.Cookies(impersonationCookie); irt != "" {
+		if err := s.renew(c, irt, impersonationCookie); err == nil {
+			return nil
+		}
+		s.endExpiredImpersonation(c, irt)
+	}
+
+	return s.renew(c, c.Cookies("rt"), "rt")
+}
+
+// renew replies the tokens of the session of rtRaw, a refresh token kept
+// in cookie, and replaces it with the next one.
+func (s *apiServer) renew(c *fiber.Ctx, rtRaw string, cookie string) error {
 	if rtRaw == "" {
 		return fiber.ErrUnauthorized
 	}
 
-	var rt refreshToken
-
-	token, err := jwt.ParseWithClaims(rtRaw, &rt, auth.DefaultJwtKeyFunc(s.KeyBytes))
-	if err != nil || !token.Valid {
+	rt, err := auth.ValidateRefreshToken(rtRaw, auth.DefaultJwtKeyFunc(s.KeyBytes))
+	if err != nil || rt.SessionID == "" {
 		return fiber.ErrUnauthorized
 	}
 
@@ -140,20 +344,49 @@ func (s *apiServer) handleRenew(c *fiber.Ctx) error {
 		return fiber.ErrInternalServerError
 	}
 
+	ctx := c.UserContext()
+	next, err := session.NewRefreshTokenID()
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	// the session is gone once the user has logged out
+	refreshTokenID, err := s.sessions.Rotate(ctx, rt.SessionID, rt.ID, next, refreshTokenGrace)
+	if err != nil {
+		if errors.Is(err, session.ErrRefreshTokenReused) {
+			s.revokeReusedSession(c, uint(subjectID), rt.SessionID)
+		}
+		return fiber.ErrUnauthorized
+	}
+
+	if err := s.sessions.Touch(ctx, rt.SessionID, c.IP(), c.Get(fiber.HeaderUserAgent)); err != nil {
+		return fiber.ErrUnauthorized
+	}
+
 	var usr user.User
 	result := s.db.First(&usr, user.User{ID: uint(subjectID)})
-	if result.Error != nil {
+	if result.Error != nil || usr.Disabled() {
 		return fiber.ErrUnauthorized
 	}
 
-	tokenPair, err := s.generateTokenPair(usr)
+	var tokenPair map[string]string
+	if rt.Actor != nil {
+		tokenPair, err = s.generateImpersonationTokenPair(usr, rt.Actor, rt.SessionID, refreshTokenID, rt.ExpiresAt.Time)
+	} else {
+		tokenPair, err = s.generateTokenPair(usr, rt.SessionID, refreshTokenID)
+	}
 	if err != nil {
 		return fiber.ErrUnauthorized
 	}
 
+	e := userEvent("token_renewed", &usr)
+	e.Metadata = map[string]interface{}{"session": rt.SessionID}
+	actingAs(&e, rt.Actor, usr.ID)
+	auditEvent(c, s.db, e)
+
 	c.SendStatus(fiber.StatusCreated)
 	c.Cookie(&fiber.Cookie{
-		Name:     "rt",
+		Name:     cookie,
 		Value:    tokenPair["refresh_token"],
 		Domain:   s.v.GetString("cookie_domain"),
 		Expires:  time.Now().Add(auth.RefreshTokenExpiration),
@@ -163,10 +396,12 @@ func (s *apiServer) handleRenew(c *fiber.Ctx) error {
 	return c.JSON(tokenPair)
 }
 
+// handleUsersProfile gets the profile of the current user, or of anyone
+// in the tenants it manages when the token grants rbac.PermUsersRead.
 func (s *apiServer) handleUsersProfile(c *fiber.Ctx) error {
 	paramUserID := c.Params("userid")
 	userid, err := strconv.Atoi(paramUserID)
-	if err != nil {
+	if err != nil || userid <= 0 {
 		return fiber.ErrBadRequest
 	}
 
@@ -176,13 +411,13 @@ func (s *apiServer) handleUsersProfile(c *fiber.Ctx) error {
 		return fiber.ErrInternalServerError
 	}
 
-	if at.User.ID != uint(userid) {
+	if at.User.ID != uint(userid) && !at.Can(rbac.PermUsersRead) {
 		return fiber.ErrForbidden
 	}
 
 	var usr user.User
-	result := s.db.First(&usr, user.User{ID: at.User.ID})
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	result := s.db.First(&usr, user.User{ID: uint(userid)})
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) || !managesTenant(at, usr.TenantID) {
 		return errs.WriteErrorJSON(c, errs.ErrCredentialNotFound)
 	}
 
@@ -202,17 +437,11 @@ func (s *apiServer) handleUsersStore(c *fiber.Ctx) error {
 		return fiber.ErrInternalServerError
 	}
 
-	hashedPwd, err := util.HashString(util.StringToBytes(body.Password))
-	if err != nil {
-		jww.ERROR.Println("unable to hash password:", err)
-		return fiber.ErrInternalServerError
-	}
-
 	usr := &user.User{
 		Email:     body.Email,
 		FirstName: body.FirstName,
 		LastName:  body.LastName,
-		Password:  hashedPwd,
+		Password:  body.Password,
 	}
 
 	err = user.Validate(usr)
@@ -221,6 +450,16 @@ func (s *apiServer) handleUsersStore(c *fiber.Ctx) error {
 		return replyValidationErrors(c, validationErrors)
 	}
 
+	if err := s.passwords.check(*usr, body.Password); err != nil {
+		return replyPasswordViolation(c, err)
+	}
+
+	usr.Password, err = util.HashString(util.StringToBytes(body.Password))
+	if err != nil {
+		jww.ERROR.Println("unable to hash password:", err)
+		return fiber.ErrInternalServerError
+	}
+
 	err = user.NewStore(s.db).Create(usr)
 	if err != nil {
 		jww.ERROR.Println("unable to store user on register:", err)
@@ -228,6 +467,9 @@ func (s *apiServer) handleUsersStore(c *fiber.Ctx) error {
 		return fiber.ErrInternalServerError
 	}
 
+	auditEvent(c, s.db, userEvent("user_registered", usr))
+	s.verifier.sendOnRegister(c.UserContext(), *usr)
+
 	c.SendStatus(fiber.StatusCreated)
 	return c.JSON(usr)
 }
@@ -248,8 +490,15 @@ func (s *apiServer) bearerAuth(c *fiber.Ctx) error {
 	return c.Next()
 }
 
-func (s *apiServer) generateTokenPair(usr user.User) (map[string]string, error) {
-	at, rt, err := auth.GenerateTokenPair(usr, s.KeyBytes)
+// generateTokenPair mints the tokens of a session, the access token
+// carries the grants usr has now.
+func (s *apiServer) generateTokenPair(usr user.User, sessionID string, refreshTokenID string) (map[string]string, error) {
+	grants, err := rbac.NewStore(s.db).Grants(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	at, rt, err := auth.GenerateTokenPair(usr, *grants, sessionID, refreshTokenID, s.KeyBytes)
 	if err != nil {
 		return nil, err
 	}
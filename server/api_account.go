@@ -0,0 +1,199 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/user"
+	"github.com/9d4/semaphore/util"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ownUser gets the user in the userid param, which has to be the user
+// the request is authenticated as.
+func (s *apiServer) ownUser(c *fiber.Ctx) (*user.User, *auth.AccessToken, error) {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userid, err := strconv.Atoi(c.Params("userid"))
+	if err != nil {
+		return nil, nil, fiber.ErrBadRequest
+	}
+	if at.User.ID != uint(userid) {
+		return nil, nil, fiber.ErrForbidden
+	}
+
+	usr, err := user.NewStore(s.db).UserByID(at.User.ID)
+	if err != nil {
+		return nil, nil, fiber.ErrUnauthorized
+	}
+
+	return usr, at, nil
+}
+
+// checkCurrentPassword returns ErrPasswordIncorrect unless pwd is the
+// password of usr. It is guarded like a login, a stolen access token
+// doesn't allow guessing the password.
+func (s *apiServer) checkCurrentPassword(c *fiber.Ctx, usr user.User, pwd string) error {
+	key, err := userGuardKey(s.db, usr)
+	if err != nil {
+		return err
+	}
+	if err := s.guard.attempt(c, key); err != nil {
+		return err
+	}
+
+	if !util.VerifyEncoded([]byte(pwd), []byte(usr.Password)) {
+		s.guard.failed(c.UserContext(), key, c.IP())
+		return errs.ErrPasswordIncorrect
+	}
+	s.guard.passed(c.UserContext(), key, c.IP())
+	s.guard.succeeded(c.UserContext(), key)
+
+	return nil
+}
+
+// replyValidationError replies err the way validation errors are replied
+// when it is one.
+func replyValidationError(c *fiber.Ctx, err error) error {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		return replyValidationErrors(c, validationErrors)
+	}
+	return replyError(c, err)
+}
+
+// handleUsersProfileUpdate changes the names of the current user, the
+// ones left out of the body stay the same.
+func (s *apiServer) handleUsersProfileUpdate(c *fiber.Ctx) error {
+	usr, _, err := s.ownUser(c)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		FirstName *string `json:"firstname"`
+		LastName  *string `json:"lastname"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	if body.FirstName != nil {
+		usr.FirstName = *body.FirstName
+	}
+	if body.LastName != nil {
+		usr.LastName = *body.LastName
+	}
+
+	if err := user.GetValidate().StructPartial(usr, "FirstName", "LastName"); err != nil {
+		return replyValidationError(c, err)
+	}
+
+	if err := user.NewStore(s.db).UpdateProfile(usr.ID, usr.FirstName, usr.LastName); err != nil {
+		return replyError(c, err)
+	}
+	auditEvent(c, s.db, userEvent("profile_updated", usr))
+
+	return c.JSON(usr)
+}
+
+// handleUsersPasswordChange sets a new password for the current user,
+// who has to know the current one. Every other session is signed out.
+func (s *apiServer) handleUsersPasswordChange(c *fiber.Ctx) error {
+	usr, at, err := s.ownUser(c)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	if err := s.checkCurrentPassword(c, *usr, body.CurrentPassword); err != nil {
+		return replyError(c, err)
+	}
+
+	if err := user.GetValidate().StructPartial(&user.User{Password: body.Password}, "Password"); err != nil {
+		return replyValidationError(c, err)
+	}
+	if err := s.passwords.set(*usr, body.Password); err != nil {
+		return replyPasswordViolation(c, err)
+	}
+
+	ctx := c.UserContext()
+	sessions, err := s.sessions.Sessions(ctx, usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	revoked := 0
+	for _, sess := range sessions {
+		if sess.ID == at.SessionID {
+			continue
+		}
+
+		s.revoker.revokeSession(ctx, sess)
+		revoked++
+	}
+	e := userEvent("password_changed", usr)
+	e.Metadata = map[string]interface{}{"sessions_revoked": revoked}
+	auditEvent(c, s.db, e)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleUsersEmailChange mails a link to the new address of the current
+// user, who has to know its password. The address only changes once the
+// link is followed.
+func (s *apiServer) handleUsersEmailChange(c *fiber.Ctx) error {
+	usr, _, err := s.ownUser(c)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	if err := s.checkCurrentPassword(c, *usr, body.Password); err != nil {
+		return replyError(c, err)
+	}
+
+	if err := user.GetValidate().StructPartial(&user.User{Email: body.Email}, "Email"); err != nil {
+		return replyValidationError(c, err)
+	}
+
+	if strings.EqualFold(body.Email, usr.Email) {
+		return errs.WriteErrorJSON(c, errs.ErrEmailTaken)
+	}
+	if _, err := user.NewStore(s.db).ForTenant(usr.TenantID).UserByEmail(body.Email); !errors.Is(err, user.ErrUserNotFound) {
+		if err == nil {
+			return errs.WriteErrorJSON(c, errs.ErrEmailTaken)
+		}
+		return replyError(c, err)
+	}
+
+	if err := s.verifier.sendChange(c.UserContext(), *usr, body.Email); err != nil {
+		return replyError(c, err)
+	}
+	e := userEvent("email_change_requested", usr)
+	e.Metadata = map[string]interface{}{"email": body.Email}
+	auditEvent(c, s.db, e)
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/server/middleware"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/user"
+	"github.com/9d4/semaphore/util"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_apiServer_account(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &password.History{}, &session.Session{}, &session.Client{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	key := []byte("key")
+	config := &Config{KeyBytes: key, Issuer: "http://semaphore.test", VerifyEmail: verifyEmailOff, PasswordMinLength: 8}
+	passwords, err := newPasswordPolicy(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &recordingMailer{}
+	verifier, err := newEmailVerifier(db, nil, m, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestApiServer(db, config)
+	s.verifier = verifier
+	s.passwords = passwords
+	sessions := s.sessions
+
+	app := fiber.New()
+	users := app.Group("/users/", middleware.BearerAuth(key, nil))
+	users.Patch(":userid/profile", s.handleUsersProfileUpdate)
+	users.Post(":userid/password", s.handleUsersPasswordChange)
+	users.Post(":userid/email", s.handleUsersEmailChange)
+	app.Get("/auth/change-email", verifier.handleChange)
+
+	hashedPwd, err := util.HashString([]byte("old password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr := &user.User{Email: "jane@example.com", FirstName: "Jane", LastName: "Doe", Password: hashedPwd}
+	other := &user.User{Email: "john@example.com", FirstName: "John", LastName: "Doe"}
+	for _, u := range []*user.User{usr, other} {
+		if err := passwords.users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current := &session.Session{UserID: usr.ID}
+	another := &session.Session{UserID: usr.ID}
+	for _, sess := range []*session.Session{current, another} {
+		if err := sessions.Create(ctx, sess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at, _, err := auth.GenerateTokenPair(*usr, rbac.Grants{}, current.ID, current.RefreshTokenID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method string, path string, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+at)
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := map[string]interface{}{}
+		_ = json.NewDecoder(res.Body).Decode(&data)
+		return res.StatusCode, data
+	}
+	own := "/users/" + strconv.Itoa(int(usr.ID))
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		want     int
+		wantTag  string
+		wantCode string
+	}{
+		{name: "other user's profile", method: "PATCH", path: "/users/" + strconv.Itoa(int(other.ID)) + "/profile", body: `{"firstname":"Evil"}`, want: fiber.StatusForbidden},
+		{name: "invalid profile", method: "PATCH", path: own + "/profile", body: `{"firstname":"J"}`, want: fiber.StatusBadRequest, wantTag: "min"},
+		{name: "profile", method: "PATCH", path: own + "/profile", body: `{"lastname":"Roe"}`, want: fiber.StatusOK},
+		{name: "wrong current password", method: "POST", path: own + "/password", body: `{"current_password":"wrong","password":"new password"}`, want: fiber.StatusForbidden, wantCode: "password_incorrect"},
+		{name: "weak password", method: "POST", path: own + "/password", body: `{"current_password":"old password","password":"short"}`, want: fiber.StatusBadRequest, wantTag: "min"},
+		{name: "password", method: "POST", path: own + "/password", body: `{"current_password":"old password","password":"new password"}`, want: fiber.StatusNoContent},
+		{name: "email without password", method: "POST", path: own + "/email", body: `{"email":"jane@example.org"}`, want: fiber.StatusForbidden, wantCode: "password_incorrect"},
+		{name: "invalid email", method: "POST", path: own + "/email", body: `{"email":"jane","password":"new password"}`, want: fiber.StatusBadRequest, wantTag: "email"},
+		{name: "taken email", method: "POST", path: own + "/email", body: `{"email":"john@example.com","password":"new password"}`, want: fiber.StatusConflict, wantCode: "email_taken"},
+		{name: "email", method: "POST", path: own + "/email", body: `{"email":"jane@example.org","password":"new password"}`, want: fiber.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, data := do(tt.method, tt.path, tt.body)
+			if status != tt.want {
+				t.Fatalf("status = %d %v, want %d", status, data, tt.want)
+			}
+			if tt.wantCode != "" && data["error"] != tt.wantCode {
+				t.Errorf("error = %v, want %v", data["error"], tt.wantCode)
+			}
+			if tt.wantTag != "" {
+				errors, _ := data["errors"].([]interface{})
+				if len(errors) != 1 || errors[0].(map[string]interface{})["tag"] != tt.wantTag {
+					t.Errorf("errors = %v, want tag %s", data["errors"], tt.wantTag)
+				}
+			}
+		})
+	}
+
+	got, err := passwords.users.UserByID(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FirstName != "Jane" || got.LastName != "Roe" {
+		t.Errorf("names = %s %s, want Jane Roe", got.FirstName, got.LastName)
+	}
+	if !util.VerifyEncoded([]byte("new password"), []byte(got.Password)) {
+		t.Error("password has not been changed")
+	}
+	if got.Email != usr.Email {
+		t.Errorf("email = %s before following the link, want %s", got.Email, usr.Email)
+	}
+
+	// the password change signs out the other sessions only
+	if _, err := sessions.Session(ctx, current.ID); err != nil {
+		t.Errorf("current session error = %v, want it kept", err)
+	}
+	if _, err := sessions.Session(ctx, another.ID); err != session.ErrSessionNotFound {
+		t.Errorf("other session error = %v, want %v", err, session.ErrSessionNotFound)
+	}
+
+	// following the link mailed to the new address switches to it
+	if len(m.sent) != 1 || m.sent[0].To != "jane@example.org" {
+		t.Fatalf("sent mails = %v, want one to the new address", m.sent)
+	}
+	link := m.sent[0].Text[strings.Index(m.sent[0].Text, "http"):]
+	link = strings.Fields(link)[0]
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"/login?email_changed=1", "/login?email_changed=0"} {
+		res, err := app.Test(httptest.NewRequest("GET", u.RequestURI(), nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Header.Get("Location"); got != want {
+			t.Errorf("following the link %d times redirects to %s, want %s", i+1, got, want)
+		}
+	}
+
+	got, _ = passwords.users.UserByID(usr.ID)
+	if got.Email != "jane@example.org" || !got.EmailVerified() {
+		t.Errorf("email = %s verified %v, want jane@example.org verified", got.Email, got.EmailVerified())
+	}
+	if len(m.sent) != 2 || m.sent[1].To != usr.Email {
+		t.Errorf("sent mails = %v, want the old address told", m.sent)
+	}
+}
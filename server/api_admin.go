@@ -0,0 +1,349 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/federation"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/9d4/semaphore/util"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// allowLogin returns why usr can't sign in, if it can't: it is disabled,
+// or it hasn't verified its address when that is required.
+func allowLogin(v *emailVerifier, usr user.User) error {
+	if usr.Disabled() {
+		return errs.ErrUserDisabled
+	}
+	return v.allowLogin(usr)
+}
+
+// adminUser is a user the way admins see it.
+type adminUser struct {
+	ID              uint       `json:"id"`
+	UUID            string     `json:"uuid"`
+	TenantID        uint       `json:"tenant_id"`
+	Email           string     `json:"email"`
+	FirstName       string     `json:"firstname"`
+	LastName        string     `json:"lastname"`
+	MFAEnforced     bool       `json:"mfa_enforced"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	DisabledAt      *time.Time `json:"disabled_at"`
+	DeletedAt       *time.Time `json:"deleted_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func newAdminUser(usr *user.User) adminUser {
+	u := adminUser{
+		ID:              usr.ID,
+		UUID:            usr.UUID,
+		TenantID:        usr.TenantID,
+		Email:           usr.Email,
+		FirstName:       usr.FirstName,
+		LastName:        usr.LastName,
+		MFAEnforced:     usr.MFAEnforced,
+		EmailVerifiedAt: usr.EmailVerifiedAt,
+		DisabledAt:      usr.DisabledAt,
+		CreatedAt:       usr.CreatedAt,
+		UpdatedAt:       usr.UpdatedAt,
+	}
+	if usr.DeletedAt.Valid {
+		u.DeletedAt = &usr.DeletedAt.Time
+	}
+	return u
+}
+
+// handleAdminUsers lists a page of users. The query filters them by
+// email, name, created_after, created_before (RFC 3339), status and role,
+// directly or through a group, orders them by sort, descending when it
+// starts with "-", and continues from cursor.
+func (s *apiServer) handleAdminUsers(c *fiber.Ctx) error {
+	q := user.ListQuery{
+		Email:  c.Query("email"),
+		Name:   c.Query("name"),
+		Status: c.Query("status"),
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+	if len(q.Sort) > 0 && q.Sort[0] == '-' {
+		q.Sort, q.Desc = q.Sort[1:], true
+	}
+
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return fiber.ErrBadRequest
+		}
+	}
+	if q.CreatedAfter, err = queryTime(c, "created_after"); err != nil {
+		return err
+	}
+	if q.CreatedBefore, err = queryTime(c, "created_before"); err != nil {
+		return err
+	}
+
+	if role := c.Query("role"); role != "" {
+		q.IDs, err = rbac.NewStore(s.db).UsersWithRole(role)
+		if errors.Is(err, rbac.ErrRoleNotFound) {
+			q.IDs = []uint{}
+		} else if err != nil {
+			return replyError(c, err)
+		}
+	}
+
+	tenantID, err := s.adminTenant(c)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	users, next, err := user.NewStore(s.db).ForTenant(tenantID).Users(q)
+	switch {
+	case errors.Is(err, user.ErrListSortInvalid),
+		errors.Is(err, user.ErrListStatusInvalid),
+		errors.Is(err, user.ErrListCursorInvalid):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case err != nil:
+		return replyError(c, err)
+	}
+
+	list := make([]adminUser, len(users))
+	for i, usr := range users {
+		list[i] = newAdminUser(usr)
+	}
+
+	return c.JSON(fiber.Map{
+		"users":       list,
+		"next_cursor": next,
+	})
+}
+
+// queryTime parses the RFC 3339 time in the key query, zero when it is
+// left out.
+func queryTime(c *fiber.Ctx, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fiber.NewError(fiber.StatusBadRequest, key+" is not an RFC 3339 time")
+	}
+	return t, nil
+}
+
+// adminTenant gets the ID of the tenant the admin works on the users of:
+// its own, or the one in the tenant query for admins of the default
+// tenant.
+func (s *apiServer) adminTenant(c *fiber.Ctx) (uint, error) {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return 0, err
+	}
+
+	slug := c.Query("tenant")
+	if at.User.TenantID != 0 || slug == "" {
+		return at.User.TenantID, nil
+	}
+
+	t, err := tenant.NewStore(s.db).Tenant(slug)
+	if errors.Is(err, tenant.ErrTenantNotFound) {
+		return 0, errs.ErrTenantNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return t.ID, nil
+}
+
+// adminTarget gets the user in the id param, soft-deleted ones too, which
+// can't be the admin itself when self is false. The users of tenants the
+// admin doesn't manage are not found.
+func (s *apiServer) adminTarget(c *fiber.Ctx, self bool) (*user.User, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil || id <= 0 {
+		return nil, fiber.ErrBadRequest
+	}
+
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return nil, err
+	}
+	if !self && at.User.ID == uint(id) {
+		return nil, errs.ErrAdminSelf
+	}
+
+	var usr user.User
+	err = s.db.Unscoped().Where("id = ?", id).First(&usr).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !managesTenant(at, usr.TenantID)) {
+		return nil, errs.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &usr, nil
+}
+
+// handleAdminUser gets a user, soft-deleted ones too.
+func (s *apiServer) handleAdminUser(c *fiber.Ctx) error {
+	usr, err := s.adminTarget(c, true)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(newAdminUser(usr))
+}
+
+// handleAdminUserCreate creates a user in the tenant the admin works on,
+// see adminTenant.
+func (s *apiServer) handleAdminUserCreate(c *fiber.Ctx) error {
+	body := struct {
+		Email     string `json:"email"`
+		FirstName string `json:"firstname"`
+		LastName  string `json:"lastname"`
+		Password  string `json:"password"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	tenantID, err := s.adminTenant(c)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	usr := &user.User{
+		Email:     body.Email,
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+		Password:  body.Password,
+	}
+	if err := user.Validate(usr); err != nil {
+		return replyValidationError(c, err)
+	}
+	if err := s.passwords.check(*usr, body.Password); err != nil {
+		return replyPasswordViolation(c, err)
+	}
+
+	users := user.NewStore(s.db).ForTenant(tenantID)
+	if _, err := users.UserByEmail(body.Email); !errors.Is(err, user.ErrUserNotFound) {
+		if err == nil {
+			return errs.WriteErrorJSON(c, errs.ErrEmailTaken)
+		}
+		return replyError(c, err)
+	}
+
+	usr.Password, err = util.HashString(util.StringToBytes(body.Password))
+	if err != nil {
+		return replyError(c, err)
+	}
+	if err := users.Create(usr); err != nil {
+		return replyError(c, err)
+	}
+
+	e := targetEvent("user_created", usr)
+	e.Metadata = map[string]interface{}{"email": usr.Email}
+	auditEvent(c, s.db, e)
+	if s.VerifyEmail != verifyEmailOff {
+		s.verifier.sendOnRegister(c.UserContext(), *usr)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(newAdminUser(usr))
+}
+
+// handleAdminUserDisable keeps a user from signing in and ends its
+// sessions.
+func (s *apiServer) handleAdminUserDisable(c *fiber.Ctx) error {
+	return s.adminAction(c, "user_disabled", func(id uint) error {
+		if err := user.NewStore(s.db).SetDisabled(id, true); err != nil {
+			return err
+		}
+		return s.revoker.revokeUser(c.UserContext(), id)
+	})
+}
+
+// handleAdminUserEnable lets a disabled user sign in again.
+func (s *apiServer) handleAdminUserEnable(c *fiber.Ctx) error {
+	return s.adminAction(c, "user_enabled", func(id uint) error {
+		return user.NewStore(s.db).SetDisabled(id, false)
+	})
+}
+
+// handleAdminUserDelete soft-deletes a user and ends its sessions, it can
+// be restored.
+func (s *apiServer) handleAdminUserDelete(c *fiber.Ctx) error {
+	return s.adminAction(c, "user_deleted", func(id uint) error {
+		if err := user.NewStore(s.db).Delete(id); err != nil {
+			return err
+		}
+		return s.revoker.revokeUser(c.UserContext(), id)
+	})
+}
+
+// handleAdminUserRestore brings back a soft-deleted user.
+func (s *apiServer) handleAdminUserRestore(c *fiber.Ctx) error {
+	return s.adminAction(c, "user_restored", func(id uint) error {
+		return user.NewStore(s.db).Restore(id)
+	})
+}
+
+// handleAdminUserPurge ends the sessions of a user and deletes it for good
+// with its roles, groups, linked identities and access tokens. Sessions are ended first, while the tenant of
+// the user can still be told.
+func (s *apiServer) handleAdminUserPurge(c *fiber.Ctx) error {
+	return s.adminAction(c, "user_purged", func(id uint) error {
+		if err := s.revoker.revokeUser(c.UserContext(), id); err != nil {
+			return err
+		}
+		if err := user.NewStore(s.db).Purge(id); err != nil {
+			return err
+		}
+		if err := rbac.NewStore(s.db).DeleteUser(id); err != nil {
+			return err
+		}
+		if err := pat.NewStore(s.db).DeleteAll(id); err != nil {
+			return err
+		}
+		return federation.NewStore(s.db).DeleteUser(id)
+	})
+}
+
+// handleAdminUserLogout ends every session of a user.
+func (s *apiServer) handleAdminUserLogout(c *fiber.Ctx) error {
+	return s.adminAction(c, "user_logged_out", func(id uint) error {
+		if _, err := user.NewStore(s.db).UserByID(id); err != nil {
+			return err
+		}
+		return s.revoker.revokeUser(c.UserContext(), id)
+	})
+}
+
+// adminAction does action to the user in the id param, which isn't the
+// admin itself, and records it as event.
+func (s *apiServer) adminAction(c *fiber.Ctx, event string, action func(id uint) error) error {
+	usr, err := s.adminTarget(c, false)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	id := usr.ID
+	if err := action(id); err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			return errs.WriteErrorJSON(c, errs.ErrUserNotFound)
+		}
+		return replyError(c, err)
+	}
+
+	auditEvent(c, s.db, targetEvent(event, usr))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
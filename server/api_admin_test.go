@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/federation"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/server/middleware"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_apiServer_adminUsers(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &audit.Event{}, &federation.Identity{}, &pat.Token{}); err != nil {
+		t.Fatal(err)
+	}
+	roles := rbac.NewStore(db)
+	if err := roles.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := user.NewStore(db)
+	admin := &user.User{Email: "admin@example.com", FirstName: "Admin"}
+	alice := &user.User{Email: "alice@example.com", FirstName: "Alice"}
+	bob := &user.User{Email: "bob@example.com", FirstName: "Bob"}
+	for _, usr := range []*user.User{admin, alice, bob} {
+		if err := users.Create(usr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := roles.AssignRole(admin.ID, rbac.AdminRole); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	revoker := &recordingRevoker{}
+	s := newTestApiServer(db, &Config{KeyBytes: key})
+	s.revoker = revoker
+
+	read := middleware.RequirePermission(rbac.PermUsersRead)
+	write := middleware.RequirePermission(rbac.PermUsersWrite)
+
+	app := fiber.New()
+	router := app.Group("/admin/users/", middleware.BearerAuth(key, nil))
+	router.Get("/", read, s.handleAdminUsers)
+	router.Get(":id", read, s.handleAdminUser)
+	router.Delete(":id", write, s.handleAdminUserDelete)
+	router.Post(":id/disable", write, s.handleAdminUserDisable)
+	router.Post(":id/enable", write, s.handleAdminUserEnable)
+	router.Post(":id/restore", write, s.handleAdminUserRestore)
+	router.Post(":id/logout", write, s.handleAdminUserLogout)
+	router.Delete(":id/purge", write, s.handleAdminUserPurge)
+
+	token := func(usr *user.User) string {
+		return grantedToken(t, db, key, usr)
+	}
+	do := func(at string, method string, path string, v interface{}) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+at)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != nil {
+			_ = json.NewDecoder(res.Body).Decode(v)
+		}
+		return res.StatusCode
+	}
+	list := func(query string) []string {
+		body := struct {
+			Users []adminUser `json:"users"`
+		}{}
+		if status := do(token(admin), "GET", "/admin/users/?"+query, &body); status != fiber.StatusOK {
+			t.Fatalf("list %q = %d, want %d", query, status, fiber.StatusOK)
+		}
+
+		var emails []string
+		for _, u := range body.Users {
+			emails = append(emails, u.Email)
+		}
+		return emails
+	}
+
+	if status := do(token(alice), "GET", "/admin/users/", nil); status != fiber.StatusForbidden {
+		t.Fatalf("list as a user = %d, want %d", status, fiber.StatusForbidden)
+	}
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantList   string
+		wantActive []string
+	}{
+		{name: "disable alice", method: "POST", path: "/%d/disable", wantStatus: fiber.StatusNoContent, wantList: "status=disabled", wantActive: []string{"admin@example.com", "bob@example.com"}},
+		{name: "enable alice", method: "POST", path: "/%d/enable", wantStatus: fiber.StatusNoContent, wantList: "status=disabled", wantActive: []string{"admin@example.com", "alice@example.com", "bob@example.com"}},
+		{name: "delete alice", method: "DELETE", path: "/%d", wantStatus: fiber.StatusNoContent, wantList: "status=deleted", wantActive: []string{"admin@example.com", "bob@example.com"}},
+		{name: "restore alice", method: "POST", path: "/%d/restore", wantStatus: fiber.StatusNoContent, wantList: "status=deleted", wantActive: []string{"admin@example.com", "alice@example.com", "bob@example.com"}},
+		{name: "restore alice again", method: "POST", path: "/%d/restore", wantStatus: fiber.StatusNotFound, wantList: "status=deleted", wantActive: []string{"admin@example.com", "alice@example.com", "bob@example.com"}},
+		{name: "log alice out", method: "POST", path: "/%d/logout", wantStatus: fiber.StatusNoContent, wantList: "status=deleted", wantActive: []string{"admin@example.com", "alice@example.com", "bob@example.com"}},
+		{name: "purge alice", method: "DELETE", path: "/%d/purge", wantStatus: fiber.StatusNoContent, wantList: "status=deleted", wantActive: []string{"admin@example.com", "bob@example.com"}},
+		{name: "purge alice again", method: "DELETE", path: "/%d/purge", wantStatus: fiber.StatusNotFound, wantList: "status=deleted", wantActive: []string{"admin@example.com", "bob@example.com"}},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/admin/users" + fmt.Sprintf(tt.path, alice.ID)
+			if status := do(token(admin), tt.method, path, nil); status != tt.wantStatus {
+				t.Fatalf("%s %s = %d, want %d", tt.method, path, status, tt.wantStatus)
+			}
+
+			if got := list("status=active"); fmt.Sprint(got) != fmt.Sprint(tt.wantActive) {
+				t.Errorf("active users = %v, want %v", got, tt.wantActive)
+			}
+		})
+	}
+
+	if want := []uint{alice.ID, alice.ID, alice.ID, alice.ID}; fmt.Sprint(revoker.userIDs) != fmt.Sprint(want) {
+		t.Errorf("revoked users = %v, want %v", revoker.userIDs, want)
+	}
+
+	if got := list("email=BOB"); fmt.Sprint(got) != "[bob@example.com]" {
+		t.Errorf("users by email = %v, want [bob@example.com]", got)
+	}
+	if got := list("role=admin"); fmt.Sprint(got) != "[admin@example.com]" {
+		t.Errorf("users by role = %v, want [admin@example.com]", got)
+	}
+	if got := list("role=missing"); len(got) != 0 {
+		t.Errorf("users by missing role = %v, want none", got)
+	}
+	if status := do(token(admin), "GET", "/admin/users/?sort=password", nil); status != fiber.StatusBadRequest {
+		t.Errorf("list by password = %d, want %d", status, fiber.StatusBadRequest)
+	}
+	if status := do(token(admin), "POST", fmt.Sprintf("/admin/users/%d/disable", admin.ID), nil); status != fiber.StatusBadRequest {
+		t.Errorf("disable self = %d, want %d", status, fiber.StatusBadRequest)
+	}
+}
+
+// grantedToken mints an access token for usr carrying its grants.
+func grantedToken(t *testing.T, db *gorm.DB, key []byte, usr *user.User) string {
+	t.Helper()
+
+	grants, err := rbac.NewStore(db).Grants(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at, err := auth.GenerateAccessToken(*usr, *grants, key, auth.AccessTokenExpiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func Test_allowLogin(t *testing.T) {
+	verifier := &emailVerifier{mode: verifyEmailLogin}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		usr     user.User
+		wantErr error
+	}{
+		{name: "verified", usr: user.User{EmailVerifiedAt: &now}, wantErr: nil},
+		{name: "unverified", usr: user.User{}, wantErr: errs.ErrEmailNotVerified},
+		{name: "disabled", usr: user.User{EmailVerifiedAt: &now, DisabledAt: &now}, wantErr: errs.ErrUserDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := allowLogin(verifier, tt.usr); err != tt.wantErr {
+				t.Errorf("allowLogin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/9d4/semaphore/audit"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleAuditEvents lists a page of audit events of the tenant the admin
+// works on, see adminTenant, filtered by the query. format=csv exports
+// them as CSV with the cursor of the next page in X-Next-Cursor.
+func (s *apiServer) handleAuditEvents(c *fiber.Ctx) error {
+	q := audit.Query{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		Outcome:    c.Query("outcome"),
+		Cursor:     c.Query("cursor"),
+	}
+
+	var err error
+	if actor := c.Query("actor_id"); actor != "" {
+		id, err := strconv.ParseUint(actor, 10, 64)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		q.ActorID = uint(id)
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return fiber.ErrBadRequest
+		}
+	}
+	if q.Since, err = queryTime(c, "since"); err != nil {
+		return err
+	}
+	if q.Until, err = queryTime(c, "until"); err != nil {
+		return err
+	}
+
+	tenantID, err := s.adminTenant(c)
+	if err != nil {
+		return replyError(c, err)
+	}
+	q.TenantID = &tenantID
+
+	events, next, err := audit.NewStore(s.db).Events(q)
+	switch {
+	case errors.Is(err, audit.ErrOutcomeInvalid), errors.Is(err, audit.ErrCursorInvalid):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case err != nil:
+		return replyError(c, err)
+	}
+
+	switch c.Query("format") {
+	case "", "json":
+		return c.JSON(fiber.Map{
+			"events":      events,
+			"next_cursor": next,
+		})
+	case "csv":
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit.csv"`)
+		c.Set("X-Next-Cursor", next)
+		return audit.WriteCSV(c, events)
+	default:
+		return errs.WriteErrorJSON(c, errs.NewError(fiber.StatusBadRequest, "audit_format_invalid", "Format is json or csv"))
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/server/middleware"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_apiServer_handleAuditEvents(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &password.History{}, &tenant.Tenant{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	roles := rbac.NewStore(db)
+	if err := roles.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	config := &Config{KeyBytes: key, VerifyEmail: verifyEmailOff, PasswordMinLength: 8}
+	passwords, err := newPasswordPolicy(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestApiServer(db, config)
+	s.passwords = passwords
+
+	acme := &tenant.Tenant{Slug: "acme", Name: "Acme"}
+	if err := tenant.NewStore(db).Create(acme); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &user.User{Email: "admin@example.com"}
+	if err := user.NewStore(db).Create(admin); err != nil {
+		t.Fatal(err)
+	}
+	acmeAdmin := &user.User{Email: "admin@example.com"}
+	if err := user.NewStore(db).ForTenant(acme.ID).Create(acmeAdmin); err != nil {
+		t.Fatal(err)
+	}
+	for _, usr := range []*user.User{admin, acmeAdmin} {
+		if err := roles.AssignRole(usr.ID, rbac.AdminRole); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nobody := &user.User{Email: "nobody@example.com"}
+	if err := user.NewStore(db).Create(nobody); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	router := app.Group("/admin/", middleware.BearerAuth(key, nil))
+	router.Post("users/", s.handleAdminUserCreate)
+	router.Get("audit/", middleware.RequirePermission(rbac.PermAuditRead), s.handleAuditEvents)
+
+	do := func(as *user.User, method string, path string, body interface{}) (int, *bytes.Buffer) {
+		t.Helper()
+
+		buf, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(buf))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+grantedToken(t, db, key, as))
+		// creating users hashes their passwords, which is slow
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resBody := new(bytes.Buffer)
+		resBody.ReadFrom(res.Body)
+		return res.StatusCode, resBody
+	}
+
+	newUser := map[string]string{"email": "jane@example.com", "firstname": "Jane", "lastname": "Doe", "password": "correct horse"}
+	for _, path := range []string{"/admin/users/", "/admin/users/?tenant=acme"} {
+		if status, body := do(admin, "POST", path, newUser); status != fiber.StatusCreated {
+			t.Fatalf("POST %s = %d: %s", path, status, body)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		as         *user.User
+		path       string
+		wantStatus int
+		wantEvents int
+	}{
+		{name: "admin lists the default tenant", as: admin, path: "/admin/audit/", wantStatus: fiber.StatusOK, wantEvents: 1},
+		{name: "admin lists acme", as: admin, path: "/admin/audit/?tenant=acme", wantStatus: fiber.StatusOK, wantEvents: 1},
+		{name: "tenant admin lists its tenant", as: acmeAdmin, path: "/admin/audit/?tenant=default", wantStatus: fiber.StatusOK, wantEvents: 1},
+		{name: "admin filters by action", as: admin, path: "/admin/audit/?action=user_deleted", wantStatus: fiber.StatusOK, wantEvents: 0},
+		{name: "admin filters by actor", as: admin, path: "/admin/audit/?tenant=acme&actor_id=" + userTarget(admin.ID), wantStatus: fiber.StatusOK, wantEvents: 1},
+		{name: "admin uses an invalid outcome", as: admin, path: "/admin/audit/?outcome=maybe", wantStatus: fiber.StatusBadRequest},
+		{name: "admin uses an invalid since", as: admin, path: "/admin/audit/?since=yesterday", wantStatus: fiber.StatusBadRequest},
+		{name: "admin uses an unknown format", as: admin, path: "/admin/audit/?format=xml", wantStatus: fiber.StatusBadRequest},
+		{name: "user without audit:read", as: nobody, path: "/admin/audit/", wantStatus: fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := do(tt.as, "GET", tt.path, nil)
+			if status != tt.wantStatus {
+				t.Fatalf("GET %s = %d, want %d: %s", tt.path, status, tt.wantStatus, body)
+			}
+			if status != fiber.StatusOK {
+				return
+			}
+
+			res := struct {
+				Events []audit.Event `json:"events"`
+			}{}
+			if err := json.Unmarshal(body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Events) != tt.wantEvents {
+				t.Errorf("GET %s = %d events, want %d", tt.path, len(res.Events), tt.wantEvents)
+			}
+			for _, e := range res.Events {
+				if e.Action != "user_created" || e.ActorID != admin.ID || e.Outcome != audit.OutcomeSuccess {
+					t.Errorf("event = %+v, want user_created by the admin", e)
+				}
+			}
+		})
+	}
+
+	status, body := do(admin, "GET", "/admin/audit/?tenant=acme&format=csv", nil)
+	if status != fiber.StatusOK {
+		t.Fatalf("GET csv = %d: %s", status, body)
+	}
+	records, err := csv.NewReader(body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][5] != "user_created" {
+		t.Errorf("csv = %v, want the header and user_created", records)
+	}
+}
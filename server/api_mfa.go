@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/mfa"
+	serverutil "github.com/9d4/semaphore/server/util"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// currentUser gets the user the access token was issued to.
+func (s *apiServer) currentUser(c *fiber.Ctx) (*user.User, error) {
+	at, err := serverutil.UseContext[*auth.AccessToken](c, "access_token")
+	if err != nil {
+		return nil, fiber.ErrUnauthorized
+	}
+
+	usr, err := user.NewStore(s.db).UserByID(at.User.ID)
+	if err != nil {
+		return nil, fiber.ErrUnauthorized
+	}
+
+	return usr, nil
+}
+
+func (s *apiServer) handleMFAStatus(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	methods, err := s.mfa.methods(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	left, err := s.mfa.mfa.RecoveryCodesLeft(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"totp":           hasMethod(methods, mfaMethodTOTP),
+		"webauthn":       hasMethod(methods, mfaMethodWebAuthn),
+		"enforced":       usr.MFAEnforced,
+		"recovery_codes": left,
+		"recovery_low":   left <= mfa.RecoveryCodesLow,
+	})
+}
+
+// handleRecoveryCodes generates a new batch of recovery codes, the
+// previous one stops working. They are only shown this once.
+func (s *apiServer) handleRecoveryCodes(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	methods, err := s.mfa.methods(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	if len(methods) == 0 {
+		return errs.WriteErrorJSON(c, errs.ErrMFANotEnrolled)
+	}
+
+	codes, err := s.mfa.mfa.RegenerateRecoveryCodes(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	e := userEvent("recovery_codes_regenerated", usr)
+	e.Metadata = map[string]interface{}{"count": len(codes)}
+	auditEvent(c, s.db, e)
+	return c.JSON(fiber.Map{"codes": codes})
+}
+
+func (s *apiServer) handleTOTPEnroll(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	enrollment, err := s.mfa.startEnrollment(*usr)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(enrollment)
+}
+
+func (s *apiServer) handleTOTPConfirm(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		Code string `json:"code"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	enrolled, err := s.mfa.enrolled(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	if enrolled {
+		return errs.WriteErrorJSON(c, errs.ErrMFAAlreadyEnrolled)
+	}
+
+	if err := s.mfa.mfa.ConfirmTOTP(usr.ID, body.Code); err != nil {
+		return replyError(c, mfaError(err))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleTOTPDelete disables TOTP. A confirmed enrollment can only be
+// removed with a valid code, and not at all when MFA is enforced and it is
+// the only second factor left.
+func (s *apiServer) handleTOTPDelete(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		Code string `json:"code"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	t, err := s.mfa.mfa.TOTP(usr.ID)
+	if err != nil {
+		if errors.Is(err, mfa.ErrTOTPNotFound) {
+			return errs.WriteErrorJSON(c, errs.ErrMFANotEnrolled)
+		}
+		return replyError(c, err)
+	}
+
+	if t.Confirmed() {
+		last, err := s.mfa.lastMethod(usr.ID, mfaMethodTOTP)
+		if err != nil {
+			return replyError(c, err)
+		}
+
+		if usr.MFAEnforced && last {
+			return errs.WriteErrorJSON(c, errs.ErrMFAEnforced)
+		}
+
+		if err := s.mfa.mfa.VerifyTOTP(usr.ID, body.Code); err != nil {
+			return replyError(c, mfaError(err))
+		}
+	}
+
+	if err := s.mfa.mfa.DeleteTOTP(usr.ID); err != nil {
+		return replyError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func hasMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// passkeyNameMax is the longest name a passkey can be given.
+const passkeyNameMax = 64
+
+func (s *apiServer) handlePasskeys(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := s.passkeys.passkeys.Credentials(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(credentials)
+}
+
+func (s *apiServer) handlePasskeyRegister(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := s.passkeys.beginRegistration(c.UserContext(), usr)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(challenge)
+}
+
+func (s *apiServer) handlePasskeyRegisterFinish(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		Ceremony   string          `json:"ceremony"`
+		Credential json.RawMessage `json:"credential"`
+		Name       string          `json:"name"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	name, ok := passkeyName(body.Name)
+	if !ok {
+		return fiber.ErrBadRequest
+	}
+
+	credential, err := s.passkeys.finishRegistration(c.UserContext(), usr, body.Ceremony, body.Credential, name)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(credential)
+}
+
+func (s *apiServer) handlePasskeyRename(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return fiber.ErrBadRequest
+	}
+
+	body := struct {
+		Name string `json:"name"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	name, ok := passkeyName(body.Name)
+	if !ok || name == "" {
+		return fiber.ErrBadRequest
+	}
+
+	if err := s.passkeys.passkeys.Rename(usr.ID, uint(id), name); err != nil {
+		return replyError(c, passkeyError(err))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handlePasskeyDelete removes a passkey, unless MFA is enforced and it is
+// the only second factor left.
+func (s *apiServer) handlePasskeyDelete(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return fiber.ErrBadRequest
+	}
+
+	if _, err := s.passkeys.passkeys.Credential(usr.ID, uint(id)); err != nil {
+		return replyError(c, passkeyError(err))
+	}
+
+	if usr.MFAEnforced {
+		last, err := s.mfa.lastMethod(usr.ID, mfaMethodWebAuthn)
+		if err != nil {
+			return replyError(c, err)
+		}
+
+		credentials, err := s.passkeys.passkeys.Credentials(usr.ID)
+		if err != nil {
+			return replyError(c, err)
+		}
+
+		if last && len(credentials) == 1 {
+			return errs.WriteErrorJSON(c, errs.ErrMFAEnforced)
+		}
+	}
+
+	if err := s.passkeys.passkeys.Delete(usr.ID, uint(id)); err != nil {
+		return replyError(c, passkeyError(err))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// passkeyName trims name and reports whether it isn't too long.
+func passkeyName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, len([]rune(name)) <= passkeyNameMax
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Changes to roles and groups reach users the next time their access
+// token is minted, at the latest when it is renewed.
+
+// replyRBACError replies the errors of rbac.Store for the client.
+func replyRBACError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, rbac.ErrRoleNotFound):
+		return errs.WriteErrorJSON(c, errs.ErrRoleNotFound)
+	case errors.Is(err, rbac.ErrGroupNotFound):
+		return errs.WriteErrorJSON(c, errs.ErrGroupNotFound)
+	case errors.Is(err, rbac.ErrNameInvalid),
+		errors.Is(err, rbac.ErrPermissionInvalid),
+		errors.Is(err, rbac.ErrRoleBuiltIn):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, user.ErrUserNotFound):
+		return errs.WriteErrorJSON(c, errs.ErrUserNotFound)
+	}
+	return replyError(c, err)
+}
+
+// checkGrantable returns ErrGrantForbidden unless the current access token
+// grants every permission of roles itself, nobody can hand out more than
+// it has.
+func (s *apiServer) checkGrantable(c *fiber.Ctx, roles ...string) error {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+
+	store := rbac.NewStore(s.db)
+	for _, name := range roles {
+		r, err := store.Role(name)
+		if err != nil {
+			return err
+		}
+		if err := checkPermissions(at, r.Permissions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPermissions(at *auth.AccessToken, permissions []string) error {
+	for _, p := range permissions {
+		if !at.Can(p) {
+			return errs.ErrGrantForbidden
+		}
+	}
+	return nil
+}
+
+func (s *apiServer) handleRoles(c *fiber.Ctx) error {
+	roles, err := rbac.NewStore(s.db).Roles()
+	if err != nil {
+		return replyError(c, err)
+	}
+	return c.JSON(roles)
+}
+
+// handleRoleSave creates the role in the name param, or replaces the
+// description and the permissions of the existing one.
+func (s *apiServer) handleRoleSave(c *fiber.Ctx) error {
+	body := struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+	if err := checkPermissions(at, body.Permissions); err != nil {
+		return replyError(c, err)
+	}
+	// narrowing a role takes permissions away from its users, only those
+	// who could grant them may do it
+	if err := s.checkGrantable(c, c.Params("name")); err != nil && !errors.Is(err, rbac.ErrRoleNotFound) {
+		return replyRBACError(c, err)
+	}
+
+	r := &rbac.Role{Name: c.Params("name"), Description: body.Description, Permissions: body.Permissions}
+	if err := rbac.NewStore(s.db).SaveRole(r); err != nil {
+		return replyRBACError(c, err)
+	}
+	auditEvent(c, s.db, audit.Event{
+		Action:     "role_saved",
+		TargetType: audit.TargetRole,
+		TargetID:   r.Name,
+		Metadata:   map[string]interface{}{"permissions": r.Permissions},
+	})
+
+	return c.JSON(r)
+}
+
+func (s *apiServer) handleRoleDelete(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := s.checkGrantable(c, name); err != nil {
+		return replyRBACError(c, err)
+	}
+
+	if err := rbac.NewStore(s.db).DeleteRole(name); err != nil {
+		return replyRBACError(c, err)
+	}
+
+	auditEvent(c, s.db, audit.Event{Action: "role_deleted", TargetType: audit.TargetRole, TargetID: name})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *apiServer) handleGroups(c *fiber.Ctx) error {
+	groups, err := rbac.NewStore(s.db).Groups()
+	if err != nil {
+		return replyError(c, err)
+	}
+	return c.JSON(groups)
+}
+
+// handleGroupSave creates the group in the name param, or replaces the
+// description and the roles of the existing one.
+func (s *apiServer) handleGroupSave(c *fiber.Ctx) error {
+	body := struct {
+		Description string   `json:"description"`
+		Roles       []string `json:"roles"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	if err := s.checkGrantable(c, body.Roles...); err != nil {
+		return replyRBACError(c, err)
+	}
+
+	store := rbac.NewStore(s.db)
+	if g, err := store.Group(c.Params("name")); err == nil {
+		if err := s.checkGrantable(c, g.Roles...); err != nil {
+			return replyRBACError(c, err)
+		}
+	}
+
+	g := &rbac.Group{Name: c.Params("name"), Description: body.Description, Roles: body.Roles}
+	if err := store.SaveGroup(g); err != nil {
+		return replyRBACError(c, err)
+	}
+
+	auditEvent(c, s.db, audit.Event{
+		Action:     "group_saved",
+		TargetType: audit.TargetGroup,
+		TargetID:   g.Name,
+		Metadata:   map[string]interface{}{"roles": g.Roles},
+	})
+
+	return c.JSON(g)
+}
+
+func (s *apiServer) handleGroupDelete(c *fiber.Ctx) error {
+	store := rbac.NewStore(s.db)
+	g, err := store.Group(c.Params("name"))
+	if err != nil {
+		return replyRBACError(c, err)
+	}
+	if err := s.checkGrantable(c, g.Roles...); err != nil {
+		return replyRBACError(c, err)
+	}
+
+	if err := store.DeleteGroup(g.Name); err != nil {
+		return replyRBACError(c, err)
+	}
+
+	auditEvent(c, s.db, audit.Event{Action: "group_deleted", TargetType: audit.TargetGroup, TargetID: g.Name})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleGroupMember adds the user in the id param to the group in the
+// name param, or removes it when remove is set.
+func (s *apiServer) handleGroupMember(remove bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		usr, err := s.adminTarget(c, true)
+		if err != nil {
+			return replyError(c, err)
+		}
+		id := usr.ID
+		if _, err := user.NewStore(s.db).UserByID(id); err != nil {
+			return replyRBACError(c, err)
+		}
+
+		store := rbac.NewStore(s.db)
+		g, err := store.Group(c.Params("name"))
+		if err != nil {
+			return replyRBACError(c, err)
+		}
+		if err := s.checkGrantable(c, g.Roles...); err != nil {
+			return replyRBACError(c, err)
+		}
+
+		event := "group_member_added"
+		if remove {
+			event = "group_member_removed"
+			err = store.RemoveMember(g.Name, id)
+		} else {
+			err = store.AddMember(g.Name, id)
+		}
+		if err != nil {
+			return replyRBACError(c, err)
+		}
+
+		e := targetEvent(event, usr)
+		e.Metadata = map[string]interface{}{"group": g.Name}
+		auditEvent(c, s.db, e)
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// handleAdminUserGrants gets the roles, groups and permissions of a user.
+func (s *apiServer) handleAdminUserGrants(c *fiber.Ctx) error {
+	usr, err := s.adminTarget(c, true)
+	if err != nil {
+		return replyError(c, err)
+	}
+	id := usr.ID
+	if _, err := user.NewStore(s.db).UserByID(id); err != nil {
+		return replyRBACError(c, err)
+	}
+
+	grants, err := rbac.NewStore(s.db).Grants(id)
+	if err != nil {
+		return replyError(c, err)
+	}
+	return c.JSON(grants)
+}
+
+// handleAdminUserRole assigns the role in the role param to the user in
+// the id param, or takes it away when unassign is set. Admins can't take
+// roles away from themselves, they would lock themselves out.
+func (s *apiServer) handleAdminUserRole(unassign bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		usr, err := s.adminTarget(c, !unassign)
+		if err != nil {
+			return replyError(c, err)
+		}
+		id := usr.ID
+		if _, err := user.NewStore(s.db).UserByID(id); err != nil {
+			return replyRBACError(c, err)
+		}
+
+		role := c.Params("role")
+		if err := s.checkGrantable(c, role); err != nil {
+			return replyRBACError(c, err)
+		}
+
+		store := rbac.NewStore(s.db)
+		event := "role_assigned"
+		if unassign {
+			event = "role_unassigned"
+			err = store.UnassignRole(id, role)
+		} else {
+			err = store.AssignRole(id, role)
+		}
+		if err != nil {
+			return replyRBACError(c, err)
+		}
+
+		e := targetEvent(event, usr)
+		e.Metadata = map[string]interface{}{"role": role}
+		auditEvent(c, s.db, e)
+
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
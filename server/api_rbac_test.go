@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/oauth2/models"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/server/middleware"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_apiServer_rbac(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	roles := rbac.NewStore(db)
+	if err := roles.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := user.NewStore(db)
+	admin := &user.User{Email: "admin@example.com"}
+	manager := &user.User{Email: "manager@example.com"}
+	alice := &user.User{Email: "alice@example.com"}
+	for _, usr := range []*user.User{admin, manager, alice} {
+		if err := users.Create(usr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := roles.AssignRole(admin.ID, rbac.AdminRole); err != nil {
+		t.Fatal(err)
+	}
+	err = roles.SaveRole(&rbac.Role{Name: "manager", Permissions: []string{rbac.PermRolesRead, rbac.PermRolesWrite, rbac.PermUsersRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.AssignRole(manager.ID, "manager"); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	s := newTestApiServer(db, &Config{KeyBytes: key})
+
+	read := middleware.RequirePermission(rbac.PermRolesRead)
+	write := middleware.RequirePermission(rbac.PermRolesWrite)
+
+	app := fiber.New()
+	router := app.Group("/admin/", middleware.BearerAuth(key, nil))
+	router.Get("roles/", read, s.handleRoles)
+	router.Put("roles/:name", write, s.handleRoleSave)
+	router.Delete("roles/:name", write, s.handleRoleDelete)
+	router.Put("groups/:name", write, s.handleGroupSave)
+	router.Put("groups/:name/members/:id", write, s.handleGroupMember(false))
+	router.Get("users/:id/grants", read, s.handleAdminUserGrants)
+	router.Put("users/:id/roles/:role", write, s.handleAdminUserRole(false))
+	router.Delete("users/:id/roles/:role", write, s.handleAdminUserRole(true))
+
+	do := func(as *user.User, method string, path string, body interface{}) int {
+		buf, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(buf))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+grantedToken(t, db, key, as))
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	steps := []struct {
+		name       string
+		as         *user.User
+		method     string
+		path       string
+		body       interface{}
+		wantStatus int
+	}{
+		{name: "user lists roles", as: alice, method: "GET", path: "/admin/roles/", wantStatus: fiber.StatusForbidden},
+		{name: "manager lists roles", as: manager, method: "GET", path: "/admin/roles/", wantStatus: fiber.StatusOK},
+		{name: "manager creates a role it can grant", as: manager, method: "PUT", path: "/admin/roles/support",
+			body: fiber.Map{"permissions": []string{rbac.PermUsersRead}}, wantStatus: fiber.StatusOK},
+		{name: "manager creates a role it can't grant", as: manager, method: "PUT", path: "/admin/roles/writer",
+			body: fiber.Map{"permissions": []string{rbac.PermUsersWrite}}, wantStatus: fiber.StatusForbidden},
+		{name: "admin creates it", as: admin, method: "PUT", path: "/admin/roles/writer",
+			body: fiber.Map{"permissions": []string{rbac.PermUsersWrite}}, wantStatus: fiber.StatusOK},
+		{name: "manager narrows a role it can't grant", as: manager, method: "PUT", path: "/admin/roles/writer",
+			body: fiber.Map{"permissions": []string{}}, wantStatus: fiber.StatusForbidden},
+		{name: "invalid permission", as: admin, method: "PUT", path: "/admin/roles/broken",
+			body: fiber.Map{"permissions": []string{"users"}}, wantStatus: fiber.StatusBadRequest},
+		{name: "manager assigns support", as: manager, method: "PUT", path: fmt.Sprintf("/admin/users/%d/roles/support", alice.ID), wantStatus: fiber.StatusNoContent},
+		{name: "manager assigns writer", as: manager, method: "PUT", path: fmt.Sprintf("/admin/users/%d/roles/writer", alice.ID), wantStatus: fiber.StatusForbidden},
+		{name: "manager makes itself admin", as: manager, method: "PUT", path: fmt.Sprintf("/admin/users/%d/roles/admin", manager.ID), wantStatus: fiber.StatusForbidden},
+		{name: "missing role", as: admin, method: "PUT", path: fmt.Sprintf("/admin/users/%d/roles/missing", alice.ID), wantStatus: fiber.StatusNotFound},
+		{name: "missing user", as: admin, method: "PUT", path: "/admin/users/99/roles/support", wantStatus: fiber.StatusNotFound},
+		{name: "admin unassigns its own role", as: admin, method: "DELETE", path: fmt.Sprintf("/admin/users/%d/roles/admin", admin.ID), wantStatus: fiber.StatusBadRequest},
+		{name: "manager creates a group", as: manager, method: "PUT", path: "/admin/groups/staff",
+			body: fiber.Map{"roles": []string{"support"}}, wantStatus: fiber.StatusOK},
+		{name: "manager adds alice", as: manager, method: "PUT", path: fmt.Sprintf("/admin/groups/staff/members/%d", alice.ID), wantStatus: fiber.StatusNoContent},
+		{name: "manager deletes admin", as: manager, method: "DELETE", path: "/admin/roles/admin", wantStatus: fiber.StatusForbidden},
+		{name: "admin deletes admin", as: admin, method: "DELETE", path: "/admin/roles/admin", wantStatus: fiber.StatusBadRequest},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := do(tt.as, tt.method, tt.path, tt.body); status != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, status, tt.wantStatus)
+			}
+		})
+	}
+
+	grants, err := roles.Grants(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rbac.Grants{Roles: []string{"support"}, Groups: []string{"staff"}, Permissions: []string{rbac.PermUsersRead}}
+	if fmt.Sprint(*grants) != fmt.Sprint(want) {
+		t.Errorf("grants of alice = %v, want %v", *grants, want)
+	}
+}
+
+func Test_oauthServer_userClaims(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := rbac.NewStore(db)
+	if err := roles.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.SaveGroup(&rbac.Group{Name: "staff", Roles: []string{rbac.AdminRole}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.AddMember("staff", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &oauthServer{db: db}
+	tests := []struct {
+		name       string
+		client     *models.Client
+		wantRoles  []string
+		wantGroups []string
+	}{
+		{name: "none", client: &models.Client{}},
+		{name: "roles", client: &models.Client{RolesClaim: true}, wantRoles: []string{rbac.AdminRole}},
+		{name: "groups", client: &models.Client{GroupsClaim: true}, wantGroups: []string{"staff"}},
+		{name: "both", client: &models.Client{RolesClaim: true, GroupsClaim: true}, wantRoles: []string{rbac.AdminRole}, wantGroups: []string{"staff"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles, groups, err := s.userClaims(context.Background(), tt.client, "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(roles) != fmt.Sprint(tt.wantRoles) || fmt.Sprint(groups) != fmt.Sprint(tt.wantGroups) {
+				t.Errorf("userClaims() = %v %v, want %v %v", roles, groups, tt.wantRoles, tt.wantGroups)
+			}
+		})
+	}
+}
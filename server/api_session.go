@@ -0,0 +1,156 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	serverutil "github.com/9d4/semaphore/server/util"
+	"github.com/9d4/semaphore/session"
+	"github.com/gofiber/fiber/v2"
+)
+
+// refreshTokenGrace is how long a rotated refresh token is still
+// renewed, tabs of the same browser may race to renew with it.
+const refreshTokenGrace = 10 * time.Second
+
+// sessionInfo is what a user is shown of one of its sessions.
+type sessionInfo struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	AuthTime   time.Time `json:"auth_time"`
+	Current    bool      `json:"current"`
+	// Impersonated is set on sessions of an admin acting as the user
+	Impersonated bool `json:"impersonated,omitempty"`
+}
+
+// currentAccessToken gets the access token the request is authenticated
+// with.
+func currentAccessToken(c *fiber.Ctx) (*auth.AccessToken, error) {
+	at, err := serverutil.UseContext[*auth.AccessToken](c, "access_token")
+	if err != nil {
+		return nil, fiber.ErrUnauthorized
+	}
+	return at, nil
+}
+
+// handleSessions lists the sessions of the current user, most recently
+// seen first.
+func (s *apiServer) handleSessions(c *fiber.Ctx) error {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.sessions.Sessions(c.UserContext(), at.User.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		infos = append(infos, sessionInfo{
+			ID:         sess.ID,
+			UserAgent:  sess.UserAgent,
+			IP:         sess.IP,
+			CreatedAt:  sess.CreatedAt,
+			LastSeenAt: sess.LastSeenAt,
+			AuthTime:   sess.AuthTime,
+			Current:    sess.ID == at.SessionID,
+
+			Impersonated: sess.ActorID != 0,
+		})
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].LastSeenAt.After(infos[j].LastSeenAt)
+	})
+
+	return c.JSON(infos)
+}
+
+// handleSessionRevoke signs out one session of the current user.
+func (s *apiServer) handleSessionRevoke(c *fiber.Ctx) error {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	sess, err := s.sessions.Session(ctx, c.Params("id"))
+	if err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			return errs.WriteErrorJSON(c, errs.ErrSessionNotFound)
+		}
+		return replyError(c, err)
+	}
+
+	// another user's session looks like a missing one
+	if sess.UserID != at.User.ID {
+		return errs.WriteErrorJSON(c, errs.ErrSessionNotFound)
+	}
+
+	s.revoker.revokeSession(ctx, sess)
+	auditEvent(c, s.db, audit.Event{Action: "session_revoked", TargetType: audit.TargetSession, TargetID: sess.ID})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleSessionsRevoke signs out every session of the current user, but
+// the one the request is made from when keep_current is 1.
+func (s *apiServer) handleSessionsRevoke(c *fiber.Ctx) error {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	sessions, err := s.sessions.Sessions(ctx, at.User.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	keepCurrent := c.Query("keep_current") == "1"
+	revoked := 0
+	for _, sess := range sessions {
+		if keepCurrent && sess.ID == at.SessionID {
+			continue
+		}
+
+		s.revoker.revokeSession(ctx, sess)
+		revoked++
+	}
+	auditEvent(c, s.db, audit.Event{
+		Action:     "sessions_revoked",
+		TargetType: audit.TargetUser,
+		TargetID:   userTarget(at.User.ID),
+		Metadata:   map[string]interface{}{"count": revoked},
+	})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// revokeReusedSession ends a session whose refresh token has been used
+// after it was rotated. Either the user or whoever has copied the token
+// is replaying it, there's no telling which one so neither is trusted.
+func (s *apiServer) revokeReusedSession(c *fiber.Ctx, userID uint, sessionID string) {
+	auditEvent(c, s.db, audit.Event{
+		ActorID:    userID,
+		Action:     "refresh_token_reused",
+		TargetType: audit.TargetSession,
+		TargetID:   sessionID,
+		Outcome:    audit.OutcomeFailure,
+	})
+
+	ctx := c.UserContext()
+	sess, err := s.sessions.Session(ctx, sessionID)
+	if err != nil {
+		return
+	}
+	s.revoker.revokeSession(ctx, sess)
+}
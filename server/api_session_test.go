@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/mfa"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_apiServer_sessions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &pat.Token{}, &session.Session{}, &session.Client{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rbac.NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	key := []byte("key")
+	s := newTestApiServer(db, &Config{KeyBytes: key})
+	sessions := s.sessions
+	app := s.app
+
+	alice := user.User{Email: "alice@example.com"}
+	if err := user.NewStore(db).Create(&alice); err != nil {
+		t.Fatal(err)
+	}
+	var aliceSessions []*session.Session
+	for i, agent := range []string{"Firefox", "Safari", "Chrome"} {
+		// Safari was seen last, then Chrome, then Firefox
+		lastSeen := time.Now().Add(-[]time.Duration{3, 1, 2}[i] * time.Hour)
+		sess := &session.Session{UserID: alice.ID, UserAgent: agent, LastSeenAt: lastSeen}
+		if err := sessions.Create(ctx, sess); err != nil {
+			t.Fatal(err)
+		}
+		aliceSessions = append(aliceSessions, sess)
+	}
+	bob := &session.Session{UserID: 2}
+	if err := sessions.Create(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	at, _, err := auth.GenerateTokenPair(alice, rbac.Grants{}, aliceSessions[0].ID, aliceSessions[0].RefreshTokenID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a personal access token or an admin acting as alice can't see or
+	// end her sessions
+	personal, err := pat.NewStore(db).Create(&pat.Token{UserID: alice.ID, Name: "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acting, _, err := auth.GenerateImpersonationTokenPair(alice, rbac.Grants{}, &auth.Actor{Subject: "9", Email: "admin@example.com"}, aliceSessions[0].ID, aliceSessions[0].RefreshTokenID, key, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bearer := range map[string]string{"personal access token": personal, "impersonation": acting} {
+		for _, method := range []string{"GET", "DELETE"} {
+			req := httptest.NewRequest(method, "/sessions/", nil)
+			req.Header.Set("Authorization", "Bearer "+bearer)
+			res, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != fiber.StatusForbidden {
+				t.Errorf("%s /sessions/ with %s = %d, want %d", method, name, res.StatusCode, fiber.StatusForbidden)
+			}
+		}
+	}
+	if left, _ := sessions.Sessions(ctx, alice.ID); len(left) != 3 {
+		t.Fatalf("%d sessions of alice left, want 3", len(left))
+	}
+
+	do := func(method string, path string) (int, []sessionInfo) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+at)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var infos []sessionInfo
+		_ = json.NewDecoder(res.Body).Decode(&infos)
+		return res.StatusCode, infos
+	}
+
+	status, infos := do("GET", "/sessions/")
+	if status != fiber.StatusOK || len(infos) != 3 {
+		t.Fatalf("list = %d %v, want the 3 sessions of alice", status, infos)
+	}
+	for i, agent := range []string{"Safari", "Chrome", "Firefox"} {
+		if infos[i].UserAgent != agent {
+			t.Errorf("session %d = %s, want %s, most recently seen first", i, infos[i].UserAgent, agent)
+		}
+	}
+	for _, info := range infos {
+		if want := info.ID == aliceSessions[0].ID; info.Current != want {
+			t.Errorf("session %s current = %v, want %v", info.UserAgent, info.Current, want)
+		}
+	}
+
+	if status, _ := do("DELETE", "/sessions/"+bob.ID); status != fiber.StatusNotFound {
+		t.Errorf("revoke other user's session status = %d, want %d", status, fiber.StatusNotFound)
+	}
+	if status, _ := do("DELETE", "/sessions/"+aliceSessions[1].ID); status != fiber.StatusNoContent {
+		t.Errorf("revoke session status = %d, want %d", status, fiber.StatusNoContent)
+	}
+	if status, _ := do("DELETE", "/sessions/?keep_current=1"); status != fiber.StatusNoContent {
+		t.Errorf("revoke others status = %d, want %d", status, fiber.StatusNoContent)
+	}
+
+	left, err := sessions.Sessions(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].ID != aliceSessions[0].ID {
+		t.Errorf("sessions left = %v, want only the current one", left)
+	}
+	if _, err := sessions.Session(ctx, bob.ID); err != nil {
+		t.Errorf("other user's session error = %v, want it kept", err)
+	}
+}
+
+func Test_apiServer_handleRenew(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &session.Session{}, &session.Client{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rbac.NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	key := []byte("key")
+	s := newTestApiServer(db, &Config{KeyBytes: key})
+	sessions := s.sessions
+
+	app := fiber.New()
+	app.Post("/renew", s.handleRenew)
+
+	usr := user.User{Email: "alice@example.com"}
+	if err := db.Create(&usr).Error; err != nil {
+		t.Fatal(err)
+	}
+	sess := &session.Session{UserID: usr.ID}
+	if err := sessions.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+
+	_, first, err := auth.GenerateTokenPair(usr, rbac.Grants{}, sess.ID, sess.RefreshTokenID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renew := func(rt string) (int, string) {
+		req := httptest.NewRequest("POST", "/renew", nil)
+		req.AddCookie(&http.Cookie{Name: "rt", Value: rt})
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, cookie := range res.Cookies() {
+			if cookie.Name == "rt" {
+				return res.StatusCode, cookie.Value
+			}
+		}
+		return res.StatusCode, ""
+	}
+
+	status, second := renew(first)
+	if status != fiber.StatusCreated || second == "" {
+		t.Fatalf("renew = %d, want %d with a new refresh token", status, fiber.StatusCreated)
+	}
+
+	// a tab racing the renew is still let through
+	if status, _ := renew(first); status != fiber.StatusCreated {
+		t.Fatalf("racing renew = %d, want %d", status, fiber.StatusCreated)
+	}
+
+	// once the grace is over the rotated token is a replay
+	db.Model(&session.Session{}).Where("id = ?", sess.ID).Update("rotated_at", time.Now().Add(-time.Minute))
+	if status, _ := renew(first); status != fiber.StatusUnauthorized {
+		t.Fatalf("replayed renew = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	// which ends the session for the legitimate token too
+	if _, err := sessions.Session(ctx, sess.ID); err != session.ErrSessionNotFound {
+		t.Errorf("session after replay error = %v, want %v", err, session.ErrSessionNotFound)
+	}
+	if status, _ := renew(second); status != fiber.StatusUnauthorized {
+		t.Errorf("renew after replay = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+}
+
+func Test_apiServer_loginMFA(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &tenant.Tenant{}, &mfa.TOTP{}, &mfa.RecoveryCode{}, &session.Session{}, &session.Client{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rbac.NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	s := newTestApiServer(db, &Config{KeyBytes: key})
+	s.verifier = &emailVerifier{mode: verifyEmailLogin}
+	acme := &tenant.Tenant{Slug: "acme", Name: "Acme"}
+	if err := tenant.NewStore(db).Create(acme); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name       string
+		usr        *user.User
+		tenantID   uint
+		wantStatus int
+	}{
+		{name: "default tenant", usr: &user.User{Email: "alice@example.com", EmailVerifiedAt: &now}, wantStatus: fiber.StatusOK},
+		{name: "other tenant", usr: &user.User{Email: "bob@example.com", EmailVerifiedAt: &now}, tenantID: acme.ID, wantStatus: fiber.StatusUnauthorized},
+		{name: "disabled", usr: &user.User{Email: "carol@example.com", EmailVerifiedAt: &now, DisabledAt: &now}, wantStatus: fiber.StatusForbidden},
+		{name: "unverified", usr: &user.User{Email: "dave@example.com"}, wantStatus: fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := user.NewStore(db).ForTenant(tt.tenantID).Create(tt.usr); err != nil {
+				t.Fatal(err)
+			}
+			codes, err := s.mfa.mfa.RegenerateRecoveryCodes(tt.usr.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := auth.GenerateMFAToken(*tt.usr, false, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body := `{"mfa_token":"` + token + `","code":"` + codes[0] + `"}`
+			req := httptest.NewRequest("POST", "/login/mfa/recovery", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			// recovery codes are checked against slow password hashes
+			res, err := s.app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("POST /login/mfa/recovery = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if sessions, _ := s.sessions.Sessions(context.Background(), tt.usr.ID); (len(sessions) == 1) != (tt.wantStatus == fiber.StatusOK) {
+				t.Errorf("sessions = %d, want one only when signed in", len(sessions))
+			}
+		})
+	}
+}
+
+// newTestApiServer builds the API on db the way the server does, with
+// sessions kept in db, a login guard counting in memory and a revoker
+// that only ends sessions. Tests set the collaborators they need more.
+func newTestApiServer(db *gorm.DB, config *Config) *apiServer {
+	sessions := session.NewDBStore(db, time.Hour)
+	guard := newMemLoginGuard()
+	s := newApiServer(&server{
+		Config:   config,
+		v:        viper.New(),
+		db:       db,
+		sessions: sessions,
+		mfa:      newMFAChallenge(db, guard, config.KeyBytes),
+		guard:    guard,
+	})
+	s.revoker = &storeRevoker{sessions: sessions}
+	return s
+}
+
+// storeRevoker ends sessions by deleting them from the store.
+type storeRevoker struct {
+	sessions session.Store
+}
+
+func (r *storeRevoker) revokeUser(ctx context.Context, userID uint) error {
+	sessions, err := r.sessions.Sessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, sess := range sessions {
+		r.revokeSession(ctx, sess)
+	}
+	return nil
+}
+
+func (r *storeRevoker) revokeSession(ctx context.Context, sess *session.Session) {
+	_ = r.sessions.Delete(ctx, sess.ID)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/9d4/semaphore/audit"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// replyTenantError replies the errors of the tenant store.
+func replyTenantError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, tenant.ErrTenantNotFound):
+		return errs.WriteErrorJSON(c, errs.ErrTenantNotFound)
+	case errors.Is(err, tenant.ErrSlugTaken):
+		return errs.WriteErrorJSON(c, errs.ErrTenantSlugTaken)
+	case errors.Is(err, tenant.ErrSlugInvalid):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return replyError(c, err)
+}
+
+// requireDefaultTenant lets only users of the default tenant through,
+// admins of other tenants don't manage tenants, nor the roles and groups
+// all tenants share.
+func requireDefaultTenant(c *fiber.Ctx) error {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+	if at.User.TenantID != 0 {
+		return fiber.ErrForbidden
+	}
+	return c.Next()
+}
+
+// handleTenantBranding gets how the sign in pages of a tenant look, for
+// anyone to see.
+func (s *apiServer) handleTenantBranding(c *fiber.Ctx) error {
+	t, err := tenant.NewStore(s.db).Tenant(c.Params("slug"))
+	if err != nil {
+		return replyTenantError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"slug":     t.Slug,
+		"name":     t.Name,
+		"branding": t.Branding,
+	})
+}
+
+func (s *apiServer) handleTenants(c *fiber.Ctx) error {
+	tenants, err := tenant.NewStore(s.db).Tenants()
+	if err != nil {
+		return replyError(c, err)
+	}
+	return c.JSON(tenants)
+}
+
+func (s *apiServer) handleTenant(c *fiber.Ctx) error {
+	t, err := tenant.NewStore(s.db).Tenant(c.Params("slug"))
+	if err == nil && t.IsDefault() {
+		err = tenant.ErrTenantNotFound
+	}
+	if err != nil {
+		return replyTenantError(c, err)
+	}
+	return c.JSON(t)
+}
+
+// handleTenantSave creates the tenant in the slug param or replaces its
+// name, scopes and branding.
+func (s *apiServer) handleTenantSave(c *fiber.Ctx) error {
+	body := struct {
+		Name     string          `json:"name"`
+		Scopes   []string        `json:"scopes"`
+		Branding tenant.Branding `json:"branding"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+	for _, scope := range body.Scopes {
+		if OAuth2Scopes[scope] == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "unknown scope "+scope)
+		}
+	}
+
+	store := tenant.NewStore(s.db)
+	t := &tenant.Tenant{Slug: c.Params("slug"), Name: body.Name, Scopes: body.Scopes, Branding: body.Branding}
+
+	event := "tenant_updated"
+	err := store.Update(t)
+	if errors.Is(err, tenant.ErrTenantNotFound) {
+		event = "tenant_created"
+		err = store.Create(t)
+	}
+	if err != nil {
+		return replyTenantError(c, err)
+	}
+
+	auditEvent(c, s.db, audit.Event{Action: event, TargetType: audit.TargetTenant, TargetID: t.Slug})
+
+	return c.JSON(t)
+}
+
+// handleTenantRotateKey replaces the signing key of a tenant, the tokens
+// it has issued stop working.
+func (s *apiServer) handleTenantRotateKey(c *fiber.Ctx) error {
+	slug := c.Params("slug")
+	if err := tenant.NewStore(s.db).RotateKey(slug); err != nil {
+		return replyTenantError(c, err)
+	}
+
+	auditEvent(c, s.db, audit.Event{Action: "tenant_key_rotated", TargetType: audit.TargetTenant, TargetID: slug})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleTenantDelete deletes a tenant that has no users left, soft-deleted
+// ones included.
+func (s *apiServer) handleTenantDelete(c *fiber.Ctx) error {
+	store := tenant.NewStore(s.db)
+	t, err := store.Tenant(c.Params("slug"))
+	if err == nil && t.IsDefault() {
+		err = tenant.ErrTenantNotFound
+	}
+	if err != nil {
+		return replyTenantError(c, err)
+	}
+
+	var users int64
+	err = s.db.Unscoped().Model(&user.User{}).Where("tenant_id = ?", t.ID).Count(&users).Error
+	if err != nil {
+		return replyError(c, err)
+	}
+	if users > 0 {
+		return errs.WriteErrorJSON(c, errs.ErrTenantNotEmpty)
+	}
+
+	if err := store.Delete(t.Slug); err != nil {
+		return replyTenantError(c, err)
+	}
+
+	auditEvent(c, s.db, audit.Event{Action: "tenant_deleted", TargetType: audit.TargetTenant, TargetID: t.Slug})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
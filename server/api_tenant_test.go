@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/server/middleware"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_apiServer_tenants(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &password.History{}, &tenant.Tenant{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	roles := rbac.NewStore(db)
+	if err := roles.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	config := &Config{KeyBytes: key, Issuer: "http://semaphore.test", VerifyEmail: verifyEmailOff, PasswordMinLength: 8}
+	passwords, err := newPasswordPolicy(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := newEmailVerifier(db, nil, &recordingMailer{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestApiServer(db, config)
+	s.verifier = verifier
+	s.passwords = passwords
+
+	acme := &tenant.Tenant{Slug: "acme", Name: "Acme"}
+	if err := tenant.NewStore(db).Create(acme); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &user.User{Email: "admin@example.com"}
+	if err := user.NewStore(db).Create(admin); err != nil {
+		t.Fatal(err)
+	}
+	acmeAdmin := &user.User{Email: "admin@example.com"}
+	if err := user.NewStore(db).ForTenant(acme.ID).Create(acmeAdmin); err != nil {
+		t.Fatal(err)
+	}
+	for _, usr := range []*user.User{admin, acmeAdmin} {
+		if err := roles.AssignRole(usr.ID, rbac.AdminRole); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	router := app.Group("/admin/", middleware.BearerAuth(key, nil))
+	router.Get("users/", s.handleAdminUsers)
+	router.Post("users/", s.handleAdminUserCreate)
+	router.Get("users/:id", s.handleAdminUser)
+	tenants := router.Group("tenants/", requireDefaultTenant)
+	tenants.Get("/", s.handleTenants)
+	tenants.Put(":slug", s.handleTenantSave)
+	tenants.Delete(":slug", s.handleTenantDelete)
+	app.Get("/tenants/:slug", s.handleTenantBranding)
+
+	do := func(as *user.User, method string, path string, body interface{}) (int, []byte) {
+		buf, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(buf))
+		req.Header.Set("Content-Type", "application/json")
+		if as != nil {
+			req.Header.Set("Authorization", "Bearer "+grantedToken(t, db, key, as))
+		}
+		// creating users hashes their passwords, which is slow
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resBody := new(bytes.Buffer)
+		resBody.ReadFrom(res.Body)
+		return res.StatusCode, resBody.Bytes()
+	}
+
+	newUser := map[string]string{"email": "jane@example.com", "firstname": "Jane", "lastname": "Doe", "password": "correct horse"}
+
+	tests := []struct {
+		name       string
+		as         *user.User
+		method     string
+		path       string
+		body       interface{}
+		wantStatus int
+	}{
+		{name: "admin creates a tenant", as: admin, method: "PUT", path: "/admin/tenants/globex",
+			body: map[string]interface{}{"name": "Globex", "scopes": []string{"openid"}}, wantStatus: fiber.StatusOK},
+		{name: "admin updates a tenant", as: admin, method: "PUT", path: "/admin/tenants/globex",
+			body: map[string]interface{}{"name": "Globex Corp", "branding": map[string]string{"primary_color": "#00f"}}, wantStatus: fiber.StatusOK},
+		{name: "admin asks for an unknown scope", as: admin, method: "PUT", path: "/admin/tenants/initech",
+			body: map[string]interface{}{"scopes": []string{"admin"}}, wantStatus: fiber.StatusBadRequest},
+		{name: "admin uses an invalid slug", as: admin, method: "PUT", path: "/admin/tenants/default", wantStatus: fiber.StatusBadRequest},
+		{name: "tenant admin lists tenants", as: acmeAdmin, method: "GET", path: "/admin/tenants/", wantStatus: fiber.StatusForbidden},
+		{name: "tenant admin creates a tenant", as: acmeAdmin, method: "PUT", path: "/admin/tenants/initech", wantStatus: fiber.StatusForbidden},
+		{name: "anyone sees the branding", method: "GET", path: "/tenants/globex", wantStatus: fiber.StatusOK},
+		{name: "admin creates a user in acme", as: admin, method: "POST", path: "/admin/users/?tenant=acme", body: newUser, wantStatus: fiber.StatusCreated},
+		{name: "admin creates the same user in acme", as: admin, method: "POST", path: "/admin/users/?tenant=acme", body: newUser, wantStatus: fiber.StatusConflict},
+		{name: "admin creates the user in an unknown tenant", as: admin, method: "POST", path: "/admin/users/?tenant=initech", body: newUser, wantStatus: fiber.StatusNotFound},
+		{name: "tenant admin creates the user in its tenant", as: acmeAdmin, method: "POST", path: "/admin/users/?tenant=globex", body: newUser, wantStatus: fiber.StatusConflict},
+		{name: "admin gets the acme admin", as: admin, method: "GET", path: fmt.Sprintf("/admin/users/%d", acmeAdmin.ID), wantStatus: fiber.StatusOK},
+		{name: "tenant admin gets the admin", as: acmeAdmin, method: "GET", path: fmt.Sprintf("/admin/users/%d", admin.ID), wantStatus: fiber.StatusNotFound},
+		{name: "admin deletes acme with users", as: admin, method: "DELETE", path: "/admin/tenants/acme", wantStatus: fiber.StatusConflict},
+		{name: "admin deletes globex", as: admin, method: "DELETE", path: "/admin/tenants/globex", wantStatus: fiber.StatusNoContent},
+		{name: "admin deletes globex again", as: admin, method: "DELETE", path: "/admin/tenants/globex", wantStatus: fiber.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := do(tt.as, tt.method, tt.path, tt.body); status != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, status, tt.wantStatus, body)
+			}
+		})
+	}
+
+	list := func(as *user.User, path string) []adminUser {
+		t.Helper()
+
+		status, body := do(as, "GET", path, nil)
+		if status != fiber.StatusOK {
+			t.Fatalf("GET %s = %d: %s", path, status, body)
+		}
+
+		res := struct {
+			Users []adminUser `json:"users"`
+		}{}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Users
+	}
+
+	// roles and groups are shared, tenant admins keep out of them
+	shared := []struct {
+		as         *user.User
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{as: admin, method: "GET", path: "/admin/roles/", wantStatus: fiber.StatusOK},
+		{as: acmeAdmin, method: "GET", path: "/admin/roles/", wantStatus: fiber.StatusForbidden},
+		{as: acmeAdmin, method: "PUT", path: "/admin/roles/" + rbac.AdminRole, wantStatus: fiber.StatusForbidden},
+		{as: acmeAdmin, method: "DELETE", path: "/admin/roles/" + rbac.AdminRole, wantStatus: fiber.StatusForbidden},
+		{as: admin, method: "GET", path: "/admin/groups/", wantStatus: fiber.StatusOK},
+		{as: acmeAdmin, method: "GET", path: "/admin/groups/", wantStatus: fiber.StatusForbidden},
+		{as: acmeAdmin, method: "PUT", path: "/admin/groups/staff", wantStatus: fiber.StatusForbidden},
+		{as: acmeAdmin, method: "PUT", path: fmt.Sprintf("/admin/groups/staff/members/%d", acmeAdmin.ID), wantStatus: fiber.StatusForbidden},
+	}
+	for _, tt := range shared {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+grantedToken(t, db, key, tt.as))
+		res, err := s.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.wantStatus {
+			t.Errorf("%s %s as %d = %d, want %d", tt.method, tt.path, tt.as.TenantID, res.StatusCode, tt.wantStatus)
+		}
+	}
+	if _, err := roles.Role(rbac.AdminRole); err != nil {
+		t.Errorf("admin role after tenant admin deleted it error = %v", err)
+	}
+
+	if got := list(admin, "/admin/users/"); len(got) != 1 || got[0].ID != admin.ID {
+		t.Errorf("admin users = %v, want the admin alone", got)
+	}
+	if got := list(admin, "/admin/users/?tenant=acme"); len(got) != 2 {
+		t.Errorf("acme users = %v, want the acme admin and jane", got)
+	}
+	// tenant admins can't look into other tenants
+	if got := list(acmeAdmin, "/admin/users/?tenant=default"); len(got) != 2 || got[0].TenantID != acme.ID {
+		t.Errorf("acme admin users = %v, want the acme admin and jane", got)
+	}
+}
+
+func Test_oauthServer_tenantRealm(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants := tenant.NewStore(db)
+	if err := tenants.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	acme := &tenant.Tenant{Slug: "acme", Scopes: []string{"openid", "email"}}
+	if err := tenants.Create(acme); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &oauthServer{Config: &Config{Issuer: "http://semaphore.test/"}, db: db, realms: make(map[string]*realm)}
+	s.realm = &realm{tenant: tenant.Default, issuer: s.Issuer}
+
+	rl := s.tenantRealm(acme)
+	if rl.issuer != "http://semaphore.test/t/acme" || string(rl.key) != acme.SigningKey {
+		t.Errorf("tenantRealm() issuer = %s, key = %s, want the ones of acme", rl.issuer, rl.key)
+	}
+	if s.tenantRealm(acme) != rl {
+		t.Error("tenantRealm() didn't keep the realm of an unchanged tenant")
+	}
+	if s.tenantRealm(tenant.Default) != s.realm {
+		t.Error("tenantRealm(default) isn't the default realm")
+	}
+
+	if err := tenants.RotateKey("acme"); err != nil {
+		t.Fatal(err)
+	}
+	acme, _ = tenants.Tenant("acme")
+	if rotated := s.tenantRealm(acme); rotated == rl || string(rotated.key) != acme.SigningKey {
+		t.Error("tenantRealm() kept the realm after the key was rotated")
+	}
+
+	if got := rl.filterScopes("openid profile email unknown"); got != "openid email" {
+		t.Errorf("filterScopes() = %q, want %q", got, "openid email")
+	}
+	if got := rl.query("client_id=app"); got != "client_id=app&tenant=acme" {
+		t.Errorf("query() = %q, want the tenant added", got)
+	}
+	if got := s.realm.query("client_id=app"); got != "client_id=app" {
+		t.Errorf("default query() = %q, want it unchanged", got)
+	}
+
+	for _, path := range []string{"/t/globex/oauth2/token", "/t/acme/oauth2/userinfo", "/t/default/oauth2/token"} {
+		w := httptest.NewRecorder()
+		s.handleTenant(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != fiber.StatusNotFound {
+			t.Errorf("POST %s = %d, want %d", path, w.Code, fiber.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+// auditEvent records e, something that happened to the security of an
+// account, along with the client of c. The user of the access token of
+// c is the actor unless e names one, the admin impersonating that user
+// when there is one.
+func auditEvent(c *fiber.Ctx, db *gorm.DB, e audit.Event) {
+	e.IP = c.IP()
+	e.UserAgent = c.Get(fiber.HeaderUserAgent)
+	if rid, ok := c.Locals("requestid").(string); ok {
+		e.RequestID = rid
+	}
+
+	if at, err := currentAccessToken(c); err == nil {
+		if e.ActorID == 0 && e.Actor == "" {
+			e.ActorID, e.Actor = at.User.ID, at.User.Email
+			if e.TenantID == 0 {
+				e.TenantID = at.User.TenantID
+			}
+		}
+		actingAs(&e, at.Actor, at.User.ID)
+	}
+
+	recordAudit(db, e)
+}
+
+// auditRequest is auditEvent for the OAuth2 endpoints, which are served
+// through net/http.
+func auditRequest(r *http.Request, db *gorm.DB, e audit.Event) {
+	e.IP = requestIP(r)
+	e.UserAgent = r.UserAgent()
+	e.RequestID = r.Header.Get(fiber.HeaderXRequestID)
+
+	recordAudit(db, e)
+}
+
+// recordAudit logs e and appends it to the audit log. Failing to append
+// is logged, it doesn't fail what is being recorded.
+func recordAudit(db *gorm.DB, e audit.Event) {
+	if e.Outcome == "" {
+		e.Outcome = audit.OutcomeSuccess
+	}
+
+	jww.WARN.Printf("security: %s actor=%d %s target=%s:%s outcome=%s %v",
+		e.Action, e.ActorID, e.Actor, e.TargetType, e.TargetID, e.Outcome, e.Metadata)
+
+	if err := audit.NewStore(db).Record(&e); err != nil {
+		jww.ERROR.Println("unable to record audit event:", err)
+	}
+}
+
+// userTarget is the target ID of the user with the specified ID.
+func userTarget(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// actingAs attributes e, done by the user with userID, to actor when an
+// admin is impersonating the user.
+func actingAs(e *audit.Event, actor *auth.Actor, userID uint) {
+	if actor == nil || e.ActorID != userID {
+		return
+	}
+
+	e.ActorID, e.Actor = actor.ID(), actor.Email
+	if e.Metadata == nil {
+		e.Metadata = map[string]interface{}{}
+	}
+	e.Metadata["impersonating"] = userID
+}
+
+// targetEvent is an event of action done to usr, in the tenant of usr.
+func targetEvent(action string, usr *user.User) audit.Event {
+	return audit.Event{
+		TenantID:   usr.TenantID,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   userTarget(usr.ID),
+	}
+}
+
+// userEvent is an event of action done by usr to its own account.
+func userEvent(action string, usr *user.User) audit.Event {
+	e := targetEvent(action, usr)
+	e.ActorID, e.Actor = usr.ID, usr.Email
+	return e
+}
+
+// auditLogin records usr signing in to sess.
+func auditLogin(c *fiber.Ctx, db *gorm.DB, usr *user.User, sess *session.Session) {
+	e := userEvent("login", usr)
+	e.Metadata = map[string]interface{}{
+		"session": sess.ID,
+		"acr":     sess.ACR,
+		"amr":     sess.AMR,
+	}
+	auditEvent(c, db, e)
+}
+
+// auditLoginFailure records a failed attempt to sign in as email to the
+// tenant with the specified ID, and why it failed.
+func auditLoginFailure(c *fiber.Ctx, db *gorm.DB, tenantID uint, email, reason string) {
+	auditEvent(c, db, audit.Event{
+		TenantID: tenantID,
+		Actor:    email,
+		Action:   "login",
+		Outcome:  audit.OutcomeFailure,
+		Metadata: map[string]interface{}{"reason": reason},
+	})
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/9d4/semaphore/util"
 	"github.com/spf13/viper"
@@ -11,6 +12,7 @@ import (
 var defaultConfig *Config = &Config{
 	v:             nil,
 	Address:       "0.0.0.0:3500",
+	Issuer:        "http://semaphore.test",
 	DBHost:        "127.0.0.1",
 	DBPort:        5432,
 	DBName:        "semaphore",
@@ -20,6 +22,32 @@ var defaultConfig *Config = &Config{
 	RedisUsername: "default",
 	RedisPassword: "t00r",
 	LogRequest:    false,
+
+	CORSOrigins: "*",
+	RateLimit:   600,
+
+	ShutdownTimeout: 15 * time.Second,
+
+	Mailer:      mailerDir,
+	MailDir:     "mail",
+	MailFrom:    "Semaphore <noreply@semaphore.test>",
+	SMTPPort:    587,
+	VerifyEmail: verifyEmailOff,
+
+	LockoutThreshold:   10,
+	LockoutDuration:    15 * time.Minute,
+	LockoutIPThreshold: 100,
+
+	ImpersonationDuration: 30 * time.Minute,
+
+	PasswordMinLength: 8,
+	PasswordMaxLength: 128,
+
+	PasswordHashTime:    util.DefaultHashParams().TimeCost,
+	PasswordHashMemory:  util.DefaultHashParams().MemoryCost,
+	PasswordHashThreads: util.DefaultHashParams().Parallelism,
+
+	LDAPUserFilter: "(mail=%s)",
 }
 
 func init() {
@@ -35,7 +63,11 @@ type Config struct {
 	KeyBytes []byte
 
 	// Address to listen on
-	Address    string
+	Address string
+	// OAuthAddress to serve the OAuth2 endpoints on. When empty they are
+	// served on Address.
+	OAuthAddress string
+
 	DBHost     string
 	DBPort     int
 	DBName     string
@@ -46,7 +78,88 @@ type Config struct {
 	RedisUsername string
 	RedisPassword string
 
+	// Issuer identifies this server in OAuth2 and OpenID Connect tokens
+	Issuer string
+	// WebAuthnOrigin is the origin browsers register passkeys on, its host
+	// is the relying party id. Defaults to Issuer.
+	WebAuthnOrigin string
+
 	LogRequest bool
+
+	// CORSOrigins is a comma separated list of origins allowed to make
+	// cross-origin requests
+	CORSOrigins string
+	// RateLimit is the number of requests a client can make per minute
+	RateLimit int
+
+	// ShutdownTimeout is how long in-flight requests are waited for on
+	// shutdown
+	ShutdownTimeout time.Duration
+
+	// Mailer sends emails through smtp or writes them to MailDir
+	Mailer       string
+	MailDir      string
+	MailFrom     string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// VerifyEmail is what an unverified email address blocks: off, login
+	// or oauth
+	VerifyEmail string
+
+	// LockoutThreshold is the number of failed logins in a row that locks
+	// an account for LockoutDuration, LockoutIPThreshold does the same for
+	// failed logins from one ip. 0 never locks out.
+	LockoutThreshold   int
+	LockoutDuration    time.Duration
+	LockoutIPThreshold int
+
+	// ImpersonationDuration is how long an admin can act as another user
+	// before the impersonation ends
+	ImpersonationDuration time.Duration
+
+	// PasswordMinLength and PasswordMaxLength bound the characters of a
+	// password, PasswordClasses is how many of lowercase, uppercase,
+	// digits and symbols it mixes and PasswordHistory how many previous
+	// passwords it can't repeat
+	PasswordMinLength int
+	PasswordMaxLength int
+	PasswordClasses   int
+	PasswordHistory   int
+	// PasswordBreachedFile is a corpus of breached password hashes to
+	// refuse, see password.FileCorpus
+	PasswordBreachedFile string
+
+	// PasswordHashTime, PasswordHashMemory in KiB and PasswordHashThreads
+	// are the argon2id costs of password hashes, weaker hashes are
+	// replaced on login
+	PasswordHashTime    uint32
+	PasswordHashMemory  uint32
+	PasswordHashThreads uint8
+
+	// LDAPURL is the directory users of the default tenant sign in with
+	// before their local password is tried, none when empty. See
+	// directory.Config for the others.
+	LDAPURL           string
+	LDAPStartTLS      bool
+	LDAPCAFile        string
+	LDAPBindDN        string
+	LDAPBindPassword  string
+	LDAPUserDN        string
+	LDAPBaseDN        string
+	LDAPUserFilter    string
+	LDAPSyncFilter    string
+	LDAPEmailAttr     string
+	LDAPFirstNameAttr string
+	LDAPLastNameAttr  string
+	LDAPGroupAttr     string
+	// LDAPGroupRoles maps groups to roles as role=group dn
+	LDAPGroupRoles []string
+	// LDAPSyncInterval is how often the users of the directory are synced
+	// into the users table, never when zero
+	LDAPSyncInterval time.Duration
 }
 
 func (c *Config) Apply(conf *Config) error {
@@ -82,6 +195,9 @@ func parseViper(v *viper.Viper, defaultConf *Config) *Config {
 	}
 
 	c.Address = getOrDefault(v.GetString("address"), defaultConf.Address)
+	c.OAuthAddress = getOrDefault(v.GetString("oauth-address"), defaultConf.OAuthAddress)
+	c.Issuer = getOrDefault(v.GetString("issuer"), defaultConf.Issuer)
+	c.WebAuthnOrigin = getOrDefault(v.GetString("webauthn-origin"), defaultConf.WebAuthnOrigin)
 	c.DBHost = getOrDefault(v.GetString("db-host"), defaultConf.DBHost)
 	c.DBPort = getOrDefault(v.GetInt("db-port"), defaultConf.DBPort)
 	c.DBName = getOrDefault(v.GetString("db-name"), defaultConf.DBName)
@@ -91,6 +207,44 @@ func parseViper(v *viper.Viper, defaultConf *Config) *Config {
 	c.RedisUsername = getOrDefault(v.GetString("redis-username"), defaultConf.RedisUsername)
 	c.RedisPassword = getOrDefault(v.GetString("redis-password"), defaultConf.RedisPassword)
 	c.LogRequest = getOrDefault(v.GetBool("log-request"), defaultConf.LogRequest)
+	c.CORSOrigins = getOrDefault(v.GetString("cors-origins"), defaultConf.CORSOrigins)
+	c.RateLimit = getOrDefault(v.GetInt("rate-limit"), defaultConf.RateLimit)
+	c.ShutdownTimeout = getOrDefault(v.GetDuration("shutdown-timeout"), defaultConf.ShutdownTimeout)
+	c.Mailer = getOrDefault(v.GetString("mailer"), defaultConf.Mailer)
+	c.MailDir = getOrDefault(v.GetString("mail-dir"), defaultConf.MailDir)
+	c.MailFrom = getOrDefault(v.GetString("mail-from"), defaultConf.MailFrom)
+	c.SMTPHost = getOrDefault(v.GetString("smtp-host"), defaultConf.SMTPHost)
+	c.SMTPPort = getOrDefault(v.GetInt("smtp-port"), defaultConf.SMTPPort)
+	c.SMTPUsername = getOrDefault(v.GetString("smtp-username"), defaultConf.SMTPUsername)
+	c.SMTPPassword = getOrDefault(v.GetString("smtp-password"), defaultConf.SMTPPassword)
+	c.VerifyEmail = getOrDefault(v.GetString("verify-email"), defaultConf.VerifyEmail)
+	c.LockoutThreshold = setOrDefault(v, "lockout-threshold", v.GetInt, defaultConf.LockoutThreshold)
+	c.LockoutDuration = getOrDefault(v.GetDuration("lockout-duration"), defaultConf.LockoutDuration)
+	c.LockoutIPThreshold = setOrDefault(v, "lockout-ip-threshold", v.GetInt, defaultConf.LockoutIPThreshold)
+	c.ImpersonationDuration = getOrDefault(v.GetDuration("impersonation-duration"), defaultConf.ImpersonationDuration)
+	c.PasswordMinLength = getOrDefault(v.GetInt("password-min-length"), defaultConf.PasswordMinLength)
+	c.PasswordMaxLength = getOrDefault(v.GetInt("password-max-length"), defaultConf.PasswordMaxLength)
+	c.PasswordClasses = getOrDefault(v.GetInt("password-classes"), defaultConf.PasswordClasses)
+	c.PasswordHistory = getOrDefault(v.GetInt("password-history"), defaultConf.PasswordHistory)
+	c.PasswordBreachedFile = getOrDefault(v.GetString("password-breached-file"), defaultConf.PasswordBreachedFile)
+	c.PasswordHashTime = getOrDefault(v.GetUint32("password-hash-time"), defaultConf.PasswordHashTime)
+	c.PasswordHashMemory = getOrDefault(v.GetUint32("password-hash-memory"), defaultConf.PasswordHashMemory)
+	c.PasswordHashThreads = getOrDefault(uint8(v.GetUint("password-hash-threads")), defaultConf.PasswordHashThreads)
+	c.LDAPURL = getOrDefault(v.GetString("ldap-url"), defaultConf.LDAPURL)
+	c.LDAPStartTLS = getOrDefault(v.GetBool("ldap-start-tls"), defaultConf.LDAPStartTLS)
+	c.LDAPCAFile = getOrDefault(v.GetString("ldap-ca-file"), defaultConf.LDAPCAFile)
+	c.LDAPBindDN = getOrDefault(v.GetString("ldap-bind-dn"), defaultConf.LDAPBindDN)
+	c.LDAPBindPassword = getOrDefault(v.GetString("ldap-bind-password"), defaultConf.LDAPBindPassword)
+	c.LDAPUserDN = getOrDefault(v.GetString("ldap-user-dn"), defaultConf.LDAPUserDN)
+	c.LDAPBaseDN = getOrDefault(v.GetString("ldap-base-dn"), defaultConf.LDAPBaseDN)
+	c.LDAPUserFilter = getOrDefault(v.GetString("ldap-user-filter"), defaultConf.LDAPUserFilter)
+	c.LDAPSyncFilter = getOrDefault(v.GetString("ldap-sync-filter"), defaultConf.LDAPSyncFilter)
+	c.LDAPEmailAttr = getOrDefault(v.GetString("ldap-email-attr"), defaultConf.LDAPEmailAttr)
+	c.LDAPFirstNameAttr = getOrDefault(v.GetString("ldap-firstname-attr"), defaultConf.LDAPFirstNameAttr)
+	c.LDAPLastNameAttr = getOrDefault(v.GetString("ldap-lastname-attr"), defaultConf.LDAPLastNameAttr)
+	c.LDAPGroupAttr = getOrDefault(v.GetString("ldap-group-attr"), defaultConf.LDAPGroupAttr)
+	c.LDAPGroupRoles = getOrDefault(v.GetStringSlice("ldap-group-role"), defaultConf.LDAPGroupRoles)
+	c.LDAPSyncInterval = getOrDefault(v.GetDuration("ldap-sync-interval"), defaultConf.LDAPSyncInterval)
 
 	return c
 }
@@ -104,3 +258,13 @@ func getOrDefault[T interface{}](val T, defaultVal T) T {
 
 	return defaultVal
 }
+
+// setOrDefault is getOrDefault for keys whose zero value means something,
+// it takes key with get whenever key is set.
+func setOrDefault[T interface{}](v *viper.Viper, key string, get func(string) T, defaultVal T) T {
+	if v.IsSet(key) {
+		return get(key)
+	}
+
+	return defaultVal
+}
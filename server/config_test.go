@@ -63,6 +63,20 @@ func Test_parseViper(t *testing.T) {
 	}
 }
 
+func Test_parseViper_lockout(t *testing.T) {
+	v := viper.New()
+	v.Set("lockout-threshold", 0)
+
+	// a threshold set to 0 turns the lockout off, one left out is the default
+	config := parseViper(v, &Config{LockoutThreshold: 10, LockoutIPThreshold: 100})
+	if config.LockoutThreshold != 0 {
+		t.Errorf("LockoutThreshold = %d, want 0", config.LockoutThreshold)
+	}
+	if config.LockoutIPThreshold != 100 {
+		t.Errorf("LockoutIPThreshold = %d, want 100", config.LockoutIPThreshold)
+	}
+}
+
 func Test_getOrDefault_int(t *testing.T) {
 	type args[T interface{}] struct {
 		val        T
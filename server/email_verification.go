@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/mailer"
+	"github.com/9d4/semaphore/user"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/cast"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+// What an unverified email address blocks.
+const (
+	verifyEmailOff   = "off"
+	verifyEmailLogin = "login"
+	verifyEmailOAuth = "oauth"
+)
+
+// verificationResendInterval is how often a verification mail can be sent
+// to the same address.
+const verificationResendInterval = time.Minute
+
+var (
+	ErrInvalidVerifyEmail    = errors.New("invalid verify-email, want off, login or oauth")
+	errVerificationThrottled = errors.New("verification mail sent too recently")
+)
+
+var verificationMailTemplate = template.Must(template.New("verification_mail").Parse(`Hi {{.FirstName}},
+
+Please verify your email address by opening this link:
+
+{{.Link}}
+
+The link expires in 24 hours. If you didn't create a Semaphore account,
+you can ignore this email.
+`))
+
+var emailChangeMailTemplate = template.Must(template.New("email_change_mail").Parse(`Hi {{.FirstName}},
+
+To use this address for your Semaphore account, open this link:
+
+{{.Link}}
+
+The link expires in 24 hours. Until then your account keeps its current
+address. If you didn't ask for this, you can ignore this email.
+`))
+
+var emailChangedMailTemplate = template.Must(template.New("email_changed_mail").Parse(`Hi {{.FirstName}},
+
+The email address of your Semaphore account has just been changed to
+{{.NewEmail}}, this address won't receive its emails anymore.
+
+If you didn't do this, reset your password right away and contact your
+administrator.
+`))
+
+// emailVerifier sends verification links and checks them when the user
+// follows one.
+type emailVerifier struct {
+	users  user.Store
+	rdb    *redis.Client
+	mailer mailer.Mailer
+	key    []byte
+	issuer string
+	mode   string
+}
+
+func newEmailVerifier(db *gorm.DB, rdb *redis.Client, m mailer.Mailer, config *Config) (*emailVerifier, error) {
+	switch config.VerifyEmail {
+	case verifyEmailOff, verifyEmailLogin, verifyEmailOAuth:
+	default:
+		return nil, ErrInvalidVerifyEmail
+	}
+
+	return &emailVerifier{
+		users:  user.NewStore(db),
+		rdb:    rdb,
+		mailer: m,
+		key:    config.KeyBytes,
+		issuer: config.Issuer,
+		mode:   config.VerifyEmail,
+	}, nil
+}
+
+// send mails usr a verification link, unless one was sent to the same
+// address less than verificationResendInterval ago.
+func (v *emailVerifier) send(ctx context.Context, usr user.User) error {
+	ok, err := v.rdb.SetNX(ctx, verificationThrottleKey(usr), 1, verificationResendInterval).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errVerificationThrottled
+	}
+
+	token, err := auth.GenerateEmailVerificationToken(usr, v.key)
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(v.issuer, "/") + "/auth/verify-email?token=" + url.QueryEscape(token)
+	return sendTemplate(ctx, v.mailer, usr.Email, "Verify your email address", verificationMailTemplate, struct {
+		FirstName string
+		Link      string
+	}{
+		FirstName: usr.FirstName,
+		Link:      link,
+	})
+}
+
+// verificationThrottleKey is what verification links mailed to usr are
+// throttled by, the same address can belong to a user of every tenant.
+func verificationThrottleKey(usr user.User) string {
+	return fmt.Sprintf("email_verification:throttle:%d:%s", usr.TenantID, strings.ToLower(usr.Email))
+}
+
+// sendOnRegister mails the first verification link, failing to do so
+// doesn't fail the registration since the user can ask for another.
+func (v *emailVerifier) sendOnRegister(ctx context.Context, usr user.User) {
+	if err := v.send(ctx, usr); err != nil {
+		jww.ERROR.Println("unable to send verification mail:", err)
+	}
+}
+
+// handleResend sends another verification link to the user of the tenant
+// in the path with the address in the body. The reply is the same whether
+// or not there is such an unverified account, so it can't be used to find
+// accounts.
+func (v *emailVerifier) handleResend(c *fiber.Ctx) error {
+	body := struct {
+		Email string `json:"email"`
+	}{}
+	if err := c.BodyParser(&body); err != nil || body.Email == "" {
+		return fiber.ErrBadRequest
+	}
+
+	usr, err := v.users.ForTenant(tenantOf(c).ID).UserByEmail(body.Email)
+	if err == nil && !usr.EmailVerified() {
+		err = v.send(c.UserContext(), *usr)
+	}
+	if err != nil && !errors.Is(err, user.ErrUserNotFound) && !errors.Is(err, errVerificationThrottled) {
+		jww.ERROR.Println("unable to resend verification mail:", err)
+	}
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// handleVerify marks the address in the link as verified and sends the
+// browser to the login page telling how it went.
+func (v *emailVerifier) handleVerify(c *fiber.Ctx) error {
+	if err := v.verify(c.Query("token")); err != nil {
+		if !errors.Is(err, errs.ErrEmailVerificationInvalid) {
+			jww.ERROR.Println("unable to verify email:", err)
+		}
+		return c.Redirect("/login?email_verified=0")
+	}
+
+	return c.Redirect("/login?email_verified=1")
+}
+
+func (v *emailVerifier) verify(rawToken string) error {
+	token, err := auth.ValidateEmailVerificationToken(rawToken, auth.DefaultJwtKeyFunc(v.key))
+	if err != nil {
+		return errs.ErrEmailVerificationInvalid
+	}
+
+	// the address must still be the one the link was sent to
+	tx := v.users.DB().Model(&user.User{}).
+		Where("id = ? AND email = ?", cast.ToUint(token.Subject), token.Email).
+		Where("email_verified_at IS NULL").
+		Update("email_verified_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.RowsAffected == 0 {
+		// following the link twice is fine
+		usr, err := v.users.UserByID(cast.ToUint(token.Subject))
+		if err != nil || usr.Email != token.Email || !usr.EmailVerified() {
+			return errs.ErrEmailVerificationInvalid
+		}
+	}
+
+	return nil
+}
+
+// sendChange mails usr a link to switch to newEmail, which is verified by
+// following it.
+func (v *emailVerifier) sendChange(ctx context.Context, usr user.User, newEmail string) error {
+	token, err := auth.GenerateEmailChangeToken(usr, newEmail, v.key)
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(v.issuer, "/") + "/auth/change-email?token=" + url.QueryEscape(token)
+	return sendTemplate(ctx, v.mailer, newEmail, "Confirm your new email address", emailChangeMailTemplate, struct {
+		FirstName string
+		Link      string
+	}{
+		FirstName: usr.FirstName,
+		Link:      link,
+	})
+}
+
+// handleChange switches to the address in the link and sends the browser
+// to the login page telling how it went.
+func (v *emailVerifier) handleChange(c *fiber.Ctx) error {
+	usr, err := v.change(c.Query("token"))
+	if err != nil {
+		if !errors.Is(err, errs.ErrEmailVerificationInvalid) {
+			jww.ERROR.Println("unable to change email:", err)
+		}
+		return c.Redirect("/login?email_changed=0")
+	}
+	e := userEvent("email_changed", usr)
+	e.Metadata = map[string]interface{}{"email": usr.Email}
+	auditEvent(c, v.users.DB(), e)
+
+	return c.Redirect("/login?email_changed=1")
+}
+
+// change switches the user in rawToken to its new address, now verified,
+// and tells the old address.
+func (v *emailVerifier) change(rawToken string) (*user.User, error) {
+	token, err := auth.ValidateEmailChangeToken(rawToken, auth.DefaultJwtKeyFunc(v.key))
+	if err != nil {
+		return nil, errs.ErrEmailVerificationInvalid
+	}
+
+	usr, err := v.users.UserByID(cast.ToUint(token.Subject))
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil, errs.ErrEmailVerificationInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// taken within the tenant since the link was sent
+	if _, err := v.users.ForTenant(usr.TenantID).UserByEmail(token.NewEmail); !errors.Is(err, user.ErrUserNotFound) {
+		if err == nil {
+			return nil, errs.ErrEmailVerificationInvalid
+		}
+		return nil, err
+	}
+
+	tx := v.users.DB().Model(&user.User{}).
+		Where("id = ? AND email = ?", cast.ToUint(token.Subject), token.Email).
+		Updates(map[string]interface{}{
+			"email":             token.NewEmail,
+			"email_verified_at": time.Now(),
+		})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, errs.ErrEmailVerificationInvalid
+	}
+
+	usr, err = v.users.UserByID(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sendTemplate(context.Background(), v.mailer, token.Email, "Your email address has been changed", emailChangedMailTemplate, struct {
+		FirstName string
+		NewEmail  string
+	}{
+		FirstName: usr.FirstName,
+		NewEmail:  usr.Email,
+	})
+	if err != nil {
+		jww.ERROR.Println("unable to send email changed mail:", err)
+	}
+
+	return usr, nil
+}
+
+// allowLogin returns ErrEmailNotVerified when usr can't sign in before
+// verifying its address.
+func (v *emailVerifier) allowLogin(usr user.User) error {
+	if v.mode == verifyEmailLogin && !usr.EmailVerified() {
+		return errs.ErrEmailNotVerified
+	}
+	return nil
+}
+
+// allowAuthorization reports whether usr can authorize OAuth clients.
+func (v *emailVerifier) allowAuthorization(usr user.User) bool {
+	return v.mode == verifyEmailOff || usr.EmailVerified()
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_emailVerifier_handleVerify(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	v, err := newEmailVerifier(db, nil, nil, &Config{KeyBytes: key, VerifyEmail: verifyEmailLogin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usr := &user.User{Email: "verify@example.com"}
+	if err := v.users.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.allowLogin(*usr); err != errs.ErrEmailNotVerified {
+		t.Errorf("allowLogin() before verifying error = %v, want %v", err, errs.ErrEmailNotVerified)
+	}
+
+	token, err := auth.GenerateEmailVerificationToken(*usr, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := *usr
+	changed.Email = "old@example.com"
+	staleToken, err := auth.GenerateEmailVerificationToken(changed, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfaToken, err := auth.GenerateMFAToken(*usr, false, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/auth/verify-email", v.handleVerify)
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{name: "other address", token: staleToken, want: "/login?email_verified=0"},
+		{name: "mfa token", token: mfaToken, want: "/login?email_verified=0"},
+		{name: "garbage", token: "abc", want: "/login?email_verified=0"},
+		{name: "valid", token: token, want: "/login?email_verified=1"},
+		{name: "followed twice", token: token, want: "/login?email_verified=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := app.Test(httptest.NewRequest("GET", "/auth/verify-email?token="+tt.token, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res.Header.Get("Location"); got != tt.want {
+				t.Errorf("Location = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	verified, err := v.users.UserByID(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.allowLogin(*verified); err != nil {
+		t.Errorf("allowLogin() after verifying error = %v", err)
+	}
+}
+
+func Test_emailVerifier_allowAuthorization(t *testing.T) {
+	unverified := user.User{}
+	tests := []struct {
+		mode string
+		want bool
+	}{
+		{mode: verifyEmailOff, want: true},
+		{mode: verifyEmailLogin, want: false},
+		{mode: verifyEmailOAuth, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			v := &emailVerifier{mode: tt.mode}
+			if got := v.allowAuthorization(unverified); got != tt.want {
+				t.Errorf("allowAuthorization() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newEmailVerifier(nil, nil, nil, &Config{VerifyEmail: "always"}); err != ErrInvalidVerifyEmail {
+		t.Errorf("newEmailVerifier() error = %v, want %v", err, ErrInvalidVerifyEmail)
+	}
+}
+
+func Test_emailVerifier_handleResend(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &tenant.Tenant{}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	m := &recordingMailer{}
+	v, err := newEmailVerifier(db, newThrottleRedis(), m, &Config{KeyBytes: key, VerifyEmail: verifyEmailLogin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acme := &tenant.Tenant{Slug: "acme", Name: "Acme"}
+	if err := tenant.NewStore(db).Create(acme); err != nil {
+		t.Fatal(err)
+	}
+	inDefault := &user.User{Email: "verify@example.com"}
+	if err := v.users.Create(inDefault); err != nil {
+		t.Fatal(err)
+	}
+	inAcme := &user.User{Email: "verify@example.com"}
+	if err := v.users.ForTenant(acme.ID).Create(inAcme); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/auth/verify-email/resend", v.handleResend)
+	app.Post("/t/:tenant/auth/verify-email/resend", resolveTenant(db), v.handleResend)
+
+	tests := []struct {
+		name   string
+		path   string
+		wantTo *user.User
+	}{
+		{name: "default tenant", path: "/auth/verify-email/resend", wantTo: inDefault},
+		{name: "other tenant", path: "/t/acme/auth/verify-email/resend", wantTo: inAcme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.sent = nil
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(`{"email":"verify@example.com"}`))
+			req.Header.Set("Content-Type", "application/json")
+			res, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != fiber.StatusAccepted {
+				t.Fatalf("status = %d, want %d", res.StatusCode, fiber.StatusAccepted)
+			}
+			if len(m.sent) != 1 {
+				t.Fatalf("sent mails = %v, want one", m.sent)
+			}
+
+			// the link verifies the user of the tenant asked for
+			link := regexp.MustCompile(`token=([^\s"&]+)`).FindStringSubmatch(m.sent[0].Text)
+			if link == nil {
+				t.Fatalf("mail %q has no link", m.sent[0].Text)
+			}
+			token, _ := url.QueryUnescape(link[1])
+			if err := v.verify(token); err != nil {
+				t.Fatalf("verify() error = %v", err)
+			}
+			if got, _ := v.users.UserByID(tt.wantTo.ID); got == nil || !got.EmailVerified() {
+				t.Errorf("user %d verified = false, want true", tt.wantTo.ID)
+			}
+		})
+	}
+}
+
+// newThrottleRedis is a redis client that lets every SETNX, a SET NX
+// when it expires, through without a server, for code that only
+// throttles with redis.
+func newThrottleRedis() *redis.Client {
+	rdb := redis.NewClient(&redis.Options{})
+	rdb.AddHook(throttleHook{})
+	return rdb
+}
+
+type throttleHook struct{}
+
+func (throttleHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (throttleHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd, ok := cmd.(*redis.BoolCmd); ok && (cmd.Name() == "setnx" || cmd.Name() == "set") {
+			cmd.SetVal(true)
+			return nil
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (throttleHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/federation"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+// federationCookie keeps the state of a sign in through a connector while
+// the browser is away at the provider.
+const federationCookie = "federation"
+
+// federatedAMR are the methods of a sign in through an upstream provider.
+var federatedAMR = []string{auth.AMRFederated}
+
+// tenantPath is where t serves its sign in and OAuth2 endpoints, empty for
+// the default tenant.
+func tenantPath(t *tenant.Tenant) string {
+	if t.IsDefault() {
+		return ""
+	}
+	return "/t/" + t.Slug
+}
+
+// federationRedirectURI is where the provider of the connector with the
+// specified slug sends the browser back to t.
+func (s *server) federationRedirectURI(t *tenant.Tenant, slug string) string {
+	return strings.TrimSuffix(s.Issuer, "/") + tenantPath(t) + "/auth/federation/" + slug + "/callback"
+}
+
+// handleConnectors lists the providers users of the tenant can sign in
+// with.
+func (s *server) handleConnectors(c *fiber.Ctx) error {
+	connectors, err := federation.NewStore(s.db).Connectors(tenantOf(c).ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	type provider struct {
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+	}
+	providers := make([]provider, 0, len(connectors))
+	for _, conn := range connectors {
+		providers = append(providers, provider{Slug: conn.Slug, Name: conn.Name})
+	}
+	return c.JSON(providers)
+}
+
+// handleFederationStart sends the browser to sign in with the provider of
+// the connector param. The return query is the one of the sign in page,
+// the browser is sent back with it.
+func (s *server) handleFederationStart(c *fiber.Ctx) error {
+	t := tenantOf(c)
+	conn, err := federation.NewStore(s.db).Connector(t.ID, c.Params("connector"))
+	if errors.Is(err, federation.ErrConnectorNotFound) {
+		return errs.WriteErrorJSON(c, errs.ErrConnectorNotFound)
+	}
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	ret := c.Query("return")
+	if _, err := url.ParseQuery(ret); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	prov, err := s.providers.Provider(c.UserContext(), conn)
+	if err != nil {
+		jww.ERROR.Println("unable to reach the provider of", conn.Slug+":", err)
+		return errs.WriteErrorJSON(c, errs.ErrFederationFailed)
+	}
+
+	state, err := federation.NewState(t.ID, conn.Slug, ret)
+	if err != nil {
+		return err
+	}
+	signed, err := state.Sign(s.KeyBytes)
+	if err != nil {
+		return err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     federationCookie,
+		Value:    signed,
+		Path:     tenantPath(t) + "/auth/federation/",
+		Expires:  time.Now().Add(federation.StateExpiration),
+		HTTPOnly: true,
+		// the provider sends the browser back with a top level navigation
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(prov.AuthCodeURL(s.federationRedirectURI(t, conn.Slug), state), fiber.StatusFound)
+}
+
+// handleFederationCallback completes a sign in the provider has sent the
+// browser back from. The identity signs in the user it is linked to, or is
+// linked to the user of the tenant with the same verified address, or
+// provisions a new user when the connector allows it.
+func (s *server) handleFederationCallback(c *fiber.Ctx) error {
+	t := tenantOf(c)
+	slug := c.Params("connector")
+
+	state, err := federation.ParseState(c.Cookies(federationCookie), s.KeyBytes)
+	c.Cookie(&fiber.Cookie{
+		Name:     federationCookie,
+		Path:     tenantPath(t) + "/auth/federation/",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+	})
+	if err != nil || state.State != c.Query("state") || state.TenantID != t.ID || state.Connector != slug {
+		auditFederationFailure(c, s.db, slug, "", "state_invalid")
+		return s.federationFailed(c, t, "", errs.ErrFederationFailed)
+	}
+
+	if reason := c.Query("error"); reason != "" {
+		auditFederationFailure(c, s.db, slug, "", reason)
+		return s.federationFailed(c, t, state.Return, errs.ErrFederationFailed)
+	}
+
+	connectors := federation.NewStore(s.db)
+	conn, err := connectors.Connector(t.ID, slug)
+	if err != nil {
+		auditFederationFailure(c, s.db, slug, "", "connector_not_found")
+		return s.federationFailed(c, t, state.Return, errs.ErrConnectorNotFound)
+	}
+
+	prov, err := s.providers.Provider(c.UserContext(), conn)
+	var ext *federation.ExternalUser
+	if err == nil {
+		ext, err = prov.Exchange(c.UserContext(), s.federationRedirectURI(t, slug), c.Query("code"), state)
+	}
+	if err != nil {
+		jww.ERROR.Println("unable to sign in with", slug+":", err)
+		auditFederationFailure(c, s.db, slug, "", "exchange_failed")
+		return s.federationFailed(c, t, state.Return, errs.ErrFederationFailed)
+	}
+
+	if !conn.AllowsEmail(ext.Email) {
+		auditFederationFailure(c, s.db, slug, ext.Email, "domain_not_allowed")
+		return s.federationFailed(c, t, state.Return, errs.ErrFederationDomainNotAllowed)
+	}
+
+	usr, err := s.federatedUser(c, conn, ext)
+	if err != nil {
+		var e *errs.Error
+		if !errors.As(err, &e) {
+			jww.ERROR.Println("unable to sign in with", slug+":", err)
+			e = errs.ErrFederationFailed
+		}
+		auditFederationFailure(c, s.db, slug, ext.Email, e.ErrorID)
+		return s.federationFailed(c, t, state.Return, e)
+	}
+
+	if err := allowLogin(s.verifier, *usr); err != nil {
+		var e *errs.Error
+		if !errors.As(err, &e) {
+			return replyError(c, err)
+		}
+		return s.federationFailed(c, t, state.Return, e)
+	}
+
+	q, _ := url.ParseQuery(state.Return)
+	pending, err := s.mfa.challenge(*usr)
+	if err != nil {
+		return replyError(c, err)
+	}
+	if pending != nil {
+		// the sign in page asks for the second factor
+		if !t.IsDefault() {
+			q.Set("tenant", t.Slug)
+		}
+		q.Set("mfa_status", pending.Status)
+		q.Set("mfa_token", pending.MFAToken)
+		q.Set("mfa_methods", strings.Join(pending.Methods, ","))
+		return c.Redirect("/login?"+q.Encode(), fiber.StatusFound)
+	}
+
+	if _, err := s.startSession(c, *usr, auth.ACRPassword, federatedAMR); err != nil {
+		return err
+	}
+
+	if q.Get("from") == "oauth_authorize" {
+		return c.Redirect(tenantPath(t)+"/oauth2/authorize?"+state.Return, fiber.StatusFound)
+	}
+	return c.Redirect("/", fiber.StatusFound)
+}
+
+// federatedUser gets the user ext signs in as through conn, linking ext
+// to it.
+func (s *server) federatedUser(c *fiber.Ctx, conn *federation.Connector, ext *federation.ExternalUser) (*user.User, error) {
+	connectors := federation.NewStore(s.db)
+	users := user.NewStore(s.db).ForTenant(conn.TenantID)
+
+	identity, err := connectors.Identity(conn.ID, ext.Subject)
+	switch {
+	case err == nil:
+		usr, err := user.NewStore(s.db).UserByID(identity.UserID)
+		if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+			return nil, err
+		}
+		if err == nil && usr.TenantID == conn.TenantID {
+			identity.Email = ext.Email
+			return usr, connectors.Link(identity)
+		}
+		// the user it was linked to is gone, link it again
+	case !errors.Is(err, federation.ErrIdentityNotFound):
+		return nil, err
+	}
+
+	// an address the provider hasn't verified could belong to anyone
+	if !ext.EmailVerified || ext.Email == "" {
+		return nil, errs.ErrFederationEmailUnverified
+	}
+
+	usr, err := users.UserByEmail(ext.Email)
+	if errors.Is(err, user.ErrUserNotFound) {
+		if !conn.Provision {
+			return nil, errs.ErrFederationAccountNotFound
+		}
+
+		now := time.Now()
+		usr = &user.User{
+			Email:           ext.Email,
+			FirstName:       ext.FirstName,
+			LastName:        ext.LastName,
+			EmailVerifiedAt: &now,
+		}
+		if err := users.Create(usr); err != nil {
+			return nil, err
+		}
+
+		e := userEvent("user_provisioned", usr)
+		e.Metadata = map[string]interface{}{"connector": conn.Slug}
+		auditEvent(c, s.db, e)
+	} else if err != nil {
+		return nil, err
+	} else if !usr.EmailVerified() {
+		// the provider has verified the address for us
+		now := time.Now()
+		if err := s.db.Model(usr).Update("email_verified_at", now).Error; err != nil {
+			return nil, err
+		}
+		usr.EmailVerifiedAt = &now
+	}
+
+	if err := connectors.Link(&federation.Identity{
+		ConnectorID: conn.ID,
+		Subject:     ext.Subject,
+		UserID:      usr.ID,
+		Email:       ext.Email,
+	}); err != nil {
+		return nil, err
+	}
+
+	e := userEvent("identity_linked", usr)
+	e.Metadata = map[string]interface{}{"connector": conn.Slug, "subject": ext.Subject}
+	auditEvent(c, s.db, e)
+	return usr, nil
+}
+
+// federationFailed sends the browser back to the sign in page it started
+// from, telling it why the sign in failed.
+func (s *server) federationFailed(c *fiber.Ctx, t *tenant.Tenant, ret string, e *errs.Error) error {
+	q, _ := url.ParseQuery(ret)
+	if !t.IsDefault() {
+		q.Set("tenant", t.Slug)
+	}
+	q.Set("federation_error", e.ErrorID)
+	return c.Redirect("/login?"+q.Encode(), fiber.StatusFound)
+}
+
+// auditFederationFailure records a failed sign in through the connector
+// with the specified slug, and why it failed.
+func auditFederationFailure(c *fiber.Ctx, db *gorm.DB, slug, email, reason string) {
+	auditEvent(c, db, audit.Event{
+		TenantID: tenantOf(c).ID,
+		Actor:    email,
+		Action:   "login",
+		Outcome:  audit.OutcomeFailure,
+		Metadata: map[string]interface{}{"reason": reason, "connector": slug},
+	})
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/federation"
+	"github.com/9d4/semaphore/mfa"
+	"github.com/9d4/semaphore/passkey"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_server_federation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &mfa.TOTP{}, &mfa.RecoveryCode{}, &passkey.Credential{},
+		&session.Session{}, &session.Client{}, &federation.Connector{}, &federation.Identity{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the provider signs in whoever has the claims of the next sign in
+	var claims jwt.MapClaims
+	mux := http.NewServeMux()
+	idp := httptest.NewServer(mux)
+	defer idp.Close()
+	nonces := map[string]string{}
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		c := jwt.MapClaims{"iss": idp.URL, "aud": "semaphore", "exp": time.Now().Add(time.Minute).Unix(), "nonce": nonces[r.FormValue("code")]}
+		for k, v := range claims {
+			c[k] = v
+		}
+		idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte("idp"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+	})
+
+	connectors := federation.NewStore(db)
+	for _, conn := range []*federation.Connector{
+		{Slug: "corp", Name: "Corp", Kind: federation.KindOIDC, Issuer: idp.URL, ClientID: "semaphore", Provision: true, Domains: []string{"acme.com"}},
+		{Slug: "partner", Name: "Partner", Kind: federation.KindOIDC, Issuer: idp.URL, ClientID: "semaphore"},
+	} {
+		if err := connectors.Create(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jane := &user.User{Email: "jane@example.com", FirstName: "Jane", LastName: "Doe"}
+	if err := user.NewStore(db).Create(jane); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	s := &server{
+		Config:    &Config{Issuer: "http://semaphore.test", KeyBytes: key},
+		db:        db,
+		v:         viper.New(),
+		sessions:  session.NewDBStore(db, time.Hour),
+		mfa:       newMFAChallenge(db, newMemLoginGuard(), key),
+		verifier:  &emailVerifier{mode: verifyEmailLogin},
+		providers: federation.NewProviders(nil),
+	}
+	app := fiber.New()
+	s.setupLoginRoutes(app.Group("/auth"))
+
+	// signIn goes through the connector with the specified slug and returns
+	// where the browser ends up and the cookies it got.
+	signIn := func(t *testing.T, slug string, tamper func(q url.Values)) (*url.URL, map[string]string) {
+		req := httptest.NewRequest("GET", "/auth/federation/"+slug+"?return="+url.QueryEscape("from=oauth_authorize&client_id=app"), nil)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusFound {
+			t.Fatalf("start status = %d, want %d", res.StatusCode, fiber.StatusFound)
+		}
+
+		authURL, _ := url.Parse(res.Header.Get("Location"))
+		q := authURL.Query()
+		if q.Get("redirect_uri") != "http://semaphore.test/auth/federation/"+slug+"/callback" {
+			t.Fatalf("redirect_uri = %q", q.Get("redirect_uri"))
+		}
+		nonces["code"] = q.Get("nonce")
+
+		back := url.Values{"code": {"code"}, "state": {q.Get("state")}}
+		if tamper != nil {
+			tamper(back)
+		}
+		req = httptest.NewRequest("GET", "/auth/federation/"+slug+"/callback?"+back.Encode(), nil)
+		for _, cookie := range res.Cookies() {
+			req.AddCookie(cookie)
+		}
+		res, err = app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cookies := map[string]string{}
+		for _, cookie := range res.Cookies() {
+			cookies[cookie.Name] = cookie.Value
+		}
+		loc, _ := url.Parse(res.Header.Get("Location"))
+		return loc, cookies
+	}
+
+	tests := []struct {
+		name      string
+		slug      string
+		claims    jwt.MapClaims
+		tamper    func(q url.Values)
+		wantError string
+		wantUser  string
+	}{
+		{
+			name:     "provisioned",
+			slug:     "corp",
+			claims:   jwt.MapClaims{"sub": "1", "email": "john@acme.com", "email_verified": true, "given_name": "John", "family_name": "Roe"},
+			wantUser: "john@acme.com",
+		},
+		{
+			name:     "linked by verified email",
+			slug:     "partner",
+			claims:   jwt.MapClaims{"sub": "2", "email": "jane@example.com", "email_verified": true},
+			wantUser: "jane@example.com",
+		},
+		{
+			name:     "linked identity with another email",
+			slug:     "partner",
+			claims:   jwt.MapClaims{"sub": "2", "email": "jane@elsewhere.com"},
+			wantUser: "jane@example.com",
+		},
+		{
+			name:      "unverified email",
+			slug:      "partner",
+			claims:    jwt.MapClaims{"sub": "3", "email": "jane@example.com", "email_verified": false},
+			wantError: "federation_email_unverified",
+		},
+		{
+			name:      "no account",
+			slug:      "partner",
+			claims:    jwt.MapClaims{"sub": "4", "email": "nobody@example.com", "email_verified": true},
+			wantError: "federation_account_not_found",
+		},
+		{
+			name:      "domain not allowed",
+			slug:      "corp",
+			claims:    jwt.MapClaims{"sub": "5", "email": "jane@example.com", "email_verified": true},
+			wantError: "federation_domain_not_allowed",
+		},
+		{
+			name:      "state mismatch",
+			slug:      "corp",
+			claims:    jwt.MapClaims{"sub": "1", "email": "john@acme.com", "email_verified": true},
+			tamper:    func(q url.Values) { q.Set("state", "forged") },
+			wantError: "federation_failed",
+		},
+		{
+			name:      "denied at the provider",
+			slug:      "corp",
+			tamper:    func(q url.Values) { q.Set("error", "access_denied") },
+			wantError: "federation_failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims = tt.claims
+			loc, cookies := signIn(t, tt.slug, tt.tamper)
+
+			if tt.wantError != "" {
+				if loc.Path != "/login" || loc.Query().Get("federation_error") != tt.wantError {
+					t.Fatalf("redirected to %s, want the login page with %s", loc, tt.wantError)
+				}
+				if cookies["rt"] != "" {
+					t.Error("failed sign in set the refresh token cookie")
+				}
+				return
+			}
+
+			if loc.Path != "/oauth2/authorize" || loc.Query().Get("client_id") != "app" {
+				t.Errorf("redirected to %s, want back to authorize", loc)
+			}
+			rt, err := auth.ValidateRefreshToken(cookies["rt"], auth.DefaultJwtKeyFunc(key))
+			if err != nil {
+				t.Fatalf("refresh token cookie: %v", err)
+			}
+			sess, err := s.sessions.Session(context.Background(), rt.SessionID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			usr, err := user.NewStore(db).UserByID(sess.UserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usr.Email != tt.wantUser || len(sess.AMR) != 1 || sess.AMR[0] != auth.AMRFederated {
+				t.Errorf("signed in as %s with %v, want %s with %s", usr.Email, sess.AMR, tt.wantUser, auth.AMRFederated)
+			}
+		})
+	}
+
+	var provisioned user.User
+	if err := db.Where("email = ?", "john@acme.com").First(&provisioned).Error; err != nil {
+		t.Fatal(err)
+	}
+	if provisioned.FirstName != "John" || provisioned.LastName != "Roe" {
+		t.Errorf("provisioned %+v, want the names of the claims", provisioned)
+	}
+
+	res, err := app.Test(httptest.NewRequest("GET", "/auth/federation", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var providers []map[string]string
+	json.NewDecoder(res.Body).Decode(&providers)
+	if len(providers) != 2 || providers[0]["slug"] != "corp" || providers[0]["name"] != "Corp" {
+		t.Errorf("providers = %v, want corp and partner", providers)
+	}
+}
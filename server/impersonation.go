@@ -0,0 +1,231 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+// impersonationCookie keeps the refresh token of an impersonation next to
+// the "rt" cookie of the admin, which is renewed again once it's gone.
+const impersonationCookie = "irt"
+
+// handleImpersonate starts a session of the admin acting as the user with
+// the id param for ImpersonationDuration. Its tokens name the admin as
+// "act", users can't be impersonated by admins granted less than them.
+func (s *apiServer) handleImpersonate(c *fiber.Ctx) error {
+	body := struct {
+		Reason string `json:"reason"`
+	}{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+	}
+
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+	usr, err := s.adminTarget(c, false)
+	if err != nil {
+		return replyError(c, err)
+	}
+	if usr.DeletedAt.Valid {
+		return errs.WriteErrorJSON(c, errs.ErrUserNotFound)
+	}
+	if usr.Disabled() {
+		return errs.WriteErrorJSON(c, errs.ErrUserDisabled)
+	}
+
+	grants, err := rbac.NewStore(s.db).Grants(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+	for _, p := range grants.Permissions {
+		if !at.Can(p) {
+			return errs.WriteErrorJSON(c, errs.ErrImpersonationForbidden)
+		}
+	}
+
+	actor := auth.NewActor(user.User{ID: at.User.ID, Email: at.User.Email})
+	now := time.Now()
+	endsAt := now.Add(s.ImpersonationDuration)
+	sess := &session.Session{
+		UserID:    usr.ID,
+		ActorID:   at.User.ID,
+		AuthTime:  now,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+	}
+	if err := s.sessions.Create(c.UserContext(), sess); err != nil {
+		jww.ERROR.Println("unable to create session on impersonation:", err)
+		return fiber.ErrInternalServerError
+	}
+
+	tokenPair, err := s.generateImpersonationTokenPair(*usr, actor, sess.ID, sess.RefreshTokenID, endsAt)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	e := impersonationEvent("impersonation_started", usr, actor, sess.ID)
+	e.Metadata["expires_at"] = endsAt
+	if body.Reason != "" {
+		e.Metadata["reason"] = body.Reason
+	}
+	auditEvent(c, s.db, e)
+
+	c.Cookie(&fiber.Cookie{
+		Name:     impersonationCookie,
+		Value:    tokenPair["refresh_token"],
+		Domain:   s.v.GetString("cookie_domain"),
+		Expires:  now.Add(auth.RefreshTokenExpiration),
+		HTTPOnly: true,
+	})
+
+	return c.JSON(struct {
+		AccessToken  string    `json:"access_token"`
+		RefreshToken string    `json:"refresh_token"`
+		ExpiresAt    time.Time `json:"expires_at"`
+	}{tokenPair["access_token"], tokenPair["refresh_token"], endsAt})
+}
+
+// handleImpersonationEnd ends the impersonation the request is made from,
+// the admin renews its own tokens after.
+func (s *apiServer) handleImpersonationEnd(c *fiber.Ctx) error {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+	if at.Actor == nil {
+		return errs.WriteErrorJSON(c, errs.ErrNotImpersonating)
+	}
+
+	ctx := c.UserContext()
+	if sess, err := s.sessions.Session(ctx, at.SessionID); err == nil {
+		s.revoker.revokeSession(ctx, sess)
+	}
+	s.clearImpersonationCookie(c)
+
+	usr := &user.User{ID: at.User.ID, Email: at.User.Email, TenantID: at.User.TenantID}
+	e := impersonationEvent("impersonation_ended", usr, at.Actor, at.SessionID)
+	e.Metadata["reason"] = "ended"
+	auditEvent(c, s.db, e)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// endExpiredImpersonation drops irt, the impersonation refresh token that
+// no longer renews, and ends its session when it has run out of time.
+func (s *apiServer) endExpiredImpersonation(c *fiber.Ctx, irt string) {
+	s.clearImpersonationCookie(c)
+
+	rt, ok := auth.ExpiredRefreshToken(irt, auth.DefaultJwtKeyFunc(s.KeyBytes))
+	if !ok || rt.Actor == nil {
+		return
+	}
+
+	// the session is gone once the impersonation has been ended
+	ctx := c.UserContext()
+	sess, err := s.sessions.Session(ctx, rt.SessionID)
+	if err != nil {
+		return
+	}
+	s.revoker.revokeSession(ctx, sess)
+
+	usr := &user.User{ID: sess.UserID}
+	s.db.Unscoped().First(usr, sess.UserID)
+	e := impersonationEvent("impersonation_ended", usr, rt.Actor, sess.ID)
+	e.Metadata["reason"] = "expired"
+	auditEvent(c, s.db, e)
+}
+
+func (s *apiServer) clearImpersonationCookie(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     impersonationCookie,
+		Domain:   s.v.GetString("cookie_domain"),
+		MaxAge:   -1,
+		HTTPOnly: true,
+	})
+}
+
+// generateImpersonationTokenPair mints the tokens of actor acting as usr,
+// which last until endsAt at most.
+func (s *apiServer) generateImpersonationTokenPair(usr user.User, actor *auth.Actor, sessionID string, refreshTokenID string, endsAt time.Time) (map[string]string, error) {
+	grants, err := rbac.NewStore(s.db).Grants(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	at, rt, err := auth.GenerateImpersonationTokenPair(usr, *grants, actor, sessionID, refreshTokenID, s.KeyBytes, endsAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"access_token":  at,
+		"refresh_token": rt,
+	}, nil
+}
+
+// auditImpersonation records every request that changes something made
+// while an admin impersonates a user, whether or not its handler records
+// an event of its own.
+func (s *apiServer) auditImpersonation(c *fiber.Ctx) error {
+	err := c.Next()
+
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return err
+	}
+	at, atErr := currentAccessToken(c)
+	if atErr != nil || at.Actor == nil {
+		return err
+	}
+
+	status := c.Response().StatusCode()
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+
+	e := audit.Event{
+		ActorID:    at.User.ID,
+		Actor:      at.User.Email,
+		TenantID:   at.User.TenantID,
+		Action:     "impersonated_request",
+		TargetType: audit.TargetUser,
+		TargetID:   userTarget(at.User.ID),
+		Metadata: map[string]interface{}{
+			"session": at.SessionID,
+			"method":  c.Method(),
+			"path":    c.OriginalURL(),
+			"status":  status,
+		},
+	}
+	if status >= fiber.StatusBadRequest {
+		e.Outcome = audit.OutcomeFailure
+	}
+	auditEvent(c, s.db, e)
+
+	return err
+}
+
+// impersonationEvent is an event of actor impersonating usr in the
+// session with sessionID.
+func impersonationEvent(action string, usr *user.User, actor *auth.Actor, sessionID string) audit.Event {
+	e := targetEvent(action, usr)
+	e.ActorID, e.Actor = actor.ID(), actor.Email
+	e.Metadata = map[string]interface{}{"session": sessionID}
+	return e
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/server/middleware"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_apiServer_impersonation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &audit.Event{}, &session.Session{}, &session.Client{}); err != nil {
+		t.Fatal(err)
+	}
+	roles := rbac.NewStore(db)
+	if err := roles.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := user.NewStore(db)
+	admin := &user.User{Email: "admin@example.com"}
+	alice := &user.User{Email: "alice@example.com"}
+	support := &user.User{Email: "support@example.com"}
+	for _, usr := range []*user.User{admin, alice, support} {
+		if err := users.Create(usr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := roles.AssignRole(admin.ID, rbac.AdminRole); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.SaveRole(&rbac.Role{Name: "support", Permissions: []string{rbac.PermUsersImpersonate}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.AssignRole(support.ID, "support"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	key := []byte("key")
+	s := newTestApiServer(db, &Config{KeyBytes: key, ImpersonationDuration: time.Minute})
+	sessions := s.sessions
+
+	bearerAuth := middleware.BearerAuth(key, nil)
+	app := fiber.New()
+	app.Use(s.auditImpersonation)
+	app.Post("/renew", s.handleRenew)
+	app.Post("/impersonation/end", bearerAuth, s.handleImpersonationEnd)
+	app.Post("/users/:userid/password", bearerAuth, requireSignIn, s.handleUsersPasswordChange)
+	app.Post("/users/:userid/note", bearerAuth, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	app.Post("/admin/users/:id/impersonate", bearerAuth, requireSignIn, middleware.RequirePermission(rbac.PermUsersImpersonate), s.handleImpersonate)
+
+	adminSess := &session.Session{UserID: admin.ID}
+	if err := sessions.Create(ctx, adminSess); err != nil {
+		t.Fatal(err)
+	}
+	grants, err := roles.Grants(admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken, adminRT, err := auth.GenerateTokenPair(*admin, *grants, adminSess.ID, adminSess.RefreshTokenID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(bearer string, path string, cookies map[string]string) (int, map[string]interface{}, map[string]string) {
+		buf, _ := json.Marshal(map[string]string{"reason": "ticket 42"})
+		req := httptest.NewRequest("POST", path, bytes.NewReader(buf))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		for name, value := range cookies {
+			req.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out map[string]interface{}
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		set := map[string]string{}
+		for _, cookie := range res.Cookies() {
+			set[cookie.Name] = cookie.Value
+		}
+		return res.StatusCode, out, set
+	}
+
+	if status, _, _ := do(grantedToken(t, db, key, support), "/admin/users/1/impersonate", nil); status != fiber.StatusForbidden {
+		t.Errorf("impersonate a user granted more = %d, want %d", status, fiber.StatusForbidden)
+	}
+	if status, _, _ := do(adminToken, "/admin/users/1/impersonate", nil); status != fiber.StatusBadRequest {
+		t.Errorf("impersonate yourself = %d, want %d", status, fiber.StatusBadRequest)
+	}
+
+	status, body, cookies := do(adminToken, "/admin/users/2/impersonate", nil)
+	if status != fiber.StatusOK || body["expires_at"] == nil || cookies[impersonationCookie] == "" {
+		t.Fatalf("impersonate = %d %v %v", status, body, cookies)
+	}
+	token := body["access_token"].(string)
+	claims, err := auth.ValidateAccessToken(token, auth.DefaultJwtKeyFunc(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.User.ID != alice.ID || claims.Actor == nil || claims.Actor.ID() != admin.ID {
+		t.Fatalf("impersonation claims = %+v, want alice acted on by admin", claims)
+	}
+
+	if status, _, _ := do(token, "/admin/users/3/impersonate", nil); status != fiber.StatusForbidden {
+		t.Errorf("impersonate while impersonating = %d, want %d", status, fiber.StatusForbidden)
+	}
+	if status, body, _ := do(token, "/users/2/password", nil); status != fiber.StatusForbidden || body["error"] != "impersonating" {
+		t.Errorf("change password while impersonating = %d %v, want %d", status, body, fiber.StatusForbidden)
+	}
+	if status, _, _ := do(token, "/users/2/note", nil); status != fiber.StatusNoContent {
+		t.Errorf("POST /users/2/note = %d, want %d", status, fiber.StatusNoContent)
+	}
+
+	// the impersonation is renewed in place of the session of the admin
+	status, body, renewed := do("", "/renew", map[string]string{"rt": adminRT, impersonationCookie: cookies[impersonationCookie]})
+	if status != fiber.StatusCreated || renewed[impersonationCookie] == "" {
+		t.Fatalf("renew impersonation = %d %v", status, renewed)
+	}
+	if claims, err := auth.ValidateAccessToken(body["access_token"].(string), auth.DefaultJwtKeyFunc(key)); err != nil || claims.Actor == nil {
+		t.Errorf("renewed claims = %+v %v, want the admin as actor", claims, err)
+	}
+
+	if status, _, ended := do(token, "/impersonation/end", nil); status != fiber.StatusNoContent || ended[impersonationCookie] != "" {
+		t.Fatalf("end impersonation = %d %v", status, ended)
+	}
+	if status, _, _ := do(adminToken, "/impersonation/end", nil); status != fiber.StatusBadRequest {
+		t.Errorf("end without impersonating = %d, want %d", status, fiber.StatusBadRequest)
+	}
+	status, body, _ = do("", "/renew", map[string]string{"rt": adminRT, impersonationCookie: renewed[impersonationCookie]})
+	if status != fiber.StatusCreated {
+		t.Fatalf("renew after ending = %d", status)
+	}
+	if claims, _ := auth.ValidateAccessToken(body["access_token"].(string), auth.DefaultJwtKeyFunc(key)); claims == nil || claims.User.ID != admin.ID || claims.Actor != nil {
+		t.Errorf("claims after ending = %+v, want the admin's own", claims)
+	}
+
+	// an impersonation running out of time ends on the next renew
+	s.ImpersonationDuration = time.Second
+	_, _, cookies = do(adminToken, "/admin/users/2/impersonate", nil)
+	time.Sleep(2 * time.Second)
+	status, body, _ = do("", "/renew", map[string]string{"rt": body["refresh_token"].(string), impersonationCookie: cookies[impersonationCookie]})
+	if status != fiber.StatusCreated {
+		t.Fatalf("renew after expiry = %d", status)
+	}
+	if claims, _ := auth.ValidateAccessToken(body["access_token"].(string), auth.DefaultJwtKeyFunc(key)); claims == nil || claims.Actor != nil {
+		t.Errorf("claims after expiry = %+v, want the admin's own", claims)
+	}
+
+	events, _, err := audit.NewStore(db).Events(audit.Query{ActorID: admin.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, e := range events {
+		got[e.Action]++
+		if e.Action == "impersonated_request" && e.Metadata["impersonating"] != float64(alice.ID) {
+			t.Errorf("impersonated request metadata = %v", e.Metadata)
+		}
+	}
+	for action, want := range map[string]int{"impersonation_started": 2, "impersonation_ended": 2, "impersonated_request": 4} {
+		if got[action] != want {
+			t.Errorf("%d %s events, want %d: %v", got[action], action, want, got)
+		}
+	}
+	if remaining, _ := sessions.Sessions(ctx, alice.ID); len(remaining) != 0 {
+		t.Errorf("%d sessions of alice left, want none", len(remaining))
+	}
+}
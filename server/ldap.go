@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/9d4/semaphore/directory"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+// userDirectory is what users are signed in and synced with,
+// directory.Directory in production.
+type userDirectory interface {
+	Authenticate(login, password string) (*directory.Entry, error)
+	Users() ([]*directory.Entry, error)
+	Roles(e *directory.Entry) []string
+	MappedRoles() []string
+}
+
+// ldapBackend signs the users of a directory in to the default tenant.
+// They are saved as users on their first sign in, and their names and
+// mapped roles are updated on every one after.
+type ldapBackend struct {
+	db  *gorm.DB
+	dir userDirectory
+}
+
+func newLDAPBackend(db *gorm.DB, dir userDirectory) *ldapBackend {
+	return &ldapBackend{db: db, dir: dir}
+}
+
+func (b *ldapBackend) authenticate(ctx context.Context, t *tenant.Tenant, email, password string) (*user.User, error) {
+	if !t.IsDefault() {
+		return nil, user.ErrUserNotFound
+	}
+
+	e, err := b.dir.Authenticate(email, password)
+	switch {
+	case errors.Is(err, directory.ErrInvalidCredentials):
+		return nil, errWrongPassword
+	case err != nil:
+		// local users can still sign in while the directory is down
+		if !errors.Is(err, directory.ErrUserNotFound) {
+			jww.ERROR.Println("unable to authenticate with the directory:", err)
+		}
+		return nil, user.ErrUserNotFound
+	}
+
+	return b.save(e)
+}
+
+// save saves e as a user of the default tenant with the roles its groups
+// map to, taking away the mapped roles it no longer has.
+func (b *ldapBackend) save(e *directory.Entry) (*user.User, error) {
+	users := user.NewStore(b.db).ForTenant(0)
+
+	usr, err := users.UserByEmail(e.Email)
+	switch {
+	case errors.Is(err, user.ErrUserNotFound):
+		// the directory vouches for the address
+		now := time.Now()
+		usr = &user.User{Email: e.Email, FirstName: e.FirstName, LastName: e.LastName, EmailVerifiedAt: &now}
+		if err := users.Create(usr); err != nil {
+			return nil, err
+		}
+
+		ev := targetEvent("user_provisioned", usr)
+		ev.Metadata = map[string]interface{}{"source": "ldap", "dn": e.DN}
+		recordAudit(b.db, ev)
+	case err != nil:
+		return nil, err
+	case usr.FirstName != e.FirstName || usr.LastName != e.LastName:
+		if err := users.UpdateProfile(usr.ID, e.FirstName, e.LastName); err != nil {
+			return nil, err
+		}
+		usr.FirstName, usr.LastName = e.FirstName, e.LastName
+	}
+
+	has := make(map[string]bool)
+	for _, role := range b.dir.Roles(e) {
+		has[role] = true
+	}
+
+	roles := rbac.NewStore(b.db)
+	for _, role := range b.dir.MappedRoles() {
+		if has[role] {
+			err = roles.AssignRole(usr.ID, role)
+		} else {
+			err = roles.UnassignRole(usr.ID, role)
+		}
+		if errors.Is(err, rbac.ErrRoleNotFound) {
+			jww.WARN.Println("role", role, "mapped from a directory group doesn't exist")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return usr, nil
+}
+
+// sync saves every user of the directory.
+func (b *ldapBackend) sync() (int, error) {
+	entries, err := b.dir.Users()
+	if err != nil {
+		return 0, err
+	}
+
+	synced := 0
+	for _, e := range entries {
+		if _, err := b.save(e); err != nil {
+			jww.ERROR.Println("unable to sync", e.DN+":", err)
+			continue
+		}
+		synced++
+	}
+	return synced, nil
+}
+
+// syncEvery syncs the users of the directory every interval until ctx is
+// done.
+func (b *ldapBackend) syncEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		synced, err := b.sync()
+		if err != nil {
+			jww.ERROR.Println("unable to sync the directory:", err)
+		} else {
+			jww.INFO.Println("Synced", synced, "directory users")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
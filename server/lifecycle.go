@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/federation"
+	"github.com/9d4/semaphore/passkey"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/store"
+	"github.com/9d4/semaphore/util"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Server runs the semaphore listeners and owns the connections they share.
+type Server struct {
+	config *Config
+
+	db        *gorm.DB
+	dbLogFile *os.File
+	rdb       *redis.Client
+
+	srv   *server
+	oauth *oauthServer
+	// ldap syncs the users of the directory, nil without one
+	ldap *ldapBackend
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+// New connects to the database and redis and sets up the listeners
+// without starting them.
+func New(opts ...Option) (*Server, error) {
+	config := &Config{}
+
+	if len(opts) < 1 {
+		config = &(*defaultConfig)
+	}
+
+	sort.Slice(opts, func(i, j int) bool {
+		_, isConfig := opts[i].(*Config)
+		_, isConfig2 := opts[j].(*Config)
+		return isConfig && !isConfig2
+	})
+
+	for _, opt := range opts {
+		if opt != nil {
+			if applyErr := opt.Apply(config); applyErr != nil {
+				return nil, applyErr
+			}
+		}
+	}
+
+	err := util.SetHashParams(util.HashParams{
+		TimeCost:    config.PasswordHashTime,
+		MemoryCost:  config.PasswordHashMemory,
+		Parallelism: config.PasswordHashThreads,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dbLogFile, err := os.OpenFile("semaphore.db.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Println("Unable to create log file:", err)
+	}
+	dbLogger := logger.New(
+		log.New(dbLogFile, "\r\n", log.LstdFlags), // io writer
+		logger.Config{
+			SlowThreshold:             time.Millisecond, // Slow SQL threshold
+			LogLevel:                  logger.Info,      // Log level
+			IgnoreRecordNotFoundError: true,             // Ignore ErrRecordNotFound error for logger
+			Colorful:                  false,            // Disable color
+		},
+	)
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		config.DBHost,
+		config.DBPort,
+		config.DBUsername,
+		config.DBPassword,
+		config.DBName,
+	)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: dbLogger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     config.RedisAddress,
+		Username: config.RedisUsername,
+		Password: config.RedisPassword,
+	})
+
+	// auto migrate
+	fmt.Print("Auto Migrating...")
+	store.MigrateAll(db)
+	fmt.Println("\rAuto Migrating...done.")
+
+	// redis serves sessions, the database keeps them through a redis outage
+	sessions := session.NewFallbackStore(
+		session.NewStore(rdb, auth.RefreshTokenExpiration),
+		session.NewDBStore(db, auth.RefreshTokenExpiration),
+	)
+
+	m, err := newMailer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := newEmailVerifier(db, rdb, m, config)
+	if err != nil {
+		return nil, err
+	}
+
+	guard := newLoginGuard(rdb, config)
+	passwords, err := newPasswordPolicy(db, config)
+	if err != nil {
+		return nil, err
+	}
+	var ldap *ldapBackend
+	if config.LDAPURL != "" {
+		dir, err := newDirectory(config)
+		if err != nil {
+			return nil, err
+		}
+		ldap = newLDAPBackend(db, dir)
+	}
+	logins := newLoginBackend(db, passwords, ldap)
+
+	mfaChallenge := newMFAChallenge(db, guard, config.KeyBytes)
+	oauthSrv := newOauthServer(db, rdb, sessions, verifier, guard, logins, mfaChallenge, config)
+	resets := newPasswordReset(db, rdb, m, passwords, oauthSrv, config)
+
+	passkeys, err := newPasskeyCeremony(db, passkey.NewCeremonyStore(rdb), mfaChallenge, config)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &server{
+		Config:    config,
+		app:       newApp(config),
+		v:         config.v,
+		db:        db,
+		rdb:       rdb,
+		sessions:  sessions,
+		oauth:     oauthSrv,
+		mfa:       mfaChallenge,
+		passkeys:  passkeys,
+		verifier:  verifier,
+		passwords: passwords,
+		logins:    logins,
+		resets:    resets,
+		guard:     guard,
+		providers: federation.NewProviders(nil),
+	}
+	srv.setupRoutes()
+
+	return &Server{
+		config:    config,
+		db:        db,
+		dbLogFile: dbLogFile,
+		rdb:       rdb,
+		srv:       srv,
+		oauth:     oauthSrv,
+		ldap:      ldap,
+	}, nil
+}
+
+// Run serves until ctx is done or a listener fails, then shuts down within
+// ShutdownTimeout.
+func (s *Server) Run(ctx context.Context) error {
+	listenErr := make(chan error, 2)
+
+	go func() { listenErr <- s.srv.listen() }()
+	if s.config.OAuthAddress != "" {
+		go func() { listenErr <- s.oauth.Listen() }()
+	}
+	if s.ldap != nil && s.config.LDAPSyncInterval > 0 {
+		syncCtx, stopSync := context.WithCancel(ctx)
+		defer stopSync()
+		go s.ldap.syncEvery(syncCtx, s.config.LDAPSyncInterval)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		jww.INFO.Println("Shutting down...")
+	case err = <-listenErr:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := s.Shutdown(shutdownCtx); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+// Shutdown stops accepting connections, waits for in-flight requests until
+// ctx is done and closes every connection the server owns. Calling it more
+// than once returns the result of the first call.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx)
+	})
+	return s.shutdownErr
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+
+	collect := func(err error) {
+		if err == nil {
+			return
+		}
+
+		jww.ERROR.Println("shutdown:", err)
+		errMu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errMu.Unlock()
+	}
+
+	apps := []*fiber.App{s.srv.app}
+	if s.config.OAuthAddress != "" {
+		apps = append(apps, s.oauth.app)
+	}
+
+	// stop every listener at once, then drain them
+	for _, app := range apps {
+		wg.Add(1)
+		go func(app *fiber.App) {
+			defer wg.Done()
+			collect(app.Server().ShutdownWithContext(ctx))
+		}(app)
+	}
+	wg.Wait()
+
+	// requests are drained, nothing else will use the connections
+	collect(s.oauth.close(ctx))
+	collect(s.rdb.Close())
+
+	if sqlDB, err := s.db.DB(); err == nil {
+		collect(sqlDB.Close())
+	} else {
+		collect(err)
+	}
+
+	if s.dbLogFile != nil {
+		collect(s.dbLogFile.Sync())
+		collect(s.dbLogFile.Close())
+	}
+
+	return firstErr
+}
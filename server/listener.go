@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	jww "github.com/spf13/jwalterweatherman"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+var ErrInvalidAddress = errors.New("invalid listen address")
+
+// newApp creates a fiber app that gets its own listener. Every listener
+// shares the same middleware stack.
+func newApp(config *Config) *fiber.App {
+	app := fiber.New(fiber.Config{
+		// tcp4 is fiber's default which can't listen on IPv6 addresses
+		Network: fiber.NetworkTCP,
+	})
+
+	app.Use(requestid.New())
+	app.Use(handleLogger(config))
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:     config.CORSOrigins,
+		AllowCredentials: config.CORSOrigins != "*",
+	}))
+	app.Use(limiter.New(limiter.Config{
+		Max:        config.RateLimit,
+		Expiration: time.Minute,
+	}))
+
+	return app
+}
+
+func handleLogger(config *Config) fiber.Handler {
+	requestLogWriter := jww.TRACE.Writer()
+	if config.LogRequest {
+		requestLogWriter = jww.INFO.Writer()
+	}
+
+	return fiberlogger.New(fiberlogger.Config{
+		CustomTags: map[string]fiberlogger.LogFunc{
+			"ips": func(output fiberlogger.Buffer, c *fiber.Ctx, data *fiberlogger.Data, extraParam string) (int, error) {
+				return output.WriteString(strings.Join(c.IPs(), ">>"))
+			},
+		},
+		Format:     "${time} ${pid} ${locals:requestid} [${ips}] [${ip}]:${port} ${status} - ${method} ${path}\n",
+		Output:     requestLogWriter,
+		TimeFormat: "2006/01/02 15:04:05",
+	})
+}
+
+// httpHandler serves h as a fiber handler.
+func httpHandler(h http.Handler) fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(h)
+	return func(c *fiber.Ctx) error {
+		// net/http handlers only see the request, not the locals
+		if rid, ok := c.Locals("requestid").(string); ok {
+			c.Request().Header.Set(fiber.HeaderXRequestID, rid)
+		}
+		handler(c.Context())
+		return nil
+	}
+}
+
+// listenAddress normalizes addr to host:port. A bare port listens on every
+// interface and IPv6 hosts have to be in brackets, e.g. [::1]:3500.
+func listenAddress(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return "", ErrInvalidAddress
+	}
+
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		return "", ErrInvalidAddress
+	}
+
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return "", ErrInvalidAddress
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
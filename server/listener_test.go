@@ -0,0 +1,34 @@
+package server
+
+import "testing"
+
+func Test_listenAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{name: "host and port", addr: "0.0.0.0:3500", want: "0.0.0.0:3500"},
+		{name: "hostless", addr: ":3500", want: ":3500"},
+		{name: "port only", addr: "3500", want: ":3500"},
+		{name: "hostname", addr: "localhost:3500", want: "localhost:3500"},
+		{name: "ipv6", addr: "[::1]:3500", want: "[::1]:3500"},
+		{name: "ipv6 any", addr: "[::]:3500", want: "[::]:3500"},
+		{name: "ipv6 without brackets", addr: "::1:3500", wantErr: true},
+		{name: "missing port", addr: "0.0.0.0:", wantErr: true},
+		{name: "port out of range", addr: ":99999", wantErr: true},
+		{name: "empty", addr: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listenAddress(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listenAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("listenAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/9d4/semaphore/directory"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"gorm.io/gorm"
+)
+
+// errWrongPassword means the user exists but the password isn't its.
+var errWrongPassword = errors.New("wrong password")
+
+// loginBackend checks the password a user signs in to a tenant with.
+type loginBackend interface {
+	// authenticate gets the user signing in to t as email with password.
+	// Returns user.ErrUserNotFound when there is no such user,
+	// errWrongPassword when the password isn't its.
+	authenticate(ctx context.Context, t *tenant.Tenant, email, password string) (*user.User, error)
+}
+
+// newLoginBackend returns the local backend, after ldap when there is a
+// directory.
+func newLoginBackend(db *gorm.DB, passwords *passwordPolicy, ldap *ldapBackend) loginBackend {
+	local := &localBackend{users: user.NewStore(db), passwords: passwords}
+	if ldap == nil {
+		return local
+	}
+	return chainBackend{ldap, local}
+}
+
+// loginFailure is the reason err fails a login, empty when it is
+// something else going wrong.
+func loginFailure(err error) string {
+	switch {
+	case errors.Is(err, user.ErrUserNotFound):
+		return "unknown_user"
+	case errors.Is(err, errWrongPassword):
+		return "wrong_password"
+	}
+	return ""
+}
+
+// localBackend checks the argon2 hash of the password of the user.
+type localBackend struct {
+	users     user.Store
+	passwords *passwordPolicy
+}
+
+func (b *localBackend) authenticate(ctx context.Context, t *tenant.Tenant, email, password string) (*user.User, error) {
+	usr, err := b.users.ForTenant(t.ID).UserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !b.passwords.verify(*usr, password) {
+		return nil, errWrongPassword
+	}
+	return usr, nil
+}
+
+// chainBackend signs in with the first backend accepting the password.
+type chainBackend []loginBackend
+
+func (c chainBackend) authenticate(ctx context.Context, t *tenant.Tenant, email, password string) (*user.User, error) {
+	var err error = user.ErrUserNotFound
+	for _, b := range c {
+		usr, berr := b.authenticate(ctx, t, email, password)
+		switch {
+		case berr == nil:
+			return usr, nil
+		case errors.Is(berr, errWrongPassword):
+			err = berr
+		case !errors.Is(berr, user.ErrUserNotFound):
+			return nil, berr
+		}
+	}
+	return nil, err
+}
+
+// newDirectory returns the directory config describes.
+func newDirectory(config *Config) (*directory.Directory, error) {
+	groupRoles, err := directory.ParseGroupRoles(config.LDAPGroupRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if config.LDAPCAFile != "" {
+		pem, err := os.ReadFile(config.LDAPCAFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", config.LDAPCAFile)
+		}
+		tlsConfig = &tls.Config{RootCAs: roots}
+	}
+
+	return directory.New(directory.Config{
+		URL:          config.LDAPURL,
+		StartTLS:     config.LDAPStartTLS,
+		TLS:          tlsConfig,
+		BindDN:       config.LDAPBindDN,
+		BindPassword: config.LDAPBindPassword,
+		UserDN:       config.LDAPUserDN,
+		BaseDN:       config.LDAPBaseDN,
+		UserFilter:   config.LDAPUserFilter,
+		SyncFilter:   config.LDAPSyncFilter,
+		Attributes: directory.Attributes{
+			Email:     config.LDAPEmailAttr,
+			FirstName: config.LDAPFirstNameAttr,
+			LastName:  config.LDAPLastNameAttr,
+			Groups:    config.LDAPGroupAttr,
+		},
+		GroupRoles: groupRoles,
+	})
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/directory"
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/9d4/semaphore/util"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeDirectory has the users of entries, signing them in with the
+// password in passwords.
+type fakeDirectory struct {
+	entries    []*directory.Entry
+	passwords  map[string]string
+	groupRoles map[string]string
+	err        error
+}
+
+func (d *fakeDirectory) Authenticate(login, pwd string) (*directory.Entry, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	for _, e := range d.entries {
+		if e.Email != login {
+			continue
+		}
+		if d.passwords[login] != pwd {
+			return nil, directory.ErrInvalidCredentials
+		}
+		return e, nil
+	}
+	return nil, directory.ErrUserNotFound
+}
+
+func (d *fakeDirectory) Users() ([]*directory.Entry, error) {
+	return d.entries, d.err
+}
+
+func (d *fakeDirectory) Roles(e *directory.Entry) []string {
+	var roles []string
+	for _, group := range e.Groups {
+		if role, ok := d.groupRoles[group]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func (d *fakeDirectory) MappedRoles() []string {
+	var roles []string
+	for _, role := range d.groupRoles {
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+func Test_loginBackend(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &password.History{}, &tenant.Tenant{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	roles := rbac.NewStore(db)
+	if err := roles.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.SaveRole(&rbac.Role{Name: "ops"}); err != nil {
+		t.Fatal(err)
+	}
+
+	passwords, err := newPasswordPolicy(db, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := util.HashString([]byte("local-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := user.NewStore(db)
+	local := &user.User{Email: "local@example.com", Password: hash}
+	if err := users.Create(local); err != nil {
+		t.Fatal(err)
+	}
+
+	acme := &tenant.Tenant{Slug: "acme", Name: "Acme"}
+	if err := tenant.NewStore(db).Create(acme); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := &fakeDirectory{
+		entries: []*directory.Entry{
+			{DN: "uid=jane,ou=people,dc=example,dc=com", Email: "jane@example.com", FirstName: "Jane", LastName: "Doe",
+				Groups: []string{"cn=ops,ou=groups,dc=example,dc=com"}},
+			{DN: "uid=john,ou=people,dc=example,dc=com", Email: "john@example.com", FirstName: "John", LastName: "Roe"},
+		},
+		passwords:  map[string]string{"jane@example.com": "ldap-secret", "john@example.com": "ldap-secret"},
+		groupRoles: map[string]string{"cn=ops,ou=groups,dc=example,dc=com": "ops", "cn=qa,ou=groups,dc=example,dc=com": "qa"},
+	}
+	ldap := newLDAPBackend(db, dir)
+	logins := newLoginBackend(db, passwords, ldap)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		tenant   *tenant.Tenant
+		email    string
+		password string
+		wantErr  error
+	}{
+		{"directory user", tenant.Default, "jane@example.com", "ldap-secret", nil},
+		{"directory user wrong password", tenant.Default, "jane@example.com", "local-secret", errWrongPassword},
+		{"local user", tenant.Default, "local@example.com", "local-secret", nil},
+		{"local user wrong password", tenant.Default, "local@example.com", "ldap-secret", errWrongPassword},
+		{"unknown user", tenant.Default, "nobody@example.com", "ldap-secret", user.ErrUserNotFound},
+		{"directory user of another tenant", acme, "jane@example.com", "ldap-secret", user.ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usr, err := logins.authenticate(ctx, tt.tenant, tt.email, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && usr.Email != tt.email {
+				t.Errorf("authenticate() = %s, want %s", usr.Email, tt.email)
+			}
+		})
+	}
+
+	jane, err := users.UserByEmail("jane@example.com")
+	if err != nil {
+		t.Fatalf("jane wasn't provisioned: %v", err)
+	}
+	if !jane.EmailVerified() || jane.FirstName != "Jane" || jane.LastName != "Doe" {
+		t.Errorf("provisioned %+v, want a verified Jane Doe", jane)
+	}
+	grants, err := roles.Grants(jane.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(grants.Roles, []string{"ops"}) {
+		t.Errorf("roles = %v, want [ops]", grants.Roles)
+	}
+
+	// the directory being down leaves local users signing in
+	dir.err = errors.New("connection refused")
+	if _, err := logins.authenticate(ctx, tenant.Default, "local@example.com", "local-secret"); err != nil {
+		t.Errorf("local user with the directory down: %v", err)
+	}
+	dir.err = nil
+
+	// jane left the ops group and was renamed, john is yet to sign in
+	dir.entries[0].Groups = nil
+	dir.entries[0].LastName = "Smith"
+	synced, err := ldap.sync()
+	if err != nil || synced != 2 {
+		t.Fatalf("sync() = %d, %v, want 2", synced, err)
+	}
+
+	jane, _ = users.UserByEmail("jane@example.com")
+	if jane.LastName != "Smith" {
+		t.Errorf("last name = %s, want Smith", jane.LastName)
+	}
+	if grants, _ := roles.Grants(jane.ID); len(grants.Roles) != 0 {
+		t.Errorf("roles = %v, want none", grants.Roles)
+	}
+	if _, err := users.UserByEmail("john@example.com"); err != nil {
+		t.Errorf("john wasn't synced: %v", err)
+	}
+
+	provisioned, _, err := audit.NewStore(db).Events(audit.Query{Action: "user_provisioned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provisioned) != 2 {
+		t.Errorf("%d users provisioned, want 2", len(provisioned))
+	}
+}
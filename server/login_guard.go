@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/lockout"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+// maxChallengeAnswers is how many codes a second factor challenge can be
+// answered with before it is refused, whether or not the account is held
+// back.
+const maxChallengeAnswers = 5
+
+// lockoutWindow is how long failed logins are counted after the last one,
+// at least as long as a lockout so the next failure locks out again.
+const lockoutWindow = time.Hour
+
+// loginGuard slows down password guessing by holding back accounts and
+// client ips after failed logins.
+type loginGuard struct {
+	attempts        lockout.Store
+	lockoutDuration time.Duration
+}
+
+func newLoginGuard(rdb *redis.Client, config *Config) *loginGuard {
+	window := lockoutWindow
+	if config.LockoutDuration > window {
+		window = config.LockoutDuration
+	}
+
+	account := lockout.Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		Threshold:       config.LockoutThreshold,
+		LockoutDuration: config.LockoutDuration,
+		Window:          window,
+	}
+
+	// an ip may be shared by many users, it is given more room
+	ip := account
+	ip.FreeAttempts = 10
+	ip.Threshold = config.LockoutIPThreshold
+
+	return &loginGuard{
+		attempts:        lockout.NewStore(rdb, account, ip),
+		lockoutDuration: config.LockoutDuration,
+	}
+}
+
+// reserve reserves an attempt on key from ip before the credentials are
+// checked, see lockout.Store. It returns how long key has to wait when it
+// is held back, the attempt isn't reserved then. The guard lets logins
+// through when it can't tell.
+func (g *loginGuard) reserve(ctx context.Context, key string, ip string) time.Duration {
+	wait, err := g.attempts.Attempt(ctx, key, ip)
+	if err != nil {
+		jww.ERROR.Println("unable to check login attempts:", err)
+		return 0
+	}
+	return wait
+}
+
+// attempt is reserve for the client ip of c. It replies ErrLoginLocked
+// with Retry-After while key or the ip is held back.
+func (g *loginGuard) attempt(c *fiber.Ctx, key string) error {
+	wait := g.reserve(c.UserContext(), key, c.IP())
+	if wait <= 0 {
+		return nil
+	}
+
+	c.Set(fiber.HeaderRetryAfter, retryAfter(wait))
+	return errs.ErrLoginLocked
+}
+
+// failed records that the attempt on key from ip was wrong.
+func (g *loginGuard) failed(ctx context.Context, key string, ip string) {
+	wait, err := g.attempts.Fail(ctx, key, ip)
+	if err != nil {
+		jww.ERROR.Println("unable to record failed login:", err)
+		return
+	}
+
+	if g.lockoutDuration > 0 && wait >= g.lockoutDuration {
+		jww.WARN.Printf("security: login_locked email=%s ip=%s for=%s", key, ip, wait)
+	}
+}
+
+// passed takes back the attempt on key from ip, it wasn't wrong but the
+// login isn't complete yet. The failures before it still count.
+func (g *loginGuard) passed(ctx context.Context, key string, ip string) {
+	if err := g.attempts.Release(ctx, key, ip); err != nil {
+		jww.ERROR.Println("unable to release login attempt:", err)
+	}
+}
+
+// succeeded forgets the failed attempts on key once the login is
+// complete, not as soon as the password is right.
+func (g *loginGuard) succeeded(ctx context.Context, key string) {
+	if err := g.attempts.Unlock(ctx, key); err != nil {
+		jww.ERROR.Println("unable to reset failed logins:", err)
+	}
+}
+
+// answer counts an answer to the challenge with id, which expires after
+// ttl, before it is verified. It returns ErrMFAChallengeInvalid once the
+// challenge has been answered maxChallengeAnswers times.
+func (g *loginGuard) answer(ctx context.Context, id string, ttl time.Duration) error {
+	answers, err := g.attempts.Answer(ctx, id, ttl)
+	if err != nil {
+		jww.ERROR.Println("unable to count challenge answers:", err)
+		return nil
+	}
+
+	if answers > maxChallengeAnswers {
+		return errs.ErrMFAChallengeInvalid
+	}
+	return nil
+}
+
+// retryAfter formats wait as the seconds of a Retry-After header.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// requestIP is the client ip of a request served through net/http.
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/lockout"
+	o2errors "github.com/9d4/semaphore/oauth2/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+func Test_loginGuard(t *testing.T) {
+	attempts := newMemLockoutStore(lockout.Policy{
+		FreeAttempts:    1,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		Threshold:       3,
+		LockoutDuration: time.Hour,
+	})
+	g := &loginGuard{attempts: attempts, lockoutDuration: time.Hour}
+
+	app := fiber.New()
+	app.Post("/login", func(c *fiber.Ctx) error {
+		body := struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}{}
+		if err := c.BodyParser(&body); err != nil {
+			return err
+		}
+
+		if err := g.attempt(c, body.Email); err != nil {
+			return replyError(c, err)
+		}
+		if body.Password != "secret" {
+			g.failed(c.UserContext(), body.Email, c.IP())
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+		g.passed(c.UserContext(), body.Email, c.IP())
+		g.succeeded(c.UserContext(), body.Email)
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	login := func(email string, password string) (int, string) {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"`+email+`","password":"`+password+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, res.Header.Get(fiber.HeaderRetryAfter)
+	}
+
+	// a free attempt, then the account is held back
+	if status, _ := login("a@example.com", "wrong"); status != fiber.StatusUnauthorized {
+		t.Fatalf("first failure status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+	if status, _ := login("a@example.com", "wrong"); status != fiber.StatusUnauthorized {
+		t.Fatalf("second failure status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+	status, retry := login("A@example.com", "secret")
+	if status != fiber.StatusTooManyRequests || retry != "1" {
+		t.Fatalf("held back status = %d, Retry-After %q, want %d and 1", status, retry, fiber.StatusTooManyRequests)
+	}
+
+	// once the delay is over the right password clears the failures
+	attempts.expire("a@example.com")
+	if status, _ := login("a@example.com", "secret"); status != fiber.StatusOK {
+		t.Fatalf("after delay status = %d, want 200", status)
+	}
+	if failures, _, _ := attempts.Failures(context.Background(), "a@example.com"); failures != 0 {
+		t.Errorf("failures after success = %d, want 0", failures)
+	}
+
+	// reaching the threshold locks the account out, whatever the password
+	for i := 0; i < 3; i++ {
+		attempts.expire("b@example.com")
+		login("b@example.com", "wrong")
+	}
+	status, retry = login("b@example.com", "secret")
+	if status != fiber.StatusTooManyRequests || retry != "3600" {
+		t.Fatalf("locked out status = %d, Retry-After %q, want %d and 3600", status, retry, fiber.StatusTooManyRequests)
+	}
+
+	// until an admin unlocks it
+	if err := attempts.Unlock(context.Background(), "b@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := login("b@example.com", "secret"); status != fiber.StatusOK {
+		t.Fatalf("unlocked status = %d, want 200", status)
+	}
+
+	// attempts made in parallel are held back before any is verified
+	ctx := context.Background()
+	if wait := g.reserve(ctx, "c@example.com", "ip"); wait != 0 {
+		t.Fatalf("first reserve wait = %s, want 0", wait)
+	}
+	if wait := g.reserve(ctx, "c@example.com", "ip"); wait != 0 {
+		t.Fatalf("second reserve wait = %s, want 0", wait)
+	}
+	if wait := g.reserve(ctx, "c@example.com", "ip"); wait != time.Second {
+		t.Fatalf("parallel reserve wait = %s, want 1s", wait)
+	}
+
+	// a right password waiting for the second factor keeps the failures
+	g.failed(ctx, "c@example.com", "ip")
+	g.passed(ctx, "c@example.com", "ip")
+	if failures, wait, _ := attempts.Failures(ctx, "c@example.com"); failures != 1 || wait != 0 {
+		t.Errorf("failures after passing = %d held back %s, want 1 and 0", failures, wait)
+	}
+}
+
+func Test_oauthServer_handleInternalError_loginLocked(t *testing.T) {
+	s := &oauthServer{}
+	res := s.handleInternalError(&loginLockedError{wait: 90 * time.Second})
+	if res == nil {
+		t.Fatal("handleInternalError() = nil, want a response")
+	}
+
+	if res.Error != o2errors.ErrTemporarilyUnavailable || res.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("handleInternalError() = %v %d, want %v 429", res.Error, res.StatusCode, o2errors.ErrTemporarilyUnavailable)
+	}
+	if got := res.Header.Get("Retry-After"); got != "90" {
+		t.Errorf("Retry-After = %q, want 90", got)
+	}
+}
+
+// memLockoutStore counts failures in memory in place of redis, ignoring
+// ips. Delays only end when expire is called.
+type memLockoutStore struct {
+	policy    lockout.Policy
+	failures  map[string]int
+	blocked   map[string]time.Duration
+	blockedBy map[string]int
+	answers   map[string]int
+}
+
+func newMemLockoutStore(policy lockout.Policy) *memLockoutStore {
+	return &memLockoutStore{
+		policy:    policy,
+		failures:  map[string]int{},
+		blocked:   map[string]time.Duration{},
+		blockedBy: map[string]int{},
+		answers:   map[string]int{},
+	}
+}
+
+// newMemLoginGuard is a loginGuard counting in memory that never holds
+// anyone back.
+func newMemLoginGuard() *loginGuard {
+	return &loginGuard{attempts: newMemLockoutStore(lockout.Policy{}), lockoutDuration: time.Hour}
+}
+
+func (s *memLockoutStore) Attempt(_ context.Context, account string, _ string) (time.Duration, error) {
+	account = strings.ToLower(account)
+	if wait := s.blocked[account]; wait > 0 {
+		return wait, nil
+	}
+
+	s.failures[account]++
+	if delay := s.policy.Delay(s.failures[account]); delay > 0 {
+		s.blocked[account] = delay
+		s.blockedBy[account] = s.failures[account]
+	}
+	return 0, nil
+}
+
+func (s *memLockoutStore) Fail(_ context.Context, account string, _ string) (time.Duration, error) {
+	return s.blocked[strings.ToLower(account)], nil
+}
+
+func (s *memLockoutStore) Release(_ context.Context, account string, _ string) error {
+	account = strings.ToLower(account)
+	if s.failures[account] == 0 {
+		return nil
+	}
+	if s.blocked[account] > 0 && s.blockedBy[account] == s.failures[account] {
+		s.expire(account)
+	}
+	s.failures[account]--
+	return nil
+}
+
+func (s *memLockoutStore) Failures(_ context.Context, account string) (int, time.Duration, error) {
+	account = strings.ToLower(account)
+	return s.failures[account], s.blocked[account], nil
+}
+
+func (s *memLockoutStore) Unlock(_ context.Context, account string) error {
+	account = strings.ToLower(account)
+	delete(s.failures, account)
+	s.expire(account)
+	return nil
+}
+
+func (s *memLockoutStore) Answer(_ context.Context, challenge string, _ time.Duration) (int, error) {
+	s.answers[challenge]++
+	return s.answers[challenge], nil
+}
+
+func (s *memLockoutStore) expire(account string) {
+	account = strings.ToLower(account)
+	delete(s.blocked, account)
+	delete(s.blockedBy, account)
+}
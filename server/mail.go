@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"text/template"
+
+	"github.com/9d4/semaphore/mailer"
+)
+
+const (
+	mailerDir  = "dir"
+	mailerSMTP = "smtp"
+)
+
+var ErrInvalidMailer = errors.New("invalid mailer, want dir or smtp")
+
+// newMailer creates the mailer config asks for.
+func newMailer(config *Config) (mailer.Mailer, error) {
+	switch config.Mailer {
+	case mailerDir:
+		return mailer.NewDir(config.MailDir, config.MailFrom), nil
+	case mailerSMTP:
+		return mailer.NewSMTP(mailer.SMTPConfig{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.MailFrom,
+		}), nil
+	default:
+		return nil, ErrInvalidMailer
+	}
+}
+
+// sendTemplate renders tmpl with data as the text of a mail to to.
+func sendTemplate(ctx context.Context, m mailer.Mailer, to string, subject string, tmpl *template.Template, data interface{}) error {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return err
+	}
+
+	return m.Send(ctx, &mailer.Message{
+		To:      to,
+		Subject: subject,
+		Text:    buf.String(),
+	})
+}
@@ -0,0 +1,373 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/mfa"
+	"github.com/9d4/semaphore/passkey"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/cast"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+// totpIssuer names semaphore in authenticator apps.
+const totpIssuer = "Semaphore"
+
+// Second factors a login can be completed with.
+const (
+	mfaMethodTOTP     = "totp"
+	mfaMethodWebAuthn = "webauthn"
+	// mfaMethodRecovery replaces the others when the user has lost them,
+	// it isn't a second factor of its own.
+	mfaMethodRecovery = "recovery"
+)
+
+const (
+	mfaStatusRequired           = "mfa_required"
+	mfaStatusEnrollmentRequired = "mfa_enrollment_required"
+)
+
+// mfaAMR are the methods a login completed with TOTP is made of.
+var mfaAMR = []string{auth.AMRPassword, auth.AMROTP, auth.AMRMFA}
+
+// mfaChallenge puts a second factor between the password check and issuing
+// tokens. Both login paths share it, the codes are guessed against the same
+// guard as passwords.
+type mfaChallenge struct {
+	users    user.Store
+	mfa      mfa.Store
+	passkeys passkey.Store
+	guard    *loginGuard
+	key      []byte
+}
+
+func newMFAChallenge(db *gorm.DB, guard *loginGuard, key []byte) *mfaChallenge {
+	return &mfaChallenge{
+		users:    user.NewStore(db),
+		mfa:      mfa.NewStore(db, key),
+		passkeys: passkey.NewStore(db),
+		guard:    guard,
+		key:      key,
+	}
+}
+
+// begin reports whether usr has to pass a second factor before being signed
+// in. If so the intermediate state has been written in place of tokens.
+func (m *mfaChallenge) begin(c *fiber.Ctx, usr user.User) (bool, error) {
+	pending, err := m.challenge(usr)
+	if err != nil || pending == nil {
+		return false, err
+	}
+
+	return true, c.JSON(pending)
+}
+
+// mfaPending is the intermediate state of a login waiting for the second
+// factor.
+type mfaPending struct {
+	Status   string   `json:"status"`
+	MFAToken string   `json:"mfa_token"`
+	Methods  []string `json:"methods"`
+}
+
+// challenge returns the second factor usr has to pass before being signed
+// in, nil when it doesn't have to.
+func (m *mfaChallenge) challenge(usr user.User) (*mfaPending, error) {
+	methods, err := m.methods(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	enrolled := len(methods) > 0
+	if !enrolled && !usr.MFAEnforced {
+		return nil, nil
+	}
+
+	token, err := auth.GenerateMFAToken(usr, !enrolled, m.key)
+	if err != nil {
+		return nil, err
+	}
+
+	status := mfaStatusRequired
+	if !enrolled {
+		status = mfaStatusEnrollmentRequired
+		methods = []string{mfaMethodTOTP}
+	} else {
+		left, err := m.mfa.RecoveryCodesLeft(usr.ID)
+		if err != nil {
+			return nil, err
+		}
+		if left > 0 {
+			methods = append(methods, mfaMethodRecovery)
+		}
+	}
+
+	return &mfaPending{Status: status, MFAToken: token, Methods: methods}, nil
+}
+
+// complete checks the code sent along the mfa token and returns the user
+// signing in. A user that had to enroll completes the enrollment with it.
+func (m *mfaChallenge) complete(c *fiber.Ctx) (*user.User, error) {
+	body := struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, fiber.ErrBadRequest
+	}
+
+	usr, token, err := m.challengedUser(body.MFAToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.verify(c, usr, token, func() error {
+		if token.Enroll {
+			return mfaError(m.mfa.ConfirmTOTP(usr.ID, body.Code))
+		}
+		return mfaError(m.verifyConfirmed(usr.ID, body.Code))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usr, nil
+}
+
+// completeRecovery checks the recovery code sent along the mfa token and
+// returns the user signing in. The user is warned when codes run low.
+func (m *mfaChallenge) completeRecovery(c *fiber.Ctx) (*user.User, error) {
+	body := struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, fiber.ErrBadRequest
+	}
+
+	usr, token, err := m.challengedUser(body.MFAToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// a recovery code doesn't replace the enrollment
+	if token.Enroll {
+		return nil, errs.ErrMFAInvalidCode
+	}
+
+	var left int
+	err = m.verify(c, usr, token, func() (err error) {
+		left, err = m.mfa.UseRecoveryCode(usr.ID, body.Code)
+		return mfaError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e := userEvent("recovery_code_used", usr)
+	e.Metadata = map[string]interface{}{"left": left}
+	auditEvent(c, m.users.DB(), e)
+	if left <= mfa.RecoveryCodesLow {
+		e.Action = "recovery_codes_low"
+		auditEvent(c, m.users.DB(), e)
+	}
+
+	return usr, nil
+}
+
+// handleEnroll starts the enrollment of a user that can't sign in without
+// a second factor.
+func (m *mfaChallenge) handleEnroll(c *fiber.Ctx) error {
+	body := struct {
+		MFAToken string `json:"mfa_token"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	usr, token, err := m.challengedUser(body.MFAToken)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	if !token.Enroll {
+		return errs.WriteErrorJSON(c, errs.ErrMFAAlreadyEnrolled)
+	}
+
+	enrollment, err := m.startEnrollment(*usr)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(enrollment)
+}
+
+// startEnrollment generates a new secret for usr. Until it is confirmed
+// with a code, enrolling again replaces it.
+func (m *mfaChallenge) startEnrollment(usr user.User) (fiber.Map, error) {
+	enrolled, err := m.enrolled(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if enrolled {
+		return nil, errs.ErrMFAAlreadyEnrolled
+	}
+
+	secret, err := mfa.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.mfa.SaveTOTP(&mfa.TOTP{UserID: usr.ID, Secret: secret}); err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"secret": secret,
+		"uri":    mfa.TOTPURI(totpIssuer, usr.Email, secret),
+	}, nil
+}
+
+func (m *mfaChallenge) challengedUser(rawToken string) (*user.User, *auth.MFAToken, error) {
+	token, err := auth.ValidateMFAToken(rawToken, auth.DefaultJwtKeyFunc(m.key))
+	if err != nil {
+		return nil, nil, errs.ErrMFAChallengeInvalid
+	}
+
+	usr, err := m.users.UserByID(cast.ToUint(token.Subject))
+	if err != nil {
+		return nil, nil, errs.ErrMFAChallengeInvalid
+	}
+
+	return usr, token, nil
+}
+
+// verify runs check, answering the challenge of token for usr, under the
+// login guard. A wrong answer counts as a failed login of usr and the
+// token is refused after maxChallengeAnswers of them. The failures of usr
+// are forgotten once check passes since it completes the login.
+func (m *mfaChallenge) verify(c *fiber.Ctx, usr *user.User, token *auth.MFAToken, check func() error) error {
+	key, err := userGuardKey(m.users.DB(), *usr)
+	if err != nil {
+		return err
+	}
+	if err := m.guard.attempt(c, key); err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	if err := m.guard.answer(ctx, token.ID, time.Until(token.ExpiresAt.Time)); err != nil {
+		m.guard.passed(ctx, key, c.IP())
+		return err
+	}
+
+	err = check()
+	switch {
+	case errors.Is(err, errs.ErrMFAInvalidCode), errors.Is(err, errs.ErrPasskeyInvalid):
+		m.guard.failed(ctx, key, c.IP())
+		return err
+	case err != nil:
+		m.guard.passed(ctx, key, c.IP())
+		return err
+	}
+
+	m.guard.passed(ctx, key, c.IP())
+	m.guard.succeeded(ctx, key)
+	return nil
+}
+
+// methods lists the second factors the user can complete a login with.
+func (m *mfaChallenge) methods(userID uint) ([]string, error) {
+	var methods []string
+
+	enrolled, err := m.enrolled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enrolled {
+		methods = append(methods, mfaMethodTOTP)
+	}
+
+	credentials, err := m.passkeys.Credentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) > 0 {
+		methods = append(methods, mfaMethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+// lastMethod reports whether method is the only second factor the user
+// has left.
+func (m *mfaChallenge) lastMethod(userID uint, method string) (bool, error) {
+	methods, err := m.methods(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return len(methods) == 1 && methods[0] == method, nil
+}
+
+// enrolled reports whether the user has a confirmed TOTP enrollment.
+func (m *mfaChallenge) enrolled(userID uint) (bool, error) {
+	t, err := m.mfa.TOTP(userID)
+	if err != nil {
+		if errors.Is(err, mfa.ErrTOTPNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return t.Confirmed(), nil
+}
+
+// verifyConfirmed checks code against an enrollment that has been
+// confirmed, a pending one doesn't count as a second factor.
+func (m *mfaChallenge) verifyConfirmed(userID uint, code string) error {
+	enrolled, err := m.enrolled(userID)
+	if err != nil {
+		return err
+	}
+
+	if !enrolled {
+		return mfa.ErrTOTPNotFound
+	}
+
+	return m.mfa.VerifyTOTP(userID, code)
+}
+
+// mfaError maps mfa errors to what is replied.
+func mfaError(err error) error {
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrTOTPNotFound),
+		errors.Is(err, mfa.ErrNoRecoveryCodes):
+		return errs.ErrMFAInvalidCode
+	default:
+		return err
+	}
+}
+
+// replyError writes err if it is meant for the client, anything else is
+// logged and replied as internal server error.
+func replyError(c *fiber.Ctx, err error) error {
+	var e *errs.Error
+	if errors.As(err, &e) {
+		return errs.WriteErrorJSON(c, e)
+	}
+
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe
+	}
+
+	jww.ERROR.Println(c.Path(), err)
+	return fiber.ErrInternalServerError
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/mfa"
+	"github.com/9d4/semaphore/passkey"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_mfaChallenge(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &mfa.TOTP{}, &mfa.RecoveryCode{}, &passkey.Credential{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	m := newMFAChallenge(db, newMemLoginGuard(), key)
+
+	plain := user.User{Email: "plain@example.com"}
+	enforced := user.User{Email: "enforced@example.com", MFAEnforced: true}
+	for _, u := range []*user.User{&plain, &enforced} {
+		if err := m.users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Post("/password/:email", func(c *fiber.Ctx) error {
+		usr, err := m.users.UserByEmail(c.Params("email"))
+		if err != nil {
+			return err
+		}
+
+		required, err := m.begin(c, *usr)
+		if err != nil || required {
+			return err
+		}
+		return c.SendString("signed in")
+	})
+	app.Post("/login/mfa", func(c *fiber.Ctx) error {
+		usr, err := m.complete(c)
+		if err != nil {
+			return replyError(c, err)
+		}
+		return c.SendString("signed in " + usr.Email)
+	})
+	app.Post("/login/mfa/enroll", m.handleEnroll)
+	app.Post("/login/mfa/recovery", func(c *fiber.Ctx) error {
+		usr, err := m.completeRecovery(c)
+		if err != nil {
+			return replyError(c, err)
+		}
+		return c.SendString("signed in " + usr.Email)
+	})
+
+	post := func(path string, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		// recovery codes are checked against slow password hashes
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := map[string]interface{}{}
+		_ = json.NewDecoder(res.Body).Decode(&data)
+		return res.StatusCode, data
+	}
+
+	// without a second factor the login goes through
+	if status, data := post("/password/plain@example.com", ""); status != 200 || len(data) != 0 {
+		t.Fatalf("plain login = %d %v, want signed in", status, data)
+	}
+
+	// an enforced user has to enroll first
+	_, data := post("/password/enforced@example.com", "")
+	if data["status"] != mfaStatusEnrollmentRequired {
+		t.Fatalf("status = %v, want %v", data["status"], mfaStatusEnrollmentRequired)
+	}
+	token := data["mfa_token"].(string)
+
+	// a recovery code doesn't skip the enrollment
+	if status, _ := post("/login/mfa/recovery", `{"mfa_token":"`+token+`","code":"abcde-fghjk"}`); status != fiber.StatusUnauthorized {
+		t.Fatalf("recovery while enrolling status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	_, enrollment := post("/login/mfa/enroll", `{"mfa_token":"`+token+`"}`)
+	secret, _ := enrollment["secret"].(string)
+	if secret == "" {
+		t.Fatalf("enrollment = %v, want a secret", enrollment)
+	}
+
+	if status, _ := post("/login/mfa", `{"mfa_token":"`+token+`","code":"000000"}`); status != fiber.StatusUnauthorized {
+		t.Fatalf("wrong code status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	code := currentTOTPCode(t, secret)
+	if status, _ := post("/login/mfa", `{"mfa_token":"`+token+`","code":"`+code+`"}`); status != 200 {
+		t.Fatalf("enrollment code status = %d, want 200", status)
+	}
+
+	// once enrolled the code is asked for
+	_, data = post("/password/enforced@example.com", "")
+	if data["status"] != mfaStatusRequired {
+		t.Fatalf("status = %v, want %v", data["status"], mfaStatusRequired)
+	}
+
+	// the code that completed the enrollment can't be used again
+	if status, _ := post("/login/mfa", `{"mfa_token":"`+data["mfa_token"].(string)+`","code":"`+code+`"}`); status != fiber.StatusUnauthorized {
+		t.Fatalf("reused code status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	// a recovery code replaces the second factor once
+	codes, err := m.mfa.RegenerateRecoveryCodes(enforced.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, data = post("/password/enforced@example.com", "")
+	if methods, _ := data["methods"].([]interface{}); len(methods) != 2 || methods[1] != mfaMethodRecovery {
+		t.Errorf("methods = %v, want [%s %s]", data["methods"], mfaMethodTOTP, mfaMethodRecovery)
+	}
+	recovery := `{"mfa_token":"` + data["mfa_token"].(string) + `","code":"` + codes[0] + `"}`
+	if status, _ := post("/login/mfa/recovery", recovery); status != 200 {
+		t.Fatalf("recovery code status = %d, want 200", status)
+	}
+	if status, _ := post("/login/mfa/recovery", recovery); status != fiber.StatusUnauthorized {
+		t.Fatalf("reused recovery code status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	// wrong codes count as failed logins and wear the mfa token out
+	ctx := context.Background()
+	before, _, _ := m.guard.attempts.Failures(ctx, enforced.Email)
+	_, data = post("/password/enforced@example.com", "")
+	answer := func(code string) (int, map[string]interface{}) {
+		return post("/login/mfa", `{"mfa_token":"`+data["mfa_token"].(string)+`","code":"`+code+`"}`)
+	}
+	for i := 0; i < maxChallengeAnswers; i++ {
+		if status, body := answer("000000"); status != fiber.StatusUnauthorized || body["error"] != "mfa_invalid_code" {
+			t.Fatalf("wrong code %d = %d %v, want mfa_invalid_code", i, status, body)
+		}
+	}
+	if failures, _, _ := m.guard.attempts.Failures(ctx, enforced.Email); failures != before+maxChallengeAnswers {
+		t.Errorf("failures after wrong codes = %d, want %d", failures, before+maxChallengeAnswers)
+	}
+	if status, body := answer(currentTOTPCode(t, secret)); status != fiber.StatusUnauthorized || body["error"] != "mfa_challenge_invalid" {
+		t.Fatalf("worn out token = %d %v, want mfa_challenge_invalid", status, body)
+	}
+
+	// an access token isn't an mfa token
+	at, err := auth.GenerateAccessToken(enforced, rbac.Grants{}, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := post("/login/mfa", `{"mfa_token":"`+at+`","code":"`+code+`"}`); status != fiber.StatusUnauthorized {
+		t.Fatalf("access token status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+}
+
+func currentTOTPCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	code, err := mfa.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
@@ -3,19 +3,45 @@ package middleware
 import (
 	"context"
 	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/pat"
 	"github.com/9d4/semaphore/server/types"
 	"github.com/9d4/semaphore/server/util"
 	"github.com/gofiber/fiber/v2"
+	"strings"
 )
 
-func BearerAuth(key []byte) fiber.Handler {
+// PersonalTokens authenticates the personal access tokens accepted
+// alongside JWTs.
+type PersonalTokens interface {
+	// AccessToken gets the claims of the user token belongs to, with the
+	// permissions the scopes of token allow.
+	AccessToken(token string) (*auth.AccessToken, error)
+}
+
+// personalToken reads the claims of token when it is a personal access
+// token. It reports false for other tokens, or when tokens is nil.
+func personalToken(tokens PersonalTokens, token string) (*auth.AccessToken, bool, error) {
+	if tokens == nil || !strings.HasPrefix(token, pat.TokenPrefix) {
+		return nil, false, nil
+	}
+
+	at, err := tokens.AccessToken(token)
+	return at, true, err
+}
+
+// BearerAuth lets requests with an access token signed with key through,
+// or with a personal access token when tokens isn't nil.
+func BearerAuth(key []byte, tokens PersonalTokens) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		token, err := util.GetBearerToken(c)
 		if err != nil {
 			return err
 		}
 
-		at, err := auth.ValidateAccessToken(token, auth.DefaultJwtKeyFunc(key))
+		at, ok, err := personalToken(tokens, token)
+		if !ok {
+			at, err = auth.ValidateAccessToken(token, auth.DefaultJwtKeyFunc(key))
+		}
 		if err != nil {
 			return fiber.ErrUnauthorized
 		}
@@ -25,3 +51,19 @@ func BearerAuth(key []byte) fiber.Handler {
 		return c.Next()
 	}
 }
+
+// RequirePermission lets the request through when its access token grants
+// permission. It goes after BearerAuth.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		at, err := util.UseContext[*auth.AccessToken](c, "access_token")
+		if err != nil {
+			return fiber.ErrUnauthorized
+		}
+
+		if !at.Can(permission) {
+			return fiber.ErrForbidden
+		}
+		return c.Next()
+	}
+}
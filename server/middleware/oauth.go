@@ -11,13 +11,32 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func OAuthBearerAuth(key []byte) fiber.Handler {
+// OAuthBearerAuth lets requests with an OAuth2 access token signed with
+// key through, or with a personal access token when tokens isn't nil.
+func OAuthBearerAuth(key []byte, tokens PersonalTokens) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		token, err := util.GetBearerToken(c)
 		if err != nil {
 			return err
 		}
 
+		if at, ok, err := personalToken(tokens, token); ok {
+			if err != nil {
+				return fiber.ErrUnauthorized
+			}
+
+			claims := generates.JWTAccessClaims{Roles: at.Roles, Groups: at.Groups}
+			claims.Issuer = at.Issuer
+			claims.Subject = at.Subject
+			if at.ExpiresAt != nil {
+				claims.ExpiresAt = at.ExpiresAt.Unix()
+			}
+
+			ctx := context.WithValue(c.UserContext(), types.ContextKey("access_token"), claims)
+			c.SetUserContext(ctx)
+			return c.Next()
+		}
+
 		claims := generates.JWTAccessClaims{}
 
 		tk, err := jwt.ParseWithClaims(token, &claims, auth.DefaultJwtKeyFunc(key))
@@ -1,16 +1,20 @@
 package server
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"github.com/9d4/semaphore/audit"
 	"github.com/9d4/semaphore/auth"
 	"github.com/9d4/semaphore/oauth2"
+	o2errors "github.com/9d4/semaphore/oauth2/errors"
 	"github.com/9d4/semaphore/oauth2/generates"
 	"github.com/9d4/semaphore/oauth2/manage"
 	"github.com/9d4/semaphore/oauth2/models"
 	o2server "github.com/9d4/semaphore/oauth2/server"
 	"github.com/9d4/semaphore/oauth2/store"
 	oredis "github.com/9d4/semaphore/oauth2/store/redis"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/tenant"
 	"github.com/9d4/semaphore/user"
 	redis8 "github.com/go-redis/redis/v8"
 	"github.com/go-redis/redis/v9"
@@ -21,33 +25,44 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-var ErrSuspended = errors.New("waiting user authorization")
-
 type oauthServer struct {
 	*Config
 	app *fiber.App
 	db  *gorm.DB
 	rdb *redis.Client
 
-	manager    *manage.Manager
-	tokenStore oauth2.TokenStore
-	server     *o2server.Server
-	mux        *http.ServeMux
+	// realm serves the default tenant, realms the others by slug
+	realm    *realm
+	realms   map[string]*realm
+	realmsMu sync.Mutex
+	tokenRDB *redis8.Client
+	mux      *http.ServeMux
+	sessions session.Store
+	notifier *backchannelNotifier
+	verifier *emailVerifier
+	guard    *loginGuard
+	logins   loginBackend
+	mfa      *mfaChallenge
 }
 
-func newOauthServer(db *gorm.DB, rdb *redis.Client, config *Config) *oauthServer {
+func newOauthServer(db *gorm.DB, rdb *redis.Client, sessions session.Store, verifier *emailVerifier, guard *loginGuard, logins loginBackend, mfa *mfaChallenge, config *Config) *oauthServer {
 	os := &oauthServer{
-		Config: config,
-		app:    fiber.New(),
-		db:     db,
-		rdb:    rdb,
+		Config:   config,
+		app:      newApp(config),
+		db:       db,
+		rdb:      rdb,
+		realms:   make(map[string]*realm),
+		sessions: sessions,
+		notifier: newBackchannelNotifier(),
+		verifier: verifier,
+		guard:    guard,
+		logins:   logins,
+		mfa:      mfa,
 	}
 
-	os.manager = manage.NewDefaultManager()
-	os.manager.MapAccessGenerate(generates.NewJWTAccessGenerate("http://semaphore.test", "semaphore-oauth2", config.KeyBytes, jwt.SigningMethodHS512))
-
 	// storages
 	clientStore := store.NewClientStoreRedis(rdb)
 	_ = clientStore.Set("mymoodle", &models.Client{
@@ -55,13 +70,36 @@ func newOauthServer(db *gorm.DB, rdb *redis.Client, config *Config) *oauthServer
 		Secret: "mymoodle-secret",
 		Domain: "moodle.test",
 	})
-	os.tokenStore = oredis.NewRedisStore(&redis8.Options{
+	os.tokenRDB = redis8.NewClient(&redis8.Options{
 		Addr: config.RedisAddress,
 		DB:   2,
 	})
 
-	os.manager.MapClientStorage(clientStore)
-	os.manager.MapTokenStorage(os.tokenStore)
+	os.realm = os.newRealm(tenant.Default, config.Issuer, config.KeyBytes, clientStore, oredis.NewRedisStoreWithCli(os.tokenRDB))
+
+	os.mux = http.NewServeMux()
+	os.mux.HandleFunc("/oauth2/authorize", os.handleAuthorize)
+	os.mux.HandleFunc("/oauth2/token", os.handleToken)
+	os.mux.HandleFunc("/oauth2/logout", os.handleLogout)
+	os.mux.HandleFunc("/t/", os.handleTenant)
+	os.app.All("/oauth2/*", os.Handler())
+	os.app.All("/t/:tenant/oauth2/*", os.Handler())
+
+	return os
+}
+
+// newRealm builds the authorization server of tenant t, issuing tokens as
+// issuer signed with key to the clients in clients.
+func (s *oauthServer) newRealm(t *tenant.Tenant, issuer string, key []byte, clients oauth2.ClientStore, tokens oauth2.TokenStore) *realm {
+	rl := &realm{tenant: t, issuer: issuer, key: key, tokens: tokens}
+
+	rl.manager = manage.NewDefaultManager()
+	accessGenerate := generates.NewJWTAccessGenerate(issuer, "semaphore-oauth2", key, jwt.SigningMethodHS512)
+	accessGenerate.UserClaims = s.userClaims
+	rl.manager.MapAccessGenerate(accessGenerate)
+	rl.manager.MapClientStorage(clients)
+	rl.manager.MapTokenStorage(tokens)
+	rl.manager.SetValidateURIHandler(validateRedirectURI)
 
 	srv := o2server.NewServer(&o2server.Config{
 		TokenType:            "Bearer",
@@ -74,109 +112,294 @@ func newOauthServer(db *gorm.DB, rdb *redis.Client, config *Config) *oauthServer
 			oauth2.CodeChallengePlain,
 			oauth2.CodeChallengeS256,
 		},
-	}, os.manager)
+	}, rl.manager)
 
 	srv.SetClientInfoHandler(o2server.ClientBasicHandler)
-	srv.SetUserAuthorizationHandler(os.handleUserAuthorization)
-	srv.SetAuthorizeScopeHandler(os.handleAuthorizeScope)
-
-	os.mux = http.NewServeMux()
-	os.mux.HandleFunc("/oauth2/authorize", func(w http.ResponseWriter, r *http.Request) {
-		err := srv.HandleAuthorizeRequest(w, r)
-		if err != nil {
-			if err != ErrSuspended {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			}
-		}
-	})
-	os.mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
-		err := srv.HandleTokenRequest(w, r)
-		if err != nil {
-			jww.ERROR.Println(err)
-		}
+	srv.SetUserAuthorizationHandler(s.handleUserAuthorization)
+	srv.SetPasswordAuthorizationHandler(s.handlePasswordAuthorization)
+	srv.SetRefreshingValidationHandler(s.handleRefreshingValidation)
+	srv.SetAuthorizeScopeHandler(s.handleAuthorizeScope)
+	srv.SetAuthorizeSessionHandler(s.handleAuthorizeSession)
+	// the token info carries no request, so the realm is bound here
+	srv.SetExtensionFieldsHandler(func(ti oauth2.TokenInfo) map[string]interface{} {
+		return s.handleExtensionFields(withRealm(context.Background(), rl), ti)
 	})
+	srv.SetInternalErrorHandler(s.handleInternalError)
 
-	os.server = srv
-	return os
+	rl.server = srv
+	return rl
+}
+
+func (s *oauthServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	// the password grant counts failed logins per client ip
+	r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, requestIP(r)))
+	rl := s.realmOf(r.Context())
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	err := rl.server.HandleTokenRequest(rec, r)
+	if err != nil {
+		jww.ERROR.Println(err)
+	}
+
+	clientID := r.FormValue("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID = id
+	}
+	e := audit.Event{
+		TenantID:   rl.tenant.ID,
+		Actor:      "client:" + clientID,
+		Action:     "token_issued",
+		TargetType: audit.TargetClient,
+		TargetID:   clientID,
+		Metadata:   map[string]interface{}{"grant_type": r.FormValue("grant_type")},
+	}
+	if rec.status != http.StatusOK {
+		e.Outcome = audit.OutcomeFailure
+		e.Metadata["status"] = rec.status
+	}
+	auditRequest(r, s.db, e)
+}
+
+// statusRecorder remembers the status code of the response it writes.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Handler serves the OAuth2 endpoints, to be mounted on an app that isn't
+// listening on OAuthAddress.
+func (s *oauthServer) Handler() fiber.Handler {
+	return httpHandler(s.mux)
 }
 
+// Listen serves the OAuth2 endpoints on OAuthAddress.
 func (s *oauthServer) Listen() error {
-	address := strings.Split(s.Config.Address, ":")
-	newPort, err := strconv.Atoi(address[1])
+	addr, err := listenAddress(s.OAuthAddress)
 	if err != nil {
-		jww.FATAL.Fatal(err)
+		return err
 	}
-	newAddr := fmt.Sprint(address[0], ":", newPort+1)
 
-	jww.INFO.Println("OAuth Server listening on", newAddr)
-	return http.ListenAndServe(newAddr, s.mux)
+	jww.INFO.Println("OAuth Server listening on", addr)
+	return s.app.Listen(addr)
 }
 
-// check if user authenticated or not and consent screen
+// close waits for pending back-channel logouts until ctx is done and closes
+// the token stores of every realm.
+func (s *oauthServer) close(ctx context.Context) error {
+	if err := s.notifier.wait(ctx); err != nil {
+		jww.WARN.Println("back-channel logouts still pending:", err)
+	}
+
+	return s.tokenRDB.Close()
+}
+
+// check if user authenticated or not and consent screen. An empty userID
+// with no error means the user has been redirected to sign in or consent.
 func (s *oauthServer) handleUserAuthorization(w http.ResponseWriter, r *http.Request) (userID string, err error) {
-	rtCookie, err := r.Cookie("rt")
+	ctx := r.Context()
+	rl := s.realmOf(ctx)
+
+	areq, err := parseAuthRequest(r, s.KeyBytes)
 	if err != nil {
-		s.redirectConsent(w, r, "oauth_authorize")
-		return "", fiber.ErrUnauthorized
+		return "", err
 	}
 
-	var rt refreshToken
-
-	token, err := jwt.ParseWithClaims(rtCookie.Value, &rt, auth.DefaultJwtKeyFunc(s.KeyBytes))
-	if err != nil || !token.Valid {
+	rt, sess, err := s.currentSession(r)
+	if err != nil {
+		if areq.prompt[promptNone] {
+			return "", o2errors.ErrLoginRequired
+		}
 		s.redirectConsent(w, r, "oauth_authorize")
-		return "", ErrSuspended
+		return "", nil
 	}
 
 	subjectID, err := strconv.Atoi(rt.Subject)
 	if err != nil {
-		return "", fiber.ErrInternalServerError
+		return "", o2errors.ErrServerError
 	}
 
+	// the account is gone or belongs to another tenant, treat it like
+	// being signed out
 	var usr user.User
 	result := s.db.First(&usr, user.User{ID: uint(subjectID)})
-	if result.Error != nil {
-		return "", fiber.ErrUnauthorized
+	if result.Error != nil || usr.TenantID != rl.tenant.ID {
+		if areq.prompt[promptNone] {
+			return "", o2errors.ErrLoginRequired
+		}
+		s.redirectConsent(w, r, "oauth_authorize")
+		return "", nil
 	}
 
-	queryConsent := r.FormValue("consent")
-	if queryConsent == "1" {
-		return strconv.Itoa(int(usr.ID)), nil
+	if loginRequired, stepUpRequired := areq.loginRequired(sess), areq.stepUpRequired(sess); loginRequired || stepUpRequired {
+		if areq.prompt[promptNone] {
+			return "", o2errors.ErrLoginRequired
+		}
+
+		// the user has just signed in again and still falls short
+		if !loginRequired && areq.reauthenticated(sess) {
+			return "", o2errors.ErrUnmetAuthenticationRequirements
+		}
+
+		if err := s.redirectReauth(w, r, sess); err != nil {
+			return "", err
+		}
+		return "", nil
 	}
 
-	w.Header().Set("Location", "/o/oauth/authorize?"+r.URL.RawQuery)
-	w.WriteHeader(http.StatusFound)
-	return "", ErrSuspended
+	if !s.verifier.allowAuthorization(usr) {
+		return "", o2errors.ErrAccessDenied
+	}
+
+	clientID := r.FormValue("client_id")
+	scope := rl.filterScopes(r.FormValue("scope"))
+
+	// consent is only taken from the form the consent screen posts
+	granted := r.Method == http.MethodPost && r.FormValue("consent") == "1"
+	if granted {
+		if err := s.saveConsent(ctx, usr.ID, clientID, scope); err != nil {
+			return "", err
+		}
+
+		e := userEvent("consent_granted", &usr)
+		e.TargetType, e.TargetID = audit.TargetClient, clientID
+		e.Metadata = map[string]interface{}{"scope": scope}
+		auditRequest(r, s.db, e)
+	} else if !areq.prompt[promptConsent] && !areq.prompt[promptSelectAccount] {
+		granted, err = s.hasConsent(ctx, usr.ID, clientID, scope)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !granted {
+		if areq.prompt[promptNone] {
+			return "", o2errors.ErrConsentRequired
+		}
+
+		w.Header().Set("Location", "/o/oauth/authorize?"+rl.query(r.URL.RawQuery))
+		w.WriteHeader(http.StatusFound)
+		return "", nil
+	}
+
+	// remember the client so it can be signed out along with the session
+	if err := s.sessions.AddClient(ctx, sess.ID, clientID); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(int(usr.ID)), nil
+}
+
+// currentSession gets the refresh token from the rt cookie along with the
+// session it belongs to.
+func (s *oauthServer) currentSession(r *http.Request) (*auth.RefreshToken, *session.Session, error) {
+	rtCookie, err := r.Cookie("rt")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rt, err := auth.ValidateRefreshToken(rtCookie.Value, auth.DefaultJwtKeyFunc(s.KeyBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rt.SessionID == "" {
+		return nil, nil, session.ErrSessionNotFound
+	}
+
+	sess, err := s.sessions.Session(r.Context(), rt.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rt, sess, nil
+}
+
+func (s *oauthServer) handleAuthorizeSession(r *http.Request) (sessionID string, err error) {
+	_, sess, err := s.currentSession(r)
+	if err != nil {
+		return "", err
+	}
+
+	return sess.ID, nil
+}
+
+// handleRefreshingValidation refuses the refresh tokens of a session that
+// has ended.
+func (s *oauthServer) handleRefreshingValidation(ti oauth2.TokenInfo) (bool, error) {
+	sid := ti.GetSessionID()
+	if sid == "" {
+		return true, nil
+	}
+
+	if _, err := s.sessions.Session(context.Background(), sid); err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
 }
 
 func (s *oauthServer) redirectConsent(w http.ResponseWriter, r *http.Request, from string) {
-	w.Header().Set("Location", "/o/oauth/authorize?"+r.URL.RawQuery+"&from="+from)
+	w.Header().Set("Location", "/o/oauth/authorize?"+s.realmOf(r.Context()).query(r.URL.RawQuery)+"&from="+from)
+	w.WriteHeader(http.StatusFound)
+}
+
+// redirectReauth sends the user of sess to sign in again. A signed marker
+// of when is kept in the request so the fresh authentication is
+// recognised on return.
+func (s *oauthServer) redirectReauth(w http.ResponseWriter, r *http.Request, sess *session.Session) error {
+	marker, err := auth.GenerateReauthToken(sess.ID, r.FormValue("client_id"), s.KeyBytes)
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+	q.Set("from", "oauth_authorize")
+	q.Set("reauth", marker)
+	if rl := s.realmOf(r.Context()); !rl.tenant.IsDefault() {
+		q.Set("tenant", rl.tenant.Slug)
+	}
+
+	w.Header().Set("Location", "/login?"+q.Encode())
 	w.WriteHeader(http.StatusFound)
+	return nil
 }
 
 func (s *oauthServer) handleAuthorizeScope(w http.ResponseWriter, r *http.Request) (scope string, err error) {
-	reqScopesRaw := r.FormValue("scope")
-	reqScopes := strings.Split(strings.TrimSpace(reqScopesRaw), " ")
+	return s.realmOf(r.Context()).filterScopes(r.FormValue("scope")), nil
+}
+
+// filterScopes drops the requested scopes semaphore doesn't know about.
+func filterScopes(raw string) (scope string) {
+	reqScopes := strings.Split(strings.TrimSpace(raw), " ")
 	for _, s := range reqScopes {
 		if OAuth2Scopes[s] != "" {
 			scope = scope + s + " "
 		}
 	}
 
-	scope = strings.TrimSpace(scope)
-	return
+	return strings.TrimSpace(scope)
 }
 
 type OAuth2Scope string
 
 const (
-	ScopeEmail OAuth2Scope = "email"
+	ScopeOpenID  OAuth2Scope = "openid"
+	ScopeProfile OAuth2Scope = "profile"
+	ScopeEmail   OAuth2Scope = "email"
 )
 
 var OAuth2Scopes map[string]OAuth2Scope = getOAuth2Scopes()
 
 func getOAuth2Scopes() map[string]OAuth2Scope {
 	m := make(map[string]OAuth2Scope)
+	m[string(ScopeOpenID)] = ScopeOpenID
+	m[string(ScopeProfile)] = ScopeProfile
 	m[string(ScopeEmail)] = ScopeEmail
 	return m
 }
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	o2errors "github.com/9d4/semaphore/oauth2/errors"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var oauthErrorTemplate = template.Must(template.New("oauth_error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorization error - Semaphore</title>
+</head>
+<body>
+<h1>Semaphore</h1>
+<p>The application sent an invalid authorization request, so you can't be sent back to it.</p>
+<p><strong>{{.Error}}</strong>{{if .Description}}: {{.Description}}{{end}}</p>
+<p><a href="/">Back to Semaphore</a></p>
+</body>
+</html>
+`))
+
+// handleAuthorize checks that the client and its redirect uri can be
+// trusted before handing the request to the authorization server. Every
+// error after that is redirected back to the client.
+func (s *oauthServer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := s.validateAuthorizeClient(r); err != nil {
+		s.renderOAuthError(w, err)
+		return
+	}
+
+	if err := s.realmOf(r.Context()).server.HandleAuthorizeRequest(w, r); err != nil {
+		s.renderOAuthError(w, err)
+	}
+}
+
+// validateAuthorizeClient reports errors that must not be redirected:
+// an unknown client or a redirect uri that doesn't belong to it.
+func (s *oauthServer) validateAuthorizeClient(r *http.Request) error {
+	clientID := r.FormValue("client_id")
+	if clientID == "" {
+		return o2errors.ErrInvalidRequest
+	}
+
+	cli, err := s.realmOf(r.Context()).manager.GetClient(r.Context(), clientID)
+	if err != nil {
+		return o2errors.ErrInvalidClient
+	}
+
+	// without redirect_uri the client domain is used, so it has to be a
+	// usable uri too
+	redirectURI := r.FormValue("redirect_uri")
+	if redirectURI == "" {
+		redirectURI = cli.GetDomain()
+	}
+
+	return validateRedirectURI(cli.GetDomain(), redirectURI)
+}
+
+// renderOAuthError writes err as a page instead of redirecting it.
+func (s *oauthServer) renderOAuthError(w http.ResponseWriter, err error) {
+	data, statusCode, _ := s.realm.server.GetErrorData(err)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	err = oauthErrorTemplate.Execute(w, struct {
+		Error       interface{}
+		Description interface{}
+	}{
+		Error:       data["error"],
+		Description: data["error_description"],
+	})
+	if err != nil {
+		jww.ERROR.Println("unable to render oauth error page:", err)
+	}
+}
+
+// handleInternalError maps errors that aren't in the oauth2 error
+// responses. Anything unexpected is logged and reported as server_error.
+func (s *oauthServer) handleInternalError(err error) *o2errors.Response {
+	var locked *loginLockedError
+	if errors.As(err, &locked) {
+		return loginLockedResponse(locked)
+	}
+
+	if err == o2errors.ErrInvalidRedirectURI {
+		return &o2errors.Response{
+			Error:       o2errors.ErrInvalidRequest,
+			Description: "The redirect_uri is invalid or not registered for the client",
+			StatusCode:  http.StatusBadRequest,
+		}
+	}
+
+	jww.ERROR.Println("oauth2:", err)
+	return nil
+}
+
+// validateRedirectURI validates that redirectURI is an absolute http(s) uri
+// on the client domain or one of its subdomains. baseURI is either a bare
+// host or an uri whose scheme and port have to match too.
+func validateRedirectURI(baseURI string, redirectURI string) error {
+	base := &url.URL{Host: baseURI}
+	if strings.Contains(baseURI, "://") {
+		var err error
+		base, err = url.Parse(baseURI)
+		if err != nil {
+			return o2errors.ErrInvalidRedirectURI
+		}
+	}
+
+	redirect, err := url.Parse(redirectURI)
+	if err != nil || redirect.Host == "" || redirect.Fragment != "" || redirect.User != nil {
+		return o2errors.ErrInvalidRedirectURI
+	}
+
+	if redirect.Scheme != "http" && redirect.Scheme != "https" {
+		return o2errors.ErrInvalidRedirectURI
+	}
+
+	if base.Scheme != "" && redirect.Scheme != base.Scheme {
+		return o2errors.ErrInvalidRedirectURI
+	}
+
+	if base.Port() != "" && redirect.Port() != base.Port() {
+		return o2errors.ErrInvalidRedirectURI
+	}
+
+	host, baseHost := strings.ToLower(redirect.Hostname()), strings.ToLower(base.Hostname())
+	if baseHost == "" || (host != baseHost && !strings.HasSuffix(host, "."+baseHost)) {
+		return o2errors.ErrInvalidRedirectURI
+	}
+
+	return nil
+}
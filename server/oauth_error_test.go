@@ -0,0 +1,34 @@
+package server
+
+import "testing"
+
+func Test_validateRedirectURI(t *testing.T) {
+	tests := []struct {
+		name        string
+		baseURI     string
+		redirectURI string
+		wantErr     bool
+	}{
+		{name: "bare domain", baseURI: "moodle.test", redirectURI: "https://moodle.test/login/oauth2/callback.php"},
+		{name: "bare domain subdomain", baseURI: "moodle.test", redirectURI: "http://learn.moodle.test/cb"},
+		{name: "bare domain suffix", baseURI: "moodle.test", redirectURI: "https://evilmoodle.test/cb", wantErr: true},
+		{name: "bare domain other host", baseURI: "moodle.test", redirectURI: "https://evil.test/?moodle.test", wantErr: true},
+		{name: "relative", baseURI: "moodle.test", redirectURI: "/cb", wantErr: true},
+		{name: "no scheme", baseURI: "moodle.test", redirectURI: "moodle.test/cb", wantErr: true},
+		{name: "javascript", baseURI: "moodle.test", redirectURI: "javascript://moodle.test/%0aalert(1)", wantErr: true},
+		{name: "fragment", baseURI: "moodle.test", redirectURI: "https://moodle.test/cb#x", wantErr: true},
+		{name: "userinfo", baseURI: "moodle.test", redirectURI: "https://moodle.test@evil.test/cb", wantErr: true},
+		{name: "uri base", baseURI: "https://app.test", redirectURI: "https://app.test/cb"},
+		{name: "uri base scheme mismatch", baseURI: "https://app.test", redirectURI: "http://app.test/cb", wantErr: true},
+		{name: "uri base port", baseURI: "http://127.0.0.1:8080", redirectURI: "http://127.0.0.1:8080/cb"},
+		{name: "uri base port mismatch", baseURI: "http://127.0.0.1:8080", redirectURI: "http://127.0.0.1:9090/cb", wantErr: true},
+		{name: "bare base as redirect", baseURI: "moodle.test", redirectURI: "moodle.test", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRedirectURI(tt.baseURI, tt.redirectURI); (err != nil) != tt.wantErr {
+				t.Errorf("validateRedirectURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/oauth2"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/session"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutTokenClaims represents OpenID Connect Back-Channel Logout Token claims.
+type logoutTokenClaims struct {
+	jwt.RegisteredClaims
+	SessionID string              `json:"sid,omitempty"`
+	Events    map[string]struct{} `json:"events"`
+}
+
+var logoutTemplate = template.Must(template.New("logout").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Signed out - Semaphore</title>
+</head>
+<body>
+<p>You have been signed out.</p>
+{{range .FrontchannelURIs}}<iframe src="{{.}}" style="display:none"></iframe>
+{{end}}
+{{- if .RedirectURI}}
+<p><a href="{{.RedirectURI}}">Continue</a></p>
+<script>
+(function () {
+  var done = false;
+  function next() {
+    if (done) return;
+    done = true;
+    window.location.replace({{.RedirectURI}});
+  }
+  window.addEventListener("load", next);
+  setTimeout(next, 5000);
+})();
+</script>
+{{- else}}
+<p><a href="{{.LoginURL}}">Sign in again</a></p>
+{{- end}}
+</body>
+</html>
+`))
+
+// handleLogout implements OpenID Connect RP-Initiated Logout. The browser
+// session is ended and every client it signed into is notified through
+// front-channel and back-channel logout.
+func (s *oauthServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rl := s.realmOf(ctx)
+
+	var (
+		cli  oauth2.ClientInfo
+		hint *idTokenClaims
+		err  error
+	)
+
+	clientID := r.FormValue("client_id")
+	if raw := r.FormValue("id_token_hint"); raw != "" {
+		hint, cli, err = s.parseIDTokenHint(ctx, raw)
+		if err != nil || (clientID != "" && clientID != cli.GetID()) {
+			http.Error(w, ErrInvalidIDTokenHint.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if clientID != "" {
+		cli, err = rl.manager.GetClient(ctx, clientID)
+		if err != nil {
+			http.Error(w, "invalid client_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	redirectURI := r.FormValue("post_logout_redirect_uri")
+	if redirectURI != "" {
+		if cli == nil || !isPostLogoutRedirectURI(cli, redirectURI) {
+			http.Error(w, "post_logout_redirect_uri is not registered", http.StatusBadRequest)
+			return
+		}
+
+		if state := r.FormValue("state"); state != "" {
+			u, err := url.Parse(redirectURI)
+			if err != nil {
+				http.Error(w, "invalid post_logout_redirect_uri", http.StatusBadRequest)
+				return
+			}
+			q := u.Query()
+			q.Set("state", state)
+			u.RawQuery = q.Encode()
+			redirectURI = u.String()
+		}
+	}
+
+	var frontchannelURIs []string
+	if rt, sess, err := s.currentSession(r); err == nil {
+		// the hint must belong to whoever is signed in
+		if hint != nil && hint.Subject != rt.Subject {
+			http.Error(w, ErrInvalidIDTokenHint.Error(), http.StatusBadRequest)
+			return
+		}
+
+		frontchannelURIs = s.endSession(ctx, sess)
+
+		subjectID, _ := strconv.ParseUint(rt.Subject, 10, 64)
+		auditRequest(r, s.db, audit.Event{
+			TenantID:   rl.tenant.ID,
+			ActorID:    uint(subjectID),
+			Action:     "logout",
+			TargetType: audit.TargetSession,
+			TargetID:   sess.ID,
+			Metadata:   map[string]interface{}{"client_id": clientID},
+		})
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "rt",
+		Value:    "",
+		Path:     "/",
+		Domain:   s.v.GetString("cookie_domain"),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	if len(frontchannelURIs) == 0 && redirectURI != "" {
+		http.Redirect(w, r, redirectURI, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err = logoutTemplate.Execute(w, struct {
+		FrontchannelURIs []string
+		RedirectURI      string
+		LoginURL         string
+	}{
+		FrontchannelURIs: frontchannelURIs,
+		RedirectURI:      redirectURI,
+		LoginURL:         rl.loginURL(),
+	})
+	if err != nil {
+		jww.ERROR.Println("unable to render logout page:", err)
+	}
+}
+
+// endSession deletes sess and sends back-channel logout to the clients it
+// signed into, which are clients of the tenant of its user. Returns
+// front-channel logout uris the browser has to load.
+func (s *oauthServer) endSession(ctx context.Context, sess *session.Session) []string {
+	rl := s.userRealm(sess.UserID)
+
+	clientIDs, err := s.sessions.Clients(ctx, sess.ID)
+	if err != nil {
+		jww.ERROR.Println("unable to get session clients:", err)
+	}
+
+	if err := s.sessions.Delete(ctx, sess.ID); err != nil {
+		jww.ERROR.Println("unable to delete session:", err)
+	}
+
+	var frontchannelURIs []string
+	for _, clientID := range clientIDs {
+		cli, err := rl.manager.GetClient(ctx, clientID)
+		if err != nil {
+			continue
+		}
+
+		logoutInfo, ok := cli.(oauth2.ClientLogoutInfo)
+		if !ok {
+			continue
+		}
+
+		if uri := logoutInfo.GetFrontchannelLogoutURI(); uri != "" {
+			frontchannelURIs = append(frontchannelURIs, rl.frontchannelLogoutURI(uri, sess.ID))
+		}
+
+		if uri := logoutInfo.GetBackchannelLogoutURI(); uri != "" {
+			token, err := rl.generateLogoutToken(cli, sess)
+			if err != nil {
+				jww.ERROR.Println("unable to generate logout token:", err)
+				continue
+			}
+			s.notifier.notify(uri, token)
+		}
+	}
+
+	return frontchannelURIs
+}
+
+// revokeUser ends every session of the user, notifying the clients they
+// signed into through back-channel logout, and deletes the personal access
+// tokens of the user along with the OAuth codes and tokens issued to it.
+func (s *oauthServer) revokeUser(ctx context.Context, userID uint) error {
+	sessions, err := s.sessions.Sessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, sess := range sessions {
+		s.endSession(ctx, sess)
+	}
+
+	if err := pat.NewStore(s.db).DeleteAll(userID); err != nil {
+		return err
+	}
+
+	tokens, ok := s.userRealm(userID).tokens.(oauth2.UserTokenStore)
+	if !ok {
+		return nil
+	}
+	return tokens.RemoveByUserID(ctx, strconv.FormatUint(uint64(userID), 10))
+}
+
+// revokeSession ends sess, notifying the clients it signed into through
+// back-channel logout.
+func (s *oauthServer) revokeSession(ctx context.Context, sess *session.Session) {
+	s.endSession(ctx, sess)
+}
+
+func (rl *realm) frontchannelLogoutURI(uri string, sessionID string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	q := u.Query()
+	q.Set("iss", rl.issuer)
+	q.Set("sid", sessionID)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (rl *realm) generateLogoutToken(cli oauth2.ClientInfo, sess *session.Session) (string, error) {
+	now := time.Now()
+	claims := logoutTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    rl.issuer,
+			Subject:   strconv.FormatUint(uint64(sess.UserID), 10),
+			Audience:  jwt.ClaimStrings{cli.GetID()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(2 * time.Minute)),
+		},
+		SessionID: sess.ID,
+		Events:    map[string]struct{}{backchannelLogoutEvent: {}},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(rl.clientSigningKey(cli))
+}
+
+func isPostLogoutRedirectURI(cli oauth2.ClientInfo, uri string) bool {
+	logoutInfo, ok := cli.(oauth2.ClientLogoutInfo)
+	if !ok {
+		return false
+	}
+
+	for _, registered := range logoutInfo.GetPostLogoutRedirectURIs() {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// backchannelNotifier delivers logout tokens to clients in the background,
+// retrying with exponential backoff while the client is unreachable.
+type backchannelNotifier struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+
+	pending sync.WaitGroup
+}
+
+func newBackchannelNotifier() *backchannelNotifier {
+	return &backchannelNotifier{
+		client:   &http.Client{Timeout: 5 * time.Second},
+		attempts: 5,
+		backoff:  time.Second,
+	}
+}
+
+func (n *backchannelNotifier) notify(uri string, logoutToken string) {
+	n.pending.Add(1)
+	go func() {
+		defer n.pending.Done()
+
+		for attempt := 0; attempt < n.attempts; attempt++ {
+			if attempt > 0 {
+				time.Sleep(n.backoff << (attempt - 1))
+			}
+
+			retry, err := n.deliver(uri, logoutToken)
+			if err == nil {
+				return
+			}
+
+			jww.WARN.Printf("backchannel logout to %s failed (attempt %d): %v", uri, attempt+1, err)
+			if !retry {
+				return
+			}
+		}
+
+		jww.ERROR.Println("backchannel logout to", uri, "gave up")
+	}()
+}
+
+// wait blocks until every pending notification is delivered or given up,
+// or ctx is done.
+func (n *backchannelNotifier) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliver posts the logout token once. retry reports whether the failure
+// is worth another attempt; a 4xx means the client rejected the token.
+func (n *backchannelNotifier) deliver(uri string, logoutToken string) (retry bool, err error) {
+	form := url.Values{"logout_token": {logoutToken}}
+	res, err := n.client.PostForm(uri, form)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode >= 400 && res.StatusCode < 500:
+		return false, errors.New(res.Status)
+	default:
+		return true, errors.New(res.Status)
+	}
+}
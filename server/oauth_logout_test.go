@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/oauth2/models"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_backchannelNotifier_deliver(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantErr   bool
+		wantRetry bool
+	}{
+		{name: "200 delivered", status: http.StatusOK},
+		{name: "400 rejected", status: http.StatusBadRequest, wantErr: true, wantRetry: false},
+		{name: "503 unavailable", status: http.StatusServiceUnavailable, wantErr: true, wantRetry: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.PostFormValue("logout_token") != "token" {
+					t.Errorf("logout_token = %q, want %q", r.PostFormValue("logout_token"), "token")
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			retry, err := newBackchannelNotifier().deliver(srv.URL, "token")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if retry != tt.wantRetry {
+				t.Fatalf("deliver() retry = %v, want %v", retry, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func Test_backchannelNotifier_notify(t *testing.T) {
+	var hits int32
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		close(done)
+	}))
+	defer srv.Close()
+
+	n := newBackchannelNotifier()
+	n.backoff = time.Millisecond
+	n.notify(srv.URL, "token")
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("notify() gave up after %d attempts, want delivery on attempt 3", atomic.LoadInt32(&hits))
+	}
+}
+
+func Test_backchannelNotifier_wait(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	n := newBackchannelNotifier()
+	n.notify(srv.URL, "token")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.wait(ctx); err == nil {
+		t.Fatal("wait() returned before the pending delivery finished")
+	}
+
+	close(release)
+	if err := n.wait(context.Background()); err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+}
+
+func Test_isPostLogoutRedirectURI(t *testing.T) {
+	cli := &models.Client{
+		ID:                     "app",
+		PostLogoutRedirectURIs: []string{"https://app.test/bye"},
+	}
+
+	tests := []struct {
+		name string
+		uri  string
+		want bool
+	}{
+		{name: "registered", uri: "https://app.test/bye", want: true},
+		{name: "different path", uri: "https://app.test/bye/now", want: false},
+		{name: "different host", uri: "https://evil.test/bye", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPostLogoutRedirectURI(cli, tt.uri); got != tt.want {
+				t.Errorf("isPostLogoutRedirectURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_oauthServer_revokeUser(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &pat.Token{}, &session.Session{}, &session.Client{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sessions := session.NewDBStore(db, time.Hour)
+	s := &oauthServer{db: db, sessions: sessions, realm: &realm{tenant: tenant.Default}}
+
+	tokens := pat.NewStore(db)
+	for _, usr := range []*user.User{{Email: "revoked@example.com"}, {Email: "other@example.com"}} {
+		if err := user.NewStore(db).Create(usr); err != nil {
+			t.Fatal(err)
+		}
+		if err := sessions.Create(ctx, &session.Session{UserID: usr.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokens.Create(&pat.Token{UserID: usr.ID, Name: "ci"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.revokeUser(ctx, 1); err != nil {
+		t.Fatalf("revokeUser() error = %v", err)
+	}
+
+	// a personal access token doesn't outlive the sign out of its user
+	if got, _ := tokens.Tokens(1); len(got) != 0 {
+		t.Errorf("%d tokens of the revoked user left, want none", len(got))
+	}
+	if got, _ := sessions.Sessions(ctx, 1); len(got) != 0 {
+		t.Errorf("%d sessions of the revoked user left, want none", len(got))
+	}
+	if got, _ := tokens.Tokens(2); len(got) != 1 {
+		t.Errorf("%d tokens of the other user left, want 1", len(got))
+	}
+}
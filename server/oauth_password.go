@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	o2errors "github.com/9d4/semaphore/oauth2/errors"
+)
+
+// clientIPKey holds the client ip in the context of token requests.
+type clientIPKey struct{}
+
+// loginLockedError means the resource owner credentials can't be tried
+// for a while.
+type loginLockedError struct {
+	wait time.Duration
+}
+
+func (e *loginLockedError) Error() string {
+	return "login locked for " + e.wait.String()
+}
+
+// handlePasswordAuthorization checks the resource owner password
+// credentials. It is only reached when the password grant is allowed and
+// guarded like the login form. The grant can't ask for a second factor,
+// so users that have one have to use the authorization code flow.
+func (s *oauthServer) handlePasswordAuthorization(ctx context.Context, clientID, username, password string) (string, error) {
+	rl := s.realmOf(ctx)
+	key := guardKey(rl.tenant, username)
+
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	if wait := s.guard.reserve(ctx, key, ip); wait > 0 {
+		return "", &loginLockedError{wait: wait}
+	}
+
+	usr, err := s.logins.authenticate(ctx, rl.tenant, username, password)
+	if loginFailure(err) != "" {
+		s.guard.failed(ctx, key, ip)
+		return "", nil
+	}
+	s.guard.passed(ctx, key, ip)
+	if err != nil {
+		return "", err
+	}
+	s.guard.succeeded(ctx, key)
+
+	if allowLogin(s.verifier, *usr) != nil || !s.verifier.allowAuthorization(*usr) {
+		return "", o2errors.ErrAccessDenied
+	}
+
+	methods, err := s.mfa.methods(usr.ID)
+	if err != nil {
+		return "", err
+	}
+	if len(methods) > 0 || usr.MFAEnforced {
+		return "", o2errors.ErrAccessDenied
+	}
+
+	return strconv.FormatUint(uint64(usr.ID), 10), nil
+}
+
+// loginLockedResponse replies a locked login like the login form does,
+// with the time to wait in Retry-After.
+func loginLockedResponse(err *loginLockedError) *o2errors.Response {
+	header := http.Header{}
+	header.Set("Retry-After", retryAfter(err.wait))
+
+	return &o2errors.Response{
+		Error:       o2errors.ErrTemporarilyUnavailable,
+		Description: "Too many failed logins, please try again later",
+		StatusCode:  http.StatusTooManyRequests,
+		Header:      header,
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/9d4/semaphore/auth"
+	o2errors "github.com/9d4/semaphore/oauth2/errors"
+	"github.com/9d4/semaphore/session"
+	"github.com/go-redis/redis/v9"
+)
+
+const (
+	promptNone          = "none"
+	promptLogin         = "login"
+	promptConsent       = "consent"
+	promptSelectAccount = "select_account"
+)
+
+const consentKeyPrefix = "oauth:consent:"
+
+// authRequest holds the OpenID Connect parameters of an authorization
+// request that constrain how recent and how strong the user's
+// authentication has to be.
+type authRequest struct {
+	prompt    map[string]bool
+	maxAge    time.Duration
+	hasMaxAge bool
+	acrValues []string
+
+	// reauthAt is when the session with reauthSession was sent to sign in
+	// again for this request.
+	reauthAt      time.Time
+	reauthSession string
+}
+
+// parseAuthRequest reads the parameters of r. The reauth marker only
+// counts when it was signed with key for the client of r.
+func parseAuthRequest(r *http.Request, key []byte) (*authRequest, error) {
+	a := &authRequest{prompt: make(map[string]bool)}
+
+	for _, p := range strings.Fields(r.FormValue("prompt")) {
+		switch p {
+		case promptNone, promptLogin, promptConsent, promptSelectAccount:
+			a.prompt[p] = true
+		default:
+			return nil, o2errors.ErrInvalidRequest
+		}
+	}
+
+	// none must not be combined with anything else
+	if a.prompt[promptNone] && len(a.prompt) > 1 {
+		return nil, o2errors.ErrInvalidRequest
+	}
+
+	if v := r.FormValue("max_age"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			return nil, o2errors.ErrInvalidRequest
+		}
+		a.maxAge = time.Duration(seconds) * time.Second
+		a.hasMaxAge = true
+	}
+
+	a.acrValues = strings.Fields(r.FormValue("acr_values"))
+
+	if v := r.FormValue("reauth"); v != "" {
+		marker, err := auth.ValidateReauthToken(v, auth.DefaultJwtKeyFunc(key))
+		if err == nil && marker.ClientID == r.FormValue("client_id") {
+			a.reauthAt = marker.IssuedAt.Time
+			a.reauthSession = marker.SessionID
+		}
+	}
+
+	return a, nil
+}
+
+// reauthenticated reports whether sess has authenticated since the user was
+// sent to sign in again for this request.
+func (a *authRequest) reauthenticated(sess *session.Session) bool {
+	return !a.reauthAt.IsZero() && a.reauthSession == sess.ID && !sess.AuthTime.Before(a.reauthAt)
+}
+
+// loginRequired reports whether the user has to sign in again before the
+// request can be granted.
+func (a *authRequest) loginRequired(sess *session.Session) bool {
+	if a.reauthenticated(sess) {
+		return false
+	}
+
+	if a.prompt[promptLogin] {
+		return true
+	}
+
+	return a.hasMaxAge && time.Since(sess.AuthTime) > a.maxAge
+}
+
+// stepUpRequired reports whether the session falls short of the requested
+// acr_values.
+func (a *authRequest) stepUpRequired(sess *session.Session) bool {
+	return !auth.ACRSatisfies(sess.ACR, a.acrValues)
+}
+
+// hasConsent reports whether the user has already granted every scope to
+// the client.
+func (s *oauthServer) hasConsent(ctx context.Context, userID uint, clientID string, scope string) (bool, error) {
+	granted, err := s.rdb.Get(ctx, consentKey(userID, clientID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	grantedScopes := strings.Fields(granted)
+	for _, sc := range strings.Fields(scope) {
+		if !containsString(grantedScopes, sc) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// saveConsent remembers that the user granted scope to the client, in
+// addition to whatever was granted before.
+func (s *oauthServer) saveConsent(ctx context.Context, userID uint, clientID string, scope string) error {
+	key := consentKey(userID, clientID)
+
+	granted, err := s.rdb.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	scopes := strings.Fields(granted)
+	for _, sc := range strings.Fields(scope) {
+		if !containsString(scopes, sc) {
+			scopes = append(scopes, sc)
+		}
+	}
+
+	return s.rdb.Set(ctx, key, strings.Join(scopes, " "), 0).Err()
+}
+
+func consentKey(userID uint, clientID string) string {
+	return consentKeyPrefix + strconv.FormatUint(uint64(userID), 10) + ":" + clientID
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
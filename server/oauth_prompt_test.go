@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/auth"
+	"github.com/9d4/semaphore/session"
+)
+
+func Test_parseAuthRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{name: "no parameters", query: ""},
+		{name: "prompt login consent", query: "prompt=login+consent"},
+		{name: "prompt none", query: "prompt=none"},
+		{name: "prompt none with login", query: "prompt=none+login", wantErr: true},
+		{name: "unknown prompt", query: "prompt=create", wantErr: true},
+		{name: "max_age", query: "max_age=300"},
+		{name: "negative max_age", query: "max_age=-1", wantErr: true},
+		{name: "malformed max_age", query: "max_age=soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/oauth2/authorize?"+tt.query, nil)
+			if _, err := parseAuthRequest(r, []byte("key")); (err != nil) != tt.wantErr {
+				t.Errorf("parseAuthRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_authRequest_loginRequired(t *testing.T) {
+	now := time.Now()
+	key := []byte("key")
+	marker := func(sessionID string, clientID string, k []byte) string {
+		token, err := auth.GenerateReauthToken(sessionID, clientID, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	sentAt := marker("sid", "app", key)
+
+	tests := []struct {
+		name     string
+		query    string
+		authTime time.Time
+		want     bool
+	}{
+		{name: "no constraints", query: "", authTime: now.Add(-time.Hour)},
+		{name: "prompt login", query: "prompt=login", authTime: now, want: true},
+		{name: "prompt login after reauth", query: "client_id=app&prompt=login&reauth=" + sentAt, authTime: now.Add(time.Second)},
+		{name: "prompt login before reauth", query: "client_id=app&prompt=login&reauth=" + sentAt, authTime: now.Add(-time.Hour), want: true},
+		{name: "reauth of another client", query: "client_id=other&prompt=login&reauth=" + sentAt, authTime: now.Add(time.Second), want: true},
+		{name: "reauth of another session", query: "client_id=app&prompt=login&reauth=" + marker("other", "app", key), authTime: now.Add(time.Second), want: true},
+		{name: "reauth signed with another key", query: "client_id=app&prompt=login&reauth=" + marker("sid", "app", []byte("forged")), authTime: now.Add(time.Second), want: true},
+		{name: "reauth timestamp", query: "client_id=app&max_age=0&reauth=" + strconv.FormatInt(now.Unix(), 10), authTime: now.Add(-time.Minute), want: true},
+		{name: "max_age fresh", query: "max_age=600", authTime: now.Add(-time.Minute)},
+		{name: "max_age exceeded", query: "max_age=600", authTime: now.Add(-time.Hour), want: true},
+		{name: "max_age zero", query: "max_age=0", authTime: now.Add(-time.Second), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/oauth2/authorize?"+tt.query, nil)
+			areq, err := parseAuthRequest(r, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sess := &session.Session{ID: "sid", AuthTime: tt.authTime, ACR: auth.ACRPassword}
+			if got := areq.loginRequired(sess); got != tt.want {
+				t.Errorf("loginRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authRequest_stepUpRequired(t *testing.T) {
+	r := httptest.NewRequest("GET", "/oauth2/authorize?acr_values="+auth.ACRMultiFactor, nil)
+	areq, err := parseAuthRequest(r, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !areq.stepUpRequired(&session.Session{ACR: auth.ACRPassword}) {
+		t.Error("stepUpRequired() = false for password session, want true")
+	}
+
+	if areq.stepUpRequired(&session.Session{ACR: auth.ACRMultiFactor}) {
+		t.Error("stepUpRequired() = true for mfa session, want false")
+	}
+}
@@ -51,7 +51,15 @@ func newOAuthResourceServer(db *gorm.DB, opts ...Option) *oAuthResourceServer {
 }
 
 func (s *oAuthResourceServer) setupRoutes() {
-	bearerAuth := middleware.OAuthBearerAuth(s.KeyBytes)
+	// tenants verify the tokens they issued with their own key, before the
+	// default key gets to reject them
+	tokens := &personalTokens{db: s.db}
+	tenantRouter := s.Group("/t/:tenant", resolveTenant(s.db), func(c *fiber.Ctx) error {
+		return middleware.OAuthBearerAuth([]byte(tenantOf(c).SigningKey), tokens)(c)
+	})
+	tenantRouter.Get("/userinfo", s.handleUserInfo)
+
+	bearerAuth := middleware.OAuthBearerAuth(s.KeyBytes, tokens)
 
 	router := s.Group("/", bearerAuth)
 	router.Get("/userinfo", s.handleUserInfo)
@@ -64,6 +72,9 @@ func (s *oAuthResourceServer) handleUserInfo(c *fiber.Ctx) error {
 	}
 
 	usr, err := s.userStore.UserByID(cast.ToUint(at.Subject))
+	if err == nil && usr.TenantID != tenantOf(c).ID {
+		err = user.ErrUserNotFound
+	}
 	if err != nil {
 		if errors.Is(err, user.ErrUserNotFound) {
 			return fiber.ErrNotFound
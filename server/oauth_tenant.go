@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/9d4/semaphore/oauth2"
+	"github.com/9d4/semaphore/oauth2/manage"
+	o2server "github.com/9d4/semaphore/oauth2/server"
+	"github.com/9d4/semaphore/oauth2/store"
+	oredis "github.com/9d4/semaphore/oauth2/store/redis"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+// realm is the authorization server of a tenant, with its own issuer,
+// signing key, clients and tokens.
+type realm struct {
+	tenant  *tenant.Tenant
+	issuer  string
+	key     []byte
+	manager *manage.Manager
+	server  *o2server.Server
+	tokens  oauth2.TokenStore
+}
+
+// realmKey holds the realm serving the request in its context.
+type realmKey struct{}
+
+func withRealm(ctx context.Context, rl *realm) context.Context {
+	return context.WithValue(ctx, realmKey{}, rl)
+}
+
+// realmOf gets the realm serving ctx, the one of the default tenant
+// outside of /t/{tenant}/.
+func (s *oauthServer) realmOf(ctx context.Context) *realm {
+	if rl, ok := ctx.Value(realmKey{}).(*realm); ok {
+		return rl
+	}
+	return s.realm
+}
+
+// tenantRealm gets the realm of t. Realms are kept until t changes, its
+// key or scopes may have.
+func (s *oauthServer) tenantRealm(t *tenant.Tenant) *realm {
+	if t.IsDefault() {
+		return s.realm
+	}
+
+	s.realmsMu.Lock()
+	defer s.realmsMu.Unlock()
+
+	rl, ok := s.realms[t.Slug]
+	if ok && rl.tenant.ID == t.ID && rl.tenant.UpdatedAt.Equal(t.UpdatedAt) && string(rl.key) == t.SigningKey {
+		return rl
+	}
+
+	issuer := strings.TrimSuffix(s.Issuer, "/") + "/t/" + t.Slug
+	clients := store.NewTenantClientStoreRedis(s.rdb, t.Slug)
+	tokens := oredis.NewRedisStoreWithCli(s.tokenRDB, "tenant:"+t.Slug+":")
+
+	rl = s.newRealm(t, issuer, []byte(t.SigningKey), clients, tokens)
+	s.realms[t.Slug] = rl
+	return rl
+}
+
+// userRealm gets the realm of the tenant of the user with the specified
+// ID, the one of the default tenant when the user can't be found.
+func (s *oauthServer) userRealm(userID uint) *realm {
+	var usr user.User
+	err := s.db.Unscoped().Select("tenant_id").Where("id = ?", userID).First(&usr).Error
+	if err != nil || usr.TenantID == 0 {
+		return s.realm
+	}
+
+	t, err := tenant.NewStore(s.db).TenantByID(usr.TenantID)
+	if err != nil {
+		return s.realm
+	}
+	return s.tenantRealm(t)
+}
+
+// handleTenant serves /t/{tenant}/oauth2/... with the realm of the tenant.
+func (s *oauthServer) handleTenant(w http.ResponseWriter, r *http.Request) {
+	slug, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/t/"), "/")
+	if !ok || slug == tenant.DefaultSlug {
+		http.NotFound(w, r)
+		return
+	}
+
+	handler := map[string]http.HandlerFunc{
+		"oauth2/authorize": s.handleAuthorize,
+		"oauth2/token":     s.handleToken,
+		"oauth2/logout":    s.handleLogout,
+	}[rest]
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t, err := tenant.NewStore(s.db).Tenant(slug)
+	if errors.Is(err, tenant.ErrTenantNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		jww.ERROR.Println("unable to get tenant:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	handler(w, r.WithContext(withRealm(r.Context(), s.tenantRealm(t))))
+}
+
+// filterScopes drops the requested scopes semaphore doesn't know about or
+// the tenant doesn't allow.
+func (rl *realm) filterScopes(raw string) string {
+	var scopes []string
+	for _, scope := range strings.Fields(filterScopes(raw)) {
+		if rl.tenant.AllowsScope(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// query adds the tenant to the query of an authorization request handed
+// to the sign in and consent pages, which post back to the tenant.
+func (rl *realm) query(rawQuery string) string {
+	if rl.tenant.IsDefault() {
+		return rawQuery
+	}
+	return rawQuery + "&tenant=" + url.QueryEscape(rl.tenant.Slug)
+}
+
+// loginURL is where the users of the realm sign in again.
+func (rl *realm) loginURL() string {
+	if rl.tenant.IsDefault() {
+		return "/login"
+	}
+	return "/login?tenant=" + url.QueryEscape(rl.tenant.Slug)
+}
+
+// clientSigningKey returns the key tokens addressed to cli are signed with.
+// Confidential clients verify with their own secret as OpenID Connect
+// specifies for HMAC algorithms.
+func (rl *realm) clientSigningKey(cli oauth2.ClientInfo) []byte {
+	if secret := cli.GetSecret(); secret != "" {
+		return []byte(secret)
+	}
+	return rl.key
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/9d4/semaphore/oauth2"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/user"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/cast"
+	jww "github.com/spf13/jwalterweatherman"
+)
+
+var ErrInvalidIDTokenHint = errors.New("invalid id_token_hint")
+
+// idTokenClaims represents OpenID Connect ID Token claims.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	SessionID     string           `json:"sid,omitempty"`
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	ACR           string           `json:"acr,omitempty"`
+	AMR           []string         `json:"amr,omitempty"`
+	Email         string           `json:"email,omitempty"`
+	EmailVerified *bool            `json:"email_verified,omitempty"`
+	GivenName     string           `json:"given_name,omitempty"`
+	FamilyName    string           `json:"family_name,omitempty"`
+	Roles         []string         `json:"roles,omitempty"`
+	Groups        []string         `json:"groups,omitempty"`
+}
+
+// handleExtensionFields adds id_token to the token response when openid
+// scope is granted, ctx carries the realm that issued ti.
+func (s *oauthServer) handleExtensionFields(ctx context.Context, ti oauth2.TokenInfo) map[string]interface{} {
+	if !hasScope(ti.GetScope(), ScopeOpenID) {
+		return nil
+	}
+
+	idToken, err := s.generateIDToken(ctx, ti)
+	if err != nil {
+		jww.ERROR.Println("unable to generate id token:", err)
+		return nil
+	}
+
+	return map[string]interface{}{"id_token": idToken}
+}
+
+func (s *oauthServer) generateIDToken(ctx context.Context, ti oauth2.TokenInfo) (string, error) {
+	rl := s.realmOf(ctx)
+	cli, err := rl.manager.GetClient(ctx, ti.GetClientID())
+	if err != nil {
+		return "", err
+	}
+
+	usr, err := user.NewStore(s.db).UserByID(cast.ToUint(ti.GetUserID()))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    rl.issuer,
+			Subject:   ti.GetUserID(),
+			Audience:  jwt.ClaimStrings{cli.GetID()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn())),
+		},
+		SessionID: ti.GetSessionID(),
+	}
+
+	if sid := ti.GetSessionID(); sid != "" {
+		sess, err := s.sessions.Session(ctx, sid)
+		if err == nil {
+			claims.AuthTime = jwt.NewNumericDate(sess.AuthTime)
+			claims.ACR = sess.ACR
+			claims.AMR = sess.AMR
+		}
+	}
+
+	if hasScope(ti.GetScope(), ScopeEmail) {
+		claims.Email = usr.Email
+		verified := usr.EmailVerified()
+		claims.EmailVerified = &verified
+	}
+
+	if hasScope(ti.GetScope(), ScopeProfile) {
+		claims.GivenName = usr.FirstName
+		claims.FamilyName = usr.LastName
+	}
+
+	claims.Roles, claims.Groups, err = s.userClaims(ctx, cli, ti.GetUserID())
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(rl.clientSigningKey(cli))
+}
+
+// userClaims gets the roles and groups of the user for the tokens of cli,
+// each only when the client is registered to receive it.
+func (s *oauthServer) userClaims(_ context.Context, cli oauth2.ClientInfo, userID string) ([]string, []string, error) {
+	info, ok := cli.(oauth2.ClientClaimsInfo)
+	if !ok || !(info.GetRolesClaim() || info.GetGroupsClaim()) {
+		return nil, nil, nil
+	}
+
+	grants, err := rbac.NewStore(s.db).Grants(cast.ToUint(userID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var roles, groups []string
+	if info.GetRolesClaim() {
+		roles = grants.Roles
+	}
+	if info.GetGroupsClaim() {
+		groups = grants.Groups
+	}
+	return roles, groups, nil
+}
+
+// parseIDTokenHint verifies an ID Token previously issued by this server and
+// returns it along with the client it was issued to. Expiration is not
+// checked since RPs commonly send the hint after the token has expired.
+func (s *oauthServer) parseIDTokenHint(ctx context.Context, raw string) (*idTokenClaims, oauth2.ClientInfo, error) {
+	rl := s.realmOf(ctx)
+	claims := &idTokenClaims{}
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation(), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	var cli oauth2.ClientInfo
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if len(claims.Audience) != 1 {
+			return nil, ErrInvalidIDTokenHint
+		}
+
+		c, err := rl.manager.GetClient(ctx, claims.Audience[0])
+		if err != nil {
+			return nil, ErrInvalidIDTokenHint
+		}
+
+		cli = c
+		return rl.clientSigningKey(c), nil
+	})
+	if err != nil || claims.Issuer != rl.issuer {
+		return nil, nil, ErrInvalidIDTokenHint
+	}
+
+	return claims, cli, nil
+}
+
+func hasScope(scope string, want OAuth2Scope) bool {
+	for _, sc := range strings.Fields(scope) {
+		if sc == string(want) {
+			return true
+		}
+	}
+	return false
+}
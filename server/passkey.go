@@ -0,0 +1,354 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/passkey"
+	"github.com/9d4/semaphore/user"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidWebAuthnOrigin = errors.New("invalid webauthn origin")
+
+var (
+	// passkeyAMR are the methods a passwordless login is made of. User
+	// verification is required so the passkey counts as two factors.
+	passkeyAMR = []string{auth.AMRHWK, auth.AMRMFA}
+	// passkeyMFAAMR are the methods of a password login completed with a
+	// passkey.
+	passkeyMFAAMR = []string{auth.AMRPassword, auth.AMRHWK, auth.AMRMFA}
+)
+
+// passkeyCeremony runs the WebAuthn registration and assertion ceremonies.
+// The challenge of each one is kept in the ceremony store until the
+// browser answers it.
+type passkeyCeremony struct {
+	webauthn   *webauthn.WebAuthn
+	users      user.Store
+	passkeys   passkey.Store
+	ceremonies passkey.CeremonyStore
+	mfa        *mfaChallenge
+}
+
+func newPasskeyCeremony(db *gorm.DB, ceremonies passkey.CeremonyStore, m *mfaChallenge, config *Config) (*passkeyCeremony, error) {
+	origin := getOrDefault(config.WebAuthnOrigin, config.Issuer)
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" {
+		return nil, ErrInvalidWebAuthnOrigin
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: totpIssuer,
+		RPID:          u.Hostname(),
+		RPOrigin:      origin,
+		Timeout:       int(passkey.CeremonyTimeout.Milliseconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyCeremony{
+		webauthn:   wa,
+		users:      user.NewStore(db),
+		passkeys:   passkey.NewStore(db),
+		ceremonies: ceremonies,
+		mfa:        m,
+	}, nil
+}
+
+// passkeyUser loads usr along with its credentials.
+func (p *passkeyCeremony) passkeyUser(usr *user.User) (*passkey.User, error) {
+	credentials, err := p.passkeys.Credentials(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkey.User{User: usr, Credentials: credentials}, nil
+}
+
+// beginRegistration challenges the browser to create a new credential for
+// usr. Passkeys are preferred so the credential can sign in on its own.
+func (p *passkeyCeremony) beginRegistration(ctx context.Context, usr *user.User) (fiber.Map, error) {
+	pu, err := p.passkeyUser(usr)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, len(pu.Credentials))
+	for i, c := range pu.Credentials {
+		exclusions[i] = c.WebAuthn().Descriptor()
+	}
+
+	options, session, err := p.webauthn.BeginRegistration(pu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.startCeremony(ctx, passkey.CeremonyRegistration, usr.ID, session, options)
+}
+
+// finishRegistration verifies the new credential and stores it under name.
+func (p *passkeyCeremony) finishRegistration(ctx context.Context, usr *user.User, ceremonyID string, response json.RawMessage, name string) (*passkey.Credential, error) {
+	ceremony, err := p.takeCeremony(ctx, ceremonyID, passkey.CeremonyRegistration, usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	pu, err := p.passkeyUser(usr)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := p.webauthn.CreateCredential(pu, ceremony.Session, parsed)
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+
+	c := passkey.NewCredential(usr.ID, name, credential)
+	if err := p.passkeys.Create(c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// handleLoginBegin starts a passwordless login. Any passkey stored on the
+// authenticator can answer it, the user is known once it does.
+func (p *passkeyCeremony) handleLoginBegin(c *fiber.Ctx) error {
+	options, session, err := p.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	challenge, err := p.startCeremony(c.UserContext(), passkey.CeremonyLogin, 0, session, options)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(challenge)
+}
+
+// completeLogin verifies the answer to a passwordless login challenge and
+// returns the user signing in.
+func (p *passkeyCeremony) completeLogin(c *fiber.Ctx) (*user.User, error) {
+	body := struct {
+		Ceremony   string          `json:"ceremony"`
+		Credential json.RawMessage `json:"credential"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, fiber.ErrBadRequest
+	}
+
+	ceremony, err := p.takeCeremony(c.UserContext(), body.Ceremony, passkey.CeremonyLogin, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body.Credential))
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	var pu *passkey.User
+	credential, err := p.webauthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) == 0 {
+			return nil, passkey.ErrCredentialNotFound
+		}
+
+		usr, err := p.users.ForTenant(tenantOf(c).ID).User(&user.User{UUID: string(userHandle)})
+		if err != nil {
+			return nil, err
+		}
+
+		pu, err = p.passkeyUser(usr)
+		return pu, err
+	}, ceremony.Session, parsed)
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	if err := p.used(pu, credential); err != nil {
+		return nil, err
+	}
+
+	return pu.User, nil
+}
+
+// handleSecondFactorBegin challenges a user that is waiting for the second
+// factor with the credentials it has registered.
+func (p *passkeyCeremony) handleSecondFactorBegin(c *fiber.Ctx) error {
+	body := struct {
+		MFAToken string `json:"mfa_token"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+
+	usr, _, err := p.mfa.challengedUser(body.MFAToken)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	pu, err := p.passkeyUser(usr)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	if len(pu.Credentials) == 0 {
+		return errs.WriteErrorJSON(c, errs.ErrPasskeyNotFound)
+	}
+
+	options, session, err := p.webauthn.BeginLogin(pu)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	challenge, err := p.startCeremony(c.UserContext(), passkey.CeremonySecondFactor, usr.ID, session, options)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(challenge)
+}
+
+// completeSecondFactor verifies the answer to a second factor challenge
+// and returns the user signing in.
+func (p *passkeyCeremony) completeSecondFactor(c *fiber.Ctx) (*user.User, error) {
+	body := struct {
+		MFAToken   string          `json:"mfa_token"`
+		Ceremony   string          `json:"ceremony"`
+		Credential json.RawMessage `json:"credential"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, fiber.ErrBadRequest
+	}
+
+	usr, token, err := p.mfa.challengedUser(body.MFAToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.mfa.verify(c, usr, token, func() error {
+		ceremony, err := p.takeCeremony(c.UserContext(), body.Ceremony, passkey.CeremonySecondFactor, usr.ID)
+		if err != nil {
+			return err
+		}
+
+		parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body.Credential))
+		if err != nil {
+			return passkeyError(err)
+		}
+
+		pu, err := p.passkeyUser(usr)
+		if err != nil {
+			return err
+		}
+
+		credential, err := p.webauthn.ValidateLogin(pu, ceremony.Session, parsed)
+		if err != nil {
+			return passkeyError(err)
+		}
+
+		return p.used(pu, credential)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usr, nil
+}
+
+// used records a successful assertion. An assertion whose signature
+// counter didn't increase is refused since the credential may be cloned.
+func (p *passkeyCeremony) used(pu *passkey.User, credential *webauthn.Credential) error {
+	c := pu.Credential(credential.ID)
+	if c == nil {
+		return errs.ErrPasskeyInvalid
+	}
+
+	if credential.Authenticator.CloneWarning {
+		jww.WARN.Printf("passkey %d of user %d reported a sign count that didn't increase", c.ID, c.UserID)
+		return errs.ErrPasskeyInvalid
+	}
+
+	if err := p.passkeys.Used(c, credential.Authenticator.SignCount); err != nil {
+		return passkeyError(err)
+	}
+
+	return nil
+}
+
+func (p *passkeyCeremony) startCeremony(ctx context.Context, kind string, userID uint, session *webauthn.SessionData, options interface{}) (fiber.Map, error) {
+	ceremony := &passkey.Ceremony{
+		Kind:    kind,
+		UserID:  userID,
+		Session: *session,
+	}
+	if err := p.ceremonies.Create(ctx, ceremony); err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"ceremony": ceremony.ID,
+		"options":  options,
+	}, nil
+}
+
+// takeCeremony gets the ceremony the browser is answering, it must have
+// been started for the same kind and user.
+func (p *passkeyCeremony) takeCeremony(ctx context.Context, id string, kind string, userID uint) (*passkey.Ceremony, error) {
+	if id == "" {
+		return nil, errs.ErrPasskeyInvalid
+	}
+
+	ceremony, err := p.ceremonies.Take(ctx, id)
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	if ceremony.Kind != kind || ceremony.UserID != userID {
+		return nil, errs.ErrPasskeyInvalid
+	}
+
+	return ceremony, nil
+}
+
+// passkeyError maps passkey and webauthn errors to what is replied.
+func passkeyError(err error) error {
+	var pe *protocol.Error
+	switch {
+	case errors.Is(err, passkey.ErrCredentialNotFound):
+		return errs.ErrPasskeyNotFound
+	case errors.Is(err, passkey.ErrCeremonyNotFound), errors.Is(err, passkey.ErrSignCount):
+		return errs.ErrPasskeyInvalid
+	case errors.As(err, &pe):
+		jww.DEBUG.Println("passkey:", pe.Type, pe.Details, pe.DevInfo)
+		return errs.ErrPasskeyInvalid
+	default:
+		return err
+	}
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/9d4/semaphore/mfa"
+	"github.com/9d4/semaphore/passkey"
+	"github.com/9d4/semaphore/user"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_passkeyCeremony(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &mfa.TOTP{}, &mfa.RecoveryCode{}, &passkey.Credential{}); err != nil {
+		t.Fatal(err)
+	}
+
+	const origin = "https://semaphore.test"
+	m := newMFAChallenge(db, newMemLoginGuard(), []byte("key"))
+	p, err := newPasskeyCeremony(db, newMemCeremonyStore(), m, &Config{Issuer: origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usr := &user.User{Email: "passkey@example.com"}
+	if err := m.users.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/register", func(c *fiber.Ctx) error {
+		challenge, err := p.beginRegistration(c.UserContext(), usr)
+		if err != nil {
+			return replyError(c, err)
+		}
+		return c.JSON(challenge)
+	})
+	app.Post("/register/finish", func(c *fiber.Ctx) error {
+		body := struct {
+			Ceremony   string          `json:"ceremony"`
+			Credential json.RawMessage `json:"credential"`
+		}{}
+		if err := c.BodyParser(&body); err != nil {
+			return err
+		}
+
+		credential, err := p.finishRegistration(c.UserContext(), usr, body.Ceremony, body.Credential, "")
+		if err != nil {
+			return replyError(c, err)
+		}
+		return c.JSON(credential)
+	})
+	app.Post("/password", func(c *fiber.Ctx) error {
+		required, err := m.begin(c, *usr)
+		if err != nil || required {
+			return err
+		}
+		return c.SendString("signed in")
+	})
+	app.Post("/login/passkey", p.handleLoginBegin)
+	app.Post("/login/passkey/finish", func(c *fiber.Ctx) error {
+		signedIn, err := p.completeLogin(c)
+		if err != nil {
+			return replyError(c, err)
+		}
+		return c.JSON(fiber.Map{"email": signedIn.Email})
+	})
+	app.Post("/login/mfa/webauthn", p.handleSecondFactorBegin)
+	app.Post("/login/mfa/webauthn/finish", func(c *fiber.Ctx) error {
+		signedIn, err := p.completeSecondFactor(c)
+		if err != nil {
+			return replyError(c, err)
+		}
+		return c.JSON(fiber.Map{"email": signedIn.Email})
+	})
+
+	post := func(path string, body interface{}) (int, map[string]interface{}) {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("POST", path, strings.NewReader(string(buf)))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := map[string]interface{}{}
+		_ = json.NewDecoder(res.Body).Decode(&data)
+		return res.StatusCode, data
+	}
+
+	authenticator := newSoftAuthenticator(t, origin)
+
+	_, challenge := post("/register", nil)
+	status, _ := post("/register/finish", fiber.Map{
+		"ceremony":   challenge["ceremony"],
+		"credential": authenticator.create(t, challenge, []byte(usr.UUID)),
+	})
+	if status != 200 {
+		t.Fatalf("registration status = %d, want 200", status)
+	}
+
+	// registering the same authenticator again is excluded
+	_, challenge = post("/register", nil)
+	excluded := challenge["options"].(map[string]interface{})["publicKey"].(map[string]interface{})["excludeCredentials"]
+	if list, _ := excluded.([]interface{}); len(list) != 1 {
+		t.Errorf("excludeCredentials = %v, want the registered credential", excluded)
+	}
+
+	// passwordless
+	_, challenge = post("/login/passkey", nil)
+	answer := fiber.Map{
+		"ceremony":   challenge["ceremony"],
+		"credential": authenticator.get(t, challenge, 2),
+	}
+	if status, data := post("/login/passkey/finish", answer); status != 200 || data["email"] != usr.Email {
+		t.Fatalf("passkey login = %d %v, want signed in", status, data)
+	}
+
+	if status, _ := post("/login/passkey/finish", answer); status != fiber.StatusUnauthorized {
+		t.Errorf("replayed login status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	// a counter that didn't increase looks like a cloned authenticator
+	_, challenge = post("/login/passkey", nil)
+	if status, _ := post("/login/passkey/finish", fiber.Map{
+		"ceremony":   challenge["ceremony"],
+		"credential": authenticator.get(t, challenge, 2),
+	}); status != fiber.StatusUnauthorized {
+		t.Errorf("same sign count status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	// as a second factor after the password
+	_, data := post("/password", nil)
+	if data["status"] != mfaStatusRequired {
+		t.Fatalf("status = %v, want %v", data["status"], mfaStatusRequired)
+	}
+	if methods, _ := data["methods"].([]interface{}); len(methods) != 1 || methods[0] != mfaMethodWebAuthn {
+		t.Errorf("methods = %v, want [%s]", data["methods"], mfaMethodWebAuthn)
+	}
+	token := data["mfa_token"]
+
+	// a passwordless challenge doesn't complete a second factor
+	_, challenge = post("/login/passkey", nil)
+	if status, _ := post("/login/mfa/webauthn/finish", fiber.Map{
+		"mfa_token":  token,
+		"ceremony":   challenge["ceremony"],
+		"credential": authenticator.get(t, challenge, 3),
+	}); status != fiber.StatusUnauthorized {
+		t.Errorf("login ceremony as second factor status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	_, challenge = post("/login/mfa/webauthn", fiber.Map{"mfa_token": token})
+	if status, data := post("/login/mfa/webauthn/finish", fiber.Map{
+		"mfa_token":  token,
+		"ceremony":   challenge["ceremony"],
+		"credential": authenticator.get(t, challenge, 4),
+	}); status != 200 || data["email"] != usr.Email {
+		t.Fatalf("second factor = %d %v, want signed in", status, data)
+	}
+
+	credentials, err := p.passkeys.Credentials(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials) != 1 || credentials[0].SignCount != 4 || credentials[0].LastUsedAt == nil {
+		t.Errorf("Credentials() = %+v, want one used at sign count 4", credentials)
+	}
+}
+
+// softAuthenticator is a WebAuthn authenticator holding a single P-256
+// credential, it always verifies the user.
+type softAuthenticator struct {
+	origin     string
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+}
+
+func newSoftAuthenticator(t *testing.T, origin string) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{origin: origin, key: key, id: id}
+}
+
+const (
+	authFlagUserPresent  = 0x01
+	authFlagUserVerified = 0x04
+	authFlagAttestedData = 0x40
+)
+
+// create answers a registration challenge with a "none" attestation.
+func (a *softAuthenticator) create(t *testing.T, challenge map[string]interface{}, userHandle []byte) fiber.Map {
+	t.Helper()
+	a.userHandle = userHandle
+
+	options := challenge["options"].(map[string]interface{})["publicKey"].(map[string]interface{})
+	rpID := options["rp"].(map[string]interface{})["id"].(string)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authData := a.authData(rpID, authFlagUserPresent|authFlagUserVerified|authFlagAttestedData, 1)
+	authData = append(authData, make([]byte, 16)...) // aaguid
+	idLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(idLen, uint16(len(a.id)))
+	authData = append(authData, idLen...)
+	authData = append(authData, a.id...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fiber.Map{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": fiber.Map{
+			"clientDataJSON":    a.clientData(t, "webauthn.create", options["challenge"].(string)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	}
+}
+
+// get answers an assertion challenge reporting signCount.
+func (a *softAuthenticator) get(t *testing.T, challenge map[string]interface{}, signCount uint32) fiber.Map {
+	t.Helper()
+
+	options := challenge["options"].(map[string]interface{})["publicKey"].(map[string]interface{})
+	rpID := options["rpId"].(string)
+
+	clientData := a.clientData(t, "webauthn.get", options["challenge"].(string))
+	clientDataJSON, _ := base64.RawURLEncoding.DecodeString(clientData)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	authData := a.authData(rpID, authFlagUserPresent|authFlagUserVerified, signCount)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fiber.Map{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": fiber.Map{
+			"clientDataJSON":    clientData,
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+		},
+	}
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, signCount)
+	return append(append(rpIDHash[:], flags), counter...)
+}
+
+// clientData collects the client data the way a browser does, the
+// challenge is decoded from the options and encoded as base64url.
+func (a *softAuthenticator) clientData(t *testing.T, typ string, challenge string) string {
+	t.Helper()
+
+	raw, err := base64.StdEncoding.DecodeString(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(raw),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// memCeremonyStore keeps ceremonies in memory in place of redis.
+type memCeremonyStore struct {
+	mu         sync.Mutex
+	ceremonies map[string]passkey.Ceremony
+	next       int
+}
+
+func newMemCeremonyStore() *memCeremonyStore {
+	return &memCeremonyStore{ceremonies: map[string]passkey.Ceremony{}}
+}
+
+func (s *memCeremonyStore) Create(_ context.Context, c *passkey.Ceremony) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next++
+	c.ID = strconv.Itoa(s.next)
+	s.ceremonies[c.ID] = *c
+	return nil
+}
+
+func (s *memCeremonyStore) Take(_ context.Context, id string) (*passkey.Ceremony, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.ceremonies[id]
+	if !ok {
+		return nil, passkey.ErrCeremonyNotFound
+	}
+	delete(s.ceremonies, id)
+	return &c, nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/user"
+	"github.com/9d4/semaphore/util"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+// passwordPolicy decides which passwords users can choose, wherever they
+// choose one.
+type passwordPolicy struct {
+	policy  password.Policy
+	users   user.Store
+	history password.HistoryStore
+	// corpus is nil when no breached password corpus is configured
+	corpus password.Corpus
+}
+
+func newPasswordPolicy(db *gorm.DB, config *Config) (*passwordPolicy, error) {
+	p := &passwordPolicy{
+		policy: password.Policy{
+			MinLength: config.PasswordMinLength,
+			MaxLength: config.PasswordMaxLength,
+			Classes:   config.PasswordClasses,
+			History:   config.PasswordHistory,
+		},
+		users:   user.NewStore(db),
+		history: password.NewHistoryStore(db),
+	}
+
+	if config.PasswordBreachedFile != "" {
+		corpus, err := password.OpenCorpus(config.PasswordBreachedFile)
+		if err != nil {
+			return nil, err
+		}
+		p.corpus = corpus
+	}
+
+	return p, nil
+}
+
+// check returns a *password.Violation when usr can't choose pwd. usr
+// without an ID is one registering, it has no passwords to repeat.
+func (p *passwordPolicy) check(usr user.User, pwd string) error {
+	if err := p.policy.Check(pwd, usr.Email, usr.FirstName, usr.LastName); err != nil {
+		return err
+	}
+
+	if p.corpus != nil {
+		breached, err := p.corpus.Breached(pwd)
+		if err != nil {
+			// an unreadable corpus doesn't stop users from choosing
+			// passwords
+			jww.ERROR.Println("unable to search breached passwords:", err)
+		}
+		if breached {
+			return &password.Violation{Rule: password.RuleBreached}
+		}
+	}
+
+	if usr.ID == 0 || p.policy.History <= 0 {
+		return nil
+	}
+
+	var hashes []string
+	if p.policy.History > 1 {
+		var err error
+		hashes, err = p.history.Hashes(usr.ID, p.policy.History-1)
+		if err != nil {
+			return err
+		}
+	}
+	if usr.Password != "" {
+		hashes = append(hashes, usr.Password)
+	}
+	for _, hash := range hashes {
+		if util.VerifyEncoded([]byte(pwd), []byte(hash)) {
+			return &password.Violation{Rule: password.RuleReused, Param: strconv.Itoa(p.policy.History)}
+		}
+	}
+
+	return nil
+}
+
+// set checks pwd and replaces the password of usr with it, remembering
+// the one it replaces.
+func (p *passwordPolicy) set(usr user.User, pwd string) error {
+	if err := p.check(usr, pwd); err != nil {
+		return err
+	}
+
+	hashedPwd, err := util.HashString(util.StringToBytes(pwd))
+	if err != nil {
+		return err
+	}
+
+	if err := p.users.UpdatePassword(usr.ID, hashedPwd); err != nil {
+		return err
+	}
+
+	if p.policy.History > 1 && usr.Password != "" {
+		if err := p.history.Add(usr.ID, usr.Password, p.policy.History-1); err != nil {
+			jww.ERROR.Println("unable to remember replaced password:", err)
+		}
+	}
+	return nil
+}
+
+// verify reports whether pwd is the password of usr. A hash weaker than
+// what passwords are hashed with now is replaced while pwd is at hand.
+func (p *passwordPolicy) verify(usr user.User, pwd string) bool {
+	if !util.VerifyEncoded([]byte(pwd), []byte(usr.Password)) {
+		return false
+	}
+
+	if util.NeedsRehash([]byte(usr.Password)) {
+		hashedPwd, err := util.HashString(util.StringToBytes(pwd))
+		if err == nil {
+			err = p.users.UpdatePassword(usr.ID, hashedPwd)
+		}
+		if err != nil {
+			jww.ERROR.Println("unable to rehash password:", err)
+		}
+	}
+	return true
+}
+
+// replyPasswordViolation replies the rule a password breaks the way
+// validation errors are replied, or the error when it's something else.
+func replyPasswordViolation(c *fiber.Ctx, err error) error {
+	var violation *password.Violation
+	if !errors.As(err, &violation) {
+		return replyError(c, err)
+	}
+
+	c.Status(fiber.StatusBadRequest)
+	return c.JSON(fiber.Map{
+		"errors": []fiber.Map{{
+			"field": user.UserFieldJsonMap["Password"],
+			"tag":   violation.Rule,
+			"param": violation.Param,
+		}},
+	})
+}
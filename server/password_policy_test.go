@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/user"
+	"github.com/9d4/semaphore/util"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_passwordPolicy_verify(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &password.History{}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newPasswordPolicy(db, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an account imported with its bcrypt hash
+	imported, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr := &user.User{Email: "imported@example.com", Password: string(imported)}
+	if err := p.users.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.verify(*usr, "wrong horse") {
+		t.Fatal("verify() wrong password true, want false")
+	}
+	if got, _ := p.users.UserByID(usr.ID); got.Password != string(imported) {
+		t.Fatal("hash replaced after a wrong password")
+	}
+
+	if !p.verify(*usr, "correct horse") {
+		t.Fatal("verify() false, want true")
+	}
+	got, err := p.users.UserByID(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got.Password, "$argon2id$") || util.NeedsRehash([]byte(got.Password)) {
+		t.Errorf("hash after login = %s, want a current argon2id one", got.Password)
+	}
+	if !p.verify(*got, "correct horse") {
+		t.Error("verify() with the new hash false, want true")
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/mailer"
+	"github.com/9d4/semaphore/reset"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/go-redis/redis/v9"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+const (
+	// passwordResetInterval is how often a reset link can be sent to the
+	// same address.
+	passwordResetInterval = time.Minute
+	// passwordResetMailTimeout bounds sending a reset link once the
+	// request has been replied to.
+	passwordResetMailTimeout = 30 * time.Second
+)
+
+var errPasswordResetThrottled = errors.New("password reset mail sent too recently")
+
+var passwordResetMailTemplate = template.Must(template.New("password_reset_mail").Parse(`Hi {{.FirstName}},
+
+Someone asked to reset the password of your Semaphore account. To choose
+a new password, open this link:
+
+{{.Link}}
+
+The link expires in 1 hour and can be used once. If you didn't ask for
+it, you can ignore this email, your password stays the same.
+`))
+
+var passwordChangedMailTemplate = template.Must(template.New("password_changed_mail").Parse(`Hi {{.FirstName}},
+
+The password of your Semaphore account has just been reset, and every
+device signed into it has been signed out.
+
+If you didn't do this, reset your password again right away and check
+the second factors of your account.
+`))
+
+// revoker signs users out.
+type revoker interface {
+	// revokeUser ends every session of the user and revokes its personal
+	// access and OAuth tokens.
+	revokeUser(ctx context.Context, userID uint) error
+
+	// revokeSession ends sess, notifying the clients it signed into.
+	revokeSession(ctx context.Context, sess *session.Session)
+}
+
+// passwordReset mails single-use links to set a new password and sets it
+// when the user follows one.
+type passwordReset struct {
+	users     user.Store
+	resets    reset.Store
+	passwords *passwordPolicy
+	rdb       *redis.Client
+	mailer    mailer.Mailer
+	revoker   revoker
+	issuer    string
+}
+
+func newPasswordReset(db *gorm.DB, rdb *redis.Client, m mailer.Mailer, passwords *passwordPolicy, revoker revoker, config *Config) *passwordReset {
+	return &passwordReset{
+		users:     user.NewStore(db),
+		resets:    reset.NewStore(db),
+		passwords: passwords,
+		rdb:       rdb,
+		mailer:    m,
+		revoker:   revoker,
+		issuer:    config.Issuer,
+	}
+}
+
+// send mails usr of t a reset link, unless one was sent to the same
+// address of t less than passwordResetInterval ago.
+func (p *passwordReset) send(ctx context.Context, t *tenant.Tenant, usr user.User) error {
+	ok, err := p.rdb.SetNX(ctx, passwordResetThrottleKey(usr), 1, passwordResetInterval).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errPasswordResetThrottled
+	}
+
+	token, err := p.resets.Create(usr.ID)
+	if err != nil {
+		return err
+	}
+
+	link := passwordResetLink(p.issuer, t, token)
+	return sendTemplate(ctx, p.mailer, usr.Email, "Reset your password", passwordResetMailTemplate, struct {
+		FirstName string
+		Link      string
+	}{
+		FirstName: usr.FirstName,
+		Link:      link,
+	})
+}
+
+// handleRequest mails a reset link to the address in the body, of a user
+// of the tenant of the route. The reply is the same, and as quick, whether
+// or not there is such an account, so it can't be used to find accounts.
+func (p *passwordReset) handleRequest(c *fiber.Ctx) error {
+	body := struct {
+		Email string `json:"email"`
+	}{}
+	if err := c.BodyParser(&body); err != nil || body.Email == "" {
+		return fiber.ErrBadRequest
+	}
+
+	go func(t *tenant.Tenant, email string) {
+		ctx, cancel := context.WithTimeout(context.Background(), passwordResetMailTimeout)
+		defer cancel()
+
+		usr, err := p.users.ForTenant(t.ID).UserByEmail(email)
+		if err == nil {
+			err = p.send(ctx, t, *usr)
+		}
+		if err != nil && !errors.Is(err, user.ErrUserNotFound) && !errors.Is(err, errPasswordResetThrottled) {
+			jww.ERROR.Println("unable to send password reset mail:", err)
+		}
+	}(tenantOf(c), body.Email)
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// handleConfirm sets the password of the user the reset token was issued
+// to and signs the user out everywhere.
+func (p *passwordReset) handleConfirm(c *fiber.Ctx) error {
+	body := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	if err := c.BodyParser(&body); err != nil || body.Token == "" {
+		return fiber.ErrBadRequest
+	}
+
+	token, err := p.resets.Token(body.Token)
+	if err != nil {
+		if errors.Is(err, reset.ErrTokenInvalid) {
+			return errs.WriteErrorJSON(c, errs.ErrPasswordResetInvalid)
+		}
+		return replyError(c, err)
+	}
+
+	usr, err := p.users.UserByID(token.UserID)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			return errs.WriteErrorJSON(c, errs.ErrPasswordResetInvalid)
+		}
+		return replyError(c, err)
+	}
+
+	// check the password first so a rejected one doesn't use up the link
+	if err := p.passwords.check(*usr, body.Password); err != nil {
+		return replyPasswordViolation(c, err)
+	}
+
+	if _, err := p.resets.Take(body.Token); err != nil {
+		if errors.Is(err, reset.ErrTokenInvalid) {
+			return errs.WriteErrorJSON(c, errs.ErrPasswordResetInvalid)
+		}
+		return replyError(c, err)
+	}
+
+	if err := p.passwords.set(*usr, body.Password); err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			return errs.WriteErrorJSON(c, errs.ErrPasswordResetInvalid)
+		}
+		return replyPasswordViolation(c, err)
+	}
+	auditEvent(c, p.users.DB(), userEvent("password_reset", usr))
+
+	ctx := c.UserContext()
+	if err := p.revoker.revokeUser(ctx, usr.ID); err != nil {
+		jww.ERROR.Println("unable to revoke sessions on password reset:", err)
+	}
+
+	err = sendTemplate(ctx, p.mailer, usr.Email, "Your password has been reset", passwordChangedMailTemplate, struct {
+		FirstName string
+	}{
+		FirstName: usr.FirstName,
+	})
+	if err != nil {
+		jww.ERROR.Println("unable to send password changed mail:", err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// passwordResetThrottleKey is what reset links mailed to usr are throttled
+// by, the same address can belong to a user of every tenant.
+func passwordResetThrottleKey(usr user.User) string {
+	return fmt.Sprintf("password_reset:throttle:%d:%s", usr.TenantID, strings.ToLower(usr.Email))
+}
+
+// passwordResetLink is the page of issuer to set a new password with
+// token at, which signs in to t after.
+func passwordResetLink(issuer string, t *tenant.Tenant, token string) string {
+	query := url.Values{"token": {token}}
+	if !t.IsDefault() {
+		query.Set("tenant", t.Slug)
+	}
+	return strings.TrimSuffix(issuer, "/") + "/reset-password?" + query.Encode()
+}
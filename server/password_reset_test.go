@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/mailer"
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/reset"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/9d4/semaphore/util"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_passwordReset_handleConfirm(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &reset.Token{}, &password.History{}, &audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{PasswordMinLength: 8, PasswordHistory: 2}
+	passwords, err := newPasswordPolicy(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &recordingMailer{}
+	revoker := &recordingRevoker{}
+	p := newPasswordReset(db, nil, m, passwords, revoker, config)
+
+	hashedPwd, err := util.HashString([]byte("old password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr := &user.User{Email: "reset@example.com", Password: hashedPwd}
+	if err := p.users.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.resets.Create(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/password/reset", p.handleConfirm)
+
+	tests := []struct {
+		name      string
+		token     string
+		password  string
+		want      int
+		wantError string
+	}{
+		{name: "weak password", token: token, password: "abc", want: fiber.StatusBadRequest},
+		{name: "personal password", token: token, password: "my reset password", want: fiber.StatusBadRequest},
+		{name: "current password", token: token, password: "old password", want: fiber.StatusBadRequest},
+		{name: "unknown token", token: "unknown", password: "new password", want: fiber.StatusBadRequest, wantError: "password_reset_invalid"},
+		{name: "valid", token: token, password: "new password", want: fiber.StatusNoContent},
+		{name: "used twice", token: token, password: "other password", want: fiber.StatusBadRequest, wantError: "password_reset_invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"token":"` + tt.token + `","password":"` + tt.password + `"}`
+			req := httptest.NewRequest("POST", "/password/reset", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			res, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.want)
+			}
+
+			data := map[string]interface{}{}
+			_ = json.NewDecoder(res.Body).Decode(&data)
+			if tt.wantError != "" && data["error"] != tt.wantError {
+				t.Errorf("error = %v, want %v", data["error"], tt.wantError)
+			}
+		})
+	}
+
+	changed, err := p.users.UserByID(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !util.VerifyEncoded([]byte("new password"), []byte(changed.Password)) {
+		t.Error("password has not been changed")
+	}
+	if hashes, _ := passwords.history.Hashes(usr.ID, 1); len(hashes) != 1 || hashes[0] != hashedPwd {
+		t.Errorf("password history = %v, want the replaced password", hashes)
+	}
+	if len(revoker.userIDs) != 1 || revoker.userIDs[0] != usr.ID {
+		t.Errorf("revoked users = %v, want [%d]", revoker.userIDs, usr.ID)
+	}
+	if len(m.sent) != 1 || m.sent[0].To != usr.Email {
+		t.Errorf("sent mails = %v, want one to %s", m.sent, usr.Email)
+	}
+}
+
+// recordingMailer keeps the messages it is asked to send.
+type recordingMailer struct {
+	sent []*mailer.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg *mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+type recordingRevoker struct {
+	userIDs    []uint
+	sessionIDs []string
+}
+
+func (r *recordingRevoker) revokeUser(_ context.Context, userID uint) error {
+	r.userIDs = append(r.userIDs, userID)
+	return nil
+}
+
+func (r *recordingRevoker) revokeSession(_ context.Context, sess *session.Session) {
+	r.sessionIDs = append(r.sessionIDs, sess.ID)
+}
+
+func Test_passwordReset_tenants(t *testing.T) {
+	acme := &tenant.Tenant{ID: 2, Slug: "acme"}
+
+	// the same address in two tenants is throttled apart
+	inDefault := passwordResetThrottleKey(user.User{Email: "Reset@example.com"})
+	inAcme := passwordResetThrottleKey(user.User{Email: "reset@example.com", TenantID: acme.ID})
+	if inDefault == inAcme {
+		t.Errorf("throttle keys of both tenants = %q, want them apart", inDefault)
+	}
+	if again := passwordResetThrottleKey(user.User{Email: "reset@example.com"}); again != inDefault {
+		t.Errorf("throttle key = %q, want %q whatever the case", again, inDefault)
+	}
+
+	if got, want := passwordResetLink("https://sso.example.com/", tenant.Default, "tok"), "https://sso.example.com/reset-password?token=tok"; got != want {
+		t.Errorf("passwordResetLink() = %q, want %q", got, want)
+	}
+	if got, want := passwordResetLink("https://sso.example.com", acme, "tok"), "https://sso.example.com/reset-password?tenant=acme&token=tok"; got != want {
+		t.Errorf("passwordResetLink() of a tenant = %q, want %q", got, want)
+	}
+}
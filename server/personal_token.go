@@ -0,0 +1,230 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+// personalTokens lets scripts use the API with the personal access tokens
+// of their users, see middleware.PersonalTokens.
+type personalTokens struct {
+	db *gorm.DB
+}
+
+func (p *personalTokens) AccessToken(token string) (*auth.AccessToken, error) {
+	t, err := pat.NewStore(p.db).Authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	usr, err := user.NewStore(p.db).UserByID(t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if usr.Disabled() {
+		return nil, errs.ErrUserDisabled
+	}
+
+	grants, err := rbac.NewStore(p.db).Grants(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	at := &auth.AccessToken{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   auth.AccessTokenIssuer,
+			Subject:  strconv.FormatUint(uint64(usr.ID), 10),
+			IssuedAt: jwt.NewNumericDate(t.CreatedAt),
+		},
+		User: auth.UserInfo{
+			ID:        usr.ID,
+			Email:     usr.Email,
+			FirstName: usr.FirstName,
+			LastName:  usr.LastName,
+			TenantID:  usr.TenantID,
+		},
+		Roles:       grants.Roles,
+		Groups:      grants.Groups,
+		Permissions: t.Narrow(grants.Permissions),
+		TokenID:     t.ID,
+	}
+	if t.ExpiresAt != nil {
+		at.ExpiresAt = jwt.NewNumericDate(*t.ExpiresAt)
+	}
+	return at, nil
+}
+
+// requireSignIn keeps requests made with a personal access token, or by
+// an admin impersonating the user, from what only a signed in user does,
+// like changing credentials or issuing more tokens. It goes after
+// BearerAuth.
+func requireSignIn(c *fiber.Ctx) error {
+	at, err := currentAccessToken(c)
+	if err != nil {
+		return err
+	}
+	if at.TokenID != 0 {
+		return errs.WriteErrorJSON(c, errs.ErrSignInRequired)
+	}
+	if at.Actor != nil {
+		return errs.WriteErrorJSON(c, errs.ErrImpersonating)
+	}
+	return c.Next()
+}
+
+func replyTokenError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, pat.ErrTokenNotFound):
+		return errs.WriteErrorJSON(c, errs.ErrTokenNotFound)
+	case errors.Is(err, pat.ErrNameTaken):
+		return errs.WriteErrorJSON(c, errs.ErrTokenNameTaken)
+	case errors.Is(err, pat.ErrNameInvalid),
+		errors.Is(err, pat.ErrScopeInvalid),
+		errors.Is(err, pat.ErrExpiryInvalid):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return replyError(c, err)
+}
+
+// tokenEvent is an event of action done to the personal access token t
+// of usr.
+func tokenEvent(action string, usr *user.User, t *pat.Token) audit.Event {
+	e := targetEvent(action, usr)
+	e.TargetType = audit.TargetToken
+	e.TargetID = strconv.FormatUint(uint64(t.ID), 10)
+	e.Metadata = map[string]interface{}{"user": usr.ID, "name": t.Name}
+	return e
+}
+
+// handleTokens lists the personal access tokens of the current user.
+func (s *apiServer) handleTokens(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := pat.NewStore(s.db).Tokens(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(tokens)
+}
+
+// handleTokenCreate issues a personal access token to the current user.
+// The token is only ever in this response. Its scopes are permissions of
+// the user, those the user loses stop being granted.
+func (s *apiServer) handleTokenCreate(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	body := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// ExpiresIn is the number of days the token lasts, 0 for a token
+		// that doesn't expire
+		ExpiresIn int `json:"expires_in"`
+	}{}
+	if err := c.BodyParser(&body); err != nil || body.ExpiresIn < 0 {
+		return fiber.ErrBadRequest
+	}
+
+	t := &pat.Token{UserID: usr.ID, TenantID: usr.TenantID, Name: body.Name, Scopes: body.Scopes}
+	if body.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, body.ExpiresIn)
+		t.ExpiresAt = &expiresAt
+	}
+
+	token, err := pat.NewStore(s.db).Create(t)
+	if err != nil {
+		return replyTokenError(c, err)
+	}
+
+	e := tokenEvent("token_created", usr, t)
+	e.Metadata["scopes"] = t.Scopes
+	e.Metadata["expires_at"] = t.ExpiresAt
+	auditEvent(c, s.db, e)
+
+	return c.Status(fiber.StatusCreated).JSON(struct {
+		*pat.Token
+		Secret string `json:"token"`
+	}{t, token})
+}
+
+// handleTokenDelete revokes a personal access token of the current user.
+func (s *apiServer) handleTokenDelete(c *fiber.Ctx) error {
+	usr, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteToken(c, usr)
+}
+
+// handleAdminUserTokens lists the personal access tokens of a user.
+func (s *apiServer) handleAdminUserTokens(c *fiber.Ctx) error {
+	usr, err := s.adminTarget(c, true)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	tokens, err := pat.NewStore(s.db).Tokens(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return c.JSON(tokens)
+}
+
+// handleAdminUserTokenDelete revokes a personal access token of a user.
+func (s *apiServer) handleAdminUserTokenDelete(c *fiber.Ctx) error {
+	usr, err := s.adminTarget(c, true)
+	if err != nil {
+		return replyError(c, err)
+	}
+
+	return s.deleteToken(c, usr)
+}
+
+// deleteToken deletes the token of usr in the token param.
+func (s *apiServer) deleteToken(c *fiber.Ctx, usr *user.User) error {
+	id, err := strconv.ParseUint(c.Params("token"), 10, 64)
+	if err != nil {
+		return errs.WriteErrorJSON(c, errs.ErrTokenNotFound)
+	}
+
+	store := pat.NewStore(s.db)
+	tokens, err := store.Tokens(usr.ID)
+	if err != nil {
+		return replyError(c, err)
+	}
+	var t *pat.Token
+	for _, token := range tokens {
+		if token.ID == uint(id) {
+			t = token
+		}
+	}
+	if t == nil {
+		return errs.WriteErrorJSON(c, errs.ErrTokenNotFound)
+	}
+
+	if err := store.Delete(usr.ID, t.ID); err != nil {
+		return replyTokenError(c, err)
+	}
+	auditEvent(c, s.db, tokenEvent("token_deleted", usr, t))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/server/middleware"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_apiServer_tokens(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &audit.Event{}, &pat.Token{}); err != nil {
+		t.Fatal(err)
+	}
+	roles := rbac.NewStore(db)
+	if err := roles.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := user.NewStore(db)
+	admin := &user.User{Email: "admin@example.com", FirstName: "Admin"}
+	alice := &user.User{Email: "alice@example.com", FirstName: "Alice"}
+	for _, usr := range []*user.User{admin, alice} {
+		if err := users.Create(usr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := roles.AssignRole(admin.ID, rbac.AdminRole); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("key")
+	s := newTestApiServer(db, &Config{KeyBytes: key})
+
+	bearerAuth := middleware.BearerAuth(key, &personalTokens{db: db})
+	app := fiber.New()
+	app.Get("/tokens/", bearerAuth, s.handleTokens)
+	app.Post("/tokens/", bearerAuth, requireSignIn, s.handleTokenCreate)
+	app.Delete("/tokens/:token", bearerAuth, requireSignIn, s.handleTokenDelete)
+	app.Get("/admin/users/:id", bearerAuth, middleware.RequirePermission(rbac.PermUsersRead), s.handleAdminUser)
+	app.Delete("/admin/users/:id/tokens/:token", bearerAuth, middleware.RequirePermission(rbac.PermUsersWrite), s.handleAdminUserTokenDelete)
+
+	do := func(bearer string, method string, path string, body interface{}) (int, map[string]interface{}) {
+		buf, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(buf))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out map[string]interface{}
+		json.NewDecoder(res.Body).Decode(&out)
+		return res.StatusCode, out
+	}
+
+	jwt := grantedToken(t, db, key, admin)
+	status, created := do(jwt, "POST", "/tokens/", map[string]interface{}{"name": "reports", "scopes": []string{rbac.PermUsersRead}, "expires_in": 30})
+	if status != fiber.StatusCreated || created["expires_at"] == nil {
+		t.Fatalf("POST /tokens/ = %d %v", status, created)
+	}
+	token := created["token"].(string)
+
+	if status, _ := do(jwt, "POST", "/tokens/", map[string]interface{}{"name": "reports"}); status != fiber.StatusConflict {
+		t.Errorf("POST /tokens/ taken name = %d, want %d", status, fiber.StatusConflict)
+	}
+	if status, _ := do(jwt, "POST", "/tokens/", map[string]interface{}{"name": "all", "scopes": []string{"all"}}); status != fiber.StatusBadRequest {
+		t.Errorf("POST /tokens/ invalid scope = %d, want %d", status, fiber.StatusBadRequest)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{name: "scoped permission", method: "GET", path: "/admin/users/2", wantStatus: fiber.StatusOK},
+		{name: "permission out of scope", method: "DELETE", path: "/admin/users/1/tokens/1", wantStatus: fiber.StatusForbidden},
+		{name: "issue another token", method: "POST", path: "/tokens/", wantStatus: fiber.StatusForbidden},
+		{name: "list tokens", method: "GET", path: "/tokens/", wantStatus: fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := do(token, tt.method, tt.path, map[string]string{"name": "more"}); status != tt.wantStatus {
+				t.Errorf("%s %s = %d %v, want %d", tt.method, tt.path, status, body, tt.wantStatus)
+			}
+		})
+	}
+
+	// alice's token doesn't get permissions alice doesn't have
+	status, aliceToken := do(grantedToken(t, db, key, alice), "POST", "/tokens/", map[string]interface{}{"name": "mine", "scopes": []string{rbac.PermUsersRead}})
+	if status != fiber.StatusCreated {
+		t.Fatalf("POST /tokens/ as alice = %d", status)
+	}
+	if status, _ := do(aliceToken["token"].(string), "GET", "/admin/users/2", nil); status != fiber.StatusForbidden {
+		t.Errorf("GET /admin/users/2 with alice's token = %d, want %d", status, fiber.StatusForbidden)
+	}
+
+	if status, _ := do(jwt, "DELETE", "/tokens/2", nil); status != fiber.StatusNotFound {
+		t.Errorf("DELETE token of another user = %d, want %d", status, fiber.StatusNotFound)
+	}
+	if status, _ := do(jwt, "DELETE", "/admin/users/2/tokens/2", nil); status != fiber.StatusNoContent {
+		t.Errorf("DELETE /admin/users/2/tokens/2 = %d, want %d", status, fiber.StatusNoContent)
+	}
+	if status, _ := do(jwt, "DELETE", "/tokens/1", nil); status != fiber.StatusNoContent {
+		t.Errorf("DELETE /tokens/1 = %d, want %d", status, fiber.StatusNoContent)
+	}
+	if status, _ := do(token, "GET", "/tokens/", nil); status != fiber.StatusUnauthorized {
+		t.Errorf("GET /tokens/ with a deleted token = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+
+	if err := users.SetDisabled(alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	_, other := do(grantedToken(t, db, key, alice), "POST", "/tokens/", map[string]interface{}{"name": "other"})
+	if status, _ := do(other["token"].(string), "GET", "/tokens/", nil); status != fiber.StatusUnauthorized {
+		t.Errorf("GET /tokens/ with the token of a disabled user = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,1033 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/scim"
+	serverutil "github.com/9d4/semaphore/server/util"
+	"github.com/9d4/semaphore/user"
+	"github.com/9d4/semaphore/util"
+	"github.com/gofiber/fiber/v2"
+	jww "github.com/spf13/jwalterweatherman"
+	"gorm.io/gorm"
+)
+
+// scimTokenLocal is the local the token of the provisioning client is
+// kept in.
+const scimTokenLocal = "scim_token"
+
+// scimUserAttributes are the columns users are filtered on.
+var scimUserAttributes = map[string]scim.Attribute{
+	"id":              {Column: "uuid", CaseExact: true},
+	"username":        {Column: "email"},
+	"emails":          {Column: "email"},
+	"emails.value":    {Column: "email"},
+	"name.givenname":  {Column: "first_name"},
+	"name.familyname": {Column: "last_name"},
+	"active":          {Column: "(disabled_at IS NULL)"},
+}
+
+var (
+	errSCIMPreconditionFailed = fiber.NewError(fiber.StatusPreconditionFailed, "the resource has changed")
+	errSCIMNotModified        = fiber.NewError(fiber.StatusNotModified)
+)
+
+// scimServer serves SCIM 2.0 to provisioning clients, which manage the
+// users of the tenant of their token. Only the clients of the default
+// tenant manage groups, groups belong to every tenant.
+type scimServer struct {
+	*Config
+	app       *fiber.App
+	db        *gorm.DB
+	passwords *passwordPolicy
+	revoker   revoker
+}
+
+func newSCIMServer(db *gorm.DB, passwords *passwordPolicy, revoker revoker, config *Config) *scimServer {
+	s := &scimServer{
+		Config:    config,
+		app:       fiber.New(fiber.Config{ErrorHandler: handleSCIMError}),
+		db:        db,
+		passwords: passwords,
+		revoker:   revoker,
+	}
+	s.setupRoutes()
+	return s
+}
+
+func (s *scimServer) setupRoutes() {
+	s.app.Get("/ServiceProviderConfig", s.handleServiceProviderConfig)
+	s.app.Get("/ResourceTypes", s.handleResourceTypes)
+	s.app.Get("/ResourceTypes/:id", s.handleResourceTypes)
+	s.app.Get("/Schemas", s.handleSchemas)
+	s.app.Get("/Schemas/:id", s.handleSchemas)
+
+	users := s.app.Group("/Users", s.authenticate)
+	users.Get("/", s.handleUsers)
+	users.Post("/", s.handleUserCreate)
+	users.Get("/:id", s.handleUser)
+	users.Put("/:id", s.handleUserReplace)
+	users.Patch("/:id", s.handleUserPatch)
+	users.Delete("/:id", s.handleUserDelete)
+
+	groups := s.app.Group("/Groups", s.authenticate, requireSCIMDefaultTenant)
+	groups.Get("/", s.handleGroups)
+	groups.Post("/", s.handleGroupCreate)
+	groups.Get("/:id", s.handleGroup)
+	groups.Put("/:id", s.handleGroupReplace)
+	groups.Patch("/:id", s.handleGroupPatch)
+	groups.Delete("/:id", s.handleGroupDelete)
+}
+
+// handleSCIMError replies err the way SCIM clients expect errors.
+func handleSCIMError(c *fiber.Ctx, err error) error {
+	status, scimType := fiber.StatusInternalServerError, scim.ErrorType(err)
+
+	var fe *fiber.Error
+	switch {
+	case scimType != "":
+		status = fiber.StatusBadRequest
+	case errors.Is(err, user.ErrUserNotFound), errors.Is(err, rbac.ErrGroupNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, rbac.ErrNameInvalid):
+		status, scimType = fiber.StatusBadRequest, "invalidValue"
+	case errors.Is(err, rbac.ErrNameTaken), errors.Is(err, errs.ErrEmailTaken):
+		status, scimType = fiber.StatusConflict, "uniqueness"
+	case errors.As(err, &fe):
+		status = fe.Code
+	default:
+		jww.ERROR.Println(c.Path(), err)
+		err = errors.New("internal server error")
+	}
+
+	if status == fiber.StatusNotModified {
+		return c.SendStatus(status)
+	}
+	return sendSCIM(c, status, scim.NewError(status, scimType, err.Error()))
+}
+
+// sendSCIM replies v as SCIM JSON.
+func sendSCIM(c *fiber.Ctx, status int, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, scim.ContentType)
+	return c.Status(status).Send(buf)
+}
+
+// authenticate lets provisioning clients with a token through.
+func (s *scimServer) authenticate(c *fiber.Ctx) error {
+	bearer, err := serverutil.GetBearerToken(c)
+	if err != nil {
+		return fiber.ErrUnauthorized
+	}
+
+	t, err := scim.NewStore(s.db).Authenticate(bearer)
+	if errors.Is(err, scim.ErrTokenInvalid) {
+		return fiber.ErrUnauthorized
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Locals(scimTokenLocal, t)
+	return c.Next()
+}
+
+func scimTokenOf(c *fiber.Ctx) *scim.Token {
+	t, _ := c.Locals(scimTokenLocal).(*scim.Token)
+	return t
+}
+
+func requireSCIMDefaultTenant(c *fiber.Ctx) error {
+	if scimTokenOf(c).TenantID != 0 {
+		return fiber.NewError(fiber.StatusForbidden, "groups are managed by the clients of the default tenant")
+	}
+	return c.Next()
+}
+
+// base is the URL the SCIM endpoints are served at.
+func (s *scimServer) base() string {
+	return strings.TrimSuffix(s.Issuer, "/") + "/scim/v2"
+}
+
+func (s *scimServer) location(endpoint, id string) string {
+	return s.base() + "/" + endpoint + "/" + id
+}
+
+func (s *scimServer) handleServiceProviderConfig(c *fiber.Ctx) error {
+	return sendSCIM(c, fiber.StatusOK, scim.NewServiceProviderConfig(s.base()))
+}
+
+func (s *scimServer) handleResourceTypes(c *fiber.Ctx) error {
+	types := scim.ResourceTypes(s.base())
+	if id := c.Params("id"); id != "" {
+		for _, t := range types {
+			if t.ID == id {
+				return sendSCIM(c, fiber.StatusOK, t)
+			}
+		}
+		return fiber.ErrNotFound
+	}
+
+	list := make([]interface{}, len(types))
+	for i, t := range types {
+		list[i] = t
+	}
+	return sendSCIM(c, fiber.StatusOK, scim.NewListResponse(list, 1, len(list)))
+}
+
+func (s *scimServer) handleSchemas(c *fiber.Ctx) error {
+	schemas := scim.Schemas(s.base())
+	if id := c.Params("id"); id != "" {
+		for _, schema := range schemas {
+			if schema.ID == id {
+				return sendSCIM(c, fiber.StatusOK, schema)
+			}
+		}
+		return fiber.ErrNotFound
+	}
+
+	list := make([]interface{}, len(schemas))
+	for i, schema := range schemas {
+		list[i] = schema
+	}
+	return sendSCIM(c, fiber.StatusOK, scim.NewListResponse(list, 1, len(list)))
+}
+
+// scimPage gets the 1-based index of the first resource and the number of
+// resources the client asks for.
+func scimPage(c *fiber.Ctx) (int, int) {
+	start, err := strconv.Atoi(c.Query("startIndex"))
+	if err != nil || start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(c.Query("count"))
+	if err != nil {
+		count = scim.DefaultCount
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > scim.MaxCount {
+		count = scim.MaxCount
+	}
+	return start, count
+}
+
+// scimFilter parses the filter query, nil when there is none.
+func scimFilter(c *fiber.Ctx) (scim.Filter, error) {
+	if c.Query("filter") == "" {
+		return nil, nil
+	}
+	return scim.ParseFilter(c.Query("filter"))
+}
+
+// render leaves the attributes in the excludedAttributes query out of
+// resource, except those always returned.
+func render(c *fiber.Ctx, resource interface{}) (interface{}, error) {
+	excluded := c.Query("excludedAttributes")
+	if excluded == "" {
+		return resource, nil
+	}
+
+	m, err := toMap(resource)
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range strings.Split(excluded, ",") {
+		attr = strings.TrimSpace(attr)
+		if i := strings.LastIndex(attr, ":"); i >= 0 {
+			attr = attr[i+1:]
+		}
+		for k := range m {
+			if strings.EqualFold(k, attr) && k != "id" && k != "schemas" {
+				delete(m, k)
+			}
+		}
+	}
+	return m, nil
+}
+
+// excludes reports whether the excludedAttributes query leaves attr out.
+func excludes(c *fiber.Ctx, attr string) bool {
+	for _, excluded := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(excluded), attr) {
+			return true
+		}
+	}
+	return false
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	return m, json.Unmarshal(buf, &m)
+}
+
+func fromMap(m map[string]interface{}, v interface{}) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("%w: %v", scim.ErrInvalidValue, err)
+	}
+	return nil
+}
+
+// parseSCIMBody parses the body of c into v.
+func parseSCIMBody(c *fiber.Ctx, v interface{}) error {
+	if err := json.Unmarshal(c.Body(), v); err != nil {
+		if errors.Is(err, scim.ErrInvalidValue) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", scim.ErrInvalidSyntax, err)
+	}
+	return nil
+}
+
+// sendResource replies resource, tagged with version, unless the
+// If-None-Match header of c already has that version.
+func sendResource(c *fiber.Ctx, status int, resource interface{}, version string) error {
+	if status == fiber.StatusOK && matchesVersion(c.Get(fiber.HeaderIfNoneMatch), version) {
+		return errSCIMNotModified
+	}
+
+	rendered, err := render(c, resource)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderETag, version)
+	return sendSCIM(c, status, rendered)
+}
+
+// checkVersion returns errSCIMPreconditionFailed unless the If-Match
+// header of c, when there is one, has version.
+func checkVersion(c *fiber.Ctx, version string) error {
+	match := c.Get(fiber.HeaderIfMatch)
+	if match == "" || matchesVersion(match, version) {
+		return nil
+	}
+	return errSCIMPreconditionFailed
+}
+
+// matchesVersion reports whether the entity tags in header have version,
+// by weak comparison.
+func matchesVersion(header, version string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(version, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// scimEvent is an event of action done by the provisioning client of c.
+func scimEvent(c *fiber.Ctx, action string, usr *user.User) audit.Event {
+	e := targetEvent(action, usr)
+	e.Actor = "scim:" + scimTokenOf(c).Name
+	return e
+}
+
+func (s *scimServer) userResource(usr *user.User) (*scim.User, error) {
+	var groups []*rbac.Group
+	err := s.db.Joins("JOIN group_members ON group_members.group_id = groups.id").
+		Where("group_members.user_id = ?", usr.ID).
+		Order("groups.name").
+		Find(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	active := scim.Boolean(!usr.Disabled())
+	u := &scim.User{
+		Schemas:  []string{scim.SchemaUser},
+		ID:       usr.UUID,
+		UserName: usr.Email,
+		Name: &scim.Name{
+			Formatted:  strings.TrimSpace(usr.FirstName + " " + usr.LastName),
+			GivenName:  usr.FirstName,
+			FamilyName: usr.LastName,
+		},
+		Emails: []scim.MultiValue{{Value: usr.Email, Type: "work", Primary: true}},
+		Active: &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      usr.CreatedAt,
+			LastModified: usr.UpdatedAt,
+			Location:     s.location("Users", usr.UUID),
+			Version:      scim.Version(usr.UpdatedAt),
+		},
+	}
+	for _, g := range groups {
+		id := strconv.FormatUint(uint64(g.ID), 10)
+		u.Groups = append(u.Groups, scim.MultiValue{Value: id, Display: g.Name, Ref: s.location("Groups", id)})
+	}
+	return u, nil
+}
+
+// scimUser gets the user of the tenant of the client with the id in the
+// id param.
+func (s *scimServer) scimUser(c *fiber.Ctx) (*user.User, error) {
+	return user.NewStore(s.db).ForTenant(scimTokenOf(c).TenantID).User(&user.User{UUID: c.Params("id")})
+}
+
+func (s *scimServer) handleUsers(c *fiber.Ctx) error {
+	f, err := scimFilter(c)
+	if err != nil {
+		return err
+	}
+	tx, err := scim.Where(s.db.Model(&user.User{}).Where("tenant_id = ?", scimTokenOf(c).TenantID), f, scimUserAttributes)
+	if err != nil {
+		return err
+	}
+	tx = tx.Session(&gorm.Session{})
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return err
+	}
+
+	start, count := scimPage(c)
+	var users []*user.User
+	if count > 0 {
+		if err := tx.Order("id").Offset(start - 1).Limit(count).Find(&users).Error; err != nil {
+			return err
+		}
+	}
+
+	resources := make([]interface{}, len(users))
+	for i, usr := range users {
+		u, err := s.userResource(usr)
+		if err != nil {
+			return err
+		}
+		if resources[i], err = render(c, u); err != nil {
+			return err
+		}
+	}
+	return sendSCIM(c, fiber.StatusOK, scim.NewListResponse(resources, start, int(total)))
+}
+
+func (s *scimServer) handleUser(c *fiber.Ctx) error {
+	usr, err := s.scimUser(c)
+	if err != nil {
+		return err
+	}
+	return s.sendUser(c, fiber.StatusOK, usr.ID)
+}
+
+// sendUser replies the user with the specified ID as it is now.
+func (s *scimServer) sendUser(c *fiber.Ctx, status int, id uint) error {
+	usr, err := user.NewStore(s.db).UserByID(id)
+	if err != nil {
+		return err
+	}
+	u, err := s.userResource(usr)
+	if err != nil {
+		return err
+	}
+
+	if status == fiber.StatusCreated {
+		c.Location(u.Meta.Location)
+	}
+	return sendResource(c, status, u, u.Meta.Version)
+}
+
+// handleUserCreate provisions a user. The client vouches for its address.
+// Provisioning the address of a deleted user restores it.
+func (s *scimServer) handleUserCreate(c *fiber.Ctx) error {
+	in := &scim.User{}
+	if err := parseSCIMBody(c, in); err != nil {
+		return err
+	}
+
+	tenantID := scimTokenOf(c).TenantID
+	email := in.UserName
+	if email == "" {
+		email = in.PrimaryEmail()
+	}
+
+	var deleted user.User
+	err := s.db.Unscoped().Where("tenant_id = ? AND email = ?", tenantID, email).First(&deleted).Error
+	switch {
+	case err == nil && !deleted.DeletedAt.Valid:
+		return errs.ErrEmailTaken
+	case err == nil:
+		if err := user.NewStore(s.db).Restore(deleted.ID); err != nil {
+			return err
+		}
+		auditEvent(c, s.db, scimEvent(c, "user_restored", &deleted))
+		deleted.DeletedAt = gorm.DeletedAt{}
+
+		if err := s.updateUser(c, &deleted, in); err != nil {
+			return err
+		}
+		return s.sendUser(c, fiber.StatusCreated, deleted.ID)
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+
+	now := time.Now()
+	usr := &user.User{Email: email, EmailVerifiedAt: &now}
+	if in.Name != nil {
+		usr.FirstName, usr.LastName = in.Name.GivenName, in.Name.FamilyName
+	}
+	if in.Active != nil && !*in.Active {
+		usr.DisabledAt = &now
+	}
+	if err := validateSCIMUser(usr); err != nil {
+		return err
+	}
+
+	if in.Password != "" {
+		if err := s.passwords.check(*usr, in.Password); err != nil {
+			return fmt.Errorf("%w: %v", scim.ErrInvalidValue, err)
+		}
+		if usr.Password, err = util.HashString(util.StringToBytes(in.Password)); err != nil {
+			return err
+		}
+	}
+
+	if err := user.NewStore(s.db).ForTenant(tenantID).Create(usr); err != nil {
+		return err
+	}
+
+	e := scimEvent(c, "user_provisioned", usr)
+	e.Metadata = map[string]interface{}{"source": "scim", "email": usr.Email}
+	auditEvent(c, s.db, e)
+
+	return s.sendUser(c, fiber.StatusCreated, usr.ID)
+}
+
+// handleUserReplace replaces the attributes of a user. Whether it is
+// active is kept when the client leaves it out.
+func (s *scimServer) handleUserReplace(c *fiber.Ctx) error {
+	usr, err := s.scimUser(c)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(c, scim.Version(usr.UpdatedAt)); err != nil {
+		return err
+	}
+
+	in := &scim.User{}
+	if err := parseSCIMBody(c, in); err != nil {
+		return err
+	}
+	if err := s.updateUser(c, usr, in); err != nil {
+		return err
+	}
+	return s.sendUser(c, fiber.StatusOK, usr.ID)
+}
+
+func (s *scimServer) handleUserPatch(c *fiber.Ctx) error {
+	usr, err := s.scimUser(c)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(c, scim.Version(usr.UpdatedAt)); err != nil {
+		return err
+	}
+
+	req := &scim.PatchRequest{}
+	if err := parseSCIMBody(c, req); err != nil {
+		return err
+	}
+
+	u, err := s.userResource(usr)
+	if err != nil {
+		return err
+	}
+	m, err := toMap(u)
+	if err != nil {
+		return err
+	}
+	if err := req.Apply(m); err != nil {
+		return err
+	}
+
+	in := &scim.User{}
+	if err := fromMap(m, in); err != nil {
+		return err
+	}
+	if err := s.updateUser(c, usr, in); err != nil {
+		return err
+	}
+	return s.sendUser(c, fiber.StatusOK, usr.ID)
+}
+
+// updateUser saves what changes of in to usr: its address, names, whether
+// it is active and its password. userName is the address unless only the
+// primary email changes.
+func (s *scimServer) updateUser(c *fiber.Ctx, usr *user.User, in *scim.User) error {
+	changed := *usr
+	changed.Email = in.UserName
+	if pe := in.PrimaryEmail(); changed.Email == usr.Email && pe != "" {
+		changed.Email = pe
+	}
+	if in.Name != nil {
+		changed.FirstName, changed.LastName = in.Name.GivenName, in.Name.FamilyName
+	}
+	if err := validateSCIMUser(&changed); err != nil {
+		return err
+	}
+	if in.Password != "" {
+		if err := s.passwords.check(*usr, in.Password); err != nil {
+			return fmt.Errorf("%w: %v", scim.ErrInvalidValue, err)
+		}
+	}
+
+	users := user.NewStore(s.db)
+	if !strings.EqualFold(changed.Email, usr.Email) {
+		if _, err := users.ForTenant(usr.TenantID).UserByEmail(changed.Email); !errors.Is(err, user.ErrUserNotFound) {
+			if err == nil {
+				return errs.ErrEmailTaken
+			}
+			return err
+		}
+	}
+	if changed.Email != usr.Email {
+		err := s.db.Model(&user.User{}).Where("id = ?", usr.ID).Updates(map[string]interface{}{
+			"email":             changed.Email,
+			"email_verified_at": time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		e := scimEvent(c, "email_changed", usr)
+		e.Metadata = map[string]interface{}{"email": changed.Email}
+		auditEvent(c, s.db, e)
+	}
+
+	if changed.FirstName != usr.FirstName || changed.LastName != usr.LastName {
+		if err := users.UpdateProfile(usr.ID, changed.FirstName, changed.LastName); err != nil {
+			return err
+		}
+		auditEvent(c, s.db, scimEvent(c, "profile_updated", usr))
+	}
+
+	if in.Active != nil && bool(*in.Active) == usr.Disabled() {
+		if err := users.SetDisabled(usr.ID, !bool(*in.Active)); err != nil {
+			return err
+		}
+		event := "user_enabled"
+		if !*in.Active {
+			event = "user_disabled"
+			if err := s.revoker.revokeUser(c.UserContext(), usr.ID); err != nil {
+				return err
+			}
+		}
+		auditEvent(c, s.db, scimEvent(c, event, usr))
+	}
+
+	if in.Password != "" {
+		if err := s.passwords.set(*usr, in.Password); err != nil {
+			return fmt.Errorf("%w: %v", scim.ErrInvalidValue, err)
+		}
+		auditEvent(c, s.db, scimEvent(c, "password_changed", usr))
+	}
+	return nil
+}
+
+// validateSCIMUser checks the attributes of usr clients set.
+func validateSCIMUser(usr *user.User) error {
+	if err := user.GetValidate().StructPartial(usr, "Email", "FirstName", "LastName"); err != nil {
+		return fmt.Errorf("%w: %v", scim.ErrInvalidValue, err)
+	}
+	return nil
+}
+
+// handleUserDelete deprovisions a user: it is soft-deleted and its
+// sessions end.
+func (s *scimServer) handleUserDelete(c *fiber.Ctx) error {
+	usr, err := s.scimUser(c)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(c, scim.Version(usr.UpdatedAt)); err != nil {
+		return err
+	}
+
+	if err := user.NewStore(s.db).Delete(usr.ID); err != nil {
+		return err
+	}
+	if err := s.revoker.revokeUser(c.UserContext(), usr.ID); err != nil {
+		return err
+	}
+	auditEvent(c, s.db, scimEvent(c, "user_deleted", usr))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// groupResource gets g as a resource, without its members when members is
+// false.
+func (s *scimServer) groupResource(g *rbac.Group, members bool) (*scim.Group, error) {
+	id := strconv.FormatUint(uint64(g.ID), 10)
+	resource := &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          id,
+		DisplayName: g.Name,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: g.UpdatedAt,
+			Location:     s.location("Groups", id),
+			Version:      scim.Version(g.UpdatedAt),
+		},
+	}
+	if !members {
+		return resource, nil
+	}
+
+	ids, err := rbac.NewStore(s.db).Members(g.Name)
+	if err != nil {
+		return nil, err
+	}
+	var users []*user.User
+	if err := s.db.Where("id IN ?", ids).Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, usr := range users {
+		resource.Members = append(resource.Members, scim.MultiValue{
+			Value:   usr.UUID,
+			Display: usr.Email,
+			Ref:     s.location("Users", usr.UUID),
+		})
+	}
+	return resource, nil
+}
+
+// scimGroup gets the group with the id in the id param.
+func (s *scimServer) scimGroup(c *fiber.Ctx) (*rbac.Group, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, rbac.ErrGroupNotFound
+	}
+	return rbac.NewStore(s.db).GroupByID(uint(id))
+}
+
+// managedGroup is scimGroup for changing the group. A provisioning client
+// isn't granted anything, so it only manages groups that don't grant roles
+// either, admins manage the others.
+func (s *scimServer) managedGroup(c *fiber.Ctx) (*rbac.Group, error) {
+	g, err := s.scimGroup(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Roles) > 0 {
+		return nil, fiber.NewError(fiber.StatusForbidden, "the group grants roles, it is managed by admins")
+	}
+	return g, nil
+}
+
+// handleGroups lists the groups, filtered in memory as there are few.
+func (s *scimServer) handleGroups(c *fiber.Ctx) error {
+	f, err := scimFilter(c)
+	if err != nil {
+		return err
+	}
+
+	groups, err := rbac.NewStore(s.db).Groups()
+	if err != nil {
+		return err
+	}
+
+	var matched []interface{}
+	for _, g := range groups {
+		resource, err := s.groupResource(g, !excludes(c, "members"))
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			m, err := toMap(resource)
+			if err != nil {
+				return err
+			}
+			if !f.Match(m) {
+				continue
+			}
+		}
+		rendered, err := render(c, resource)
+		if err != nil {
+			return err
+		}
+		matched = append(matched, rendered)
+	}
+
+	start, count := scimPage(c)
+	page := matched
+	if start > len(page) {
+		page = nil
+	} else {
+		page = page[start-1:]
+	}
+	if len(page) > count {
+		page = page[:count]
+	}
+	return sendSCIM(c, fiber.StatusOK, scim.NewListResponse(page, start, len(matched)))
+}
+
+func (s *scimServer) handleGroup(c *fiber.Ctx) error {
+	g, err := s.scimGroup(c)
+	if err != nil {
+		return err
+	}
+	return s.sendGroup(c, fiber.StatusOK, g.ID)
+}
+
+// sendGroup replies the group with the specified ID as it is now.
+func (s *scimServer) sendGroup(c *fiber.Ctx, status int, id uint) error {
+	g, err := rbac.NewStore(s.db).GroupByID(id)
+	if err != nil {
+		return err
+	}
+	resource, err := s.groupResource(g, !excludes(c, "members"))
+	if err != nil {
+		return err
+	}
+
+	if status == fiber.StatusCreated {
+		c.Location(resource.Meta.Location)
+	}
+	return sendResource(c, status, resource, resource.Meta.Version)
+}
+
+// handleGroupCreate creates a group without roles, admins grant it roles.
+func (s *scimServer) handleGroupCreate(c *fiber.Ctx) error {
+	in := &scim.Group{}
+	if err := parseSCIMBody(c, in); err != nil {
+		return err
+	}
+
+	store := rbac.NewStore(s.db)
+	if _, err := store.Group(in.DisplayName); !errors.Is(err, rbac.ErrGroupNotFound) {
+		if err == nil {
+			return rbac.ErrNameTaken
+		}
+		return err
+	}
+	ids, err := s.memberIDs(c, in.Members)
+	if err != nil {
+		return err
+	}
+
+	g := &rbac.Group{Name: in.DisplayName}
+	if err := store.SaveGroup(g); err != nil {
+		return err
+	}
+	auditEvent(c, s.db, audit.Event{
+		Actor:      "scim:" + scimTokenOf(c).Name,
+		Action:     "group_saved",
+		TargetType: audit.TargetGroup,
+		TargetID:   g.Name,
+	})
+
+	if err := s.setMembers(c, g.Name, nil, ids); err != nil {
+		return err
+	}
+	return s.sendGroup(c, fiber.StatusCreated, g.ID)
+}
+
+func (s *scimServer) handleGroupReplace(c *fiber.Ctx) error {
+	g, err := s.managedGroup(c)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(c, scim.Version(g.UpdatedAt)); err != nil {
+		return err
+	}
+
+	in := &scim.Group{}
+	if err := parseSCIMBody(c, in); err != nil {
+		return err
+	}
+	if err := s.updateGroup(c, g, in); err != nil {
+		return err
+	}
+	return s.sendGroup(c, fiber.StatusOK, g.ID)
+}
+
+func (s *scimServer) handleGroupPatch(c *fiber.Ctx) error {
+	g, err := s.managedGroup(c)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(c, scim.Version(g.UpdatedAt)); err != nil {
+		return err
+	}
+
+	req := &scim.PatchRequest{}
+	if err := parseSCIMBody(c, req); err != nil {
+		return err
+	}
+
+	resource, err := s.groupResource(g, true)
+	if err != nil {
+		return err
+	}
+	m, err := toMap(resource)
+	if err != nil {
+		return err
+	}
+	if err := req.Apply(m); err != nil {
+		return err
+	}
+
+	in := &scim.Group{}
+	if err := fromMap(m, in); err != nil {
+		return err
+	}
+	if err := s.updateGroup(c, g, in); err != nil {
+		return err
+	}
+
+	return s.sendGroup(c, fiber.StatusOK, g.ID)
+}
+
+// updateGroup renames g to the displayName of in and replaces its members
+// with those of in.
+func (s *scimServer) updateGroup(c *fiber.Ctx, g *rbac.Group, in *scim.Group) error {
+	ids, err := s.memberIDs(c, in.Members)
+	if err != nil {
+		return err
+	}
+	current, err := rbac.NewStore(s.db).Members(g.Name)
+	if err != nil {
+		return err
+	}
+
+	if in.DisplayName != g.Name {
+		if err := rbac.NewStore(s.db).RenameGroup(g.Name, in.DisplayName); err != nil {
+			return err
+		}
+		auditEvent(c, s.db, audit.Event{
+			Actor:      "scim:" + scimTokenOf(c).Name,
+			Action:     "group_renamed",
+			TargetType: audit.TargetGroup,
+			TargetID:   in.DisplayName,
+			Metadata:   map[string]interface{}{"from": g.Name},
+		})
+		g.Name = in.DisplayName
+	}
+
+	return s.setMembers(c, g.Name, current, ids)
+}
+
+// setMembers replaces the members of the group, current, with ids and
+// records who is added and removed.
+func (s *scimServer) setMembers(c *fiber.Ctx, group string, current, ids []uint) error {
+	was := make(map[uint]bool, len(current))
+	for _, id := range current {
+		was[id] = true
+	}
+	is := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		is[id] = true
+	}
+	if len(was) == len(is) {
+		same := true
+		for id := range is {
+			same = same && was[id]
+		}
+		if same {
+			return nil
+		}
+	}
+
+	if err := rbac.NewStore(s.db).SetMembers(group, ids); err != nil {
+		return err
+	}
+
+	record := func(action string, id uint) {
+		e := audit.Event{
+			Actor:      "scim:" + scimTokenOf(c).Name,
+			Action:     action,
+			TargetType: audit.TargetUser,
+			TargetID:   userTarget(id),
+			Metadata:   map[string]interface{}{"group": group},
+		}
+		auditEvent(c, s.db, e)
+	}
+	for id := range is {
+		if !was[id] {
+			record("group_member_added", id)
+		}
+	}
+	for id := range was {
+		if !is[id] {
+			record("group_member_removed", id)
+		}
+	}
+	return nil
+}
+
+// memberIDs gets the IDs of the users members refer to by id, users of the
+// tenant of the client only.
+// Returns scim.ErrInvalidValue when a member isn't such a user.
+func (s *scimServer) memberIDs(c *fiber.Ctx, members []scim.MultiValue) ([]uint, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	uuids := make([]string, len(members))
+	for i, m := range members {
+		uuids[i] = m.Value
+	}
+
+	var users []*user.User
+	if err := s.db.Where("tenant_id = ? AND uuid IN ?", scimTokenOf(c).TenantID, uuids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	byUUID := make(map[string]uint, len(users))
+	for _, usr := range users {
+		byUUID[usr.UUID] = usr.ID
+	}
+
+	ids := make([]uint, 0, len(members))
+	for _, m := range members {
+		id, ok := byUUID[m.Value]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q is not a user", scim.ErrInvalidValue, m.Value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *scimServer) handleGroupDelete(c *fiber.Ctx) error {
+	g, err := s.managedGroup(c)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(c, scim.Version(g.UpdatedAt)); err != nil {
+		return err
+	}
+
+	if err := rbac.NewStore(s.db).DeleteGroup(g.Name); err != nil {
+		return err
+	}
+	auditEvent(c, s.db, audit.Event{
+		Actor:      "scim:" + scimTokenOf(c).Name,
+		Action:     "group_deleted",
+		TargetType: audit.TargetGroup,
+		TargetID:   g.Name,
+	})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
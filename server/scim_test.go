@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/scim"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_scimServer(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&user.User{}, &password.History{}, &session.Session{}, &session.Client{}, &audit.Event{}, &scim.Token{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rbac.NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{Issuer: "http://semaphore.test/", PasswordMinLength: 8}
+	passwords, err := newPasswordPolicy(db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.NewDBStore(db, time.Hour)
+	s := newSCIMServer(db, passwords, &storeRevoker{sessions: sessions}, config)
+
+	token, _, err := scim.NewStore(db).Create(0, "hr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type response struct {
+		status int
+		header func(string) string
+		body   map[string]interface{}
+	}
+	do := func(method, path string, body interface{}, headers ...string) response {
+		t.Helper()
+
+		var r io.Reader
+		if body != nil {
+			buf, _ := json.Marshal(body)
+			r = bytes.NewReader(buf)
+		}
+		path = strings.NewReplacer(" ", "%20", `"`, "%22").Replace(path)
+		req := httptest.NewRequest(method, path, r)
+		req.Header.Set("Content-Type", scim.ContentType)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		res, err := s.app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := response{status: res.StatusCode, header: res.Header.Get}
+		json.NewDecoder(res.Body).Decode(&out.body)
+		return out
+	}
+	patch := func(ops ...map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"schemas": []string{scim.MessagePatchOp}, "Operations": ops}
+	}
+
+	t.Run("discovery is public", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/ServiceProviderConfig", nil)
+		res, err := s.app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusOK || res.Header.Get("Content-Type") != scim.ContentType {
+			t.Errorf("GET /ServiceProviderConfig = %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		if got := do("GET", "/Schemas/"+scim.SchemaUser, nil); got.status != fiber.StatusOK {
+			t.Errorf("GET /Schemas/User = %d", got.status)
+		}
+		if got := do("GET", "/ResourceTypes/Group", nil); got.body["endpoint"] != "/Groups" {
+			t.Errorf("GET /ResourceTypes/Group = %v", got.body)
+		}
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/Users", nil)
+		req.Header.Set("Authorization", "Bearer "+scim.TokenPrefix+"guess")
+		res, err := s.app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("GET /Users = %d, want %d", res.StatusCode, fiber.StatusUnauthorized)
+		}
+	})
+
+	jane := do("POST", "/Users", map[string]interface{}{
+		"schemas":  []string{scim.SchemaUser},
+		"userName": "jane@example.com",
+		"name":     map[string]string{"givenName": "Jane", "familyName": "Doe"},
+		"active":   true,
+	})
+	if jane.status != fiber.StatusCreated {
+		t.Fatalf("POST /Users = %d %v", jane.status, jane.body)
+	}
+	id := jane.body["id"].(string)
+	if jane.header("Location") != "http://semaphore.test/scim/v2/Users/"+id || jane.header("ETag") == "" {
+		t.Errorf("POST /Users Location = %q, ETag = %q", jane.header("Location"), jane.header("ETag"))
+	}
+	usr, err := user.NewStore(db).UserByEmail("jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.EmailVerifiedAt == nil || usr.FirstName != "Jane" {
+		t.Errorf("provisioned %+v, want a verified Jane", usr)
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"userName": "john@example.com", "name": map[string]string{"givenName": "John", "familyName": "Doe"}},
+		{"userName": "ann@example.com", "name": map[string]string{"givenName": "Ann", "familyName": "Lee"}, "active": "False"},
+	} {
+		if got := do("POST", "/Users", body); got.status != fiber.StatusCreated {
+			t.Fatalf("POST /Users = %d %v", got.status, got.body)
+		}
+	}
+
+	t.Run("create invalid", func(t *testing.T) {
+		if got := do("POST", "/Users", map[string]interface{}{"userName": "jane@example.com", "name": map[string]string{"givenName": "Jane", "familyName": "Doe"}}); got.status != fiber.StatusConflict || got.body["scimType"] != "uniqueness" {
+			t.Errorf("POST taken = %d %v", got.status, got.body)
+		}
+		if got := do("POST", "/Users", map[string]interface{}{"userName": "x"}); got.status != fiber.StatusBadRequest || got.body["scimType"] != "invalidValue" {
+			t.Errorf("POST invalid = %d %v", got.status, got.body)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		tests := []struct {
+			query     string
+			wantTotal float64
+			wantIDs   int
+		}{
+			{query: "", wantTotal: 3, wantIDs: 3},
+			{query: "?startIndex=2&count=1", wantTotal: 3, wantIDs: 1},
+			{query: "?count=0", wantTotal: 3, wantIDs: 0},
+			{query: `?filter=userName eq "JOHN@example.com"`, wantTotal: 1, wantIDs: 1},
+			{query: `?filter=name.familyName eq "Doe" and active eq true`, wantTotal: 2, wantIDs: 2},
+			{query: `?filter=active eq false`, wantTotal: 1, wantIDs: 1},
+		}
+		for _, tt := range tests {
+			got := do("GET", "/Users"+tt.query, nil)
+			resources, _ := got.body["Resources"].([]interface{})
+			if got.body["totalResults"] != tt.wantTotal || len(resources) != tt.wantIDs {
+				t.Errorf("GET /Users%s = %v total, %d resources, want %v, %d", tt.query, got.body["totalResults"], len(resources), tt.wantTotal, tt.wantIDs)
+			}
+		}
+
+		if got := do("GET", `/Users?filter=userName xx "a"`, nil); got.status != fiber.StatusBadRequest || got.body["scimType"] != "invalidFilter" {
+			t.Errorf("GET invalid filter = %d %v", got.status, got.body)
+		}
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		got := do("GET", "/Users/"+id, nil)
+		etag := got.header("ETag")
+		if got := do("GET", "/Users/"+id, nil, "If-None-Match", etag); got.status != fiber.StatusNotModified {
+			t.Errorf("GET If-None-Match = %d, want %d", got.status, fiber.StatusNotModified)
+		}
+		if got := do("PATCH", "/Users/"+id, patch(map[string]interface{}{"op": "replace", "path": "name.givenName", "value": "Janet"}), "If-Match", `W/"stale"`); got.status != fiber.StatusPreconditionFailed {
+			t.Errorf("PATCH stale If-Match = %d, want %d", got.status, fiber.StatusPreconditionFailed)
+		}
+		if got := do("GET", "/Users/unknown", nil); got.status != fiber.StatusNotFound {
+			t.Errorf("GET unknown = %d, want %d", got.status, fiber.StatusNotFound)
+		}
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		sess := &session.Session{ID: "jane", UserID: usr.ID}
+		if err := sessions.Create(context.Background(), sess); err != nil {
+			t.Fatal(err)
+		}
+
+		got := do("PATCH", "/Users/"+id, patch(
+			map[string]interface{}{"op": "Replace", "value": map[string]interface{}{"active": "False"}},
+			map[string]interface{}{"op": "replace", "path": "name.givenName", "value": "Janet"},
+		))
+		if got.status != fiber.StatusOK || got.body["active"] != false {
+			t.Fatalf("PATCH = %d %v", got.status, got.body)
+		}
+
+		usr, _ := user.NewStore(db).UserByID(usr.ID)
+		if !usr.Disabled() || usr.FirstName != "Janet" {
+			t.Errorf("patched %+v, want disabled Janet", usr)
+		}
+		if list, _ := sessions.Sessions(context.Background(), usr.ID); len(list) != 0 {
+			t.Errorf("sessions of the disabled user = %v, want none", list)
+		}
+	})
+
+	t.Run("put", func(t *testing.T) {
+		got := do("PUT", "/Users/"+id, map[string]interface{}{
+			"userName": "janet@example.com",
+			"name":     map[string]string{"givenName": "Janet", "familyName": "Doe"},
+			"active":   true,
+		})
+		if got.status != fiber.StatusOK || got.body["userName"] != "janet@example.com" || got.body["active"] != true {
+			t.Errorf("PUT = %d %v", got.status, got.body)
+		}
+	})
+
+	t.Run("groups", func(t *testing.T) {
+		john, _ := user.NewStore(db).UserByEmail("john@example.com")
+
+		got := do("POST", "/Groups", map[string]interface{}{
+			"displayName": "staff",
+			"members":     []map[string]string{{"value": id}},
+		})
+		if got.status != fiber.StatusCreated {
+			t.Fatalf("POST /Groups = %d %v", got.status, got.body)
+		}
+		gid := got.body["id"].(string)
+
+		got = do("PATCH", "/Groups/"+gid, patch(
+			map[string]interface{}{"op": "add", "path": "members", "value": []map[string]string{{"value": john.UUID}}},
+			map[string]interface{}{"op": "remove", "path": fmt.Sprintf("members[value eq %q]", id)},
+		))
+		if got.status != fiber.StatusOK {
+			t.Fatalf("PATCH /Groups = %d %v", got.status, got.body)
+		}
+		if members, _ := rbac.NewStore(db).Members("staff"); len(members) != 1 || members[0] != john.ID {
+			t.Errorf("members = %v, want %d", members, john.ID)
+		}
+
+		if got := do("GET", `/Groups?filter=displayName eq "staff"`, nil); got.body["totalResults"] != float64(1) {
+			t.Errorf("GET /Groups filtered = %v", got.body)
+		}
+		if got := do("GET", "/Users/"+john.UUID, nil); len(got.body["groups"].([]interface{})) != 1 {
+			t.Errorf("groups of the member = %v", got.body["groups"])
+		}
+		if got := do("POST", "/Groups", map[string]interface{}{"displayName": "staff"}); got.status != fiber.StatusConflict {
+			t.Errorf("POST taken group = %d", got.status)
+		}
+		if got := do("POST", "/Groups", map[string]interface{}{"displayName": "ops", "members": []map[string]string{{"value": "nobody"}}}); got.status != fiber.StatusBadRequest {
+			t.Errorf("POST unknown member = %d", got.status)
+		}
+
+		if got := do("DELETE", "/Groups/"+gid, nil); got.status != fiber.StatusNoContent {
+			t.Errorf("DELETE /Groups = %d", got.status)
+		}
+	})
+
+	t.Run("groups granting roles", func(t *testing.T) {
+		john, _ := user.NewStore(db).UserByEmail("john@example.com")
+		admins := &rbac.Group{Name: "admins", Roles: []string{rbac.AdminRole}}
+		if err := rbac.NewStore(db).SaveGroup(admins); err != nil {
+			t.Fatal(err)
+		}
+		gid := strconv.FormatUint(uint64(admins.ID), 10)
+
+		if got := do("GET", "/Groups/"+gid, nil); got.status != fiber.StatusOK {
+			t.Errorf("GET admin group = %d, want %d", got.status, fiber.StatusOK)
+		}
+		add := patch(map[string]interface{}{"op": "add", "path": "members", "value": []map[string]string{{"value": john.UUID}}})
+		if got := do("PATCH", "/Groups/"+gid, add); got.status != fiber.StatusForbidden {
+			t.Errorf("PATCH admin group = %d, want %d", got.status, fiber.StatusForbidden)
+		}
+		if got := do("PUT", "/Groups/"+gid, map[string]interface{}{"displayName": "admins", "members": []map[string]string{{"value": john.UUID}}}); got.status != fiber.StatusForbidden {
+			t.Errorf("PUT admin group = %d, want %d", got.status, fiber.StatusForbidden)
+		}
+		if got := do("DELETE", "/Groups/"+gid, nil); got.status != fiber.StatusForbidden {
+			t.Errorf("DELETE admin group = %d, want %d", got.status, fiber.StatusForbidden)
+		}
+		if members, _ := rbac.NewStore(db).Members("admins"); len(members) != 0 {
+			t.Errorf("admin group members = %v, want none", members)
+		}
+	})
+
+	t.Run("members of other tenants", func(t *testing.T) {
+		other := &user.User{Email: "other@example.com"}
+		if err := user.NewStore(db).ForTenant(7).Create(other); err != nil {
+			t.Fatal(err)
+		}
+		got := do("POST", "/Groups", map[string]interface{}{"displayName": "mixed", "members": []map[string]string{{"value": other.UUID}}})
+		if got.status != fiber.StatusBadRequest {
+			t.Errorf("POST with a member of another tenant = %d, want %d", got.status, fiber.StatusBadRequest)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if got := do("DELETE", "/Users/"+id, nil); got.status != fiber.StatusNoContent {
+			t.Fatalf("DELETE = %d %v", got.status, got.body)
+		}
+		if got := do("GET", "/Users/"+id, nil); got.status != fiber.StatusNotFound {
+			t.Errorf("GET deleted = %d, want %d", got.status, fiber.StatusNotFound)
+		}
+
+		got := do("POST", "/Users", map[string]interface{}{
+			"userName": "janet@example.com",
+			"name":     map[string]string{"givenName": "Janet", "familyName": "Doe"},
+		})
+		if got.status != fiber.StatusCreated || got.body["id"] != id {
+			t.Errorf("POST deleted = %d %v, want %s restored", got.status, got.body, id)
+		}
+	})
+
+	events, _, err := audit.NewStore(db).Events(audit.Query{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, e := range events {
+		if e.Actor == "scim:hr" {
+			seen[e.Action] = true
+		}
+	}
+	for _, action := range []string{"user_provisioned", "user_disabled", "email_changed", "group_member_added", "group_member_removed", "user_deleted", "user_restored"} {
+		if !seen[action] {
+			t.Errorf("no %s event by the client", action)
+		}
+	}
+}
@@ -1,80 +1,95 @@
 package server
 
 import (
-	"errors"
-	"fmt"
-	"github.com/9d4/semaphore/store"
-	"log"
-	"os"
-	"sort"
-	"strings"
+	"github.com/9d4/semaphore/session"
 	"time"
 
 	"github.com/9d4/semaphore/auth"
 	errs "github.com/9d4/semaphore/errors"
-	"github.com/9d4/semaphore/util"
+	"github.com/9d4/semaphore/federation"
 	"github.com/go-redis/redis/v9"
-	jww "github.com/spf13/jwalterweatherman"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm/logger"
 
 	"github.com/9d4/semaphore/user"
 	"github.com/gofiber/fiber/v2"
-	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 type server struct {
 	*Config
-	app *fiber.App
-	db  *gorm.DB
-	rdb *redis.Client
-	v   *viper.Viper
+	app       *fiber.App
+	db        *gorm.DB
+	rdb       *redis.Client
+	v         *viper.Viper
+	sessions  session.Store
+	oauth     *oauthServer
+	mfa       *mfaChallenge
+	passkeys  *passkeyCeremony
+	verifier  *emailVerifier
+	passwords *passwordPolicy
+	logins    loginBackend
+	resets    *passwordReset
+	guard     *loginGuard
+	providers *federation.Providers
 }
 
 func (s *server) setupRoutes() {
-	s.app.Use(s.handleLogger())
-
 	s.app.Static("/", "./views/dist/", fiber.Static{
 		Compress: true,
 		Browse:   false,
 	})
 
 	authRouter := s.app.Group("/auth")
-	authRouter.Post("/login", s.handleLogin)
+	authRouter.Get("/verify-email", s.verifier.handleVerify)
+	authRouter.Get("/change-email", s.verifier.handleChange)
+	s.setupLoginRoutes(authRouter)
+
+	// the users of other tenants sign in to their tenant
+	s.setupLoginRoutes(s.app.Group("/t/:tenant/auth", resolveTenant(s.db)))
+
+	// without an address of their own the OAuth2 endpoints are served here
+	if s.OAuthAddress == "" {
+		s.app.All("/oauth2/*", s.oauth.Handler())
+		s.app.All("/t/:tenant/oauth2/*", s.oauth.Handler())
+	}
 
 	oauthResourceServer := newOAuthResourceServer(s.db, s.Config)
 	s.app.Mount("/api/oauth2", oauthResourceServer.App)
 
-	apiSrv := newApiServer(s.db, s.Config)
+	apiSrv := newApiServer(s)
 	s.app.Mount("/api", apiSrv.app)
 
+	s.app.Mount("/scim/v2", newSCIMServer(s.db, s.passwords, s.oauth, s.Config).app)
+
 	// This is kinda tricky. Mounts will be executed lastly.
 	// So if nothing found, fallback to index.html.
 	s.app.Mount("/*", viewApp())
 }
 
-func (s *server) listen() error {
-	return s.app.Listen(s.Address)
+func (s *server) setupLoginRoutes(router fiber.Router) {
+	router.Post("/login", s.handleLogin)
+	router.Post("/login/mfa", s.handleLoginMFA)
+	router.Post("/login/mfa/enroll", s.mfa.handleEnroll)
+	router.Post("/login/mfa/recovery", s.handleLoginRecovery)
+	router.Post("/login/mfa/webauthn", s.passkeys.handleSecondFactorBegin)
+	router.Post("/login/mfa/webauthn/finish", s.handleLoginMFAPasskey)
+	router.Post("/login/passkey", s.passkeys.handleLoginBegin)
+	router.Post("/login/passkey/finish", s.handleLoginPasskey)
+	router.Post("/verify-email/resend", s.verifier.handleResend)
+	router.Post("/password/forgot", s.resets.handleRequest)
+	router.Post("/password/reset", s.resets.handleConfirm)
+	router.Get("/federation", s.handleConnectors)
+	router.Get("/federation/:connector", s.handleFederationStart)
+	router.Get("/federation/:connector/callback", s.handleFederationCallback)
 }
 
-func (s *server) handleLogger() fiber.Handler {
-	requestLogWriter := jww.TRACE.Writer()
-	if s.LogRequest {
-		requestLogWriter = jww.INFO.Writer()
-	}
-
-	return fiberlogger.New(fiberlogger.Config{
-		CustomTags: map[string]fiberlogger.LogFunc{
-			"ips": func(output fiberlogger.Buffer, c *fiber.Ctx, data *fiberlogger.Data, extraParam string) (int, error) {
-				return output.WriteString(strings.Join(c.IPs(), ">>"))
-			},
-		},
-		Format:     "${time} ${pid} ${locals:requestid} [${ips}] [${ip}]:${port} ${status} - ${method} ${path}\n",
-		Output:     requestLogWriter,
-		TimeFormat: "2006/01/02 15:04:05",
-	})
+func (s *server) listen() error {
+	addr, err := listenAddress(s.Address)
+	if err != nil {
+		return err
+	}
+
+	return s.app.Listen(addr)
 }
 
 func (s *server) handleLogin(c *fiber.Ctx) error {
@@ -88,110 +103,157 @@ func (s *server) handleLogin(c *fiber.Ctx) error {
 		return err
 	}
 
-	var usr user.User
-	result := s.db.First(&usr, user.User{Email: cred.Email})
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return errs.WriteErrorJSON(c, errs.ErrCredentialNotFound)
+	t := tenantOf(c)
+	key := guardKey(t, cred.Email)
+	if err := s.guard.attempt(c, key); err != nil {
+		auditLoginFailure(c, s.db, t.ID, cred.Email, "locked_out")
+		return replyError(c, err)
 	}
 
-	if !util.VerifyEncoded([]byte(cred.Password), []byte(usr.Password)) {
+	usr, err := s.logins.authenticate(c.UserContext(), t, cred.Email, cred.Password)
+	if reason := loginFailure(err); reason != "" {
+		s.guard.failed(c.UserContext(), key, c.IP())
+		auditLoginFailure(c, s.db, t.ID, cred.Email, reason)
 		return errs.WriteErrorJSON(c, errs.ErrCredentialNotFound)
 	}
-
-	rt, err := auth.GenerateRefreshToken(usr, s.KeyBytes, auth.RefreshTokenExpiration)
+	s.guard.passed(c.UserContext(), key, c.IP())
 	if err != nil {
-		return err
+		return replyError(c, err)
 	}
 
-	c.Cookie(&fiber.Cookie{
-		Name:     "rt",
-		Value:    rt,
-		Domain:   s.v.GetString("cookie_domain"),
-		Expires:  time.Now().Add(auth.RefreshTokenExpiration),
-		HTTPOnly: true,
-	})
+	if err := allowLogin(s.verifier, *usr); err != nil {
+		return replyError(c, err)
+	}
 
-	return c.RedirectBack(c.GetReqHeaders()[fiber.HeaderReferer], 302)
-}
+	// the failures are only forgotten once the second factor is passed too
+	required, err := s.mfa.begin(c, *usr)
+	if err != nil || required {
+		return err
+	}
+	s.guard.succeeded(c.UserContext(), key)
 
-func Start(opts ...Option) (srvErr <-chan error, oauthSrvErr <-chan error) {
-	config := &Config{}
+	return s.signIn(c, *usr, auth.ACRPassword, []string{auth.AMRPassword})
+}
 
-	if len(opts) < 1 {
-		config = &(*defaultConfig)
+// handleLoginMFA completes a login that is waiting for the second factor.
+func (s *server) handleLoginMFA(c *fiber.Ctx) error {
+	usr, err := s.mfa.complete(c)
+	if err != nil {
+		return replyError(c, err)
 	}
 
-	sort.Slice(opts, func(i, j int) bool {
-		_, isConfig := opts[i].(*Config)
-		_, isConfig2 := opts[j].(*Config)
-		return isConfig && !isConfig2
-	})
+	return s.signIn(c, *usr, auth.ACRMultiFactor, mfaAMR)
+}
 
-	for _, opt := range opts {
-		if opt != nil {
-			if applyErr := opt.Apply(config); applyErr != nil {
-				jww.FATAL.Fatal(applyErr)
-			}
-		}
+// handleLoginRecovery completes a login waiting for the second factor
+// with a recovery code.
+func (s *server) handleLoginRecovery(c *fiber.Ctx) error {
+	usr, err := s.mfa.completeRecovery(c)
+	if err != nil {
+		return replyError(c, err)
 	}
 
-	dbLogFile, err := os.OpenFile("semaphore.db.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	return s.signIn(c, *usr, auth.ACRMultiFactor, mfaAMR)
+}
+
+// handleLoginMFAPasskey completes a login waiting for the second factor
+// with a passkey.
+func (s *server) handleLoginMFAPasskey(c *fiber.Ctx) error {
+	usr, err := s.passkeys.completeSecondFactor(c)
 	if err != nil {
-		log.Println("Unable to create log file:", err)
-	}
-	dbLogger := logger.New(
-		log.New(dbLogFile, "\r\n", log.LstdFlags), // io writer
-		logger.Config{
-			SlowThreshold:             time.Millisecond, // Slow SQL threshold
-			LogLevel:                  logger.Info,      // Log level
-			IgnoreRecordNotFoundError: true,             // Ignore ErrRecordNotFound error for logger
-			Colorful:                  false,            // Disable color
-		},
-	)
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		config.DBHost,
-		config.DBPort,
-		config.DBUsername,
-		config.DBPassword,
-		config.DBName,
-	)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: dbLogger,
-	})
+		return replyError(c, err)
+	}
+
+	return s.signIn(c, *usr, auth.ACRMultiFactor, passkeyMFAAMR)
+}
+
+// handleLoginPasskey signs in with a passkey alone.
+func (s *server) handleLoginPasskey(c *fiber.Ctx) error {
+	usr, err := s.passkeys.completeLogin(c)
 	if err != nil {
-		jww.FATAL.Fatal(err)
+		return replyError(c, err)
 	}
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     config.RedisAddress,
-		Username: config.RedisUsername,
-		Password: config.RedisPassword,
-	})
+	if err := allowLogin(s.verifier, *usr); err != nil {
+		return replyError(c, err)
+	}
 
-	// auto migrate
-	fmt.Print("Auto Migrating...")
-	store.MigrateAll(db)
-	fmt.Println("\rAuto Migrating...done.")
+	return s.signIn(c, *usr, auth.ACRMultiFactor, passkeyAMR)
+}
 
-	srv := &server{
-		Config: config,
-		app:    fiber.New(),
-		v:      config.v,
-		db:     db,
-		rdb:    rdb,
+// signIn sets the refresh token cookie of the browser session and sends
+// the user back. Users only sign in to their own tenant.
+func (s *server) signIn(c *fiber.Ctx, usr user.User, acr string, amr []string) error {
+	if usr.TenantID != tenantOf(c).ID {
+		return errs.WriteErrorJSON(c, errs.ErrCredentialNotFound)
 	}
-	srv.setupRoutes()
 
-	oauthSrv := newOauthServer(db, rdb, config)
+	if _, err := s.startSession(c, usr, acr, amr); err != nil {
+		return err
+	}
 
-	_srvErr := make(chan error, 1)
-	srvErr = _srvErr
+	return c.RedirectBack(c.GetReqHeaders()[fiber.HeaderReferer], 302)
+}
 
-	_oauthSrvErr := make(chan error, 1)
-	oauthSrvErr = _oauthSrvErr
+// startSession signs usr in to the browser session and sets its refresh
+// token cookie.
+func (s *server) startSession(c *fiber.Ctx, usr user.User, acr string, amr []string) (*session.Session, error) {
+	sess, err := s.loginSession(c, usr, acr, amr)
+	if err != nil {
+		return nil, err
+	}
+	auditLogin(c, s.db, &usr, sess)
 
-	go func() { _srvErr <- srv.listen() }()
-	go func() { _oauthSrvErr <- oauthSrv.Listen() }()
+	rt, err := auth.GenerateRefreshToken(usr, sess.ID, sess.RefreshTokenID, s.KeyBytes, auth.RefreshTokenExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "rt",
+		Value:    rt,
+		Domain:   s.v.GetString("cookie_domain"),
+		Expires:  time.Now().Add(auth.RefreshTokenExpiration),
+		HTTPOnly: true,
+	})
+	return sess, nil
+}
+
+// loginSession returns the session a successful login belongs to. Signing
+// in again from a browser that already has a session for the same user
+// re-authenticates it, keeping the clients it has signed into.
+func (s *server) loginSession(c *fiber.Ctx, usr user.User, acr string, amr []string) (*session.Session, error) {
+	ctx := c.UserContext()
+	now := time.Now()
+
+	rt, err := auth.ValidateRefreshToken(c.Cookies("rt"), auth.DefaultJwtKeyFunc(s.KeyBytes))
+	if err == nil && rt.SessionID != "" {
+		sess, err := s.sessions.Session(ctx, rt.SessionID)
+		if err == nil && sess.UserID == usr.ID {
+			sess.AuthTime = now
+			sess.ACR = acr
+			sess.AMR = amr
+			sess.UserAgent = c.Get(fiber.HeaderUserAgent)
+			sess.IP = c.IP()
+			sess.LastSeenAt = now
+
+			// refresh tokens minted before signing in again are retired
+			sess.RefreshTokenID, err = session.NewRefreshTokenID()
+			if err != nil {
+				return nil, err
+			}
+			sess.PreviousRefreshTokenID = ""
+			return sess, s.sessions.Update(ctx, sess)
+		}
+	}
 
-	return
+	sess := &session.Session{
+		UserID:    usr.ID,
+		AuthTime:  now,
+		ACR:       acr,
+		AMR:       amr,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+	}
+	return sess, s.sessions.Create(ctx, sess)
 }
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/9d4/semaphore/auth"
+	errs "github.com/9d4/semaphore/errors"
+	"github.com/9d4/semaphore/lockout"
+	"github.com/9d4/semaphore/tenant"
+	"github.com/9d4/semaphore/user"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// tenantLocal holds the tenant of /t/{tenant}/ routes in the locals of
+// the request.
+const tenantLocal = "tenant"
+
+// resolveTenant finds the tenant in the tenant param for the handlers
+// after it, replying ErrTenantNotFound when there is none.
+func resolveTenant(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t, err := tenant.NewStore(db).Tenant(c.Params("tenant"))
+		if errors.Is(err, tenant.ErrTenantNotFound) {
+			return errs.WriteErrorJSON(c, errs.ErrTenantNotFound)
+		}
+		if err != nil {
+			return replyError(c, err)
+		}
+
+		c.Locals(tenantLocal, t)
+		return c.Next()
+	}
+}
+
+// tenantOf gets the tenant resolveTenant found, the default one outside
+// of /t/{tenant}/.
+func tenantOf(c *fiber.Ctx) *tenant.Tenant {
+	if t, ok := c.Locals(tenantLocal).(*tenant.Tenant); ok {
+		return t
+	}
+	return tenant.Default
+}
+
+// guardKey is what failed logins with email to t are counted by, the
+// same address can belong to a user of every tenant.
+func guardKey(t *tenant.Tenant, email string) string {
+	if t.IsDefault() {
+		return lockout.TenantAccount("", email)
+	}
+	return lockout.TenantAccount(t.Slug, email)
+}
+
+// userGuardKey is guardKey of the email of usr in its own tenant.
+func userGuardKey(db *gorm.DB, usr user.User) (string, error) {
+	t, err := tenant.NewStore(db).TenantByID(usr.TenantID)
+	if err != nil {
+		return "", err
+	}
+	return guardKey(t, usr.Email), nil
+}
+
+// managesTenant reports whether the admin holding at manages the users of
+// the tenant with the specified ID. Admins of the default tenant manage
+// every tenant, the others only their own.
+func managesTenant(at *auth.AccessToken, tenantID uint) bool {
+	return at.User.TenantID == 0 || at.User.TenantID == tenantID
+}
@@ -0,0 +1,148 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type dbStore struct {
+	db  *gorm.DB
+	ttl time.Duration
+}
+
+// NewDBStore creates Store backed by the database. Sessions expire after
+// ttl unless touched, expired ones are deleted when their user signs in
+// again.
+func NewDBStore(db *gorm.DB, ttl time.Duration) Store {
+	return &dbStore{db: db, ttl: ttl}
+}
+
+func (s *dbStore) Create(ctx context.Context, sess *Session) error {
+	if err := prepare(sess, s.ttl); err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&Session{}).Select("id").
+			Where("user_id = ? AND expires_at <= ?", sess.UserID, time.Now())
+		if err := tx.Where("session_id IN (?)", expired).Delete(&Client{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND expires_at <= ?", sess.UserID, time.Now()).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(sess).Error
+	})
+}
+
+func (s *dbStore) Session(ctx context.Context, id string) (*Session, error) {
+	var sess Session
+	tx := s.db.WithContext(ctx).Where("id = ? AND expires_at > ?", id, time.Now()).First(&sess)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, tx.Error
+	}
+
+	return &sess, nil
+}
+
+func (s *dbStore) Update(ctx context.Context, sess *Session) error {
+	tx := s.db.WithContext(ctx).Model(sess).
+		Where("expires_at > ?", time.Now()).
+		Select("AuthTime", "ACR", "AMR", "UserAgent", "IP", "LastSeenAt",
+			"RefreshTokenID", "PreviousRefreshTokenID", "RotatedAt").
+		Updates(sess)
+	return resolveUpdate(tx)
+}
+
+func (s *dbStore) Touch(ctx context.Context, id string, ip string, userAgent string) error {
+	now := time.Now()
+	tx := s.db.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND expires_at > ?", id, now).
+		Updates(map[string]interface{}{
+			"ip":           ip,
+			"user_agent":   userAgent,
+			"last_seen_at": now,
+			"expires_at":   now.Add(s.ttl),
+		})
+	return resolveUpdate(tx)
+}
+
+// Rotate only writes the session while it still accepts used, a renew
+// racing it reads the session again.
+func (s *dbStore) Rotate(ctx context.Context, id string, used string, next string, grace time.Duration) (string, error) {
+	for i := 0; i < touchRetries; i++ {
+		sess, err := s.Session(ctx, id)
+		if err != nil {
+			return "", err
+		}
+
+		accepted, changed, err := rotate(sess, used, next, grace)
+		if err != nil || !changed {
+			return accepted, err
+		}
+
+		tx := s.db.WithContext(ctx).Model(&Session{}).
+			Where("id = ? AND refresh_token_id = ?", id, used).
+			Updates(map[string]interface{}{
+				"refresh_token_id":          sess.RefreshTokenID,
+				"previous_refresh_token_id": sess.PreviousRefreshTokenID,
+				"rotated_at":                sess.RotatedAt,
+			})
+		if tx.Error != nil {
+			return "", tx.Error
+		}
+		if tx.RowsAffected > 0 {
+			return accepted, nil
+		}
+	}
+
+	return "", ErrRefreshTokenReused
+}
+
+func (s *dbStore) AddClient(ctx context.Context, id string, clientID string) error {
+	return s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Client{SessionID: id, ClientID: clientID}).Error
+}
+
+func (s *dbStore) Clients(ctx context.Context, id string) ([]string, error) {
+	var clientIDs []string
+	tx := s.db.WithContext(ctx).Model(&Client{}).Where("session_id = ?", id).Pluck("client_id", &clientIDs)
+	return clientIDs, tx.Error
+}
+
+func (s *dbStore) Sessions(ctx context.Context, userID uint) ([]*Session, error) {
+	var sessions []*Session
+	tx := s.db.WithContext(ctx).
+		Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	return sessions, tx.Error
+}
+
+func (s *dbStore) Delete(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", id).Delete(&Client{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&Session{}).Error
+	})
+}
+
+func resolveUpdate(tx *gorm.DB) error {
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_dbStore(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	ctx := context.Background()
+	s := NewDBStore(db, time.Hour)
+
+	sess := &Session{UserID: 1, ACR: "1", AMR: []string{"pwd"}, IP: "192.0.2.1"}
+	if err := s.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	if sess.ID == "" || sess.LastSeenAt.IsZero() || !sess.ExpiresAt.After(time.Now()) {
+		t.Fatalf("Create() = %+v, want ID, LastSeenAt and ExpiresAt filled", sess)
+	}
+
+	sess.ACR = "2"
+	sess.AMR = []string{"pwd", "otp", "mfa"}
+	if err := s.Update(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Touch(ctx, sess.ID, "192.0.2.2", "Firefox"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Session(ctx, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ACR != "2" || len(got.AMR) != 3 || got.IP != "192.0.2.2" || got.UserAgent != "Firefox" {
+		t.Errorf("Session() = %+v, want the update and the touch", got)
+	}
+
+	if err := s.AddClient(ctx, sess.ID, "moodle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddClient(ctx, sess.ID, "moodle"); err != nil {
+		t.Errorf("AddClient() twice error = %v", err)
+	}
+	if clients, _ := s.Clients(ctx, sess.ID); len(clients) != 1 || clients[0] != "moodle" {
+		t.Errorf("Clients() = %v, want [moodle]", clients)
+	}
+
+	// an expired session is gone
+	expired := &Session{UserID: 1}
+	if err := s.Create(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(expired).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, err := s.Session(ctx, expired.ID); err != ErrSessionNotFound {
+		t.Errorf("Session() expired error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if err := s.Touch(ctx, expired.ID, "", ""); err != ErrSessionNotFound {
+		t.Errorf("Touch() expired error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if sessions, _ := s.Sessions(ctx, 1); len(sessions) != 1 || sessions[0].ID != sess.ID {
+		t.Errorf("Sessions() = %v, want only %s", sessions, sess.ID)
+	}
+
+	if err := s.Delete(ctx, sess.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Session(ctx, sess.ID); err != ErrSessionNotFound {
+		t.Errorf("Session() deleted error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if clients, _ := s.Clients(ctx, sess.ID); len(clients) != 0 {
+		t.Errorf("Clients() deleted = %v, want none", clients)
+	}
+}
+
+func Test_dbStore_Rotate(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	ctx := context.Background()
+	s := NewDBStore(db, time.Hour)
+
+	sess := &Session{UserID: 1}
+	if err := s.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	first := sess.RefreshTokenID
+
+	tests := []struct {
+		name    string
+		used    string
+		next    string
+		grace   time.Duration
+		want    string
+		wantErr error
+	}{
+		{name: "current", used: first, next: "second", grace: time.Minute, want: "second"},
+		{name: "racing renew", used: first, next: "third", grace: time.Minute, want: "second"},
+		{name: "replay after grace", used: first, next: "third", wantErr: ErrRefreshTokenReused},
+		{name: "unknown", used: "forged", next: "third", grace: time.Minute, wantErr: ErrRefreshTokenReused},
+		{name: "without ID", used: "", next: "third", grace: time.Minute, wantErr: ErrRefreshTokenReused},
+		{name: "rotated", used: "second", next: "third", grace: time.Minute, want: "third"},
+		{name: "missing session", used: "third", next: "fourth", wantErr: ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := sess.ID
+			if tt.wantErr == ErrSessionNotFound {
+				id = "missing"
+			}
+
+			got, err := s.Rotate(ctx, id, tt.used, tt.next, tt.grace)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("Rotate() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&Session{}, &Client{}); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
@@ -0,0 +1,8 @@
+package session
+
+import "errors"
+
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+)
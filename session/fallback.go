@@ -0,0 +1,165 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type fallbackStore struct {
+	primary  Store
+	fallback Store
+}
+
+// NewFallbackStore creates Store keeping sessions in primary with a copy in
+// fallback. primary is the one trusted: it is written and read first, and
+// fallback only takes over while primary fails, so sessions outlive primary
+// going down. A session primary doesn't have is gone, it isn't brought
+// back from the copy. Failing to write the copy only fails deleting a
+// session, a session must not live on in either store once it is revoked.
+func NewFallbackStore(primary Store, fallback Store) Store {
+	return &fallbackStore{primary: primary, fallback: fallback}
+}
+
+func (s *fallbackStore) Create(ctx context.Context, sess *Session) error {
+	if err := s.primary.Create(ctx, sess); err != nil {
+		return s.fallback.Create(ctx, sess)
+	}
+
+	_ = s.fallback.Create(ctx, sess)
+	return nil
+}
+
+func (s *fallbackStore) Session(ctx context.Context, id string) (*Session, error) {
+	sess, err := s.primary.Session(ctx, id)
+	if !down(err) {
+		return sess, err
+	}
+
+	return s.fallback.Session(ctx, id)
+}
+
+func (s *fallbackStore) Update(ctx context.Context, sess *Session) error {
+	if err := s.primary.Update(ctx, sess); down(err) {
+		return s.fallback.Update(ctx, sess)
+	} else if err != nil {
+		return err
+	}
+
+	// a copy that can't be updated is dropped rather than left stale
+	if err := s.fallback.Update(ctx, sess); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		_ = s.fallback.Delete(ctx, sess.ID)
+	}
+	return nil
+}
+
+func (s *fallbackStore) Touch(ctx context.Context, id string, ip string, userAgent string) error {
+	if err := s.primary.Touch(ctx, id, ip, userAgent); down(err) {
+		return s.fallback.Touch(ctx, id, ip, userAgent)
+	} else if err != nil {
+		return err
+	}
+
+	_ = s.fallback.Touch(ctx, id, ip, userAgent)
+	return nil
+}
+
+// Rotate never falls back once primary has refused used, the copy may
+// still accept it.
+func (s *fallbackStore) Rotate(ctx context.Context, id string, used string, next string, grace time.Duration) (string, error) {
+	accepted, err := s.primary.Rotate(ctx, id, used, next, grace)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		return "", err
+	}
+	if down(err) {
+		return s.fallback.Rotate(ctx, id, used, next, grace)
+	} else if err != nil {
+		return "", err
+	}
+
+	// a copy that doesn't rotate alike is dropped rather than left stale
+	got, err := s.fallback.Rotate(ctx, id, used, next, grace)
+	if (err != nil && !errors.Is(err, ErrSessionNotFound)) || (err == nil && got != accepted) {
+		_ = s.fallback.Delete(ctx, id)
+	}
+	return accepted, nil
+}
+
+func (s *fallbackStore) AddClient(ctx context.Context, id string, clientID string) error {
+	if err := s.primary.AddClient(ctx, id, clientID); down(err) {
+		return s.fallback.AddClient(ctx, id, clientID)
+	} else if err != nil {
+		return err
+	}
+
+	_ = s.fallback.AddClient(ctx, id, clientID)
+	return nil
+}
+
+// Clients reads both stores, primary misses the clients added while it was
+// failing.
+func (s *fallbackStore) Clients(ctx context.Context, id string) ([]string, error) {
+	clientIDs, err := s.primary.Clients(ctx, id)
+	copied, fallbackErr := s.fallback.Clients(ctx, id)
+	if err != nil {
+		return copied, fallbackErr
+	}
+
+	for _, clientID := range copied {
+		if !contains(clientIDs, clientID) {
+			clientIDs = append(clientIDs, clientID)
+		}
+	}
+	return clientIDs, nil
+}
+
+// Sessions reads both stores, primary misses the sessions created while it
+// was failing. primary has the say on the sessions in both.
+func (s *fallbackStore) Sessions(ctx context.Context, userID uint) ([]*Session, error) {
+	sessions, err := s.primary.Sessions(ctx, userID)
+	copied, fallbackErr := s.fallback.Sessions(ctx, userID)
+	if err != nil {
+		return copied, fallbackErr
+	}
+
+	seen := make(map[string]bool, len(sessions))
+	for _, sess := range sessions {
+		seen[sess.ID] = true
+	}
+	for _, sess := range copied {
+		if !seen[sess.ID] {
+			sessions = append(sessions, sess)
+		}
+	}
+	return sessions, nil
+}
+
+// Delete deletes the session from both stores, the copy all the same when
+// primary fails so it can't be fallen back on. It fails when either fails.
+func (s *fallbackStore) Delete(ctx context.Context, id string) error {
+	err := s.primary.Delete(ctx, id)
+	fallbackErr := s.fallback.Delete(ctx, id)
+	switch {
+	case err != nil && fallbackErr != nil:
+		return fmt.Errorf("%w, deleting the copy: %v", err, fallbackErr)
+	case err != nil:
+		return err
+	}
+	return fallbackErr
+}
+
+// down reports whether err is primary failing rather than primary not
+// having the session.
+func down(err error) bool {
+	return err != nil && !errors.Is(err, ErrSessionNotFound)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_fallbackStore(t *testing.T) {
+	primaryDB, c := createMemDB(t)
+	defer c()
+	fallbackDB, c := createMemDB(t)
+	defer c()
+
+	ctx := context.Background()
+	primary := &failingStore{Store: NewDBStore(primaryDB, time.Hour), fail: true}
+	fallback := NewDBStore(fallbackDB, time.Hour)
+	s := NewFallbackStore(primary, fallback)
+
+	// writes go through while primary is down
+	sess := &Session{UserID: 1}
+	if err := s.Create(ctx, sess); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := s.Touch(ctx, sess.ID, "192.0.2.1", "Firefox"); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	if got, err := s.Session(ctx, sess.ID); err != nil || got.IP != "192.0.2.1" {
+		t.Fatalf("Session() = %+v, %v, want it read from fallback", got, err)
+	}
+	if sessions, _ := s.Sessions(ctx, 1); len(sessions) != 1 {
+		t.Errorf("Sessions() = %v, want one", sessions)
+	}
+
+	// a revocation must reach both stores
+	if err := s.Delete(ctx, sess.ID); err == nil {
+		t.Error("Delete() with primary down error = nil, want an error")
+	}
+	if _, err := fallback.Session(ctx, sess.ID); err != ErrSessionNotFound {
+		t.Errorf("fallback Session() after Delete error = %v, want %v", err, ErrSessionNotFound)
+	}
+
+	// and fails when only the copy can't be deleted
+	primary.fail = false
+	s = NewFallbackStore(primary, &failingStore{Store: fallback, fail: true})
+	other := &Session{UserID: 1}
+	if err := primary.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, other.ID); !errors.Is(err, errStoreDown) {
+		t.Errorf("Delete() with fallback down error = %v, want %v", err, errStoreDown)
+	}
+	if _, err := primary.Session(ctx, other.ID); err != ErrSessionNotFound {
+		t.Errorf("primary Session() after Delete error = %v, want %v", err, ErrSessionNotFound)
+	}
+}
+
+func Test_fallbackStore_primary(t *testing.T) {
+	primaryDB, c := createMemDB(t)
+	defer c()
+	fallbackDB, c := createMemDB(t)
+	defer c()
+
+	ctx := context.Background()
+	primary := &failingStore{Store: NewDBStore(primaryDB, time.Hour)}
+	fallback := NewDBStore(fallbackDB, time.Hour)
+	s := NewFallbackStore(primary, fallback)
+
+	sess := &Session{UserID: 1, RefreshTokenID: "rt1"}
+	if err := s.Create(ctx, sess); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := fallback.Session(ctx, sess.ID); err != nil {
+		t.Fatalf("fallback Session() error = %v, want the copy", err)
+	}
+
+	// primary has the say on refresh tokens, the copy follows it
+	if accepted, err := s.Rotate(ctx, sess.ID, "rt1", "rt2", 0); err != nil || accepted != "rt2" {
+		t.Fatalf("Rotate() = %q, %v, want rt2", accepted, err)
+	}
+	if copied, _ := fallback.Session(ctx, sess.ID); copied == nil || copied.RefreshTokenID != "rt2" {
+		t.Errorf("fallback Session() = %+v, want it rotated to rt2", copied)
+	}
+	if _, err := s.Rotate(ctx, sess.ID, "rt1", "rt3", 0); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Rotate() reused error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+
+	sess, err := s.Session(ctx, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.ACR = "mfa"
+	if err := s.Update(ctx, sess); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	for name, store := range map[string]Store{"primary": primary, "fallback": fallback} {
+		if got, _ := store.Session(ctx, sess.ID); got == nil || got.ACR != "mfa" || got.RefreshTokenID != "rt2" {
+			t.Errorf("%s Session() after Update = %+v, want it updated", name, got)
+		}
+	}
+
+	// a session primary doesn't have isn't brought back from the copy
+	if err := primary.Store.Delete(ctx, sess.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Touch(ctx, sess.ID, "192.0.2.1", "Firefox"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Touch() after primary lost the session error = %v, want %v", err, ErrSessionNotFound)
+	}
+	if got, err := s.Session(ctx, sess.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Session() after primary lost the session = %+v, %v, want %v", got, err, ErrSessionNotFound)
+	}
+
+	// sessions created while primary was down are still listed
+	other := &Session{UserID: 1}
+	if err := s.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, err := s.Sessions(ctx, 1); err != nil || len(sessions) != 2 {
+		t.Errorf("Sessions() = %v, %v, want both", sessions, err)
+	}
+}
+
+var errStoreDown = errors.New("store down")
+
+// failingStore fails every call while fail is set.
+type failingStore struct {
+	Store
+	fail bool
+}
+
+func (s *failingStore) Create(ctx context.Context, sess *Session) error {
+	if s.fail {
+		return errStoreDown
+	}
+	return s.Store.Create(ctx, sess)
+}
+
+func (s *failingStore) Session(ctx context.Context, id string) (*Session, error) {
+	if s.fail {
+		return nil, errStoreDown
+	}
+	return s.Store.Session(ctx, id)
+}
+
+func (s *failingStore) Touch(ctx context.Context, id string, ip string, userAgent string) error {
+	if s.fail {
+		return errStoreDown
+	}
+	return s.Store.Touch(ctx, id, ip, userAgent)
+}
+
+func (s *failingStore) Delete(ctx context.Context, id string) error {
+	if s.fail {
+		return errStoreDown
+	}
+	return s.Store.Delete(ctx, id)
+}
@@ -0,0 +1,111 @@
+package session
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session represents a signed-in browser. Its ID is carried in the refresh
+// token as "sid" so every token minted from the same login can be traced
+// back to it.
+type Session struct {
+	ID        string    `json:"id" gorm:"primarykey"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// AuthTime is when the user last actively authenticated, ACR and AMR
+	// describe how.
+	AuthTime time.Time `json:"auth_time"`
+	ACR      string    `json:"acr"`
+	AMR      []string  `json:"amr" gorm:"serializer:json"`
+
+	// UserAgent and IP are of the browser when it was last seen.
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"index"`
+
+	// RefreshTokenID is the ID ("jti") of the only refresh token the
+	// session accepts. PreviousRefreshTokenID is the one it replaced at
+	// RotatedAt.
+	RefreshTokenID         string    `json:"refresh_token_id"`
+	PreviousRefreshTokenID string    `json:"previous_refresh_token_id"`
+	RotatedAt              time.Time `json:"rotated_at"`
+
+	// ActorID is the admin impersonating the user in the session, 0 for
+	// sessions the user signed in to.
+	ActorID uint `json:"actor_id,omitempty"`
+}
+
+// Client records an OAuth client a session has signed into.
+type Client struct {
+	SessionID string `gorm:"primarykey"`
+	ClientID  string `gorm:"primarykey"`
+}
+
+// TableName overrides the default clients.
+func (Client) TableName() string {
+	return "session_clients"
+}
+
+// NewRefreshTokenID generates an ID for the next refresh token of a
+// session.
+func NewRefreshTokenID() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// prepare fills what a new session leaves empty.
+func prepare(sess *Session, ttl time.Duration) error {
+	if sess.ID == "" {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return err
+		}
+		sess.ID = id.String()
+	}
+	if sess.RefreshTokenID == "" {
+		id, err := NewRefreshTokenID()
+		if err != nil {
+			return err
+		}
+		sess.RefreshTokenID = id
+	}
+
+	now := time.Now()
+	if sess.CreatedAt.IsZero() {
+		sess.CreatedAt = now
+	}
+	if sess.LastSeenAt.IsZero() {
+		sess.LastSeenAt = now
+	}
+	sess.ExpiresAt = now.Add(ttl)
+
+	return nil
+}
+
+// rotate accepts the refresh token used on sess. The token the session
+// accepts is replaced by next, and next is returned as the ID the new
+// refresh token carries. The token replaced last is still accepted for
+// grace after it was, in favor of browsers racing to renew, and then
+// returns the current ID leaving sess unchanged. Any other token is a
+// reused one.
+func rotate(sess *Session, used string, next string, grace time.Duration) (string, bool, error) {
+	now := time.Now()
+	switch {
+	case used == sess.RefreshTokenID:
+		sess.PreviousRefreshTokenID = used
+		sess.RefreshTokenID = next
+		sess.RotatedAt = now
+		return next, true, nil
+
+	case used != "" && used == sess.PreviousRefreshTokenID && now.Sub(sess.RotatedAt) < grace:
+		return sess.RefreshTokenID, false, nil
+	}
+
+	return "", false, ErrRefreshTokenReused
+}
@@ -0,0 +1,304 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+const keyPrefix = "session:"
+
+type Store interface {
+	// Create stores a new session. ID, CreatedAt and LastSeenAt are filled
+	// when empty.
+	Create(ctx context.Context, s *Session) error
+
+	// Session gets the session with the specified ID.
+	// Returns ErrSessionNotFound if it has expired or has been deleted.
+	Session(ctx context.Context, id string) (*Session, error)
+
+	// Update saves changes of an existing session without extending its lifetime.
+	Update(ctx context.Context, s *Session) error
+
+	// Touch extends the lifetime of the session and records that its
+	// browser has been seen now, from ip with userAgent.
+	Touch(ctx context.Context, id string, ip string, userAgent string) error
+
+	// Rotate accepts the refresh token with the ID used once, replacing it
+	// with next. It returns the ID the refresh token minted in exchange
+	// carries, which is the current one when used was replaced less than
+	// grace ago.
+	// Returns ErrRefreshTokenReused if used isn't accepted anymore.
+	Rotate(ctx context.Context, id string, used string, next string, grace time.Duration) (string, error)
+
+	// AddClient records that the session has signed into an OAuth client.
+	AddClient(ctx context.Context, id string, clientID string) error
+
+	// Clients gets the OAuth clients the session has signed into.
+	Clients(ctx context.Context, id string) ([]string, error)
+
+	// Sessions gets the sessions of the user that haven't expired.
+	Sessions(ctx context.Context, userID uint) ([]*Session, error)
+
+	// Delete removes the session along with its client records.
+	Delete(ctx context.Context, id string) error
+}
+
+type store struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// touchRetries is how many times Update, Touch and Rotate retry when the session
+// changes while it is being written.
+const touchRetries = 3
+
+// NewStore creates Store backed by redis. Sessions expire after ttl unless
+// touched.
+func NewStore(rdb *redis.Client, ttl time.Duration) Store {
+	return &store{rdb: rdb, ttl: ttl}
+}
+
+func (s *store) Create(ctx context.Context, sess *Session) error {
+	if err := prepare(sess, s.ttl); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, sessionKey(sess.ID), buf, s.ttl)
+	pipe.SAdd(ctx, userKey(sess.UserID), sess.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *store) Session(ctx context.Context, id string) (*Session, error) {
+	return s.get(ctx, s.rdb, id)
+}
+
+func (s *store) get(ctx context.Context, rdb redis.Cmdable, id string) (*Session, error) {
+	buf, err := rdb.Get(ctx, sessionKey(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	sess := &Session{}
+	if err := json.Unmarshal(buf, sess); err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// Update writes the fields of sess that change over its lifetime onto the
+// stored session, under the same watch as Rotate so a Touch or Rotate
+// racing it isn't overwritten with what sess was read as.
+func (s *store) Update(ctx context.Context, sess *Session) error {
+	updateTx := func(tx *redis.Tx) error {
+		stored, err := s.get(ctx, tx, sess.ID)
+		if err != nil {
+			return err
+		}
+
+		stored.AuthTime = sess.AuthTime
+		stored.ACR = sess.ACR
+		stored.AMR = sess.AMR
+		stored.UserAgent = sess.UserAgent
+		stored.IP = sess.IP
+		stored.LastSeenAt = sess.LastSeenAt
+		stored.RefreshTokenID = sess.RefreshTokenID
+		stored.PreviousRefreshTokenID = sess.PreviousRefreshTokenID
+		stored.RotatedAt = sess.RotatedAt
+
+		buf, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, sessionKey(sess.ID), buf, redis.KeepTTL)
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < touchRetries; i++ {
+		err = s.rdb.Watch(ctx, updateTx, sessionKey(sess.ID))
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *store) Touch(ctx context.Context, id string, ip string, userAgent string) error {
+	touch := func(tx *redis.Tx) error {
+		sess, err := s.get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		sess.IP = ip
+		sess.UserAgent = userAgent
+		sess.LastSeenAt = now
+		sess.ExpiresAt = now.Add(s.ttl)
+
+		buf, err := json.Marshal(sess)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, sessionKey(id), buf, s.ttl)
+			pipe.Expire(ctx, clientsKey(id), s.ttl)
+			return nil
+		})
+		return err
+	}
+
+	// the session is only written if it hasn't changed since it was read
+	var err error
+	for i := 0; i < touchRetries; i++ {
+		err = s.rdb.Watch(ctx, touch, sessionKey(id))
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *store) Rotate(ctx context.Context, id string, used string, next string, grace time.Duration) (string, error) {
+	var accepted string
+	rotateTx := func(tx *redis.Tx) error {
+		sess, err := s.get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		var changed bool
+		accepted, changed, err = rotate(sess, used, next, grace)
+		if err != nil || !changed {
+			return err
+		}
+
+		buf, err := json.Marshal(sess)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, sessionKey(id), buf, redis.KeepTTL)
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for i := 0; i < touchRetries; i++ {
+		err = s.rdb.Watch(ctx, rotateTx, sessionKey(id))
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return accepted, nil
+}
+
+func (s *store) AddClient(ctx context.Context, id string, clientID string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.SAdd(ctx, clientsKey(id), clientID)
+	pipe.Expire(ctx, clientsKey(id), s.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *store) Clients(ctx context.Context, id string) ([]string, error) {
+	return s.rdb.SMembers(ctx, clientsKey(id)).Result()
+}
+
+// Sessions reads the sessions indexed for the user, dropping the ones
+// that have expired from the index.
+func (s *store) Sessions(ctx context.Context, userID uint) ([]*Session, error) {
+	ids, err := s.rdb.SMembers(ctx, userKey(userID)).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionKey(id)
+	}
+
+	values, err := s.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sessions []*Session
+		expired  []interface{}
+	)
+	for i, v := range values {
+		buf, ok := v.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+
+		sess := &Session{}
+		if err := json.Unmarshal([]byte(buf), sess); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+
+	if len(expired) > 0 {
+		if err := s.rdb.SRem(ctx, userKey(userID), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return sessions, nil
+}
+
+func (s *store) Delete(ctx context.Context, id string) error {
+	sess, err := s.Session(ctx, id)
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, sessionKey(id), clientsKey(id))
+	if sess != nil {
+		pipe.SRem(ctx, userKey(sess.UserID), id)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func sessionKey(id string) string {
+	return keyPrefix + id
+}
+
+func userKey(userID uint) string {
+	return keyPrefix + "user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func clientsKey(id string) string {
+	return keyPrefix + id + ":clients"
+}
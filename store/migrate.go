@@ -1,14 +1,54 @@
 package store
 
 import (
+	"github.com/9d4/semaphore/audit"
+	"github.com/9d4/semaphore/federation"
+	"github.com/9d4/semaphore/mfa"
+	"github.com/9d4/semaphore/passkey"
+	"github.com/9d4/semaphore/password"
+	"github.com/9d4/semaphore/pat"
+	"github.com/9d4/semaphore/rbac"
+	"github.com/9d4/semaphore/reset"
+	"github.com/9d4/semaphore/scim"
+	"github.com/9d4/semaphore/session"
+	"github.com/9d4/semaphore/tenant"
 	"github.com/9d4/semaphore/user"
+	jww "github.com/spf13/jwalterweatherman"
 	"gorm.io/gorm"
 )
 
 func MigrateAll(db *gorm.DB) {
 	toBeMigrated := []interface{}{
 		&user.User{},
+		&mfa.TOTP{},
+		&mfa.RecoveryCode{},
+		&passkey.Credential{},
+		&reset.Token{},
+		&password.History{},
+		&session.Session{},
+		&session.Client{},
+		&rbac.Role{},
+		&rbac.RolePermission{},
+		&rbac.Group{},
+		&rbac.GroupRole{},
+		&rbac.GroupMember{},
+		&rbac.UserRole{},
+		&tenant.Tenant{},
+		&federation.Connector{},
+		&federation.Identity{},
+		&scim.Token{},
+		&pat.Token{},
+	}
+
+	// emails used to be unique across every user, now within a tenant
+	if db.Migrator().HasIndex(&user.User{}, "email_index") {
+		db.Migrator().DropIndex(&user.User{}, "email_index")
 	}
 
 	db.AutoMigrate(toBeMigrated...)
+	rbac.SeedAdminRole(db)
+
+	if err := audit.NewStore(db).Migrate(); err != nil {
+		jww.ERROR.Println("unable to migrate audit events:", err)
+	}
 }
@@ -0,0 +1,9 @@
+package tenant
+
+import "errors"
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrSlugInvalid    = errors.New("invalid tenant slug")
+	ErrSlugTaken      = errors.New("tenant slug is already in use")
+)
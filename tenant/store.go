@@ -0,0 +1,153 @@
+package tenant
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type Store interface {
+	// Create inserts the tenant with a new signing key.
+	// Returns ErrSlugInvalid or ErrSlugTaken for a slug it can't use.
+	Create(t *Tenant) error
+
+	// Tenant gets the tenant with the specified slug, Default for
+	// DefaultSlug.
+	// Returns ErrTenantNotFound if there is no such tenant.
+	Tenant(slug string) (*Tenant, error)
+
+	// TenantByID gets the tenant with the specified ID, Default for 0.
+	// Returns ErrTenantNotFound if there is no such tenant.
+	TenantByID(id uint) (*Tenant, error)
+
+	// Tenants gets every tenant but Default, by slug.
+	Tenants() ([]*Tenant, error)
+
+	// Update replaces the name, scopes and branding of the tenant with the
+	// slug of t.
+	// Returns ErrTenantNotFound if there is no such tenant.
+	Update(t *Tenant) error
+
+	// RotateKey replaces the signing key of the tenant with the specified
+	// slug, the tokens it has issued can't be verified anymore.
+	// Returns ErrTenantNotFound if there is no such tenant.
+	RotateKey(slug string) error
+
+	// Delete deletes the tenant with the specified slug.
+	// Returns ErrTenantNotFound if there is no such tenant.
+	Delete(slug string) error
+
+	// Migrate auto-migrates the Tenant model to database.
+	Migrate() error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store {
+	return &store{db: db}
+}
+
+func (s *store) Create(t *Tenant) error {
+	if !ValidSlug(t.Slug) {
+		return ErrSlugInvalid
+	}
+	if _, err := s.Tenant(t.Slug); !errors.Is(err, ErrTenantNotFound) {
+		if err == nil {
+			return ErrSlugTaken
+		}
+		return err
+	}
+
+	key, err := newSigningKey()
+	if err != nil {
+		return err
+	}
+	t.SigningKey = key
+
+	return s.db.Create(t).Error
+}
+
+func (s *store) Tenant(slug string) (*Tenant, error) {
+	if slug == DefaultSlug {
+		return Default, nil
+	}
+	return s.find("slug = ?", slug)
+}
+
+func (s *store) TenantByID(id uint) (*Tenant, error) {
+	if id == 0 {
+		return Default, nil
+	}
+	return s.find("id = ?", id)
+}
+
+func (s *store) find(query string, arg interface{}) (*Tenant, error) {
+	t := &Tenant{}
+	err := s.db.Where(query, arg).First(t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *store) Tenants() ([]*Tenant, error) {
+	var tenants []*Tenant
+	err := s.db.Order("slug").Find(&tenants).Error
+	return tenants, err
+}
+
+func (s *store) Update(t *Tenant) error {
+	current, err := s.Tenant(t.Slug)
+	if err != nil {
+		return err
+	}
+	if current.IsDefault() {
+		return ErrSlugInvalid
+	}
+
+	current.Name = t.Name
+	current.Scopes = t.Scopes
+	current.Branding = t.Branding
+	if err := s.db.Save(current).Error; err != nil {
+		return err
+	}
+
+	*t = *current
+	return nil
+}
+
+func (s *store) RotateKey(slug string) error {
+	key, err := newSigningKey()
+	if err != nil {
+		return err
+	}
+
+	tx := s.db.Model(&Tenant{}).Where("slug = ?", slug).Update("signing_key", key)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrTenantNotFound
+	}
+	return nil
+}
+
+func (s *store) Delete(slug string) error {
+	tx := s.db.Where("slug = ?", slug).Delete(&Tenant{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrTenantNotFound
+	}
+	return nil
+}
+
+func (s *store) Migrate() error {
+	return s.db.AutoMigrate(&Tenant{})
+}
@@ -0,0 +1,152 @@
+package tenant
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_store_Create(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+
+	tests := []struct {
+		name    string
+		tenant  Tenant
+		wantErr error
+	}{
+		{name: "create", tenant: Tenant{Slug: "acme", Name: "Acme", Scopes: []string{"openid"}}},
+		{name: "taken", tenant: Tenant{Slug: "acme"}, wantErr: ErrSlugTaken},
+		{name: "default", tenant: Tenant{Slug: DefaultSlug}, wantErr: ErrSlugInvalid},
+		{name: "invalid", tenant: Tenant{Slug: "Acme Corp"}, wantErr: ErrSlugInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Create(&tt.tenant); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := s.Tenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID == 0 || got.Name != "Acme" || len(got.SigningKey) != 64 {
+		t.Errorf("Tenant() = %+v, want acme with a signing key", got)
+	}
+	if !got.AllowsScope("openid") || got.AllowsScope("email") {
+		t.Errorf("Tenant() scopes = %v, want [openid]", got.Scopes)
+	}
+
+	if got, err := s.Tenant(DefaultSlug); err != nil || got != Default {
+		t.Errorf("Tenant(default) = %v, %v, want Default", got, err)
+	}
+	if got, err := s.TenantByID(0); err != nil || got != Default {
+		t.Errorf("TenantByID(0) = %v, %v, want Default", got, err)
+	}
+	if _, err := s.Tenant("globex"); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("Tenant(globex) error = %v, want %v", err, ErrTenantNotFound)
+	}
+}
+
+func Test_store_Update(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	acme := &Tenant{Slug: "acme", Name: "Acme"}
+	if err := s.Create(acme); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &Tenant{Slug: "acme", Name: "Acme Corp", Branding: Branding{PrimaryColor: "#ff0000"}}
+	if err := s.Update(update); err != nil {
+		t.Fatal(err)
+	}
+	if update.ID != acme.ID || update.SigningKey != acme.SigningKey {
+		t.Errorf("Update() = %+v, want the key and ID of acme kept", update)
+	}
+
+	got, err := s.TenantByID(acme.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Acme Corp" || got.Branding.PrimaryColor != "#ff0000" {
+		t.Errorf("TenantByID() = %+v, want the updated acme", got)
+	}
+
+	if err := s.RotateKey("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Tenant("acme"); got.SigningKey == acme.SigningKey {
+		t.Error("RotateKey() kept the signing key")
+	}
+
+	if err := s.Update(&Tenant{Slug: "globex"}); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("Update(globex) error = %v, want %v", err, ErrTenantNotFound)
+	}
+	if err := s.RotateKey("globex"); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("RotateKey(globex) error = %v, want %v", err, ErrTenantNotFound)
+	}
+}
+
+func Test_store_Delete(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	for _, slug := range []string{"acme", "globex"} {
+		if err := s.Create(&Tenant{Slug: slug}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Delete("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("acme"); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("Delete() again error = %v, want %v", err, ErrTenantNotFound)
+	}
+
+	tenants, err := s.Tenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 1 || tenants[0].Slug != "globex" {
+		t.Errorf("Tenants() = %v, want globex", tenants)
+	}
+}
+
+func createMemDB(t testing.TB) (*gorm.DB, func()) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewStore(db).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	Close := func() {
+		d, err := db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, Close
+}
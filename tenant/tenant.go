@@ -0,0 +1,75 @@
+package tenant
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"time"
+)
+
+// DefaultSlug names the tenant users and clients belong to when they
+// belong to no other. It has no row, its ID is 0 and it signs with the
+// key of the server.
+const DefaultSlug = "default"
+
+// Default is the tenant users and clients belong to when they belong to
+// no other.
+var Default = &Tenant{Slug: DefaultSlug, Name: "Default"}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+// Tenant is a realm of its own users and OAuth2 clients, which don't see
+// the ones of other tenants.
+type Tenant struct {
+	ID   uint   `json:"id" gorm:"primarykey"`
+	Slug string `json:"slug" gorm:"uniqueIndex"`
+	Name string `json:"name"`
+	// SigningKey signs the OAuth2 tokens issued by the tenant
+	SigningKey string `json:"-"`
+	// Scopes are the OAuth2 scopes clients of the tenant can be granted,
+	// any scope the server knows when empty
+	Scopes   []string `json:"scopes" gorm:"serializer:json"`
+	Branding Branding `json:"branding" gorm:"embedded;embeddedPrefix:branding_"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Branding is how the pages of a tenant look.
+type Branding struct {
+	DisplayName  string `json:"display_name"`
+	LogoURL      string `json:"logo_url"`
+	PrimaryColor string `json:"primary_color"`
+}
+
+// IsDefault reports whether t is the default tenant.
+func (t *Tenant) IsDefault() bool {
+	return t.ID == 0
+}
+
+// AllowsScope reports whether clients of t can be granted scope.
+func (t *Tenant) AllowsScope(scope string) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidSlug reports whether slug can name a tenant in URLs.
+func ValidSlug(slug string) bool {
+	return slug != DefaultSlug && slugPattern.MatchString(slug)
+}
+
+// newSigningKey generates the key a new tenant signs its tokens with.
+func newSigningKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
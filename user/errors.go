@@ -44,6 +44,10 @@ func New(base error, msg string) *Error {
 
 var (
 	ErrUserNotFound = New(gorm.ErrRecordNotFound, "user not found")
+
+	ErrListSortInvalid   = errors.New("unknown sort field")
+	ErrListCursorInvalid = errors.New("invalid cursor")
+	ErrListStatusInvalid = errors.New("unknown status")
 )
 
 func resolveError(err error) error {
@@ -0,0 +1,219 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Statuses users can be listed by.
+const (
+	// StatusActive users can sign in
+	StatusActive = "active"
+	// StatusDisabled users are kept but can't sign in
+	StatusDisabled = "disabled"
+	// StatusDeleted users are soft-deleted, they can be restored
+	StatusDeleted = "deleted"
+)
+
+// Sizes of the pages users are listed in.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+// listSortColumns maps the fields users can be sorted by to their column.
+var listSortColumns = map[string]string{
+	"id":         "id",
+	"email":      "email",
+	"firstname":  "first_name",
+	"lastname":   "last_name",
+	"created_at": "created_at",
+}
+
+// ListQuery filters and orders the users List returns. Zero fields don't
+// filter.
+type ListQuery struct {
+	// Email and Name match a part of the address or of either name,
+	// regardless of case
+	Email string
+	Name  string
+
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// Status is one of StatusActive, StatusDisabled or StatusDeleted,
+	// empty lists users that aren't deleted
+	Status string
+	// IDs narrows the users down to these when it isn't nil
+	IDs []uint
+
+	// Sort is a field of listSortColumns, id when empty
+	Sort string
+	Desc bool
+
+	// Cursor is where the previous page ended, empty for the first page
+	Cursor string
+	// Limit is the size of a page, DefaultListLimit when 0 and at most
+	// MaxListLimit
+	Limit int
+}
+
+// cursor is the position of the last user of a page in the sort order,
+// its ID breaks ties.
+type cursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func (s *store) Users(q ListQuery) ([]*User, string, error) {
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	column, ok := listSortColumns[q.Sort]
+	if !ok {
+		return nil, "", ErrListSortInvalid
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultListLimit
+	}
+	if q.Limit > MaxListLimit {
+		q.Limit = MaxListLimit
+	}
+
+	tx, err := filter(s.scoped().Model(&User{}), q)
+	if err != nil {
+		return nil, "", err
+	}
+
+	direction, compare := "ASC", ">"
+	if q.Desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if column == "id" {
+			tx = tx.Where("id "+compare+" ?", c.ID)
+		} else {
+			value, err := cursorValue(column, c.Value)
+			if err != nil {
+				return nil, "", err
+			}
+			tx = tx.Where("("+column+" "+compare+" ? OR ("+column+" = ? AND id "+compare+" ?))", value, value, c.ID)
+		}
+	}
+
+	var users []*User
+	err = tx.Order(column + " " + direction).Order("id " + direction).
+		Limit(q.Limit + 1).
+		Find(&users).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the extra user tells whether there is a next page
+	if len(users) <= q.Limit {
+		return users, "", nil
+	}
+	users = users[:q.Limit]
+
+	next, err := encodeCursor(users[len(users)-1], column)
+	if err != nil {
+		return nil, "", err
+	}
+	return users, next, nil
+}
+
+// filter narrows tx down to the users q matches.
+func filter(tx *gorm.DB, q ListQuery) (*gorm.DB, error) {
+	switch q.Status {
+	case "":
+	case StatusActive:
+		tx = tx.Where("disabled_at IS NULL")
+	case StatusDisabled:
+		tx = tx.Where("disabled_at IS NOT NULL")
+	case StatusDeleted:
+		tx = tx.Unscoped().Where("deleted_at IS NOT NULL")
+	default:
+		return nil, ErrListStatusInvalid
+	}
+
+	if q.Email != "" {
+		tx = tx.Where(`lower(email) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(q.Email))+"%")
+	}
+	if q.Name != "" {
+		name := "%" + escapeLike(strings.ToLower(q.Name)) + "%"
+		tx = tx.Where(`(lower(first_name) LIKE ? ESCAPE '\' OR lower(last_name) LIKE ? ESCAPE '\')`, name, name)
+	}
+	if !q.CreatedAfter.IsZero() {
+		tx = tx.Where("created_at >= ?", q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		tx = tx.Where("created_at < ?", q.CreatedBefore)
+	}
+	if q.IDs != nil {
+		tx = tx.Where("id IN ?", q.IDs)
+	}
+
+	return tx, nil
+}
+
+// escapeLike makes the wildcards of s match themselves.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func encodeCursor(u *User, column string) (string, error) {
+	c := cursor{ID: u.ID}
+	switch column {
+	case "email":
+		c.Value = u.Email
+	case "first_name":
+		c.Value = u.FirstName
+	case "last_name":
+		c.Value = u.LastName
+	case "created_at":
+		c.Value = u.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrListCursorInvalid
+	}
+
+	c := &cursor{}
+	if err := json.Unmarshal(buf, c); err != nil {
+		return nil, ErrListCursorInvalid
+	}
+	return c, nil
+}
+
+// cursorValue converts the value of a cursor back to the type of column.
+func cursorValue(column string, value string) (interface{}, error) {
+	switch column {
+	case "created_at":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, ErrListCursorInvalid
+		}
+		return t, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,131 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_store_Users(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	names := []string{"Carol", "Alice", "Erin", "Bob", "Dave"}
+	users := make([]*User, len(names))
+	for i, name := range names {
+		users[i] = &User{
+			Email:     fmt.Sprintf("%s@example.com", name),
+			FirstName: name,
+			LastName:  "Smith",
+		}
+		if err := s.Create(users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetDisabled(users[2].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(users[3].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		q       ListQuery
+		want    []string
+		wantErr error
+	}{
+		{name: "all", q: ListQuery{}, want: []string{"Carol", "Alice", "Erin", "Dave"}},
+		{name: "sorted", q: ListQuery{Sort: "firstname"}, want: []string{"Alice", "Carol", "Dave", "Erin"}},
+		{name: "sorted desc", q: ListQuery{Sort: "email", Desc: true}, want: []string{"Erin", "Dave", "Carol", "Alice"}},
+		{name: "email", q: ListQuery{Email: "ALICE@"}, want: []string{"Alice"}},
+		{name: "email wildcard", q: ListQuery{Email: "%"}, want: nil},
+		{name: "name", q: ListQuery{Name: "ar"}, want: []string{"Carol"}},
+		{name: "active", q: ListQuery{Status: StatusActive}, want: []string{"Carol", "Alice", "Dave"}},
+		{name: "disabled", q: ListQuery{Status: StatusDisabled}, want: []string{"Erin"}},
+		{name: "deleted", q: ListQuery{Status: StatusDeleted}, want: []string{"Bob"}},
+		{name: "ids", q: ListQuery{IDs: []uint{users[1].ID, users[3].ID}}, want: []string{"Alice"}},
+		{name: "no ids", q: ListQuery{IDs: []uint{}}, want: nil},
+		{name: "created before", q: ListQuery{CreatedBefore: time.Now().Add(-time.Hour)}, want: nil},
+		{name: "created after", q: ListQuery{CreatedAfter: time.Now().Add(-time.Hour)}, want: []string{"Carol", "Alice", "Erin", "Dave"}},
+		{name: "unknown sort", q: ListQuery{Sort: "password"}, wantErr: ErrListSortInvalid},
+		{name: "unknown status", q: ListQuery{Status: "gone"}, wantErr: ErrListStatusInvalid},
+		{name: "invalid cursor", q: ListQuery{Cursor: "!"}, wantErr: ErrListCursorInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, err := s.Users(tt.q)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Users() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if next != "" {
+				t.Errorf("Users() next = %q, want the last page", next)
+			}
+			if fmt.Sprint(firstNames(got)) != fmt.Sprint(tt.want) {
+				t.Errorf("Users() got = %v, want %v", firstNames(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_store_Users_pages(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	for _, name := range []string{"Carol", "Alice", "Erin", "Bob", "Dave"} {
+		err := s.Create(&User{Email: name + "@example.com", FirstName: name, LastName: "Smith"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    ListQuery
+		want []string
+	}{
+		{name: "by id", q: ListQuery{}, want: []string{"Carol", "Alice", "Erin", "Bob", "Dave"}},
+		{name: "by name", q: ListQuery{Sort: "firstname"}, want: []string{"Alice", "Bob", "Carol", "Dave", "Erin"}},
+		{name: "by last name desc", q: ListQuery{Sort: "lastname", Desc: true}, want: []string{"Dave", "Bob", "Erin", "Alice", "Carol"}},
+		{name: "by created", q: ListQuery{Sort: "created_at"}, want: []string{"Carol", "Alice", "Erin", "Bob", "Dave"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.q
+			q.Limit = 2
+
+			var got []string
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatal("Users() doesn't stop paging")
+				}
+
+				users, next, err := s.Users(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, firstNames(users)...)
+
+				if next == "" {
+					break
+				}
+				q.Cursor = next
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Users() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func firstNames(users []*User) []string {
+	var names []string
+	for _, u := range users {
+		names = append(names, u.FirstName)
+	}
+	return names
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 	"strings"
+	"time"
 )
 
 type Store interface {
@@ -23,10 +24,47 @@ type Store interface {
 	// Returns the user and any error that occurred.
 	UserByEmail(email string) (*User, error)
 
-	// UserByName gets users from the database with the specified name.
+	// UserByName gets users from the database with the specified name, at
+	// most MaxListLimit of them.
 	// Returns the users and any error that occurred.
 	UserByName(name string) ([]*User, error)
 
+	// Users gets a page of the users matching q, and the cursor of the next
+	// page, empty when it is the last one.
+	// Returns ErrListSortInvalid, ErrListStatusInvalid or ErrListCursorInvalid
+	// for a query it can't run.
+	Users(q ListQuery) ([]*User, string, error)
+
+	// UpdatePassword replaces the password hash of the user with the specified ID.
+	// Returns ErrUserNotFound if there is no such user.
+	UpdatePassword(id uint, hashedPassword string) error
+
+	// UpdateProfile replaces the names of the user with the specified ID.
+	// Returns ErrUserNotFound if there is no such user.
+	UpdateProfile(id uint, firstName string, lastName string) error
+
+	// SetDisabled disables or enables the user with the specified ID.
+	// Returns ErrUserNotFound if there is no such user.
+	SetDisabled(id uint, disabled bool) error
+
+	// Delete soft-deletes the user with the specified ID, it can be restored.
+	// Returns ErrUserNotFound if there is no such user.
+	Delete(id uint) error
+
+	// Restore brings back the soft-deleted user with the specified ID.
+	// Returns ErrUserNotFound if there is no such deleted user.
+	Restore(id uint) error
+
+	// Purge deletes the user with the specified ID for good, whether it is
+	// soft-deleted or not.
+	// Returns ErrUserNotFound if there is no such user.
+	Purge(id uint) error
+
+	// ForTenant gets a store of the users of the tenant with the specified
+	// ID. Lookups by criteria, email or name, listing and creating are
+	// limited to the tenant, while users are found by ID in any tenant.
+	ForTenant(tenantID uint) Store
+
 	// DB gets the underlying *gorm.DB instance.
 	DB() *gorm.DB
 
@@ -38,17 +76,28 @@ type Store interface {
 }
 
 type store struct {
-	db *gorm.DB
+	db       *gorm.DB
+	tenantID uint
 }
 
+// NewStore returns a store of the users of the default tenant.
 func NewStore(db *gorm.DB) Store {
 	return &store{db: db}
 }
 
+func (s *store) ForTenant(tenantID uint) Store {
+	return &store{db: s.db, tenantID: tenantID}
+}
+
+// scoped limits the query to the users of the tenant of the store.
+func (s *store) scoped() *gorm.DB {
+	return s.db.Where("tenant_id = ?", s.tenantID)
+}
+
 func (s *store) User(u *User) (*User, error) {
 	// Get user from db with custom query
 	var usr User
-	tx := s.db.Where(u).First(&usr)
+	tx := s.scoped().Where(u).First(&usr)
 
 	if tx.Error != nil {
 		return nil, resolveError(tx.Error)
@@ -70,7 +119,7 @@ func (s *store) UserByID(id uint) (*User, error) {
 
 func (s *store) UserByEmail(email string) (*User, error) {
 	var usr User
-	tx := s.db.Where("email = ?", email).First(&usr)
+	tx := s.scoped().Where("email = ?", email).First(&usr)
 
 	if tx.Error != nil {
 		switch err := tx.Error; {
@@ -88,9 +137,9 @@ func (s *store) UserByName(name string) ([]*User, error) {
 	name = strings.ToLower(name)
 	var users []*User
 
-	tx := s.db.
-		Where("lower(first_name) LIKE ?", "%"+name+"%").
-		Or("lower(last_name) LIKE ?", "%"+name+"%").
+	tx := s.scoped().
+		Where("lower(first_name) LIKE ? OR lower(last_name) LIKE ?", "%"+name+"%", "%"+name+"%").
+		Limit(MaxListLimit).
 		Find(&users)
 
 	if tx.Error != nil {
@@ -100,6 +149,73 @@ func (s *store) UserByName(name string) ([]*User, error) {
 	return users, nil
 }
 
+func (s *store) UpdatePassword(id uint, hashedPassword string) error {
+	tx := s.db.Model(&User{}).Where("id = ?", id).Update("password", hashedPassword)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *store) UpdateProfile(id uint, firstName string, lastName string) error {
+	tx := s.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"first_name": firstName,
+		"last_name":  lastName,
+	})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *store) SetDisabled(id uint, disabled bool) error {
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now()
+		disabledAt = &now
+	}
+
+	tx := s.db.Model(&User{}).Where("id = ?", id).Update("disabled_at", disabledAt)
+	return affected(tx)
+}
+
+func (s *store) Delete(id uint) error {
+	tx := s.db.Where("id = ?", id).Delete(&User{})
+	return affected(tx)
+}
+
+func (s *store) Restore(id uint) error {
+	tx := s.db.Unscoped().Model(&User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	return affected(tx)
+}
+
+func (s *store) Purge(id uint) error {
+	tx := s.db.Unscoped().Where("id = ?", id).Delete(&User{})
+	return affected(tx)
+}
+
+// affected returns ErrUserNotFound when tx didn't change any user.
+func affected(tx *gorm.DB) error {
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if tx.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
 func (s *store) DB() *gorm.DB {
 	return s.db
 }
@@ -123,6 +239,7 @@ func (s *store) Create(u *User) error {
 	}
 
 	u.UUID = uuid.String()
+	u.TenantID = s.tenantID
 
 	tx := s.db.Create(u)
 
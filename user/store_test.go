@@ -335,6 +335,76 @@ func Test_store_UserByName(t *testing.T) {
 	}
 }
 
+func Test_store_UpdatePassword(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	usr := &User{Email: "foo@bar.com", Password: "old"}
+	if err := s.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		id      uint
+		wantErr error
+	}{
+		{name: "existing user", id: usr.ID, wantErr: nil},
+		{name: "missing user", id: usr.ID + 1, wantErr: ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.UpdatePassword(tt.id, "new"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdatePassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := s.UserByID(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Password != "new" {
+		t.Errorf("UpdatePassword() got password = %v, want new", got.Password)
+	}
+}
+
+func Test_store_UpdateProfile(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	usr := &User{Email: "foo@bar.com", FirstName: "Foo", LastName: "Bar"}
+	if err := s.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		id      uint
+		wantErr error
+	}{
+		{name: "existing user", id: usr.ID, wantErr: nil},
+		{name: "missing user", id: usr.ID + 1, wantErr: ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.UpdateProfile(tt.id, "Baz", "Qux"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := s.UserByID(usr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FirstName != "Baz" || got.LastName != "Qux" || got.Email != usr.Email {
+		t.Errorf("UpdateProfile() got = %s %s %s, want Baz Qux %s", got.FirstName, got.LastName, got.Email, usr.Email)
+	}
+}
+
 func Test_store_SetDB(t *testing.T) {
 	db1, c := createMemDB(t)
 	defer c()
@@ -431,3 +501,147 @@ func createMemDB(t testing.TB) (*gorm.DB, func()) {
 
 	return db, Close
 }
+
+func Test_store_SetDisabled(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	usr := &User{Email: "foo@bar.com"}
+	if err := s.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		id       uint
+		disabled bool
+		wantErr  error
+	}{
+		{name: "disable", id: usr.ID, disabled: true},
+		{name: "enable", id: usr.ID, disabled: false},
+		{name: "disable again", id: usr.ID, disabled: true},
+		{name: "missing user", id: usr.ID + 1, disabled: true, wantErr: ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SetDisabled(tt.id, tt.disabled); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetDisabled() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			got, err := s.UserByID(tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Disabled() != tt.disabled {
+				t.Errorf("SetDisabled() got disabled = %v, want %v", got.Disabled(), tt.disabled)
+			}
+		})
+	}
+}
+
+func Test_store_Delete(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	usr := &User{Email: "foo@bar.com"}
+	if err := s.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name      string
+		do        func(id uint) error
+		id        uint
+		wantErr   error
+		wantFound bool
+	}{
+		{name: "restore a user that isn't deleted", do: s.Restore, id: usr.ID, wantErr: ErrUserNotFound, wantFound: true},
+		{name: "delete", do: s.Delete, id: usr.ID, wantFound: false},
+		{name: "delete again", do: s.Delete, id: usr.ID, wantErr: ErrUserNotFound, wantFound: false},
+		{name: "restore", do: s.Restore, id: usr.ID, wantFound: true},
+		{name: "delete before purge", do: s.Delete, id: usr.ID, wantFound: false},
+		{name: "purge", do: s.Purge, id: usr.ID, wantFound: false},
+		{name: "restore purged", do: s.Restore, id: usr.ID, wantErr: ErrUserNotFound, wantFound: false},
+		{name: "purge missing", do: s.Purge, id: usr.ID, wantErr: ErrUserNotFound, wantFound: false},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.do(tt.id); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			_, err := s.UserByID(tt.id)
+			if found := err == nil; found != tt.wantFound {
+				t.Errorf("UserByID() found = %v, want %v", found, tt.wantFound)
+			}
+		})
+	}
+
+	var count int64
+	db.Unscoped().Model(&User{}).Where("id = ?", usr.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Purge() left %d rows", count)
+	}
+}
+
+func Test_store_ForTenant(t *testing.T) {
+	db, c := createMemDB(t)
+	defer c()
+
+	s := NewStore(db)
+	acme := s.ForTenant(1)
+
+	usr := &User{Email: "foo@bar.com", FirstName: "Foo"}
+	if err := s.Create(usr); err != nil {
+		t.Fatal(err)
+	}
+	acmeUsr := &User{Email: "foo@bar.com", FirstName: "Foo"}
+	if err := acme.Create(acmeUsr); err != nil {
+		t.Fatalf("Create() the same email in another tenant error = %v", err)
+	}
+	if acmeUsr.TenantID != 1 {
+		t.Errorf("Create() tenant = %d, want 1", acmeUsr.TenantID)
+	}
+	if err := acme.Create(&User{Email: "foo@bar.com"}); err == nil {
+		t.Error("Create() the same email in the same tenant succeeded")
+	}
+
+	tests := []struct {
+		name  string
+		store Store
+		want  uint
+	}{
+		{name: "default", store: s, want: usr.ID},
+		{name: "acme", store: acme, want: acmeUsr.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.store.UserByEmail("foo@bar.com")
+			if err != nil || got.ID != tt.want {
+				t.Errorf("UserByEmail() = %v, %v, want user %d", got, err, tt.want)
+			}
+
+			byName, err := tt.store.UserByName("foo")
+			if err != nil || len(byName) != 1 || byName[0].ID != tt.want {
+				t.Errorf("UserByName() = %v, %v, want user %d", byName, err, tt.want)
+			}
+
+			list, _, err := tt.store.Users(ListQuery{})
+			if err != nil || len(list) != 1 || list[0].ID != tt.want {
+				t.Errorf("Users() = %v, %v, want user %d", list, err, tt.want)
+			}
+		})
+	}
+
+	if got, err := s.UserByID(acmeUsr.ID); err != nil || got.TenantID != 1 {
+		t.Errorf("UserByID() = %v, %v, want the acme user from any tenant", got, err)
+	}
+	if _, err := s.ForTenant(2).UserByEmail("foo@bar.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UserByEmail() in an empty tenant error = %v, want %v", err, ErrUserNotFound)
+	}
+}
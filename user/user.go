@@ -6,15 +6,41 @@ import (
 )
 
 type User struct {
-	ID        uint           `gorm:"primarykey"`
-	UUID      string         `json:"uuid" gorm:"index:uuid_index,unique"`
-	Email     string         `json:"email" gorm:"index:email_index,unique" validate:"required,email"`
-	FirstName string         `json:"firstname" validate:"required,min=3"`
-	LastName  string         `json:"lastname" validate:"required,min=3"`
-	Password  string         `json:"-" validate:"required,min=5"`
+	ID        uint   `gorm:"primarykey"`
+	UUID      string `json:"uuid" gorm:"index:uuid_index,unique"`
+	Email     string `json:"email" gorm:"index:tenant_email_index,unique,priority:2" validate:"required,email"`
+	FirstName string `json:"firstname" validate:"required,min=3"`
+	LastName  string `json:"lastname" validate:"required,min=3"`
+	// Password is the hash of the password, what a password can be is
+	// up to the password policy
+	Password  string         `json:"-" validate:"required"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	// MFAEnforced requires the user to sign in with a second factor
+	MFAEnforced bool `json:"mfa_enforced"`
+
+	// EmailVerifiedAt is when the user proved to own Email, nil until then
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+
+	// DisabledAt is when an admin disabled the user, it can't sign in
+	// until enabled again
+	DisabledAt *time.Time `json:"disabled_at"`
+
+	// TenantID is the tenant the user belongs to, 0 for the default one,
+	// emails are unique within a tenant
+	TenantID uint `json:"tenant_id" gorm:"index:tenant_email_index,unique,priority:1"`
+}
+
+// Disabled reports whether an admin disabled the user.
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// EmailVerified reports whether the user has verified its email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
 }
 
 // UserFieldJsonMap represents user's struct field for json key
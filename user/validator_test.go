@@ -33,12 +33,11 @@ func TestValidate(t *testing.T) {
 			wantFieldError: "FirstName",
 		},
 		{
-			name: "Password 4 letters",
+			name: "Password empty",
 			user: &User{
 				Email:     "test@gg.gg",
 				FirstName: "abcd",
 				LastName:  "xyz",
-				Password:  "secr",
 			},
 			wantErr:        true,
 			wantFieldError: "Password",
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrCiphertextMalformed = errors.New("ciphertext malformed")
+
+// Encrypt seals plaintext with AES-GCM using a key derived from key. The
+// returned string holds the nonce and the ciphertext, base64 encoded.
+func Encrypt(key []byte, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens ciphertext produced by Encrypt with the same key.
+func Decrypt(key []byte, ciphertext string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, ErrCiphertextMalformed
+	}
+
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	// the app key can be of any length, AES needs exactly 32 bytes
+	derived := sha256.Sum256(key)
+
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
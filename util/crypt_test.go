@@ -0,0 +1,59 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	key := []byte("app-key")
+	plaintext := []byte("JBSWY3DPEHPK3PXP")
+
+	ciphertext, err := Encrypt(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := Encrypt(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ciphertext == again {
+		t.Error("Encrypt() returned the same ciphertext twice, nonce must be random")
+	}
+
+	got, err := Decrypt(key, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt() = %s, want %s", got, plaintext)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	ciphertext, err := Encrypt([]byte("app-key"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext string
+	}{
+		{name: "wrong key", key: []byte("other-key"), ciphertext: ciphertext},
+		{name: "not base64", key: []byte("app-key"), ciphertext: "!!!"},
+		{name: "too short", key: []byte("app-key"), ciphertext: "AAAA"},
+		{name: "tampered", key: []byte("app-key"), ciphertext: ciphertext[:len(ciphertext)-2] + "AA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.key, tt.ciphertext); err == nil {
+				t.Error("Decrypt() error = nil, want error")
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	jww "github.com/spf13/jwalterweatherman"
 	"unsafe"
@@ -9,8 +11,43 @@ import (
 	"github.com/matthewhartstonge/argon2"
 )
 
+// HashParams are the argon2id costs passwords are hashed with. The
+// encoded hash records them, changing them doesn't affect verifying the
+// hashes made before.
+type HashParams struct {
+	// TimeCost is the number of passes over the memory
+	TimeCost uint32
+	// MemoryCost is the memory used in KiB
+	MemoryCost uint32
+	// Parallelism is the number of threads
+	Parallelism uint8
+}
+
+var ErrHashParamsInvalid = errors.New("argon2 needs a time cost of 1, 1 thread and 8 KiB of memory per thread at least")
+
+var hashConfig = argon2.DefaultConfig()
+
+// DefaultHashParams returns the costs of argon2.DefaultConfig.
+func DefaultHashParams() HashParams {
+	c := argon2.DefaultConfig()
+	return HashParams{TimeCost: c.TimeCost, MemoryCost: c.MemoryCost, Parallelism: c.Parallelism}
+}
+
+// SetHashParams sets the costs Hash uses. It isn't safe to call while
+// passwords are hashed, set them before.
+func SetHashParams(p HashParams) error {
+	if p.TimeCost < 1 || p.Parallelism < 1 || p.MemoryCost < 8*uint32(p.Parallelism) {
+		return ErrHashParamsInvalid
+	}
+
+	hashConfig.TimeCost = p.TimeCost
+	hashConfig.MemoryCost = p.MemoryCost
+	hashConfig.Parallelism = p.Parallelism
+	return nil
+}
+
 func Hash(passwd []byte) ([]byte, error) {
-	argon := argon2.DefaultConfig()
+	argon := hashConfig
 
 	buf, err := argon.HashEncoded(passwd)
 	if err != nil {
@@ -29,7 +66,13 @@ func HashString(passwd []byte) (string, error) {
 	return *(*string)(unsafe.Pointer(&buf)), nil
 }
 
+// VerifyEncoded reports whether passwd matches encoded, an argon2 hash or
+// one imported from elsewhere, see verifyImported.
 func VerifyEncoded(passwd []byte, encoded []byte) bool {
+	if !bytes.HasPrefix(encoded, []byte("$argon2")) {
+		return verifyImported(passwd, encoded)
+	}
+
 	ok, err := argon2.VerifyEncoded(passwd, encoded)
 	if err != nil {
 		return false
@@ -38,6 +81,24 @@ func VerifyEncoded(passwd []byte, encoded []byte) bool {
 	return ok
 }
 
+// NeedsRehash reports whether encoded is weaker than what Hash makes now:
+// not argon2id, or made with lower costs.
+func NeedsRehash(encoded []byte) bool {
+	raw, err := argon2.Decode(encoded)
+	if err != nil {
+		return true
+	}
+
+	c := raw.Config
+	return c.Mode != argon2.ModeArgon2id ||
+		c.Version < hashConfig.Version ||
+		c.TimeCost < hashConfig.TimeCost ||
+		c.MemoryCost < hashConfig.MemoryCost ||
+		c.Parallelism < hashConfig.Parallelism ||
+		c.SaltLength < hashConfig.SaltLength ||
+		c.HashLength < hashConfig.HashLength
+}
+
 func GenerateKey() string {
 	buff := make([]byte, 32)
 	if _, err := rand.Read(buff); err != nil {
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// verifyImported verifies passwords of users imported with the hashes of
+// another system, which NeedsRehash always upgrades to argon2id. It knows
+//
+//	$2a$, $2b$ and $2y$ bcrypt hashes
+//	$scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash> scrypt hashes
+//	$pbkdf2[-sha256|-sha512]$<rounds>$<salt>$<hash> PBKDF2 hashes
+//	pbkdf2_sha1|pbkdf2_sha256$<rounds>$<salt>$<hash> Django PBKDF2 hashes
+//
+// Salts and hashes of the $ formats are in the adapted base64 of passlib.
+func verifyImported(passwd []byte, encoded []byte) bool {
+	switch {
+	case bytes.HasPrefix(encoded, []byte("$2")):
+		return bcrypt.CompareHashAndPassword(encoded, passwd) == nil
+	case bytes.HasPrefix(encoded, []byte("$scrypt$")):
+		return verifyScrypt(passwd, string(encoded))
+	case bytes.HasPrefix(encoded, []byte("$pbkdf2")):
+		return verifyPBKDF2(passwd, string(encoded))
+	case bytes.HasPrefix(encoded, []byte("pbkdf2_")):
+		return verifyDjangoPBKDF2(passwd, string(encoded))
+	}
+
+	return false
+}
+
+func verifyScrypt(passwd []byte, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return false
+	}
+
+	var ln, r, p int
+	for _, param := range strings.Split(parts[2], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return false
+		}
+
+		switch name {
+		case "ln":
+			ln = n
+		case "r":
+			r = n
+		case "p":
+			p = n
+		}
+	}
+	if ln < 1 || ln > 30 {
+		return false
+	}
+
+	salt, err := decodeAB64(parts[3])
+	if err != nil {
+		return false
+	}
+	want, err := decodeAB64(parts[4])
+	if err != nil {
+		return false
+	}
+
+	got, err := scrypt.Key(passwd, salt, 1<<ln, r, p, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func verifyPBKDF2(passwd []byte, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return false
+	}
+
+	h := pbkdf2Hash(strings.TrimPrefix(parts[1], "pbkdf2"))
+	if h == nil {
+		return false
+	}
+	rounds, err := strconv.Atoi(parts[2])
+	if err != nil || rounds < 1 {
+		return false
+	}
+	salt, err := decodeAB64(parts[3])
+	if err != nil {
+		return false
+	}
+	want, err := decodeAB64(parts[4])
+	if err != nil {
+		return false
+	}
+
+	got := pbkdf2.Key(passwd, salt, rounds, len(want), h)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// verifyDjangoPBKDF2 verifies the hashes of Django, which salts with the
+// salt text and encodes the hash in standard base64.
+func verifyDjangoPBKDF2(passwd []byte, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 {
+		return false
+	}
+
+	h := pbkdf2Hash(strings.TrimPrefix(parts[0], "pbkdf2_"))
+	if h == nil {
+		return false
+	}
+	rounds, err := strconv.Atoi(parts[1])
+	if err != nil || rounds < 1 {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	got := pbkdf2.Key(passwd, []byte(parts[2]), rounds, len(want), h)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// pbkdf2Hash returns the hash named by the suffix of a PBKDF2 scheme.
+func pbkdf2Hash(name string) func() hash.Hash {
+	switch strings.TrimLeft(name, "-_") {
+	case "", "sha1":
+		return sha1.New
+	case "sha256":
+		return sha256.New
+	case "sha512":
+		return sha512.New
+	}
+	return nil
+}
+
+// decodeAB64 decodes the base64 of passlib, which has "." in place of "+"
+// and no padding.
+func decodeAB64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.ReplaceAll(s, ".", "+"), "=")
+	return base64.RawStdEncoding.DecodeString(s)
+}
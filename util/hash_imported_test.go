@@ -0,0 +1,91 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifyEncoded_imported(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{name: "bcrypt", encoded: string(bcryptHash)},
+		{name: "bcrypt 2y", encoded: "$2y$" + strings.TrimPrefix(string(bcryptHash), "$2a$")},
+		{name: "scrypt", encoded: "$scrypt$ln=10,r=8,p=1$c2FsdHNhbHRzYWx0MTIzNA$sBRFYJxJyR9gnQuMQio24Q6ZTaugiqeT1tSTvyCASoM"},
+		{name: "pbkdf2 sha256", encoded: "$pbkdf2-sha256$1000$c2FsdHNhbHRzYWx0MTIzNA$w7/Z.TtKe2706ziYqntGWEpywycBhHRgtLIN.hknDGQ"},
+		{name: "pbkdf2 sha1", encoded: "$pbkdf2$1000$c2FsdHNhbHRzYWx0MTIzNA$yaZGvsal3rK6xS8PX57HJJLyG.U"},
+		{name: "django pbkdf2", encoded: "pbkdf2_sha256$1000$djangosalt$ZVlGakcDeKb2taHzKsfPLaM2y3lH/BJxu2wUEIFP3Og="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !VerifyEncoded([]byte("correct horse"), []byte(tt.encoded)) {
+				t.Error("VerifyEncoded() false, want true")
+			}
+			if VerifyEncoded([]byte("wrong horse"), []byte(tt.encoded)) {
+				t.Error("VerifyEncoded() wrong password true, want false")
+			}
+			if !NeedsRehash([]byte(tt.encoded)) {
+				t.Error("NeedsRehash() false, want true")
+			}
+		})
+	}
+
+	for _, encoded := range []string{"", "plain", "$md5$abc", "$scrypt$ln=99,r=8,p=1$c2FsdA$c2FsdA", "pbkdf2_md5$1$salt$c2FsdA=="} {
+		if VerifyEncoded([]byte("plain"), []byte(encoded)) {
+			t.Errorf("VerifyEncoded(%q) true, want false", encoded)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defer SetHashParams(DefaultHashParams())
+
+	weak := HashParams{TimeCost: 1, MemoryCost: 64, Parallelism: 1}
+	if err := SetHashParams(weak); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := Hash([]byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(encoded) {
+		t.Error("NeedsRehash() with current params true, want false")
+	}
+
+	tests := []struct {
+		name   string
+		params HashParams
+		want   bool
+	}{
+		{name: "same", params: weak},
+		{name: "lower", params: HashParams{TimeCost: 1, MemoryCost: 32, Parallelism: 1}},
+		{name: "more time", params: HashParams{TimeCost: 2, MemoryCost: 64, Parallelism: 1}, want: true},
+		{name: "more memory", params: HashParams{TimeCost: 1, MemoryCost: 128, Parallelism: 1}, want: true},
+		{name: "more threads", params: HashParams{TimeCost: 1, MemoryCost: 64, Parallelism: 2}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetHashParams(tt.params); err != nil {
+				t.Fatal(err)
+			}
+			if got := NeedsRehash(encoded); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+			if !VerifyEncoded([]byte("correct horse"), encoded) {
+				t.Error("VerifyEncoded() after changing params false, want true")
+			}
+		})
+	}
+
+	if err := SetHashParams(HashParams{TimeCost: 1, MemoryCost: 8, Parallelism: 2}); err != ErrHashParamsInvalid {
+		t.Errorf("SetHashParams() too little memory error = %v, want %v", err, ErrHashParamsInvalid)
+	}
+}
@@ -21,8 +21,19 @@ const tokenPlugin = (req) => {
 };
 
 const requests = {
-  del: (url) =>
-    superagent.del(`${API_ROOT}${url}`).use(tokenPlugin).then(responseBody),
+  del: (url, body) =>
+    superagent
+      .del(`${API_ROOT}${url}`)
+      .send(body)
+      .use(tokenPlugin)
+      .then(responseBody)
+      .catch(error),
+  patch: (url, body) =>
+    superagent
+      .patch(`${API_ROOT}${url}`, body)
+      .use(tokenPlugin)
+      .then(responseBody)
+      .catch(error),
   get: (url) =>
     superagent.get(`${API_ROOT}${url}`).use(tokenPlugin).then(responseBody),
   put: (url, body) =>
@@ -40,6 +51,15 @@ const requests = {
 
 const Users = {
   get: (userID) => requests.get(`/users/${userID}/profile`),
+  updateProfile: (userID, profile) =>
+    requests.patch(`/users/${userID}/profile`, profile),
+  changePassword: (userID, currentPassword, password) =>
+    requests.post(`/users/${userID}/password`, {
+      current_password: currentPassword,
+      password,
+    }),
+  changeEmail: (userID, email, password) =>
+    requests.post(`/users/${userID}/email`, { email, password }),
   register: (body) =>
     new Promise((resolve) => {
       setTimeout(() => {
@@ -48,8 +68,49 @@ const Users = {
     }),
 };
 
+const MFA = {
+  status: () => requests.get("/mfa/"),
+  enrollTOTP: () => requests.post("/mfa/totp"),
+  confirmTOTP: (code) => requests.post("/mfa/totp/confirm", { code }),
+  deleteTOTP: (code) => requests.del("/mfa/totp", { code }),
+  regenerateRecoveryCodes: () => requests.post("/mfa/recovery-codes"),
+};
+
+const Passkeys = {
+  list: () => requests.get("/passkeys/"),
+  register: () => requests.post("/passkeys/register"),
+  finishRegister: (ceremony, credential, name) =>
+    requests.post("/passkeys/register/finish", { ceremony, credential, name }),
+  rename: (id, name) => requests.patch(`/passkeys/${id}`, { name }),
+  del: (id) => requests.del(`/passkeys/${id}`),
+};
+
+const Sessions = {
+  list: () => requests.get("/sessions/"),
+  del: (id) => requests.del(`/sessions/${id}`),
+  delOthers: () => requests.del("/sessions/?keep_current=1"),
+};
+
+const Tokens = {
+  list: () => requests.get("/tokens/"),
+  create: (name, scopes, expiresIn) =>
+    requests.post("/tokens/", { name, scopes, expires_in: expiresIn }),
+  del: (id) => requests.del(`/tokens/${id}`),
+};
+
+const Impersonation = {
+  start: (userID, reason) =>
+    requests.post(`/admin/users/${userID}/impersonate`, { reason }),
+  end: () => requests.post("/impersonation/end"),
+};
+
 const agents = {
   Users,
+  MFA,
+  Passkeys,
+  Sessions,
+  Tokens,
+  Impersonation,
 };
 
 export default agents;
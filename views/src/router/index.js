@@ -13,10 +13,11 @@ const router = createRouter({
       path: "/login",
       name: "login",
       component: () => import("../views/auth/AuthView.vue"),
-      beforeEnter: () => {
+      beforeEnter: (to) => {
         const authStore = useAuthStore();
 
-        if (authStore.isLogged) {
+        // oauth may ask a signed in user to authenticate again
+        if (authStore.isLogged && !to.query.reauth) {
           return { path: "/" };
         }
       },
@@ -33,6 +34,16 @@ const router = createRouter({
         }
       },
     },
+    {
+      path: "/forgot-password",
+      name: "forgot-password",
+      component: () => import("../views/auth/AuthView.vue"),
+    },
+    {
+      path: "/reset-password",
+      name: "reset-password",
+      component: () => import("../views/auth/AuthView.vue"),
+    },
     {
       path: "/o/oauth/authorize",
       name: "oauth:authorize",
@@ -41,7 +52,12 @@ const router = createRouter({
   ],
 });
 
-const unauthenticatedRoutes = ["login", "register"];
+const unauthenticatedRoutes = [
+  "login",
+  "register",
+  "forgot-password",
+  "reset-password",
+];
 
 router.beforeEach((to) => {
   const authStore = useAuthStore();
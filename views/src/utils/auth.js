@@ -1,8 +1,48 @@
 import { Base64 } from "js-base64";
 import { useAuthStore } from "@/stores/auth";
+import { getCredential } from "@/utils/webauthn";
+
+// tenantPrefix is where the tenant in the query serves its sign in and
+// OAuth2 endpoints, empty for the default tenant.
+export function tenantPrefix() {
+  const tenant = new URLSearchParams(window.location.search).get("tenant");
+  return tenant ? `/t/${encodeURIComponent(tenant)}` : "";
+}
+
+// tenantBranding gets the name and branding of the tenant in the query,
+// null for the default tenant.
+export async function tenantBranding() {
+  const tenant = new URLSearchParams(window.location.search).get("tenant");
+  if (!tenant) {
+    return null;
+  }
+
+  const res = await fetch(`/api/tenants/${encodeURIComponent(tenant)}`);
+  if (res.status !== 200) {
+    return null;
+  }
+  return res.json();
+}
+
+// federationProviders lists the identity providers users of the tenant
+// in the query can login with.
+export async function federationProviders() {
+  const res = await fetch(`${tenantPrefix()}/auth/federation`);
+  if (res.status !== 200) {
+    return [];
+  }
+  return res.json();
+}
+
+// federationURL is where logging in with the provider with the specified
+// slug starts. The browser comes back to the current login page.
+export function federationURL(slug) {
+  const ret = encodeURIComponent(window.location.search.slice(1));
+  return `${tenantPrefix()}/auth/federation/${encodeURIComponent(slug)}?return=${ret}`;
+}
 
 export async function authNow(email, password) {
-  const res = await fetch(`/auth/login`, {
+  const res = await fetch(`${tenantPrefix()}/auth/login`, {
     method: "POST",
     headers: {
       "Content-Type": "application/json",
@@ -13,15 +53,174 @@ export async function authNow(email, password) {
     }),
   });
 
-  let ret = { success: true, error: null };
+  return loginResult(res, "Credential not found");
+}
+
+// authMFA completes a login that is waiting for the second factor.
+export async function authMFA(mfaToken, code) {
+  const res = await fetch(`${tenantPrefix()}/auth/login/mfa`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({
+      mfa_token: mfaToken,
+      code,
+    }),
+  });
+
+  return loginResult(res, "Invalid verification code");
+}
+
+// authMFARecovery completes a login that is waiting for the second factor
+// with a recovery code.
+export async function authMFARecovery(mfaToken, code) {
+  const res = await fetch(`${tenantPrefix()}/auth/login/mfa/recovery`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({
+      mfa_token: mfaToken,
+      code,
+    }),
+  });
+
+  return loginResult(res, "Invalid recovery code");
+}
+
+// authMFAEnroll gets a new TOTP secret for a user that has to enroll
+// before being able to login.
+export async function authMFAEnroll(mfaToken) {
+  const res = await fetch(`${tenantPrefix()}/auth/login/mfa/enroll`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({
+      mfa_token: mfaToken,
+    }),
+  });
+
+  if (res.status !== 200) {
+    return null;
+  }
+
+  return res.json();
+}
+
+// authPasskey logs in with a passkey alone.
+export async function authPasskey() {
+  return passkeyLogin(`${tenantPrefix()}/auth/login/passkey`, {});
+}
+
+// authMFAPasskey completes a login that is waiting for the second factor
+// with a passkey.
+export async function authMFAPasskey(mfaToken) {
+  return passkeyLogin(`${tenantPrefix()}/auth/login/mfa/webauthn`, { mfa_token: mfaToken });
+}
+
+async function passkeyLogin(url, body) {
+  const challengeRes = await fetch(url, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(body),
+  });
+  if (challengeRes.status !== 200) {
+    return loginResult(challengeRes, "Passkey verification failed");
+  }
+
+  const challenge = await challengeRes.json();
+  let credential;
+  try {
+    credential = await getCredential(challenge.options);
+  } catch (e) {
+    return { success: false, error: "Passkey was not used", mfa: null };
+  }
+
+  const res = await fetch(`${url}/finish`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({
+      ...body,
+      ceremony: challenge.ceremony,
+      credential,
+    }),
+  });
+
+  return loginResult(res, "Passkey verification failed");
+}
+
+// resendVerification asks for another email verification link of a user
+// of the tenant in the query. The reply is the same whether or not the
+// address has an account.
+export async function resendVerification(email) {
+  await fetch(`${tenantPrefix()}/auth/verify-email/resend`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ email }),
+  });
+}
+
+// requestPasswordReset asks for a password reset link of a user of the
+// tenant in the query. The reply is the same whether or not the address
+// has an account.
+export async function requestPasswordReset(email) {
+  await fetch(`${tenantPrefix()}/auth/password/forgot`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ email }),
+  });
+}
+
+// resetPassword sets a new password with the token of a reset link.
+export async function resetPassword(token, password) {
+  const res = await fetch(`${tenantPrefix()}/auth/password/reset`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ token, password }),
+  });
+
+  if (res.status === 204) {
+    return { success: true, error: null, errors: null };
+  }
+
+  const body = await res.json().catch(() => ({}));
+  return {
+    success: false,
+    error: body.message || null,
+    errors: body.errors || null,
+  };
+}
+
+async function loginResult(res, unauthorizedMessage) {
+  let ret = { success: true, error: null, errorID: null, mfa: null };
 
   if (res.status === 200) {
+    // login redirects back on success, a json body means another step
+    const contentType = res.headers.get("content-type") || "";
+    if (contentType.includes("application/json")) {
+      ret.success = false;
+      ret.mfa = await res.json();
+    }
     return ret;
   }
 
-  if (res.status === 401) {
+  if (res.status === 401 || res.status === 403 || res.status === 429) {
+    const body = await res.json().catch(() => ({}));
     ret.success = false;
-    ret.error = "Credential not found";
+    ret.error = body.message || unauthorizedMessage;
+    ret.errorID = body.error || null;
     return ret;
   }
 
@@ -0,0 +1,74 @@
+// Helpers converting between the JSON the server speaks and the
+// ArrayBuffers navigator.credentials works with.
+
+export function passkeysSupported() {
+  return !!window.PublicKeyCredential;
+}
+
+// decode accepts both base64 and base64url, the server uses either
+// depending on the field.
+function decode(value) {
+  const b64 = value.replace(/-/g, "+").replace(/_/g, "/");
+  const padded = b64 + "=".repeat((4 - (b64.length % 4)) % 4);
+  return Uint8Array.from(atob(padded), (c) => c.charCodeAt(0)).buffer;
+}
+
+function encode(buffer) {
+  const bytes = String.fromCharCode(...new Uint8Array(buffer));
+  return btoa(bytes).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function decodeDescriptors(descriptors) {
+  return (descriptors || []).map((d) => ({ ...d, id: decode(d.id) }));
+}
+
+// createCredential runs the registration challenge the server sent.
+export async function createCredential(options) {
+  const publicKey = options.publicKey;
+  const credential = await navigator.credentials.create({
+    publicKey: {
+      ...publicKey,
+      challenge: decode(publicKey.challenge),
+      user: { ...publicKey.user, id: decode(publicKey.user.id) },
+      excludeCredentials: decodeDescriptors(publicKey.excludeCredentials),
+    },
+  });
+
+  return {
+    id: credential.id,
+    rawId: encode(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: encode(credential.response.clientDataJSON),
+      attestationObject: encode(credential.response.attestationObject),
+      transports: credential.response.getTransports
+        ? credential.response.getTransports()
+        : [],
+    },
+  };
+}
+
+// getCredential runs the assertion challenge the server sent.
+export async function getCredential(options) {
+  const publicKey = options.publicKey;
+  const credential = await navigator.credentials.get({
+    publicKey: {
+      ...publicKey,
+      challenge: decode(publicKey.challenge),
+      allowCredentials: decodeDescriptors(publicKey.allowCredentials),
+    },
+  });
+
+  const response = credential.response;
+  return {
+    id: credential.id,
+    rawId: encode(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: encode(response.clientDataJSON),
+      authenticatorData: encode(response.authenticatorData),
+      signature: encode(response.signature),
+      userHandle: response.userHandle ? encode(response.userHandle) : null,
+    },
+  };
+}
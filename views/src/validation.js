@@ -2,8 +2,24 @@ import { capitalize } from "vue";
 
 const getErrorMessage = (field, tag, param) => {
   field = capitalize(field);
-  if (tag === "required") {
-    return field + " is required";
+  switch (tag) {
+    case "required":
+      return field + " is required";
+    case "min":
+      return field + ` should be at least ${param} characters`;
+    case "max":
+      return field + ` should be at most ${param} characters`;
+    case "classes":
+      return (
+        field +
+        ` should mix ${param} of lowercase, uppercase, digits and symbols`
+      );
+    case "personal":
+      return field + " shouldn't contain your name or email";
+    case "breached":
+      return field + " has appeared in a data breach, choose another one";
+    case "reused":
+      return field + ` shouldn't be one of your last ${param} passwords`;
   }
 
   return field +  ` should be ${tag} ${param}`;